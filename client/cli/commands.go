@@ -29,6 +29,11 @@ type ShellCommand struct {
 
 // Map containing every shell command
 var shCommands = map[string]ShellCommand{
+	"PROBE": {probeServer,
+		"- PROBE: Checks whether a server is reachable and speaks the gochat protocol without connecting to it.\n" +
+			"Usage: PROBE <server address> <server port> [-tls]",
+	},
+
 	"CONN": {connect,
 		"- CONN: Connects the client to a gochat server. -noverify will avoid a TLS verification and -keep will avoid idle disconnection.\n" +
 			"Usage: CONN <server address> <server port> [-noverify] [-keep] || CONN <server name> [-noverify] [-keep]",
@@ -39,11 +44,36 @@ var shCommands = map[string]ShellCommand{
 			"Usage: DISCN",
 	},
 
+	"SECINFO": {securityInfo,
+		"- SECINFO: Prints the TLS details of the current connection and whether a reusable token is held.\n" +
+			"Usage: SECINFO",
+	},
+
 	"REQ": {requestUser,
 		"- REQ: Requests information about a user to the gochat server.\n" +
 			"Usage: REQ <username to be requested>",
 	},
 
+	"REQALL": {requestAllUsers,
+		"- REQALL: Requests information about every online user that has not been requested yet.\n" +
+			"Usage: REQALL",
+	},
+
+	"RESYNC": {resyncUser,
+		"- RESYNC: Requests the public key of a stored user again, updating it if it has changed.\n" +
+			"Usage: RESYNC <username to be resynced>",
+	},
+
+	"RESYNCALL": {resyncAllUsers,
+		"- RESYNCALL: Resyncs the public key of every stored user in the current server.\n" +
+			"Usage: RESYNCALL",
+	},
+
+	"KEYHISTORY": {showKeyHistory,
+		"- KEYHISTORY: Lists the fingerprints of every public key seen for a user, marking the current one.\n" +
+			"Usage: KEYHISTORY <username>",
+	},
+
 	"REG": {registerUser,
 		"- REG: Registers a user to the gochat server the user is connected to.\n" +
 			"Usage: REG",
@@ -56,7 +86,8 @@ var shCommands = map[string]ShellCommand{
 
 	"LOGIN": {loginUser,
 		"- LOGIN: Requests information about a user to the gochat server.\n" +
-			"Usage: LOGIN <username>",
+			"The password is asked for unless -env gives an environment variable that holds it.\n" +
+			"Usage: LOGIN <username> [-env <variable>]",
 	},
 
 	"LOGOUT": {logoutUser,
@@ -70,8 +101,14 @@ var shCommands = map[string]ShellCommand{
 	},
 
 	"MSG": {sendMessage,
-		"- MSG: Sends a message to a user. You must REQ the user prior to sending them a message.\n" +
-			"Usage: MSG <destination user> <message>",
+		"- MSG: Sends a message to a user. You must REQ the user prior to sending them a message,\n" +
+			"unless -req is given or auto_request is enabled, in which case unknown users are requested first.\n" +
+			"Usage: MSG [-req] <destination user> <message>",
+	},
+
+	"RESEND": {resendMessage,
+		"- RESEND: Sends the last message sent in this session again to another user.\n" +
+			"Usage: RESEND <destination user>",
 	},
 
 	"RECIV": {receiveMessages,
@@ -84,11 +121,31 @@ var shCommands = map[string]ShellCommand{
 			"Usage: IMPORT <username of the new user> <path of the key>",
 	},
 
+	"KEYINFO": {keyInfo,
+		"- KEYINFO: Checks a key to be imported and shows its size and fingerprint without importing it.\n" +
+			"Usage: KEYINFO <path of the key>",
+	},
+
 	"EXPORT": {exportKey,
 		"- EXPORT: Exports a user.\n" +
 			"Usage: EXPORT <user to be exported>",
 	},
 
+	"EXPORTHTML": {exportHTML,
+		"- EXPORTHTML: Exports the conversation with a user as an HTML transcript.\n" +
+			"Usage: EXPORTHTML <username> <path>",
+	},
+
+	"PASSWD": {changePassword,
+		"- PASSWD: Changes the password of a local user.\n" +
+			"Usage: PASSWD <username>",
+	},
+
+	"REKEYALL": {rekeyAll,
+		"- REKEYALL: Encrypts again the private keys of every local user, optionally under a single master passphrase.\n" +
+			"Usage: REKEYALL [-master]",
+	},
+
 	"SUB": {subscribe,
 		"- SUB: Subscribes a user to the specified hook. The user automatically unsubscribes from the hook in each disconnection.\n" +
 			"Usage: SUB <all/new_login/new_logout/duplicated_session/permissions_change>",
@@ -99,11 +156,71 @@ var shCommands = map[string]ShellCommand{
 			"Usage: UNSUB <all/new_login/new_logout/duplicated_session/permissions_change>",
 	},
 
+	"SPEEDTEST": {speedTest,
+		"- SPEEDTEST: Measures the latency and throughput of the link with the server.\n" +
+			"Usage: SPEEDTEST [count]",
+	},
+
+	"BENCHMARK": {benchmark,
+		"- BENCHMARK: Measures how long local cryptographic operations take.\n" +
+			"Usage: BENCHMARK <crypto>",
+	},
+
+	"SUBLIST": {listSubscribed,
+		"- SUBLIST: Prints the hooks the user is currently subscribed to on the server.\n" +
+			"Usage: SUBLIST",
+	},
+
+	"NOTE": {addNote,
+		"- NOTE: Appends a private local note about a user, use -clear to remove all of them.\n" +
+			"Usage: NOTE <username> <text/-clear>",
+	},
+
+	"NOTES": {showNotes,
+		"- NOTES: Shows the private local notes about a user.\n" +
+			"Usage: NOTES <username>",
+	},
+
+	"SIGNAL": {sendSignal,
+		"- SIGNAL: Sends signaling data to an online user, which the server relays without storing it.\n" +
+			"Usage: SIGNAL <username> <data>",
+	},
+
+	"PROFILE": {manageProfile,
+		"- PROFILE: Changes or removes the bio of the logged in user, or shows the bio of a user.\n" +
+			"Usage: PROFILE <set <text>/clear/show <username>>",
+	},
+
+	"SELFTEST": {selfTest,
+		"- SELFTEST: Encrypts and decrypts a text locally with the keys of the logged in user.\n" +
+			"Usage: SELFTEST",
+	},
+
 	"VER": {ver,
 		"- VER: Prints the current client gochat protocol version.\n" +
 			"Usage: VER",
 	},
 
+	"SERVERVER": {serverVersion,
+		"- SERVERVER: Prints the versions last advertised by the server.\n" +
+			"Usage: SERVERVER",
+	},
+
+	"LASTPACKET": {lastPacket,
+		"- LASTPACKET: Prints the last packet sent to the server and its reply.\n" +
+			"Usage: LASTPACKET",
+	},
+
+	"OPERATIONS": {operations,
+		"- OPERATIONS: Prints the operations defined by the protocol and what they do.\n" +
+			"Usage: OPERATIONS",
+	},
+
+	"ERRORS": {errorCodes,
+		"- ERRORS: Prints the error codes defined by the protocol and their meaning.\n" +
+			"Usage: ERRORS [code or name]",
+	},
+
 	"VERBOSE": {verbose,
 		"- VERBOSE: Switches on/off the verbose mode.\n" +
 			"Usage: VERBOSE",
@@ -118,13 +235,29 @@ var shCommands = map[string]ShellCommand{
 		"- DELSERVER: Deletes a server from the client database.\n" +
 			"Usage: DELSERVER <name>"},
 
+	"RENAMESERVER": {renameServer,
+		"- RENAMESERVER: Changes the name of a server in the client database.\n" +
+			"Usage: RENAMESERVER <name> <new name>"},
+
 	"SERVERS": {servers,
 		"- SERVERS: Prints the registered servers of the client database.\n" +
 			"Usage: SERVERS"},
 
+	"DBINFO": {databaseInfo,
+		"- DBINFO: Prints the row counts and size on disk of the client database.\n" +
+			"Usage: DBINFO"},
+
+	"DBVACUUM": {databaseVacuum,
+		"- DBVACUUM: Reclaims unused space in the client database.\n" +
+			"Usage: DBVACUUM"},
+
+	"DBCHECK": {databaseCheck,
+		"- DBCHECK: Checks the client database for corruption and rows referencing missing users, deleting those rows if -repair is given.\n" +
+			"Usage: DBCHECK [-repair]"},
+
 	"ADMIN": {sendAdminCommand,
 		"- ADMIN: Sends an administrator command to the server. The user must have permissions to do so.\n" +
-			"Usage: ADMIN <shtdwn/dereg/brdcast/chperms/kick> <args>"},
+			"Usage: ADMIN <shutdown/broadcast/ban/kick/setperms/getperms/resetperms/motd/cancel/expire/prune> <args>"},
 
 	"PERMS": {getUserPerms,
 		"- PERMS: Prints out the permission level of a user.\n" +
@@ -138,9 +271,13 @@ var shCommands = map[string]ShellCommand{
 		"- SETSERVER: Changes the value of a config object\n" +
 			"Usage: SETSERVER <name> <target> <value>"},
 
+	"DANGLING": {danglingUsers,
+		"- DANGLING: Lists the local users whose server has been deleted\n" +
+			"Usage: DANGLING"},
+
 	"RECOVER": {recoverUser,
 		"- RECOVER: Exports the conversations with a user\n" +
-			"Usage: RECOVER <user> [-cleanup]"},
+			"Usage: RECOVER <user> [-cleanup] [-format text|json] [-style plain|ansi|markdown] [-tz timezone]"},
 }
 
 // Sets up the CONN call depending on how the user specified the server.
@@ -202,7 +339,9 @@ func connect(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 		return connErr
 	}
 	cmd.Data.Server = &server
-	go commands.ListenPackets(cmd, func() {})
+	go commands.ListenPackets(cmd, func(reason error) {
+		printDisconnect(cmd, reason)
+	})
 	if keep {
 		go commands.PreventIdle(ctx, cmd.Data, time.Duration(spec.ReadTimeout-1)*time.Minute)
 	}
@@ -219,6 +358,30 @@ func disconnect(ctx context.Context, cmd commands.Command, args ...[]byte) error
 	return discnErr
 }
 
+// Calls PROBE after parsing the port of the server.
+//
+// Arguments: <server address> <server port> [-tls]
+func probeServer(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	port, parseErr := strconv.ParseUint(string(args[1]), 10, 16)
+	if parseErr != nil {
+		return parseErr
+	}
+
+	useTLS := len(args) > 2 && string(args[2]) == "-tls"
+	return commands.PROBE(cmd, string(args[0]), uint16(port), useTLS)
+}
+
+// Calls SECINFO, no aditional sanitization needed.
+//
+// Arguments: none
+func securityInfo(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.SECINFO(cmd)
+}
+
 // Calls REQ to request a user.
 //
 // Arguments: <username to be requested>
@@ -231,6 +394,64 @@ func requestUser(ctx context.Context, cmd commands.Command, args ...[]byte) erro
 	return reqErr
 }
 
+// Calls REQALL, no aditional sanitization needed.
+//
+// Arguments: none
+func requestAllUsers(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	_, reqErr := commands.REQALL(ctx, cmd)
+	return reqErr
+}
+
+// Calls RESYNC to refresh the key of a user.
+//
+// Arguments: <username to be resynced>
+func resyncUser(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+	username := string(args[0])
+	_, resyncErr := commands.RESYNC(ctx, cmd, username)
+	return resyncErr
+}
+
+// Calls RESYNCALL, no aditional sanitization needed.
+//
+// Arguments: none
+func resyncAllUsers(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	_, resyncErr := commands.RESYNCALL(ctx, cmd)
+	return resyncErr
+}
+
+// Calls KEYHISTORY and prints every key seen for a user.
+//
+// Arguments: <username>
+func showKeyHistory(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	records, err := commands.KEYHISTORY(cmd, string(args[0]))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range records {
+		seen := "unknown"
+		if !v.FirstSeen.IsZero() {
+			seen = v.FirstSeen.Format(time.DateTime)
+		}
+
+		state := "superseded"
+		if v.Current {
+			state = "current"
+		}
+
+		cmd.Output(fmt.Sprintf("%s (first seen %s): %s", state, seen, v.Fingerprint), commands.PLAIN)
+	}
+
+	return nil
+}
+
 // Opens a few prompts for the user to provide the user data and then
 // registers said user with a REG call.
 //
@@ -255,21 +476,6 @@ func registerUser(ctx context.Context, cmd commands.Command, args ...[]byte) err
 		return commands.ErrorUsernameEmpty
 	}
 
-	// Checks that the user is new
-	exists, existsErr := db.LocalUserExists(
-		cmd.Static.DB,
-		string(username),
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-
-	if existsErr != nil {
-		return existsErr
-	}
-	if exists {
-		return commands.ErrorUserExists
-	}
-
 	// Gets the password
 	cmd.Output("password: ", commands.PROMPT)
 	pass1, pass1Err := term.ReadPassword(int(os.Stdin.Fd()))
@@ -328,7 +534,7 @@ func deregisterUser(ctx context.Context, cmd commands.Command, args ...[]byte) e
 // Opens a prompt to securely ask for a password in order to call the LOGIN
 // command.
 //
-// Arguments: <username>
+// Arguments: <username> [-env <variable>]
 func loginUser(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 	if !cmd.Data.IsConnected() {
 		return commands.ErrorNotConnected
@@ -352,6 +558,15 @@ func loginUser(ctx context.Context, cmd commands.Command, args ...[]byte) error
 		return commands.ErrorUserNotFound
 	}
 
+	// Scripts can take the password from the environment
+	if len(args) > 2 && string(args[1]) == "-env" {
+		pass, ok := os.LookupEnv(string(args[2]))
+		if !ok {
+			return ErrorSecretNotSet
+		}
+		return commands.LOGIN(ctx, cmd, username, pass)
+	}
+
 	// Asks for password
 	cmd.Output(fmt.Sprintf("%s's password: ", username), commands.PROMPT)
 	pass, passErr := term.ReadPassword(int(os.Stdin.Fd()))
@@ -420,16 +635,73 @@ func getUsers(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 
 // Calls MSG, to send a message to a user.
 //
-// Arguments: <dest. username> <unencyrpted text message>
+// Arguments: [-req] <dest. username> <unencyrpted text message>
 func sendMessage(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	// Only this message requests the user if needed
+	if len(args) > 0 && string(args[0]) == "-req" {
+		static := *cmd.Static
+		static.AutoRequest = true
+		cmd.Static = &static
+		args = args[1:]
+	}
+
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
 	dstUser := string(args[0])
 	extra := args[1:]
 	plainText := bytes.Join(extra, []byte(" "))
 
-	msgErr := commands.MSG(ctx, cmd, dstUser, string(plainText))
+	// Checks the key of the user before the first message
+	if ConfirmContacts {
+		// The key must be stored to show its fingerprint
+		err := commands.AutoREQ(ctx, cmd, dstUser)
+		if err != nil {
+			return err
+		}
+
+		fingerprint, first, err := commands.FirstContact(cmd, dstUser)
+		if err != nil {
+			return err
+		}
+
+		if first {
+			cmd.Output(fmt.Sprintf(
+				"first message to %s, whose key fingerprint is:\n%s",
+				dstUser, fingerprint,
+			), commands.INFO)
+			cmd.Output("send the message? [y/N]: ", commands.PROMPT)
+
+			rd := bufio.NewReader(os.Stdin)
+			answer, readErr := rd.ReadString('\n')
+			if readErr != nil {
+				return readErr
+			}
+
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				return commands.ErrorMessageNotConfirmed
+			}
+		}
+	}
+
+	_, msgErr := commands.MSG(ctx, cmd, dstUser, string(plainText), spec.MsgText)
 	return msgErr
 }
 
+// Calls Resend, no aditional sanitization needed.
+//
+// Arguments: <destination user>
+func resendMessage(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	_, resendErr := commands.RESEND(ctx, cmd, string(args[0]))
+	return resendErr
+}
+
 // Calls Reciv, no aditional sanitization needed.
 //
 // Arguments: none
@@ -464,6 +736,43 @@ func unsubscribe(ctx context.Context, cmd commands.Command, args ...[]byte) erro
 	return unsubErr
 }
 
+// Calls Speedtest to measure the link with the server
+//
+// Arguments: [count]
+func speedTest(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	count := commands.DefaultSpeedtest
+	if len(args) > 0 {
+		n, convErr := strconv.Atoi(string(args[0]))
+		if convErr != nil {
+			return convErr
+		}
+		count = n
+	}
+
+	speedErr := commands.SPEEDTEST(ctx, cmd, count)
+	return speedErr
+}
+
+// Calls Benchmark to time local operations
+//
+// Arguments: <target>
+func benchmark(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	benchErr := commands.BENCHMARK(cmd, strings.ToLower(string(args[0])))
+	return benchErr
+}
+
+// Calls Sublist to print the subscribed hooks
+//
+// Arguments: none
+func listSubscribed(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	_, sublistErr := commands.SUBLIST(ctx, cmd)
+	return sublistErr
+}
+
 // Calls Import to import a key.
 //
 // Arguments: <username> <path>
@@ -500,6 +809,17 @@ func importKey(ctx context.Context, cmd commands.Command, args ...[]byte) error
 	return importErr
 }
 
+// Calls Keyinfo, no aditional sanitization needed.
+//
+// Arguments: <path of the key>
+func keyInfo(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	return commands.KEYINFO(cmd, string(args[0]))
+}
+
 // Calls Export to import a key.
 //
 // Arguments: <username>
@@ -527,6 +847,100 @@ func exportKey(ctx context.Context, cmd commands.Command, args ...[]byte) error
 	return exportErr
 }
 
+// Calls EXPORTHTML to write a conversation transcript.
+//
+// Arguments: <username> <path>
+func exportHTML(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	return commands.EXPORTHTML(cmd, string(args[0]), string(args[1]))
+}
+
+// Calls PASSWD to change the password of a local user.
+//
+// Arguments: <username>
+func changePassword(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	// Only the local user is changed so no connection is needed
+	username := string(args[0])
+	cmd.Output(fmt.Sprintf("%s's current password: ", username), commands.PROMPT)
+	old, oldErr := term.ReadPassword(int(os.Stdin.Fd()))
+	if oldErr != nil {
+		cmd.Output("\n", commands.PROMPT)
+		return oldErr
+	}
+	cmd.Output("\n", commands.PROMPT)
+
+	cmd.Output("new password: ", commands.PROMPT)
+	pass1, pass1Err := term.ReadPassword(int(os.Stdin.Fd()))
+	if pass1Err != nil {
+		cmd.Output("\n", commands.PROMPT)
+		return pass1Err
+	}
+	cmd.Output("\n", commands.PROMPT)
+
+	cmd.Output("repeat new password: ", commands.PROMPT)
+	pass2, pass2Err := term.ReadPassword(int(os.Stdin.Fd()))
+	if pass2Err != nil {
+		cmd.Output("\n", commands.PROMPT)
+		return pass2Err
+	}
+	cmd.Output("\n", commands.PROMPT)
+
+	if string(pass1) != string(pass2) {
+		return commands.ErrorPasswordsDontMatch
+	}
+
+	return commands.PASSWD(cmd, username, string(old), string(pass1))
+}
+
+// Calls Rekeyall to encrypt all private keys again,
+// asking for a master passphrase if specified
+//
+// Arguments: [-master]
+func rekeyAll(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	var master string
+	if len(args) > 0 && string(args[0]) == "-master" {
+		cmd.Output("master passphrase: ", commands.PROMPT)
+		pass1, pass1Err := term.ReadPassword(int(os.Stdin.Fd()))
+		if pass1Err != nil {
+			cmd.Output("\n", commands.PROMPT)
+			return pass1Err
+		}
+		cmd.Output("\n", commands.PROMPT)
+
+		cmd.Output("repeat master passphrase: ", commands.PROMPT)
+		pass2, pass2Err := term.ReadPassword(int(os.Stdin.Fd()))
+		if pass2Err != nil {
+			cmd.Output("\n", commands.PROMPT)
+			return pass2Err
+		}
+		cmd.Output("\n", commands.PROMPT)
+
+		if string(pass1) != string(pass2) {
+			return commands.ErrorPasswordsDontMatch
+		}
+		master = string(pass1)
+	}
+
+	ask := func(lu db.LocalUser) (string, error) {
+		cmd.Output(fmt.Sprintf(
+			"%s's password in %s: ",
+			lu.User.Username, lu.User.Server.Name,
+		), commands.PROMPT)
+		pass, passErr := term.ReadPassword(int(os.Stdin.Fd()))
+		cmd.Output("\n", commands.PROMPT)
+		return string(pass), passErr
+	}
+
+	return commands.REKEYALL(cmd, ask, master)
+}
+
 /* SHELL-EXCLUSIVE COMMANDS */
 
 // Prints out the gochat version used by the client.
@@ -549,6 +963,120 @@ func ver(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 	return nil
 }
 
+// Calls NOTE to add or clear the notes of a user.
+//
+// Arguments: <username> <text/-clear>
+func addNote(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	text := string(bytes.Join(args[1:], []byte(" ")))
+	clear := text == "-clear"
+
+	return commands.NOTE(cmd, string(args[0]), text, clear)
+}
+
+// Calls NOTES to show the notes of a user.
+//
+// Arguments: <username>
+func showNotes(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	return commands.NOTES(cmd, string(args[0]))
+}
+
+// Calls SIGNAL to relay data to a user.
+//
+// Arguments: <username> <data>
+func sendSignal(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	data := bytes.Join(args[1:], []byte(" "))
+	return commands.SIGNAL(ctx, cmd, string(args[0]), data)
+}
+
+// Calls PROFILE or USERPROFILE depending on the option.
+//
+// Arguments: <set <text>/clear/show <username>>
+func manageProfile(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 1 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	switch string(args[0]) {
+	case "set":
+		if len(args) < 2 {
+			return commands.ErrorInsuficientArgs
+		}
+		return commands.PROFILE(ctx, cmd, string(bytes.Join(args[1:], []byte(" "))))
+	case "clear":
+		return commands.PROFILE(ctx, cmd, "")
+	case "show":
+		if len(args) < 2 {
+			return commands.ErrorInsuficientArgs
+		}
+
+		bio, err := commands.USERPROFILE(cmd, string(args[1]))
+		if err != nil {
+			return err
+		}
+
+		if bio == "" {
+			cmd.Output(fmt.Sprintf("%s has not set a bio", args[1]), commands.RESULT)
+		} else {
+			cmd.Output(fmt.Sprintf("bio of %s: %s", args[1], bio), commands.RESULT)
+		}
+		return nil
+	default:
+		return commands.ErrorUnknownProfileOption
+	}
+}
+
+// Calls SELFTEST, no aditional sanitization needed.
+//
+// Arguments: none
+func selfTest(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.SELFTEST(cmd)
+}
+
+// Calls SERVERVERSION, no aditional sanitization needed.
+//
+// Arguments: none
+func serverVersion(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.SERVERVERSION(cmd)
+}
+
+// Calls LASTPACKET, no aditional sanitization needed.
+//
+// Arguments: none
+func lastPacket(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.LASTPACKET(cmd)
+}
+
+// Calls ERRORS with the code to look up, if any.
+//
+// Arguments: [code]
+func errorCodes(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	var code string
+	if len(args) > 0 {
+		code = string(args[0])
+	}
+
+	return commands.ERRORS(cmd, code)
+}
+
+// Calls OPERATIONS to list the protocol operations.
+//
+// Arguments: none
+func operations(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.OPERATIONS(cmd)
+}
+
 // Switches on/off the verbose mode.
 //
 // Arguments: none
@@ -615,6 +1143,31 @@ func servers(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 	return nil
 }
 
+// Calls Dbinfo to print the database statistics
+//
+// Arguments: none
+func databaseInfo(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	infoErr := commands.DBINFO(cmd)
+	return infoErr
+}
+
+// Calls Dbvacuum to reclaim database space
+//
+// Arguments: none
+func databaseVacuum(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	vacuumErr := commands.DBVACUUM(cmd)
+	return vacuumErr
+}
+
+// Calls DBCHECK to check the integrity of the database
+//
+// Arguments: [-repair]
+func databaseCheck(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	repair := len(args) > 0 && string(args[0]) == "-repair"
+	checkErr := commands.DBCHECK(cmd, repair)
+	return checkErr
+}
+
 // REQs a user to get its permission level
 //
 // Arguments: <username>
@@ -714,17 +1267,29 @@ func setConfig(ctx context.Context, cmd commands.Command, args ...[]byte) error
 	return setErr
 }
 
+// Calls DANGLING to list the users that can be recovered.
+//
+// Arguments: none
+func danglingUsers(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	return commands.DANGLING(cmd)
+}
+
 // Calls RECOVER  to obtain a file with the recovered conversation.
 //
-// Arguments: <user> [-cleanup]
+// Arguments: <user> [-cleanup] [-format text|json] [-style plain|ansi|markdown] [-tz timezone]
 func recoverUser(ctx context.Context, cmd commands.Command, args ...[]byte) error {
 	if len(args) < 1 {
 		return commands.ErrorInsuficientArgs
 	}
 
-	cleanup := false
-	if len(args) == 2 && string(args[1]) == "-cleanup" {
-		cleanup = true
+	extra := make([]string, 0, len(args)-1)
+	for _, v := range args[1:] {
+		extra = append(extra, string(v))
+	}
+
+	opts, optsErr := commands.ParseRecoverOptions(extra)
+	if optsErr != nil {
+		return optsErr
 	}
 
 	username := string(args[0])
@@ -738,10 +1303,22 @@ func recoverUser(ctx context.Context, cmd commands.Command, args ...[]byte) erro
 	}
 	cmd.Output("\n", commands.PROMPT)
 
-	recoverErr := commands.RECOVER(cmd, username, string(pass), cleanup)
+	recoverErr := commands.RECOVER(cmd, username, string(pass), opts)
 	return recoverErr
 }
 
+// Calls RENAMESERVER to change the name of a server.
+//
+// Arguments: <server name> <new name>
+func renameServer(ctx context.Context, cmd commands.Command, args ...[]byte) error {
+	if len(args) < 2 {
+		return commands.ErrorInsuficientArgs
+	}
+
+	_, renameErr := commands.RENAMESERVER(cmd, string(args[0]), string(args[1]))
+	return renameErr
+}
+
 // Deletes a server from the local database.
 //
 // Arguments: <server name>
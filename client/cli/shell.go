@@ -6,10 +6,13 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sprinter05/gochat/client/commands"
@@ -19,6 +22,22 @@ import (
 
 const ShellVersion float32 = 1.0
 
+// Maximum amount of seconds a background RECIV poll may take
+const PollTimeout = 10
+
+// Whether MSG asks for confirmation before the
+// first message to a user, off so that scripts work
+var ConfirmContacts bool
+
+var (
+	ErrorCommandNotFound = errors.New("command not found")               // command not found
+	ErrorSecretNotSet    = errors.New("environment variable is not set") // environment variable is not set
+)
+
+// Held while a shell command is running so that background
+// tasks do not print in the middle of its output.
+var running sync.Mutex
+
 // Given a string containing a command name, returns its
 // execution function.
 func fetchCommand(op string, cmd commands.Command) ShellCommand {
@@ -35,7 +54,8 @@ func fetchCommand(op string, cmd commands.Command) ShellCommand {
 }
 
 // Creates a new shell and an option connection and server.
-func New(static commands.StaticData, conn net.Conn, server db.Server) commands.Command {
+// The TLS state must be nil if the connection does not use TLS.
+func New(static commands.StaticData, conn net.Conn, state *tls.ConnectionState, server db.Server) commands.Command {
 	data := commands.NewEmptyData()
 	cmds := commands.Command{
 		Data:   &data,
@@ -45,6 +65,7 @@ func New(static commands.StaticData, conn net.Conn, server db.Server) commands.C
 
 	// Assign data variables
 	data.Conn = conn
+	data.State = state
 	data.Server = &server
 
 	if static.Verbose {
@@ -56,13 +77,16 @@ func New(static commands.StaticData, conn net.Conn, server db.Server) commands.C
 		if static.Verbose {
 			cmds.Output("listening for incoming packets...", commands.INFO)
 		}
-		go commands.ListenPackets(cmds, func() {})
+		go commands.ListenPackets(cmds, func(reason error) {
+			printDisconnect(cmds, reason)
+		})
 	}
 
 	// Starts specific command handlers to listen on the background
 	go RECIVHandler(cmds)
 	go HOOKHandler(cmds)
 	go SHTDWNHandler(cmds)
+	go SIGNALHandler(cmds)
 
 	return cmds
 }
@@ -81,39 +105,103 @@ func Run(data commands.Command) {
 			fmt.Printf("input error: %s\n", readErr)
 			continue
 		}
-		// Trims the input, removing trailing spaces and line jumps
-		input = bytes.TrimSpace(input)
-		if len(input) == 0 {
-			// Empty command, asks for input again
-			continue
-		}
 
-		op := string(bytes.Fields(input)[0])
-		if strings.ToUpper(op) == "EXIT" {
+		exit, err := runLine(data, input)
+		if exit {
 			return
 		}
 
-		// Sets up command data
-		var args [][]byte
-		args = append(args, bytes.Fields(input)[1:]...)
+		if err != nil {
+			fmt.Printf("[ERROR] %s: %s\n", bytes.Fields(input)[0], err)
+		}
+	}
+}
 
-		if strings.ToUpper(op) == "HELP" {
-			help(data, args...)
+// Runs every command of a script file in order as if
+// they were typed in the shell. Empty lines and lines
+// starting with "#" are skipped. The script stops on the
+// first failing command unless keepGoing is set. Returns
+// true if the script used EXIT.
+func RunScript(data commands.Command, path string, keepGoing bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("[ERROR] %s\n", err)
+		return false, err
+	}
+	defer f.Close()
+
+	var failed error
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
 
-		// Gets the appropiate command and executes it
-		shCmd := fetchCommand(op, data)
-		if shCmd.Run == nil {
+		// Shown as if it had been typed
+		PrintPrompt(data.Data)
+		fmt.Printf("%s\n", line)
+
+		exit, err := runLine(data, line)
+		if exit {
+			return true, failed
+		}
+
+		if err == nil {
 			continue
 		}
 
-		//* Can be changed with context.WithTimeout
-		err := shCmd.Run(context.Background(), data, args...)
-		if err != nil {
-			fmt.Printf("[ERROR] %s: %s\n", op, err)
+		err = fmt.Errorf("%s:%d: %s: %w", path, n, bytes.Fields(line)[0], err)
+		fmt.Printf("[ERROR] %s\n", err)
+		if !keepGoing {
+			return false, err
 		}
+		failed = err
 	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("[ERROR] %s: %s\n", path, err)
+		return false, err
+	}
+
+	return false, failed
+}
+
+// Runs a single line of input. Returns true if the
+// shell must exit and the error of the command if any.
+func runLine(data commands.Command, input []byte) (bool, error) {
+	// Trims the input, removing trailing spaces and line jumps
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		// Empty command, asks for input again
+		return false, nil
+	}
+
+	op := string(bytes.Fields(input)[0])
+	if strings.ToUpper(op) == "EXIT" {
+		return true, nil
+	}
+
+	// Sets up command data
+	var args [][]byte
+	args = append(args, bytes.Fields(input)[1:]...)
+
+	if strings.ToUpper(op) == "HELP" {
+		help(data, args...)
+		return false, nil
+	}
+
+	// Gets the appropiate command and executes it
+	shCmd, ok := shCommands[strings.ToUpper(op)]
+	if !ok {
+		return false, ErrorCommandNotFound
+	}
+
+	//* Can be changed with context.WithTimeout
+	running.Lock()
+	err := shCmd.Run(context.Background(), data, args...)
+	running.Unlock()
+	return false, err
 }
 
 func PrintPrompt(data *commands.Data) {
@@ -171,7 +259,51 @@ func RECIVHandler(cmd commands.Command) {
 			fmt.Println(storeErr)
 			continue
 		}
-		printMessage(reciv, decrypted.Content, cmd)
+		printMessage(reciv, decrypted, cmd)
+	}
+}
+
+// Periodically requests a message catch-up while the
+// shell is logged in. Polls are skipped while a command
+// is running, and the received messages are printed by
+// the RECIV handler. Errors are only shown in verbose mode.
+func Poll(cmd commands.Command, interval time.Duration) {
+	// Silences the result of each request
+	quiet := commands.Command{
+		Data:   cmd.Data,
+		Static: cmd.Static,
+		Output: func(string, commands.OutputType) {},
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !cmd.Data.IsLoggedIn() {
+			continue
+		}
+
+		if !running.TryLock() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			PollTimeout*time.Second,
+		)
+		err := commands.RECIV(ctx, quiet)
+		cmd.Data.Waitlist.Cancel(cancel)
+		running.Unlock()
+
+		if err == nil || errors.Is(err, spec.ErrorEmpty) {
+			continue
+		}
+
+		if cmd.Static.Verbose {
+			// Removes prompt line
+			fmt.Print("\r\033[K")
+			fmt.Printf("[ERROR] automatic RECIV: %s\n", err)
+			PrintPrompt(cmd.Data)
+		}
 	}
 }
 
@@ -191,33 +323,85 @@ func HOOKHandler(cmd commands.Command) {
 
 // Shell-specific SHTDWN handler. Listens
 // constantly for incoming SHTDWN packets
-// and prints a notice about them
+// and prints a notice about them. A SHTDWN
+// without arguments cancels the pending one.
 func SHTDWNHandler(cmd commands.Command) {
+	var pending *time.Timer
 	for {
 		shtdwn, _ := cmd.Data.Waitlist.Get(
 			context.Background(),
 			commands.Find(0, spec.SHTDWN),
 		)
 
+		// Any new packet replaces the previous shutdown
+		if pending != nil {
+			pending.Stop()
+			pending = nil
+		}
+
+		if len(shtdwn.Args) == 0 {
+			printShutdownCancel(cmd)
+			continue
+		}
+
 		stamp, _ := spec.BytesToUnixStamp(shtdwn.Args[0])
 		diff := time.Until(stamp)
-		printShutdown(int(diff), cmd)
+		printShutdown(int(diff.Seconds()), cmd)
 
-		time.Sleep(diff)
-		cmd.Output("Server shutdown incoming. Disconnecting...", commands.INFO)
-		commands.DISCN(cmd)
+		pending = time.AfterFunc(diff, func() {
+			cmd.Output("Server shutdown incoming. Disconnecting...", commands.INFO)
+			commands.DISCN(cmd)
+		})
+	}
+}
+
+// Shell-specific SIGNAL handler. Listens
+// constantly for incoming SIGNAL packets
+// and prints the data as it was received.
+func SIGNALHandler(cmd commands.Command) {
+	for {
+		signal, err := commands.ReceiveSignal(context.Background(), cmd)
+		if err != nil {
+			continue
+		}
+		printSignal(signal, cmd)
 	}
 }
 
 // Prints a received message in the shell
-func printMessage(reciv spec.Command, decryptedText string, cmd commands.Command) {
+func printMessage(reciv spec.Command, msg commands.Message, cmd commands.Command) {
 	stamp, _ := spec.BytesToUnixStamp(reciv.Args[1])
+
+	// Copies of sent messages do not ring the bell
+	if msg.Recipient != "" {
+		fmt.Print("\r\033[K")
+		fmt.Printf("\033[36m[%s] \033[32m%s -> %s\033[0m: %s\n", stamp.String(), msg.Sender, msg.Recipient, typedContent(msg))
+		PrintPrompt(cmd.Data)
+		return
+	}
+
 	// Removes prompt line and rings bell
 	fmt.Print("\r\033[K\a")
-	fmt.Printf("\033[36m[%s] \033[32m%s\033[0m: %s\n", stamp.String(), reciv.Args[0], decryptedText)
+	fmt.Printf("\033[36m[%s] \033[32m%s\033[0m: %s\n", stamp.String(), reciv.Args[0], typedContent(msg))
 	PrintPrompt(cmd.Data)
 }
 
+// Returns the content of a message marked according to its type
+func typedContent(msg commands.Message) string {
+	switch msg.Type {
+	case spec.MsgAction:
+		return "* " + msg.Content
+	case spec.MsgReaction:
+		return "reacted with " + msg.Content
+	case spec.MsgEdit:
+		return msg.Content + " (edited)"
+	case spec.MsgBroadcast:
+		return "\033[0;31m[BROADCAST]\033[0m " + msg.Content
+	}
+
+	return msg.Content
+}
+
 // Prints a received hook in the shell
 func printHook(hook spec.Command, cmd commands.Command) {
 	// Removes prompt line and rings bell
@@ -229,6 +413,14 @@ func printHook(hook spec.Command, cmd commands.Command) {
 	PrintPrompt(cmd.Data)
 }
 
+// Prints received signaling data in the shell
+func printSignal(signal commands.Signal, cmd commands.Command) {
+	// Removes prompt line
+	fmt.Print("\r\033[K")
+	fmt.Printf("\033[0;33m[SIGNAL] \033[32m%s\033[0m: %s\n", signal.Sender, signal.Data)
+	PrintPrompt(cmd.Data)
+}
+
 // Prints a shutdown notice
 func printShutdown(count int, cmd commands.Command) {
 	// Removes prompt line and rings bell
@@ -239,3 +431,34 @@ func printShutdown(count int, cmd commands.Command) {
 
 	PrintPrompt(cmd.Data)
 }
+
+// Prints why the connection with the server was closed,
+// unless it was closed manually
+func printDisconnect(cmd commands.Command, reason error) {
+	if reason == nil || errors.Is(reason, spec.ErrorDisconnected) {
+		return
+	}
+
+	// Removes prompt line
+	fmt.Print("\r\033[K")
+
+	var kick commands.KickError
+	if errors.As(reason, &kick) {
+		fmt.Printf("\033[0;31m[DISCN] \033[0mNotice: Connection closed as you were %s\n", kick)
+	} else {
+		fmt.Printf("\033[0;31m[DISCN] \033[0mNotice: Connection closed due to %s\n", reason)
+	}
+
+	PrintPrompt(cmd.Data)
+}
+
+// Prints a shutdown cancellation notice
+func printShutdownCancel(cmd commands.Command) {
+	// Removes prompt line and rings bell
+	fmt.Print("\r\033[K\a")
+
+	fmt.Printf("\033[0;31m[SHTDWN] \033[0mNotice: Scheduled shutdown of server %s has been cancelled\n",
+		cmd.Data.Server.Name)
+
+	PrintPrompt(cmd.Data)
+}
@@ -3,47 +3,424 @@ package commands
 // Contains auxiliary functions that make certain commands work
 
 import (
+	"bytes"
 	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/Sprinter05/gochat/client/db"
 	"github.com/Sprinter05/gochat/internal/models"
 	"github.com/Sprinter05/gochat/internal/spec"
+	"golang.org/x/crypto/bcrypt"
 )
 
 /* HELPER FUNCTIONS */
 
-// Requests the user logged in to get its permissions
-func GetPermissions(ctx context.Context, cmd Command, uname string) (uint, error) {
-	id := cmd.Data.NextID()
-	packet, err := spec.NewPacket(
-		spec.REQ,
-		id,
-		spec.EmptyInfo,
-		[]byte(uname),
+// Adds the fingerprint of a public key in PEM format to the
+// key history of an external user if it was not the last one.
+func recordKey(cmd Command, username string, pubKeyPEM []byte, seen time.Time) error {
+	fingerprint, err := keyFingerprint(pubKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	return db.AddKeyFingerprint(
+		cmd.Static.DB,
+		username,
+		fingerprint,
+		seen,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+}
+
+// Formats a SHA-256 fingerprint of the given bytes
+// as colon separated hexadecimal pairs.
+func formatFingerprint(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	hex := make([]string, 0, len(sum))
+	for _, v := range sum {
+		hex = append(hex, fmt.Sprintf("%02X", v))
+	}
+	return strings.Join(hex, ":")
+}
+
+// Returns the fingerprint of a public key in PEM format,
+// computed over its DER encoding.
+func keyFingerprint(pubKeyPEM []byte) (string, error) {
+	block, _ := pem.Decode(pubKeyPEM)
+	if block == nil {
+		return "", spec.ErrorCorrupted
+	}
+
+	return formatFingerprint(block.Bytes), nil
+}
+
+// Makes sure the public key of a user is stored, requesting
+// it first if automatic requests are enabled. Fails with
+// ErrorUserNotFound if the key is missing and cannot be requested.
+func AutoREQ(ctx context.Context, cmd Command, username string) error {
+	found, err := db.ExternalUserExists(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
 	)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	_, err = cmd.Data.Conn.Write(packet)
+	if found {
+		return nil
+	}
+
+	if !cmd.Static.AutoRequest {
+		return ErrorUserNotFound
+	}
+
+	_, err = REQ(ctx, cmd, username)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("%w: %s", ErrorRequestFailed, err)
 	}
 
-	verbosePrint("querying permissions...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.REQ, spec.ERR),
+	return nil
+}
+
+// Sends a copy of a message to the logged in user itself,
+// encrypted with its own key, so that the server keeps it
+// for the other devices of the user along with its recipient.
+func sendCopy(ctx context.Context, cmd Command, username string, text []byte, stamp time.Time, msgType spec.MsgType) error {
+	prvKey, err := spec.PEMToPrivkey([]byte(cmd.Data.LocalUser.PrvKey))
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := spec.EncryptText(text, &prvKey.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = cmd.Request(
+		ctx, spec.MSG, msgTypeInfo(msgType),
+		[]byte(cmd.Data.LocalUser.User.Username),
+		spec.UnixStampToBytes(stamp),
+		encrypted,
+		[]byte(username),
+	)
+	return err
+}
+
+// Returns the information field for a message of the given type.
+// Plain text is sent without one so that older servers accept it.
+func msgTypeInfo(msgType spec.MsgType) byte {
+	if msgType == spec.MsgText {
+		return spec.EmptyInfo
+	}
+	return byte(msgType)
+}
+
+// Returns the fingerprint of the stored public key of a user
+// if no message has been exchanged with it yet, so that it can
+// be checked before the first message is sent.
+func FirstContact(cmd Command, username string) (string, bool, error) {
+	if !cmd.Data.IsLoggedIn() {
+		return "", false, ErrorNotLoggedIn
+	}
+
+	ext, err := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
 	)
 	if err != nil {
-		return 0, err
+		return "", false, ErrorUserNotFound
+	}
+
+	found, err := db.HasMessages(
+		cmd.Static.DB,
+		cmd.Data.LocalUser.User.Username,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	if found {
+		return "", false, nil
+	}
+
+	fingerprint, err := keyFingerprint([]byte(ext.PubKey))
+	if err != nil {
+		return "", false, err
+	}
+
+	return fingerprint, true, nil
+}
+
+// Reads a private key from the "import" folder, checking that
+// it is an RSA key in PEM PKCS1 format that is not corrupted.
+func readImportKey(cmd Command, dir string) ([]byte, *rsa.PrivateKey, error) {
+	if _, err := os.Stat("import"); errors.Is(err, fs.ErrNotExist) {
+		cmd.Output("missing 'import' folder", ERROR)
+		return nil, nil, err
+	}
+
+	buf, err := os.ReadFile(path.Join("import", dir))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, nil, ErrorNotPEM
+	}
+
+	if block.Type != "RSA PRIVATE KEY" {
+		return nil, nil, fmt.Errorf("%w, found %s", ErrorWrongKeyType, block.Type)
+	}
+
+	priv, err := spec.PEMToPrivkey(buf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrorCorruptedKey, err)
+	}
+
+	if err := priv.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrorCorruptedKey, err)
+	}
+
+	return buf, priv, nil
+}
+
+// Returns the profile bio included in a REQ reply, without
+// any control character, or an empty string if there is none.
+func replyBio(reply spec.Command) string {
+	if len(reply.Args) <= spec.ClientArgs(spec.REQ) {
+		return ""
+	}
+
+	bio := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, string(reply.Args[3]))
+
+	return strings.TrimSpace(bio)
+}
+
+// Connects to a server only to read its greeting and returns what
+// it advertises. The connection is closed before returning.
+func probe(cmd Command, address string, port uint16, useTLS bool, noVerify bool) (ProbeResult, error) {
+	socket := net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10))
+	result := ProbeResult{Socket: socket}
+	timeout := time.Duration(ProbeTimeout) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+	start := time.Now()
+
+	verbosePrint("connecting to "+socket+"...", cmd)
+	var endpoint net.Conn
+	if useTLS {
+		con, err := tls.DialWithDialer(dialer, "tcp", socket, &tls.Config{
+			InsecureSkipVerify: noVerify,
+		})
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return result, fmt.Errorf("%w: %s", ErrorCertificate, certErr.Err)
+		}
+		if err != nil {
+			return result, fmt.Errorf("%w: %s", ErrorUnreachable, err)
+		}
+		st := con.ConnectionState()
+		endpoint, result.TLS = con, &st
+	} else {
+		con, err := dialer.Dial("tcp", socket)
+		if err != nil {
+			return result, fmt.Errorf("%w: %s", ErrorUnreachable, err)
+		}
+		endpoint = con
+	}
+	defer endpoint.Close()
+
+	verbosePrint("waiting for the server greeting...", cmd)
+	endpoint.SetReadDeadline(time.Now().Add(timeout))
+	hdr := make([]byte, spec.HeaderSize+2)
+	if _, err := io.ReadFull(endpoint, hdr); err != nil {
+		return result, fmt.Errorf("%w: no greeting received", ErrorNotGochat)
+	}
+	result.Latency = time.Since(start)
+
+	// Any other service would not send a gochat header
+	hello := spec.Command{HD: spec.NewHeader(hdr)}
+	isHeader := string(hdr[spec.HeaderSize:]) == "\r\n"
+	isGreeting := hello.HD.Op == spec.HELLO || hello.HD.Op == spec.ERR
+	if !isHeader || !isGreeting {
+		return result, fmt.Errorf("%w: invalid greeting received", ErrorNotGochat)
+	}
+
+	// Checked before the payload as other versions may use other formats
+	if hello.HD.Ver != spec.ProtocolVersion {
+		return result, fmt.Errorf(
+			"%w: server uses version %d and client uses version %d",
+			ErrorIncompatibleVersion, hello.HD.Ver, spec.ProtocolVersion,
+		)
+	}
+
+	if hello.HD.Args != 0 && hello.HD.Len != 0 {
+		if int(hello.HD.Len) > spec.MaxPayload {
+			return result, fmt.Errorf("%w: invalid greeting received", ErrorNotGochat)
+		}
+
+		conn := spec.NewConnection(endpoint, useTLS)
+		if err := hello.ListenPayload(conn); err != nil {
+			return result, fmt.Errorf("%w: incomplete greeting received", ErrorNotGochat)
+		}
+	}
+
+	if hello.HD.Op == spec.ERR {
+		err := spec.ErrorCodeToError(hello.HD.Info, hello.Args...)
+		return result, fmt.Errorf("server refused the connection: %w", err)
+	}
+
+	if spec.ValidateClientCommand(hello) != nil || hello.HD.Op != spec.HELLO {
+		return result, fmt.Errorf("%w: invalid greeting received", ErrorNotGochat)
+	}
+
+	result.Protocol = hello.HD.Ver
+	result.Banner = len(hello.Args) > 1 && len(hello.Args[1]) > 0
+	if len(hello.Args) > 2 {
+		result.Version = string(hello.Args[2])
+	}
+	if len(hello.Args) > 3 {
+		list, err := spec.BytesToListing(hello.Args[3])
+		if err == nil {
+			result.Listing = &list
+		}
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return 0, spec.ErrorCodeToError(reply.HD.Info)
+	return result, nil
+}
+
+// Removes control characters other than newlines
+// and tabs from an announcement sent by a server.
+func cleanAnnouncement(text string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.TrimSpace(clean)
+}
+
+// Keeps an announcement of a server in its history. Failing
+// to store it is only reported, as it has already been shown.
+func storeAnnouncement(cmd Command, server db.Server, kind db.AnnouncementKind, sender string, text string) {
+	text = cleanAnnouncement(text)
+	if text == "" {
+		return
+	}
+
+	_, err := db.AddAnnouncement(
+		cmd.Static.DB,
+		db.Announcement{
+			Kind:   kind,
+			Sender: sender,
+			Text:   text,
+			Stamp:  time.Now(),
+		},
+		server.Address,
+		server.Port,
+	)
+	if err != nil {
+		cmd.Output(fmt.Sprintf("failed to store announcement: %s", err), ERROR)
+	}
+}
+
+// Parses the optional arguments of a recovery, which are "-cleanup",
+// "-format <text|json>", "-style <plain|ansi|markdown>" and "-tz <timezone>".
+func ParseRecoverOptions(args []string) (RecoverOptions, error) {
+	opts := RecoverOptions{
+		Format:   TEXT_EXPORT,
+		Style:    PLAIN_STYLE,
+		Location: time.Local,
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-cleanup":
+			opts.Cleanup = true
+		case "-format":
+			if i+1 >= len(args) {
+				return opts, ErrorInsuficientArgs
+			}
+			i++
+
+			format := RecoverFormat(args[i])
+			if format != TEXT_EXPORT && format != JSON_EXPORT {
+				return opts, ErrorUnknownFormat
+			}
+			opts.Format = format
+		case "-style":
+			if i+1 >= len(args) {
+				return opts, ErrorInsuficientArgs
+			}
+			i++
+
+			style := RecoverStyle(args[i])
+			if style != PLAIN_STYLE && style != ANSI_STYLE && style != MARKDOWN_STYLE {
+				return opts, ErrorUnknownStyle
+			}
+			opts.Style = style
+		case "-tz":
+			if i+1 >= len(args) {
+				return opts, ErrorInsuficientArgs
+			}
+			i++
+
+			loc, err := time.LoadLocation(args[i])
+			if err != nil {
+				return opts, ErrorUnknownTimezone
+			}
+			opts.Location = loc
+		}
+	}
+
+	return opts, nil
+}
+
+// Requests the user logged in to get its permissions
+func GetPermissions(ctx context.Context, cmd Command, uname string) (uint, error) {
+	verbosePrint("querying permissions...", cmd)
+	reply, err := cmd.Request(
+		ctx, spec.REQ, spec.EmptyInfo,
+		[]byte(uname),
+	)
+	if err != nil {
+		return 0, err
 	}
 
 	perms, err := spec.BytesToPermission(reply.Args[2])
@@ -56,17 +433,29 @@ func GetPermissions(ctx context.Context, cmd Command, uname string) (uint, error
 
 // Performs the necessary operations to store a RECIV
 // packet in the database (decryption, REQ (if necessary)
-// insert...), then returns the decrypted message
+// insert...), then returns the decrypted message. Copies
+// of messages sent from other devices come from the logged
+// in user and are stored as sent to their recipient.
 func StoreMessage(ctx context.Context, reciv spec.Command, cmd Command) (Message, error) {
-	src, err := db.GetUser(
+	sender := string(reciv.Args[0])
+	recipient := cmd.Data.LocalUser.User.Username
+	other := sender
+
+	isCopy := sender == recipient && len(reciv.Args) > 4
+	if isCopy {
+		recipient = string(reciv.Args[4])
+		other = recipient
+	}
+
+	_, err := db.GetUser(
 		cmd.Static.DB,
-		string(reciv.Args[0]),
+		other,
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
 	if err != nil {
 		// The user most likely has not been found, so a REQ is required
-		_, reqErr := REQ(ctx, cmd, string(reciv.Args[0]))
+		_, reqErr := REQ(ctx, cmd, other)
 		if reqErr != nil {
 			return Message{}, reqErr
 		}
@@ -87,24 +476,83 @@ func StoreMessage(ctx context.Context, reciv spec.Command, cmd Command) (Message
 		return Message{}, parseErr
 	}
 
-	_, insertErr := db.StoreMessage(
+	// Broadcasts can only come from the server itself
+	msgType := spec.InfoToMsgType(reciv.HD.Info)
+	if msgType == spec.MsgBroadcast && isCopy {
+		msgType = spec.MsgText
+	}
+
+	// The type already marks it so the prefix is not needed
+	if msgType == spec.MsgBroadcast {
+		decrypted = bytes.TrimPrefix(decrypted, []byte(spec.BroadcastPrefix))
+	}
+
+	// Only messages sent during a catch up have a sequence
+	var seq uint64
+	if len(reciv.Args) > 3 {
+		seq, parseErr = spec.BytesToSequence(reciv.Args[3])
+		if parseErr != nil {
+			return Message{}, parseErr
+		}
+	}
+
+	stored, insertErr := db.StoreMessage(
 		cmd.Static.DB,
-		src.Username,
-		cmd.Data.LocalUser.User.Username,
+		sender,
+		recipient,
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 		string(decrypted),
 		stamp,
+		seq,
+		uint8(msgType),
 	)
 	if insertErr != nil {
 		return Message{}, insertErr
 	}
 
-	return Message{
-		Sender:    string(reciv.Args[0]),
+	// Only kept for diagnosing messages that were decrypted wrong
+	if cmd.Static.Verbose && stored.MessageID != 0 {
+		cipherErr := db.SetMessageCiphertext(
+			cmd.Static.DB,
+			stored.MessageID,
+			reciv.Args[2],
+		)
+		if cipherErr != nil {
+			return Message{}, cipherErr
+		}
+	}
+
+	event := CommandEvent{
+		Type:     EVENT_RECEIVED,
+		Op:       spec.RECIV,
+		Server:   cmd.Data.Server.Name,
+		Username: sender,
+		Message:  stored.MessageID,
+	}
+	msg := Message{
+		ID:        stored.MessageID,
+		Sender:    sender,
 		Content:   string(decrypted),
 		Timestamp: stamp,
-	}, nil
+		Sequence:  seq,
+		Type:      msgType,
+	}
+
+	// Broadcasts are kept apart from the conversation as well
+	if msgType == spec.MsgBroadcast {
+		storeAnnouncement(cmd, *cmd.Data.Server, db.BroadcastAnnouncement, sender, string(decrypted))
+	}
+
+	// Copies are shown as messages sent to the recipient
+	if isCopy {
+		event.Type = EVENT_SENT
+		event.Username = recipient
+		msg.Recipient = recipient
+	}
+
+	cmd.emit(event)
+	return msg, nil
 }
 
 /* AUXILIARY FUNCTIONS */
@@ -120,6 +568,13 @@ func stringToValue(val string, ref reflect.Value) any {
 
 	// Parse as boolean
 	if kind == reflect.Bool {
+		switch strings.ToLower(val) {
+		case "on":
+			return true
+		case "off":
+			return false
+		}
+
 		asBool, err := strconv.ParseBool(val)
 		if err == nil {
 			return asBool
@@ -182,48 +637,186 @@ func stringToValue(val string, ref reflect.Value) any {
 	return nil
 }
 
-// Tries to log in using a reusable token if applicable
-func tokenLogin(ctx context.Context, cmd Command, username string) error {
-	id := cmd.Data.NextID()
-
+// Tries to log in using a reusable token if applicable,
+// returning the reply of the server if it succeeds
+func tokenLogin(ctx context.Context, cmd Command, username string) (spec.Command, error) {
 	token, ok := cmd.Data.GetToken()
 	if !ok {
-		return ErrorNoReusableToken
+		return spec.Command{}, ErrorNoReusableToken
 	}
 
-	pct, err := spec.NewPacket(
-		spec.LOGIN, id,
-		spec.EmptyInfo,
+	reply, err := cmd.Request(
+		ctx, spec.LOGIN, spec.EmptyInfo,
 		[]byte(username),
 		[]byte(token),
 	)
+	if err != nil {
+		if reply.HD.Op == spec.ERR {
+			cmd.Data.ClearToken()
+		}
+		return spec.Command{}, err
+	}
+
+	if reply.HD.Op != spec.OK {
+		return spec.Command{}, spec.ErrorPacket
+	}
+
+	return reply, nil
+}
+
+// Informs of the messages received while offline and of the
+// usage of the message cache if the server included them
+// when confirming a login.
+func pendingPrint(reply spec.Command, cmd Command) {
+	if len(reply.Args) == 0 {
+		return
+	}
+
+	count, err := spec.BytesToCount(reply.Args[0])
+	if err == nil && count != 0 {
+		str := fmt.Sprintf(
+			"You have %d pending messages received while offline",
+			count,
+		)
+		cmd.Output(str, INFO)
+	}
+
+	// Only sent by servers with a quota
+	if len(reply.Args) < 3 {
+		return
+	}
+
+	used, usedErr := spec.BytesToCount(reply.Args[1])
+	quota, quotaErr := spec.BytesToCount(reply.Args[2])
+	if usedErr != nil || quotaErr != nil || quota == 0 {
+		return
+	}
+
+	str := fmt.Sprintf(
+		"Your message cache uses %s out of %s (%d%%)",
+		formatSize(int64(used)), formatSize(int64(quota)), used*100/quota,
+	)
+	cmd.Output(str, INFO)
+}
+
+// Formats an amount of bytes using the
+// biggest unit that keeps it over 1.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// Returns the first line of a message, cut to at most
+// PreviewLength characters, to refer to it in the output.
+func previewText(text string) string {
+	line, _, more := strings.Cut(text, "\n")
+	runes := []rune(line)
+	if len(runes) > PreviewLength {
+		runes, more = runes[:PreviewLength], true
+	}
+
+	if more {
+		return strconv.Quote(string(runes) + "...")
+	}
+	return strconv.Quote(string(runes))
+}
+
+// Sends the registration of an existing local user again using its
+// stored key pair, in case a previous registration was not confirmed.
+// The password must be the one of the local user. If the user is
+// already registered in the server ErrorUserExists is returned.
+func retryRegistration(ctx context.Context, cmd Command, username, pass string) error {
+	localUser, err := db.GetLocalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
 	if err != nil {
 		return err
 	}
 
-	packetPrint(pct, cmd)
+	// Another password means it is not the same registration
+	hash := []byte(localUser.Password)
+	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	if cmpErr != nil {
+		return ErrorUserExists
+	}
 
-	_, err = cmd.Data.Conn.Write(pct)
+	verbosePrint("decrypting private key...", cmd)
+	dec, err := db.DecryptData([]byte(pass), []byte(localUser.PrvKey))
 	if err != nil {
 		return err
 	}
 
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
+	pair, err := spec.PEMToPrivkey(dec)
 	if err != nil {
 		return err
 	}
 
-	if reply.HD.Op == spec.ERR {
-		cmd.Data.ClearToken()
-		return spec.ErrorCodeToError(reply.HD.Info)
+	pubKeyPEM, err := spec.PubkeytoPEM(&pair.PublicKey)
+	if err != nil {
+		return err
 	}
 
+	verbosePrint("retrying registration with the stored key pair...", cmd)
+	_, err = cmd.Request(
+		ctx, spec.REG, spec.EmptyInfo,
+		[]byte(username), pubKeyPEM,
+	)
+	if errors.Is(err, spec.ErrorExists) {
+		cmd.Output(fmt.Sprintf(
+			"user %s is already registered in the server, log in to check that it uses the stored key pair",
+			username,
+		), INFO)
+		return ErrorUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd.Output(fmt.Sprintf(
+		"user %s successfully registered using the stored key pair",
+		username,
+	), RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_REGISTERED,
+		Op:       spec.REG,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
 	return nil
 }
 
+// Encrypts a decrypted private key again using the
+// current encryption format and stores it.
+func upgradeKey(cmd Command, lu db.LocalUser, pass string, dec []byte) error {
+	verbosePrint("upgrading private key format...", cmd)
+	enc, err := db.EncryptData([]byte(pass), dec)
+	if err != nil {
+		return err
+	}
+
+	return db.ChangeLocalUserPassword(
+		cmd.Static.DB,
+		lu.User.Username,
+		lu.Password,
+		string(enc),
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+}
+
 /* PRINTING FUNCTIONS */
 
 // Prints out all local users on the current server and
@@ -332,6 +925,50 @@ func packetPrint(pct []byte, cmd Command) {
 	cmd.Output(str, PACKET)
 }
 
+// Returns a copy of the packet where arguments that are not printable
+// text are replaced by their length and first bytes in hexadecimal.
+func printableArgs(pct spec.Command) spec.Command {
+	const prefix = 16
+
+	args := make([][]byte, 0, len(pct.Args))
+	for _, v := range pct.Args {
+		printable := utf8.Valid(v) && !slices.ContainsFunc([]rune(string(v)), func(r rune) bool {
+			return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+		})
+		if printable {
+			args = append(args, v)
+			continue
+		}
+
+		shown := v[:min(len(v), prefix)]
+		hex := fmt.Sprintf("<%d bytes: %x", len(v), shown)
+		if len(v) > prefix {
+			hex += "..."
+		}
+		args = append(args, []byte(hex+">"))
+	}
+
+	pct.Args = args
+	return pct
+}
+
+// Returns a copy of the packet where the arguments that carry
+// credentials, which are the answer to the challenge in VERIF
+// and the reusable token in LOGIN, only show their length.
+func redactedArgs(pct spec.Command) spec.Command {
+	if pct.HD.Op != spec.VERIF && pct.HD.Op != spec.LOGIN {
+		return pct
+	}
+
+	args := slices.Clone(pct.Args)
+	for i := 1; i < len(args); i++ {
+		args[i] = fmt.Appendf(nil, "<redacted %d bytes>", len(args[i]))
+	}
+
+	pct.Args = args
+	return pct
+}
+
 // Prints text if the verbose mode is on.
 func verbosePrint(text string, args Command) {
 	if args.Static.Verbose {
@@ -339,6 +976,159 @@ func verbosePrint(text string, args Command) {
 	}
 }
 
+/* EXPORT FUNCTIONS */
+
+// Self-contained template used to export a conversation
+// as HTML. It must not reference any external resource.
+var htmlTranscript = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Conversation between {{.Local}} and {{.Remote}}</title>
+<style>
+body { background: #1e1e2e; color: #cdd6f4; font-family: sans-serif; margin: 0; padding: 2em; }
+h1 { font-size: 1.2em; text-align: center; }
+.conversation { display: flex; flex-direction: column; max-width: 48em; margin: auto; }
+.bubble { border-radius: 1em; margin: 0.3em 0; max-width: 70%; padding: 0.6em 1em; white-space: pre-wrap; word-wrap: break-word; }
+.sent { align-self: flex-end; background: #2a6f97; }
+.received { align-self: flex-start; background: #45475a; }
+.meta { font-size: 0.75em; opacity: 0.7; margin-bottom: 0.3em; }
+</style>
+</head>
+<body>
+<h1>Conversation between {{.Local}} and {{.Remote}} on {{.Server}}</h1>
+<div class="conversation">
+{{- range .Messages}}
+<div class="bubble {{if .Sent}}sent{{else}}received{{end}}"><div class="meta">{{.Sender}} &middot; {{.Stamp}}</div>{{.Text}}</div>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+// Data used to fill the HTML transcript template
+type htmlData struct {
+	Local    string
+	Remote   string
+	Server   string
+	Messages []htmlMessage
+}
+
+// Message as shown in the HTML transcript
+type htmlMessage struct {
+	Sender string
+	Stamp  string
+	Text   string
+	Sent   bool
+}
+
+// Writes a conversation as an HTML transcript. All
+// content is escaped by the template package.
+func renderHTML(w io.Writer, local, remote, server string, msgs []db.Message) error {
+	data := htmlData{
+		Local:    local,
+		Remote:   remote,
+		Server:   server,
+		Messages: make([]htmlMessage, 0, len(msgs)),
+	}
+
+	for _, v := range msgs {
+		data.Messages = append(data.Messages, htmlMessage{
+			Sender: v.SourceUser.Username,
+			Stamp:  v.Stamp.Local().Format(time.DateTime),
+			Text:   v.Text,
+			Sent:   v.SourceUser.Username == local,
+		})
+	}
+
+	return htmlTranscript.Execute(w, data)
+}
+
+// Writes recovered conversations as text, delimiting each
+// conversation and writing one message per line. Newlines in
+// the text of a message are escaped to keep it in one line.
+// Messages sent by the local user are told apart from received
+// ones according to the style.
+func renderRecoveredText(w io.Writer, local string, convos [][]db.Message, loc *time.Location, style RecoverStyle) {
+	for _, v := range convos {
+		fmt.Fprintln(w, "--- CONVERSATION BEGINS ---")
+		for _, m := range v {
+			stamp := m.Stamp.In(loc).Format(RecoverStamp)
+			users := fmt.Sprintf(
+				"[%s] -> [%s]",
+				m.SourceUser.Username,
+				m.DestinationUser.Username,
+			)
+			text := strings.ReplaceAll(m.Text, "\n", "\\n")
+			sent := m.SourceUser.Username == local
+
+			switch style {
+			case ANSI_STYLE:
+				color := "\033[36m" // Cyan
+				if sent {
+					color = "\033[32m" // Green
+				}
+				users = color + users + "\033[0m"
+			case MARKDOWN_STYLE:
+				emphasis := "*"
+				if sent {
+					emphasis = "**"
+				}
+				users = emphasis + users + emphasis
+				stamp = "- `" + stamp + "`"
+			}
+
+			fmt.Fprintf(w, "%s | %s: %s\n", stamp, users, text)
+		}
+		fmt.Fprintln(w, "--- CONVERSATION FINISH ---")
+	}
+}
+
+// Conversation as exported in JSON
+type jsonConversation struct {
+	With     string        `json:"with"`
+	Messages []jsonMessage `json:"messages"`
+}
+
+// Message as exported in JSON
+type jsonMessage struct {
+	Timestamp   string `json:"timestamp"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Text        string `json:"text"`
+}
+
+// Writes the recovered conversations of a local user as a JSON list of
+// conversations with timestamps in RFC 3339, which includes the offset from UTC.
+func renderRecoveredJSON(w io.Writer, local string, convos [][]db.Message, loc *time.Location) error {
+	list := make([]jsonConversation, 0, len(convos))
+	for _, v := range convos {
+		convo := jsonConversation{
+			Messages: make([]jsonMessage, 0, len(v)),
+		}
+
+		for _, m := range v {
+			convo.With = m.SourceUser.Username
+			if convo.With == local {
+				convo.With = m.DestinationUser.Username
+			}
+
+			convo.Messages = append(convo.Messages, jsonMessage{
+				Timestamp:   m.Stamp.In(loc).Format(time.RFC3339),
+				Source:      m.SourceUser.Username,
+				Destination: m.DestinationUser.Username,
+				Text:        m.Text,
+			})
+		}
+
+		list = append(list, convo)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
 /* WAITLIST FUNCTIONS */
 
 // Returns a function that returns true if the received command fulfills
@@ -368,3 +1158,45 @@ func DefaultWaitlist() models.Waitlist[spec.Command] {
 		}
 	})
 }
+
+// Prints the users affected by a prune operation
+// as given in the reply sent by the server.
+func printPruned(reply spec.Command, dry bool, cmd Command) {
+	if len(reply.Args) == 0 {
+		return
+	}
+
+	count, err := spec.BytesToCount(reply.Args[0])
+	if err != nil {
+		return
+	}
+
+	verb := "pruned"
+	if dry {
+		verb = "would be pruned"
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "%d users %s", count, verb)
+	if len(reply.Args) > 1 {
+		output.WriteString(":\n")
+		output.Write(reply.Args[1])
+	}
+
+	cmd.Output(output.String(), INFO)
+}
+
+// Prints the permission level of a user included
+// in the reply to an ADMIN_GETPERMS operation.
+func printPerms(reply spec.Command, username string, cmd Command) {
+	if len(reply.Args) == 0 {
+		return
+	}
+
+	perms, err := spec.BytesToPermission(reply.Args[0])
+	if err != nil {
+		return
+	}
+
+	cmd.Output(fmt.Sprintf("permission level of %s is %d", username, perms), INFO)
+}
@@ -8,16 +8,23 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"os"
 	"path"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sprinter05/gochat/client/db"
+	"github.com/Sprinter05/gochat/internal/models"
 	"github.com/Sprinter05/gochat/internal/spec"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -45,6 +52,37 @@ const (
 // when outputting information
 type OutputFunc func(text string, outputType OutputType)
 
+// Represents the type of a structured command event
+type EventType uint
+
+const (
+	EVENT_ERROR        EventType = iota // The server replied with an error
+	EVENT_CONNECTED                     // Connected to a server
+	EVENT_DISCONNECTED                  // Disconnected from a server
+	EVENT_REGISTERED                    // A local user has been registered in the server
+	EVENT_DEREGISTERED                  // A local user has been deregistered
+	EVENT_LOGGED_IN                     // A local user has logged in
+	EVENT_LOGGED_OUT                    // The logged in user has logged out
+	EVENT_REQUESTED                     // The public key of a user has been stored
+	EVENT_SENT                          // A message has been sent and stored
+	EVENT_RECEIVED                      // A message has been received and stored
+	EVENT_SIGNALED                      // Signaling data has been received
+	EVENT_IMPORTED                      // A local user has been imported from a key
+)
+
+// Typed result of a command, sent to Events in Command
+// so that it can be consumed without parsing the output.
+// Fields that do not apply to the event are left empty.
+type CommandEvent struct {
+	Type     EventType   // What happened
+	Op       spec.Action // Operation that caused it, NullOp if none
+	Server   string      // Name of the server
+	Username string      // User the event refers to
+	Message  uint        // Database identifier of the message
+	Code     uint8       // Specification error code if it is an error
+	Err      error       // Error returned by the server
+}
+
 // Represents the different USRS command types
 type USRSType uint
 
@@ -58,6 +96,66 @@ const (
 	REQUESTED    USRSType = 6 // All external users whose public key has been saved
 )
 
+// Represents the formats recovered messages can be exported in
+type RecoverFormat string
+
+const (
+	TEXT_EXPORT RecoverFormat = "text" // Delimited conversations, one message per line
+	JSON_EXPORT RecoverFormat = "json" // List of conversations as JSON
+)
+
+// Represents the styles used to distinguish sent and
+// received messages when exporting them as text
+type RecoverStyle string
+
+const (
+	PLAIN_STYLE    RecoverStyle = "plain"    // No distinction between messages
+	ANSI_STYLE     RecoverStyle = "ansi"     // Colored with ANSI codes, to be read with a pager such as "less -R"
+	MARKDOWN_STYLE RecoverStyle = "markdown" // Markdown list with sent messages in bold
+)
+
+// Specifies how the data of a recovered user is exported
+type RecoverOptions struct {
+	Cleanup  bool           // Whether to delete the user after recovering it
+	Format   RecoverFormat  // Format of the exported messages
+	Style    RecoverStyle   // Style of the messages exported as text
+	Location *time.Location // Timezone used for the timestamps
+}
+
+// Result of decrypting a received message again, which
+// never contains anything about the private key used.
+type Inspection struct {
+	Stored     string // Plaintext stored in the database
+	Ciphertext []byte // Ciphertext kept when receiving it, nil if it was not kept
+	Decrypted  string // Plaintext decrypted again with the current private key
+	Err        error  // Why decrypting it again failed, if it did
+}
+
+// Public key that has been seen for an external user
+type KeyRecord struct {
+	Fingerprint string    // SHA-256 fingerprint of the key
+	FirstSeen   time.Time // When it was first seen, zero if unknown
+	Current     bool      // Whether it is the key currently stored
+}
+
+// Outcome of probing a server without logging in
+type ProbeResult struct {
+	Name     string               // Name of the server if it is stored
+	Socket   string               // Address and port of the server
+	Err      error                // Why it could not be probed, nil if it is reachable
+	Latency  time.Duration        // Time taken to connect and receive the greeting
+	Protocol uint8                // Protocol version used by the server
+	Version  string               // Version advertised by the server, empty if unknown
+	Listing  *spec.Listing        // Permissions needed to list users, nil if not advertised
+	Banner   bool                 // Whether the server sends a banner
+	TLS      *tls.ConnectionState // State of the TLS connection, nil if not in use
+}
+
+// Whether decrypting the message again gives the stored plaintext
+func (i Inspection) Matches() bool {
+	return i.Ciphertext != nil && i.Err == nil && i.Decrypted == i.Stored
+}
+
 /* ERRORS AND CONSTANTS */
 
 var (
@@ -83,11 +181,74 @@ var (
 	ErrorInvalidField          error = fmt.Errorf("provided field is non-existant")                 // provided field is non-existant
 	ErrorCannotSet             error = fmt.Errorf("failed to set a value on the given field")       // failed to set a value on the given field
 	ErrorNoReusableToken       error = fmt.Errorf("reusable token is empty")                        // reusable token is empty
+	ErrorSelfTestMismatch      error = fmt.Errorf("decrypted text does not match the original")     // decrypted text does not match the original
+	ErrorEmptyNote             error = fmt.Errorf("note cannot be empty")                           // note cannot be empty
+	ErrorInvalidCount          error = fmt.Errorf("amount must be a positive number")               // amount must be a positive number
+	ErrorEchoMismatch          error = fmt.Errorf("echoed payload does not match the one sent")     // echoed payload does not match the one sent
+	ErrorUnknownFormat         error = fmt.Errorf("unknown export format provided")                 // unknown export format provided
+	ErrorUnknownStyle          error = fmt.Errorf("unknown export style provided")                  // unknown export style provided
+	ErrorNoTrace               error = fmt.Errorf("no request has been sent to the server")         // no request has been sent to the server
+	ErrorUnreachable           error = fmt.Errorf("server is unreachable")                          // server is unreachable
+	ErrorNotGochat             error = fmt.Errorf("endpoint is not a gochat server")                // endpoint is not a gochat server
+	ErrorIncompatibleVersion   error = fmt.Errorf("server protocol version is incompatible")        // server protocol version is incompatible
+	ErrorCertificate           error = fmt.Errorf("server certificate could not be verified")       // server certificate could not be verified
+	ErrorUnknownTimezone       error = fmt.Errorf("unknown timezone provided")                      // unknown timezone provided
+	ErrorUnknownBenchmark      error = fmt.Errorf("unknown benchmark target provided")              // unknown benchmark target provided
+	ErrorRestrictedListing     error = fmt.Errorf("server restricts this list to privileged users") // server restricts this list to privileged users
+	ErrorUnknownErrorCode      error = fmt.Errorf("unknown error code provided")                    // unknown error code provided
+	ErrorMessageNotConfirmed   error = fmt.Errorf("message was not confirmed")                      // message was not confirmed
+	ErrorServerNotFound        error = fmt.Errorf("server not found")                               // server not found
+	ErrorServerNameTaken       error = fmt.Errorf("server name already in use")                     // server name already in use
+	ErrorBioTooLong            error = fmt.Errorf("bio exceeds the maximum size")                   // bio exceeds the maximum size
+	ErrorUnknownProfileOption  error = fmt.Errorf("unknown profile option provided")                // unknown profile option provided
+	ErrorRequestFailed         error = fmt.Errorf("could not request the user")                     // could not request the user
+	ErrorSignalToSelf          error = fmt.Errorf("cannot send signaling data to yourself")         // cannot send signaling data to yourself
+	ErrorNotReceived           error = fmt.Errorf("message was not received by the logged in user") // message was not received by the logged in user
+	ErrorCorruptedDatabase     error = fmt.Errorf("database is corrupted and cannot be repaired")   // database is corrupted and cannot be repaired
+	ErrorMessageTooLong        error = fmt.Errorf("message exceeds the maximum size")               // message exceeds the maximum size
+	ErrorNoLastMessage         error = fmt.Errorf("no message has been sent in this session")       // no message has been sent in this session
+	ErrorNotPEM                error = fmt.Errorf("file is not in PEM format")                      // file is not in PEM format
+	ErrorWrongKeyType          error = fmt.Errorf("file does not contain an RSA private key")       // file does not contain an RSA private key
+	ErrorCorruptedKey          error = fmt.Errorf("private key is corrupted")                       // private key is corrupted
 )
 
 // Default level of permissions that should be used
 const DefaultPerms = 0755
 
+// Text used to check that a key pair works
+const selfTestText = "gochat self test"
+
+// Maximum amount of requests that can be awaiting
+// a reply at the same time when requesting in bulk
+const MaxConcurrentRequests = 4
+
+// Amount of echoes sent by a speed
+// test if none is specified
+const DefaultSpeedtest = 10
+
+// Maximum size in bytes of the text of a message,
+// limited by the keys used to encrypt it
+const MaxMessageSize = spec.RSABitSize/8 - 2*sha256.Size - 2
+
+// Seconds to wait for a probed server
+// to connect and send its greeting
+const ProbeTimeout = 5
+
+// Targets that can be measured by a benchmark
+const BenchmarkCrypto = "crypto"
+
+// Database size in bytes from which a
+// warning is shown before vacuuming it
+const VacuumWarnSize = 64 << 20
+
+// Maximum amount of characters of a message
+// shown when it is referred to in the output
+const PreviewLength = 40
+
+// Layout of the timestamps of recovered messages,
+// which always includes the offset from UTC
+const RecoverStamp = "2006-01-02 15:04:05 -07:00"
+
 /* LOOKUP TABLES */
 
 // List of hooks and their names.
@@ -102,12 +263,17 @@ var hooksList = map[string]spec.Hook{
 // List of admin operations and their
 // names.
 var adminList = map[string]spec.Admin{
-	"shutdown":  spec.AdminShutdown,
-	"broadcast": spec.AdminBroadcast,
-	"ban":       spec.AdminDeregister,
-	"kick":      spec.AdminDisconnect,
-	"setperms":  spec.AdminChangePerms,
-	"motd":      spec.AdminMotd,
+	"shutdown":   spec.AdminShutdown,
+	"broadcast":  spec.AdminBroadcast,
+	"ban":        spec.AdminDeregister,
+	"kick":       spec.AdminDisconnect,
+	"setperms":   spec.AdminChangePerms,
+	"motd":       spec.AdminMotd,
+	"cancel":     spec.AdminCancel,
+	"expire":     spec.AdminExpire,
+	"prune":      spec.AdminPrune,
+	"getperms":   spec.AdminGetPerms,
+	"resetperms": spec.AdminResetPerms,
 }
 
 /* CLIENT COMMANDS */
@@ -199,9 +365,36 @@ func CONFIG(objs ...ConfigObj) [][]byte {
 	return buf
 }
 
+// Lists the local users whose server has been deleted,
+// which are the ones that can be recovered with RECOVER.
+// Does not require a Data struct in Command
+func DANGLING(cmd Command) error {
+	users, err := db.GetDanglingUsers(cmd.Static.DB)
+	if err != nil {
+		return err
+	}
+
+	if len(users) == 0 {
+		cmd.Output("there are no dangling users", RESULT)
+		return nil
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "%-32s %-10s %s\n", "USERNAME", "SERVER", "MESSAGES")
+	for _, v := range users {
+		fmt.Fprintf(&output,
+			"%-32s %-10s %d\n",
+			v.Username, fmt.Sprintf("#%d", v.ServerID), v.Messages,
+		)
+	}
+
+	cmd.Output(strings.TrimSuffix(output.String(), "\n"), RESULT)
+	return nil
+}
+
 // Recovers the private key and messages for a specified user
 // Does not require a Data struct in Command
-func RECOVER(cmd Command, username, pass string, cleanup bool) error {
+func RECOVER(cmd Command, username, pass string, opts RecoverOptions) error {
 	verbosePrint("recovering data...", cmd)
 	users, err := db.RecoverUsers(cmd.Static.DB, username)
 	if err != nil {
@@ -212,7 +405,7 @@ func RECOVER(cmd Command, username, pass string, cleanup bool) error {
 	attempts := 0
 
 	clean := func() {
-		if cleanup {
+		if opts.Cleanup {
 			db.CleanupUser(cmd.Static.DB, target)
 			cmd.Output("deleted user from database", RESULT)
 		}
@@ -267,24 +460,35 @@ func RECOVER(cmd Command, username, pass string, cleanup bool) error {
 		return nil
 	}
 
-	var messages strings.Builder
-	for _, v := range msgs {
-		messages.WriteString("--- CONVERSATION BEGINS ---\n")
-		for _, m := range v {
-			str := fmt.Sprintf(
-				"%s | [%s] -> [%s]: %s\n",
-				m.Stamp.Format(time.RFC850),
-				m.SourceUser.Username,
-				m.DestinationUser.Username,
-				m.Text,
-			)
-			messages.WriteString(str)
-		}
-		messages.WriteString("--- CONVERSATION FINISH ---\n")
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
 	}
 
 	msgsdir := path.Join("export", username+".msgs")
-	err = os.WriteFile(msgsdir, []byte(messages.String()), DefaultPerms)
+	switch opts.Format {
+	case TEXT_EXPORT, "":
+		if opts.Style == MARKDOWN_STYLE {
+			msgsdir = path.Join("export", username+".md")
+		}
+	case JSON_EXPORT:
+		msgsdir = path.Join("export", username+".json")
+	default:
+		return ErrorUnknownFormat
+	}
+
+	// Rendered first so that a failure does not leave an empty file
+	var messages bytes.Buffer
+	if opts.Format == JSON_EXPORT {
+		err = renderRecoveredJSON(&messages, username, msgs, loc)
+	} else {
+		renderRecoveredText(&messages, username, msgs, loc, opts.Style)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(msgsdir, messages.Bytes(), DefaultPerms)
 	if err != nil {
 		return err
 	}
@@ -301,24 +505,12 @@ func RECOVER(cmd Command, username, pass string, cleanup bool) error {
 // Imports a private RSA key for a new local user
 // from the "import" directory using the specification PEM format.
 func IMPORT(cmd Command, username, pass, dir string) error {
-	// Creates import/ directory if it does not exist
-	if _, err := os.Stat("import"); errors.Is(err, fs.ErrNotExist) {
-		cmd.Output("missing 'import' folder", ERROR)
-		return err
-	}
-
 	verbosePrint("reading private key...", cmd)
-	fulldir := path.Join("import", dir)
-	buf, readErr := os.ReadFile(fulldir)
+	buf, _, readErr := readImportKey(cmd, dir)
 	if readErr != nil {
 		return readErr
 	}
 
-	_, chkErr := spec.PEMToPrivkey(buf)
-	if chkErr != nil {
-		return chkErr
-	}
-
 	verbosePrint("hashing password...", cmd)
 	hashPass, hashErr := bcrypt.GenerateFromPassword([]byte(pass), 12)
 	if hashErr != nil {
@@ -348,6 +540,48 @@ func IMPORT(cmd Command, username, pass, dir string) error {
 		"local user %s successfully added to the database",
 		username,
 	), RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_IMPORTED,
+		Op:       spec.NullOp,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
+	return nil
+}
+
+// Checks a private key in the "import" folder and shows its
+// size and the fingerprint of its public key, without importing
+// it, so that the right file can be picked before importing.
+func KEYINFO(cmd Command, dir string) error {
+	_, priv, err := readImportKey(cmd, dir)
+	if err != nil {
+		return err
+	}
+
+	pubPEM, err := spec.PubkeytoPEM(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := keyFingerprint(pubPEM)
+	if err != nil {
+		return err
+	}
+
+	size := priv.N.BitLen()
+	var output strings.Builder
+	fmt.Fprintf(&output, "%s contains a valid private key:\n", path.Join("import", dir))
+	fmt.Fprintf(&output, "* Key size: %d bits\n", size)
+	fmt.Fprintf(&output, "* Public key fingerprint (SHA256): %s", fingerprint)
+	cmd.Output(output.String(), RESULT)
+
+	if size != spec.RSABitSize {
+		cmd.Output(fmt.Sprintf(
+			"keys used by gochat are of %d bits, servers may refuse this one",
+			spec.RSABitSize,
+		), ERROR)
+	}
+
 	return nil
 }
 
@@ -409,750 +643,1933 @@ func EXPORT(cmd Command, username, pass string) error {
 	return nil
 }
 
-// Starts a connection with a server. If noverify is set,
-// in case of TLS connections, certificate origins wont be checked.
-// This command does not spawn a listening thread.
-func CONN(cmd Command, server db.Server, noverify bool) error {
-	if cmd.Data.IsConnected() {
-		return ErrorAlreadyConnected
-	}
-
-	useTLS := server.TLS
-	skipVerify := false
-
-	if noverify {
-		if !useTLS {
-			return ErrorInvalidSkipVerify
-		}
-
-		skipVerify = true
-		verbosePrint("certificate verification is going to be skipped!", cmd)
-	}
-
-	conn, conErr := SocketConnect(
-		server.Address,
-		server.Port,
-		useTLS,
-		skipVerify,
+// Changes the password of a local user, re-encrypting
+// its private key with the new password.
+func PASSWD(cmd Command, username, oldPass, newPass string) error {
+	found, existsErr := db.LocalUserExists(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
 	)
-	if conErr != nil {
-		return conErr
-	}
-
-	err := WaitConnect(cmd, conn, server)
-	if err != nil {
-		return err
-	}
-
-	cmd.Data.Conn = conn
-
-	if cmd.Static.Verbose {
-		cmd.Output("Listening for incoming packets...", INFO)
+	if existsErr != nil {
+		return existsErr
 	}
-
-	return nil
-}
-
-// Registers a user to a server and also adds it to the client database.
-func REG(ctx context.Context, cmd Command, username, pass string) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
+	if !found {
+		return ErrorUserNotFound
 	}
 
-	exists, existsErr := db.LocalUserExists(
+	localUser, localUserErr := db.GetLocalUser(
 		cmd.Static.DB,
-		string(username),
+		username,
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
-	if existsErr != nil {
-		return existsErr
-	}
-	if exists {
-		return ErrorUserExists
+	if localUserErr != nil {
+		return localUserErr
 	}
 
-	// Generates the PEM arrays of both the private and public key of the pair
-	verbosePrint("generating RSA key pair...", cmd)
-	pair, rsaErr := rsa.GenerateKey(rand.Reader, spec.RSABitSize)
-	if rsaErr != nil {
-		return rsaErr
+	verbosePrint("checking password...", cmd)
+	hash := []byte(localUser.Password)
+	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(oldPass))
+	if cmpErr != nil {
+		return ErrorWrongCredentials
 	}
 
-	prvKeyPEM := spec.PrivkeytoPEM(pair)
-	pubKeyPEM, pubKeyPEMErr := spec.PubkeytoPEM(&pair.PublicKey)
-	if pubKeyPEMErr != nil {
-		return pubKeyPEMErr
+	verbosePrint("decrypting private key...", cmd)
+	dec, decryptErr := db.DecryptData([]byte(oldPass), []byte(localUser.PrvKey))
+	if decryptErr != nil {
+		return decryptErr
 	}
 
-	// Hashes the provided password
 	verbosePrint("hashing password...", cmd)
-	hashPass, hashErr := bcrypt.GenerateFromPassword([]byte(pass), 12)
+	hashPass, hashErr := bcrypt.GenerateFromPassword([]byte(newPass), 12)
 	if hashErr != nil {
 		return hashErr
 	}
 
-	// Assembles the REG packet
-	id := cmd.Data.NextID()
-	verbosePrint("performing registration...", cmd)
-	pctArgs := [][]byte{[]byte(username), pubKeyPEM}
-	pct, pctErr := spec.NewPacket(
-		spec.REG, id,
-		spec.EmptyInfo, pctArgs...,
-	)
-	if pctErr != nil {
-		return pctErr
-	}
-
-	packetPrint(pct, cmd)
-
-	// Sends the packet
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return wErr
-	}
-
-	// Awaits a response
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
-	if err != nil {
-		return err
-	}
-
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
-	}
-
-	// Encrypts the private key
 	verbosePrint("encrypting private key...", cmd)
-	enc, err := db.EncryptData([]byte(pass), prvKeyPEM)
-	if err != nil {
-		return err
+	enc, encryptErr := db.EncryptData([]byte(newPass), dec)
+	if encryptErr != nil {
+		return encryptErr
 	}
 
-	// Creates the user
-	_, insertErr := db.AddLocalUser(
+	updateErr := db.ChangeLocalUserPassword(
 		cmd.Static.DB,
-		string(username),
+		username,
 		string(hashPass),
 		string(enc),
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
-	if insertErr != nil {
-		return insertErr
+	if updateErr != nil {
+		return updateErr
+	}
+
+	// Keep the session data consistent
+	if cmd.Data.IsLoggedIn() && cmd.Data.LocalUser.User.Username == username {
+		cmd.Data.LocalUser.Password = string(hashPass)
 	}
 
 	cmd.Output(fmt.Sprintf(
-		"local user %s successfully added to the database",
+		"password of local user %s successfully changed",
 		username,
 	), RESULT)
 	return nil
 }
 
-// Deregisters a user from the server and also removes it locally.
-func DEREG(ctx context.Context, cmd Command, username, pass string) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
+// Encrypts again the private key of every local user in the database,
+// asking for the current password of each account through the given
+// function. If a master passphrase is provided it becomes the password
+// of every account, otherwise each one keeps its own password.
+// Nothing is modified unless every key is encrypted again correctly.
+func REKEYALL(cmd Command, ask func(db.LocalUser) (string, error), master string) error {
+	users, err := db.GetAllLocalUsers(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	if !cmd.Data.IsLoggedIn() {
-		return ErrorNotLoggedIn
+	if len(users) == 0 {
+		cmd.Output("there are no local users to encrypt again", RESULT)
+		return nil
 	}
 
-	found, existsErr := db.LocalUserExists(
-		cmd.Static.DB,
-		username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if existsErr != nil {
-		return existsErr
-	}
-	if !found {
-		return ErrorUserNotFound
+	var hashMaster []byte
+	if master != "" {
+		verbosePrint("hashing master passphrase...", cmd)
+		hashMaster, err = bcrypt.GenerateFromPassword([]byte(master), 12)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Verifies password
-	localUser, localUserErr := db.GetLocalUser(
-		cmd.Static.DB,
-		username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if localUserErr != nil {
-		return localUserErr
-	}
+	for i, v := range users {
+		name := fmt.Sprintf("%s (%s)", v.User.Username, v.User.Server.Name)
 
-	verbosePrint("checking password...", cmd)
-	hash := []byte(localUser.Password)
-	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(pass))
-	if cmpErr != nil {
-		return ErrorWrongCredentials
-	}
+		pass, err := ask(v)
+		if err != nil {
+			return err
+		}
 
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(spec.DEREG, id, spec.EmptyInfo)
-	if pctErr != nil {
-		return pctErr
-	}
+		verbosePrint(fmt.Sprintf("checking password of %s...", name), cmd)
+		cmpErr := bcrypt.CompareHashAndPassword([]byte(v.Password), []byte(pass))
+		if cmpErr != nil {
+			return fmt.Errorf("%s: %w", name, ErrorWrongCredentials)
+		}
+
+		verbosePrint(fmt.Sprintf("decrypting private key of %s...", name), cmd)
+		dec, err := db.DecryptData([]byte(pass), []byte(v.PrvKey))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
 
-	packetPrint(pct, cmd)
+		if master != "" {
+			pass = master
+			users[i].Password = string(hashMaster)
+		}
 
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return wErr
+		verbosePrint(fmt.Sprintf("encrypting private key of %s...", name), cmd)
+		enc, err := db.EncryptData([]byte(pass), dec)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		users[i].PrvKey = string(enc)
 	}
 
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
+	err = db.ReplaceLocalUserKeys(cmd.Static.DB, users)
 	if err != nil {
 		return err
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	// Keep the session data consistent
+	if cmd.Data != nil && cmd.Data.IsLoggedIn() && master != "" {
+		cmd.Data.LocalUser.Password = string(hashMaster)
 	}
 
-	dbErr := db.DeleteLocalUser(
-		cmd.Static.DB,
-		username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if dbErr != nil {
-		return dbErr
-	}
-
-	cmd.Data.LocalUser = nil
-
-	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
-	cmd.Output(fmt.Sprintf("user %s deregistered correctly", username), RESULT)
+	cmd.Output(fmt.Sprintf(
+		"private keys of %d local users successfully encrypted again",
+		len(users),
+	), RESULT)
 	return nil
 }
 
-// Logs a user to a server, also performs the verification.
-func LOGIN(ctx context.Context, cmd Command, username, pass string) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
+// Prints the amount of rows of each table in the
+// database and the space it takes on disk.
+func DBINFO(cmd Command) error {
+	stats, err := db.GetStats(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	if cmd.Data.IsLoggedIn() {
-		return ErrorAlreadyLoggedIn
+	size, err := db.GetDatabaseSize(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	found, existsErr := db.LocalUserExists(
-		cmd.Static.DB,
-		username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if existsErr != nil {
-		return existsErr
-	}
-	if !found {
-		return ErrorUserNotFound
-	}
+	var output strings.Builder
+	fmt.Fprintln(&output, "database information:")
+	fmt.Fprintf(&output, "* Servers: %d\n", stats.Servers)
+	fmt.Fprintf(&output, "* Users: %d\n", stats.Users)
+	fmt.Fprintf(&output, "* Local users: %d\n", stats.LocalUsers)
+	fmt.Fprintf(&output, "* External users: %d\n", stats.ExternalUsers)
+	fmt.Fprintf(&output, "* Messages: %d\n", stats.Messages)
+	fmt.Fprintf(&output, "* Size on disk: %s", formatSize(size))
 
-	// Verifies password
-	localUser, localUserErr := db.GetLocalUser(
-		cmd.Static.DB,
-		username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if localUserErr != nil {
-		return localUserErr
+	cmd.Output(output.String(), RESULT)
+	return nil
+}
+
+// Rebuilds the database to reclaim unused space,
+// printing its size before and after doing so.
+func DBVACUUM(cmd Command) error {
+	before, err := db.GetDatabaseSize(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	verbosePrint("checking password...", cmd)
-	hash := []byte(localUser.Password)
-	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(pass))
-	if cmpErr != nil {
-		return ErrorWrongCredentials
+	if before >= VacuumWarnSize {
+		cmd.Output(fmt.Sprintf(
+			"database takes %s, this may take a while...",
+			formatSize(before),
+		), INFO)
 	}
 
-	// Get the decrypted private key
-	verbosePrint("decrypting private key...", cmd)
-	dec, err := db.DecryptData([]byte(pass), []byte(localUser.PrvKey))
+	verbosePrint("vacuuming database...", cmd)
+	err = db.VacuumDatabase(cmd.Static.DB)
 	if err != nil {
 		return err
 	}
-	localUser.PrvKey = string(dec)
 
-	getPerms := func() {
-		perms, err := GetPermissions(ctx, cmd, localUser.User.Username)
-		if err == nil {
-			str := fmt.Sprintf(
-				"Logged in with permission level %d",
-				perms,
-			)
-			cmd.Output(str, INFO)
-		}
+	after, err := db.GetDatabaseSize(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	// Try to login with a reusable token
-	_, validToken := cmd.Data.GetToken()
-	if cmd.Data.Server.TLS && validToken {
-		err := tokenLogin(ctx, cmd, username)
-		if err == nil {
-			str := fmt.Sprintf(
-				"logged in using a reusable token!\nWelcome %s",
-				username,
-			)
-			cmd.Output(str, RESULT)
-
-			cmd.Data.LocalUser = &localUser
-			getPerms()
-			return nil
-		}
+	cmd.Output(fmt.Sprintf(
+		"database vacuumed from %s to %s",
+		formatSize(before), formatSize(after),
+	), RESULT)
+	return nil
+}
 
-		cmd.Output(err.Error(), ERROR)
-		cmd.Output("token verification failed, trying normal login", ERROR)
+// Checks the integrity of the database and looks for rows that
+// reference users which no longer exist. Nothing is modified
+// unless repairing is requested, which deletes those rows.
+func DBCHECK(cmd Command, repair bool) error {
+	verbosePrint("checking database integrity...", cmd)
+	problems, err := db.CheckIntegrity(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	// Sends a LOGIN packet with the username as an argument
-	verbosePrint("performing login...", cmd)
-	id1 := cmd.Data.NextID()
-	loginPct, loginPctErr := spec.NewPacket(
-		spec.LOGIN, id1,
-		spec.EmptyInfo, []byte(username),
-	)
-	if loginPctErr != nil {
-		return loginPctErr
+	orphans, err := db.GetOrphans(cmd.Static.DB)
+	if err != nil {
+		return err
 	}
 
-	packetPrint(loginPct, cmd)
+	dangling, err := db.GetDanglingUsers(cmd.Static.DB)
+	if err != nil {
+		return err
+	}
 
-	// Sends the packet
-	_, loginWErr := cmd.Data.Conn.Write(loginPct)
-	if loginWErr != nil {
-		return loginWErr
+	var output strings.Builder
+	fmt.Fprintln(&output, "database check:")
+	if len(problems) == 0 {
+		fmt.Fprintln(&output, "* Integrity: ok")
+	} else {
+		fmt.Fprintf(&output, "* Integrity: %d problems found\n", len(problems))
+		for _, v := range problems {
+			fmt.Fprintf(&output, "  - %s\n", v)
+		}
 	}
+	fmt.Fprintf(&output, "* Local users without a user: %d\n", len(orphans.LocalUsers))
+	fmt.Fprintf(&output, "* External users without a user: %d\n", len(orphans.ExternalUsers))
+	fmt.Fprintf(&output, "* Messages with unknown users: %d\n", len(orphans.Messages))
+	fmt.Fprintf(&output, "* Local users without a server: %d", len(dangling))
+	cmd.Output(output.String(), RESULT)
 
-	verbosePrint("awaiting response...", cmd)
-	loginReply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id1, spec.VERIF, spec.ERR),
-	)
-	if err != nil {
-		return err
+	if len(dangling) > 0 {
+		cmd.Output(
+			"local users without a server are not lost, list them with DANGLING and get their data back with RECOVER",
+			INFO,
+		)
 	}
 
-	if loginReply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(loginReply.HD.Info)
+	if orphans.Count() == 0 {
+		return nil
 	}
 
-	// The reply is a VERIF
-	// Decrypts the message
-	pKey, pemErr := spec.PEMToPrivkey([]byte(localUser.PrvKey))
-	if pemErr != nil {
-		return pemErr
+	if !repair {
+		cmd.Output(fmt.Sprintf(
+			"%d rows reference users that no longer exist, run the check again with -repair to delete them",
+			orphans.Count(),
+		), INFO)
+		return nil
 	}
 
-	decrypted, decryptErr := spec.DecryptText([]byte(loginReply.Args[0]), pKey)
-	if decryptErr != nil {
-		return decryptErr
+	// Deleting rows could make things worse
+	if len(problems) > 0 {
+		return ErrorCorruptedDatabase
 	}
 
-	// Sends a reply to the VERIF packet
-	verbosePrint("performing verification...", cmd)
-	id2 := cmd.Data.NextID()
-	verifPct, verifPctErr := spec.NewPacket(
-		spec.VERIF, id2,
-		spec.EmptyInfo,
-		[]byte(username), decrypted,
-	)
-	if verifPctErr != nil {
-		return verifPctErr
+	verbosePrint("deleting orphaned rows...", cmd)
+	deleted, err := db.RemoveOrphans(cmd.Static.DB, orphans)
+	if err != nil {
+		return err
 	}
 
-	packetPrint(verifPct, cmd)
+	cmd.Output(fmt.Sprintf("%d orphaned rows deleted", deleted), RESULT)
+	return nil
+}
 
-	// Sends the packet
-	_, verifWErr := cmd.Data.Conn.Write(verifPct)
-	if verifWErr != nil {
-		return verifWErr
+// Exports the conversation between the logged in user and
+// another user as a self-contained HTML file in the given path.
+func EXPORTHTML(cmd Command, username, file string) error {
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
 	}
 
-	// Listens for response
-	verbosePrint("awaiting response...", cmd)
-	verifReply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id2, spec.OK, spec.ERR),
+	verbosePrint("querying messages...", cmd)
+	msgs, err := db.GetAllUsersMessages(
+		cmd.Static.DB,
+		cmd.Data.LocalUser.User.Username,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
 	)
 	if err != nil {
 		return err
 	}
 
-	if verifReply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(verifReply.HD.Info)
+	if len(msgs) == 0 {
+		cmd.Output("no messages to export", RESULT)
+		return nil
 	}
-	verbosePrint("verification successful", cmd)
-	// Assigns the logged in user to Data
-	cmd.Data.LocalUser = &localUser
 
-	cmd.Output("login successful!", RESULT)
-	cmd.Output(fmt.Sprintf("Welcome, %s", username), INFO)
-	getPerms()
+	verbosePrint("rendering transcript...", cmd)
+	var buf bytes.Buffer
+	err = renderHTML(
+		&buf,
+		cmd.Data.LocalUser.User.Username,
+		username,
+		cmd.Data.Server.Name,
+		msgs,
+	)
+	if err != nil {
+		return err
+	}
 
-	if cmd.Data.Server.TLS {
-		cmd.Data.SetToken(string(decrypted))
+	err = os.WriteFile(file, buf.Bytes(), DefaultPerms)
+	if err != nil {
+		return err
 	}
 
+	str := fmt.Sprintf(
+		"%d messages succesfully written to %s",
+		len(msgs), file,
+	)
+	cmd.Output(str, RESULT)
 	return nil
 }
 
-// Logs out a user from a server.
-func LOGOUT(ctx context.Context, cmd Command) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
-	}
-	if !cmd.Data.IsLoggedIn() {
-		return ErrorNotLoggedIn
+// Starts a connection with a server. If noverify is set,
+// in case of TLS connections, certificate origins wont be checked.
+// The same happens if the server is configured to skip verification.
+// This command does not spawn a listening thread.
+func CONN(cmd Command, server db.Server, noverify bool) error {
+	if cmd.Data.IsConnected() {
+		return ErrorAlreadyConnected
 	}
 
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(spec.LOGOUT, id, spec.EmptyInfo)
-	if pctErr != nil {
-		return pctErr
-	}
+	useTLS := server.TLS
+	skipVerify := false
 
-	packetPrint(pct, cmd)
+	if noverify && !useTLS {
+		return ErrorInvalidSkipVerify
+	}
 
-	// Sends the packet
-	_, pctWErr := cmd.Data.Conn.Write(pct)
-	if pctWErr != nil {
-		return pctWErr
+	// The server policy only applies to TLS endpoints
+	if noverify || (useTLS && server.SkipVerify) {
+		skipVerify = true
+		verbosePrint("certificate verification is going to be skipped!", cmd)
 	}
 
-	// Listens for response
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
+	conn, state, conErr := SocketConnect(
+		server.Address,
+		server.Port,
+		useTLS,
+		skipVerify,
 	)
+	if conErr != nil {
+		return conErr
+	}
+
+	err := WaitConnect(cmd, conn, server)
 	if err != nil {
 		return err
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
-	}
+	cmd.Data.Conn = conn
+	cmd.Data.State = state
 
-	// Empties the user value in Data
-	cmd.Data.LocalUser = nil
+	if cmd.Static.Verbose {
+		cmd.Output("Listening for incoming packets...", INFO)
+	}
 
-	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
-	cmd.Output("logged out", RESULT)
+	cmd.emit(CommandEvent{
+		Type:   EVENT_CONNECTED,
+		Op:     spec.HELLO,
+		Server: server.Name,
+	})
 	return nil
 }
 
-// Disconnects a client from a server.
-func DISCN(cmd Command) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
-	}
-
-	err := cmd.Data.Conn.Close()
+// Connects to a server only to read its greeting, reporting whether it
+// speaks the protocol and what it advertises, and closes the connection.
+// Nothing is stored in the database and no session is created.
+func PROBE(cmd Command, address string, port uint16, useTLS bool) error {
+	socket := net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10))
+	result, err := probe(cmd, address, port, useTLS, false)
 	if err != nil {
 		return err
 	}
 
-	// Closes the client session
-	cmd.Data.Conn = nil
-	cmd.Data.LocalUser = nil
-	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
-	cmd.Data.Waitlist.Clear()
-	cmd.Output("sucessfully disconnected from the server", RESULT)
+	version := result.Version
+	if version == "" {
+		version = "unknown"
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "%s is a reachable gochat server:\n", socket)
+	fmt.Fprintf(&output, "* Protocol version: %d\n", result.Protocol)
+	fmt.Fprintf(&output, "* Server version: %s\n", version)
+	if result.Listing != nil {
+		fmt.Fprintf(&output, "* Listing users requires: all %d, online %d\n", result.Listing.All, result.Listing.Online)
+	}
+	fmt.Fprintf(&output, "* Has banner: %t\n", result.Banner)
+	fmt.Fprintf(&output, "* Latency: %s\n", result.Latency.Round(time.Millisecond))
+	if result.TLS != nil {
+		fmt.Fprintf(&output, "* TLS: %s using %s", tls.VersionName(result.TLS.Version), tls.CipherSuiteName(result.TLS.CipherSuite))
+	} else {
+		fmt.Fprint(&output, "* TLS: not in use")
+	}
 
+	cmd.Output(output.String(), RESULT)
 	return nil
 }
 
-// Sends a message to a user with the current time stamp and stores it in the database.
-func MSG(ctx context.Context, cmd Command, username, message string) error {
-	if !cmd.Data.IsConnected() {
-		return ErrorNotConnected
+// Probes every server stored in the database at the same time, up to
+// a limit, and returns the result of each one in the order they are
+// stored. Like PROBE, no session is created for any of them.
+func PROBEALL(cmd Command) ([]ProbeResult, error) {
+	servers, err := db.GetAllServers(cmd.Static.DB)
+	if err != nil {
+		return nil, err
 	}
 
-	if !cmd.Data.IsLoggedIn() {
-		return ErrorNotLoggedIn
+	var wg sync.WaitGroup
+	results := make([]ProbeResult, len(servers))
+	limit := models.NewCounter(MaxConcurrentRequests)
+
+	for i, v := range servers {
+		limit.Inc()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer limit.Dec()
+
+			result, err := probe(cmd, v.Address, v.Port, v.TLS, v.SkipVerify)
+			result.Name = v.Name
+			result.Err = err
+			results[i] = result
+		}()
 	}
 
-	// Stores the message before encrypting to store it in the database
-	plainMessage := make([]byte, len(message))
-	copy(plainMessage, message)
+	wg.Wait()
+	return results, nil
+}
+
+// Registers a user to a server and also adds it to the client database.
+// The local user is stored before contacting the server and only removed
+// if the server refuses the registration, so that a registration whose
+// reply was lost can be sent again with the same key pair.
+func REG(ctx context.Context, cmd Command, username, pass string) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
 
-	found, existsErr := db.ExternalUserExists(
+	exists, existsErr := db.LocalUserExists(
 		cmd.Static.DB,
-		username,
+		string(username),
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
 	if existsErr != nil {
 		return existsErr
 	}
-	if !found {
-		return ErrorUserNotFound
+	if exists {
+		return retryRegistration(ctx, cmd, username, pass)
 	}
-	// Retrieves the public key in PEM format to encrypt the message
-	externalUser, externalUserErr := db.GetExternalUser(
+
+	// Generates the PEM arrays of both the private and public key of the pair
+	verbosePrint("generating RSA key pair...", cmd)
+	pair, rsaErr := rsa.GenerateKey(rand.Reader, spec.RSABitSize)
+	if rsaErr != nil {
+		return rsaErr
+	}
+
+	prvKeyPEM := spec.PrivkeytoPEM(pair)
+	pubKeyPEM, pubKeyPEMErr := spec.PubkeytoPEM(&pair.PublicKey)
+	if pubKeyPEMErr != nil {
+		return pubKeyPEMErr
+	}
+
+	// Hashes the provided password
+	verbosePrint("hashing password...", cmd)
+	hashPass, hashErr := bcrypt.GenerateFromPassword([]byte(pass), 12)
+	if hashErr != nil {
+		return hashErr
+	}
+
+	// Encrypts the private key
+	verbosePrint("encrypting private key...", cmd)
+	enc, err := db.EncryptData([]byte(pass), prvKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	// Creates the user before the server confirms it
+	_, insertErr := db.AddLocalUser(
 		cmd.Static.DB,
-		username,
+		string(username),
+		string(hashPass),
+		string(enc),
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
-	if externalUserErr != nil {
-		return externalUserErr
+	if insertErr != nil {
+		return insertErr
 	}
-	pubKey, pemErr := spec.PEMToPubkey([]byte(externalUser.PubKey))
-	if pemErr != nil {
+
+	// Sends the REG packet
+	verbosePrint("performing registration...", cmd)
+	reply, err := cmd.Request(
+		ctx, spec.REG, spec.EmptyInfo,
+		[]byte(username), pubKeyPEM,
+	)
+	if err != nil && reply.HD.Op == spec.ERR {
+		// The server refused it so the user is not kept
+		dbErr := db.DeleteLocalUser(
+			cmd.Static.DB,
+			username,
+			cmd.Data.Server.Address,
+			cmd.Data.Server.Port,
+		)
+		if dbErr != nil {
+			return dbErr
+		}
+		return err
+	}
+	if err != nil {
+		cmd.Output(fmt.Sprintf(
+			"registration of %s could not be confirmed, the local user has been kept and registering it again will retry it",
+			username,
+		), ERROR)
+		return err
+	}
+
+	cmd.Output(fmt.Sprintf(
+		"local user %s successfully added to the database",
+		username,
+	), RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_REGISTERED,
+		Op:       spec.REG,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
+	return nil
+}
+
+// Deregisters a user from the server and also removes it locally.
+func DEREG(ctx context.Context, cmd Command, username, pass string) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	found, existsErr := db.LocalUserExists(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !found {
+		return ErrorUserNotFound
+	}
+
+	// Verifies password
+	localUser, localUserErr := db.GetLocalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if localUserErr != nil {
+		return localUserErr
+	}
+
+	verbosePrint("checking password...", cmd)
+	hash := []byte(localUser.Password)
+	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	if cmpErr != nil {
+		return ErrorWrongCredentials
+	}
+
+	_, err := cmd.Request(ctx, spec.DEREG, spec.EmptyInfo)
+	if err != nil {
+		return err
+	}
+
+	dbErr := db.DeleteLocalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if dbErr != nil {
+		return dbErr
+	}
+
+	cmd.Data.LocalUser = nil
+
+	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
+	cmd.Output(fmt.Sprintf("user %s deregistered correctly", username), RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_DEREGISTERED,
+		Op:       spec.DEREG,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
+	return nil
+}
+
+// Logs a user to a server, also performs the verification.
+func LOGIN(ctx context.Context, cmd Command, username, pass string) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	if cmd.Data.IsLoggedIn() {
+		return ErrorAlreadyLoggedIn
+	}
+
+	found, existsErr := db.LocalUserExists(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !found {
+		return ErrorUserNotFound
+	}
+
+	// Verifies password
+	localUser, localUserErr := db.GetLocalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if localUserErr != nil {
+		return localUserErr
+	}
+
+	verbosePrint("checking password...", cmd)
+	hash := []byte(localUser.Password)
+	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	if cmpErr != nil {
+		return ErrorWrongCredentials
+	}
+
+	// Get the decrypted private key
+	verbosePrint("decrypting private key...", cmd)
+	dec, err := db.DecryptData([]byte(pass), []byte(localUser.PrvKey))
+	if err != nil {
+		return err
+	}
+
+	// Keys stored in an older format are migrated transparently
+	if db.OutdatedData([]byte(localUser.PrvKey)) {
+		err := upgradeKey(cmd, localUser, pass, dec)
+		if err != nil {
+			cmd.Output(fmt.Sprintf("could not upgrade the private key format: %s", err), ERROR)
+		}
+	}
+	localUser.PrvKey = string(dec)
+
+	getPerms := func() {
+		perms, err := GetPermissions(ctx, cmd, localUser.User.Username)
+		if err == nil {
+			str := fmt.Sprintf(
+				"Logged in with permission level %d",
+				perms,
+			)
+			cmd.Output(str, INFO)
+		}
+	}
+
+	// Try to login with a reusable token
+	_, validToken := cmd.Data.GetToken()
+	if cmd.Data.Server.TLS && validToken {
+		reply, err := tokenLogin(ctx, cmd, username)
+		if err == nil {
+			str := fmt.Sprintf(
+				"logged in using a reusable token!\nWelcome %s",
+				username,
+			)
+			cmd.Output(str, RESULT)
+
+			cmd.Data.LocalUser = &localUser
+			getPerms()
+			pendingPrint(reply, cmd)
+			cmd.emit(CommandEvent{
+				Type:     EVENT_LOGGED_IN,
+				Op:       spec.LOGIN,
+				Server:   cmd.Data.Server.Name,
+				Username: username,
+			})
+			return nil
+		}
+
+		cmd.Output(err.Error(), ERROR)
+		cmd.Output("token verification failed, trying normal login", ERROR)
+	}
+
+	// Sends a LOGIN packet with the username as an argument
+	verbosePrint("performing login...", cmd)
+	loginReply, err := cmd.Request(
+		ctx, spec.LOGIN, spec.EmptyInfo,
+		[]byte(username),
+	)
+	if err != nil {
+		return err
+	}
+
+	if loginReply.HD.Op != spec.VERIF {
+		return spec.ErrorPacket
+	}
+
+	// The reply is a VERIF
+	// Decrypts the message
+	pKey, pemErr := spec.PEMToPrivkey([]byte(localUser.PrvKey))
+	if pemErr != nil {
 		return pemErr
 	}
+
+	decrypted, decryptErr := spec.DecryptText([]byte(loginReply.Args[0]), pKey)
+	if decryptErr != nil {
+		return decryptErr
+	}
+
+	// Sends a reply to the VERIF packet
+	verbosePrint("performing verification...", cmd)
+	verifReply, err := cmd.Request(
+		ctx, spec.VERIF, spec.EmptyInfo,
+		[]byte(username), decrypted,
+	)
+	if err != nil {
+		return err
+	}
+	verbosePrint("verification successful", cmd)
+	// Assigns the logged in user to Data
+	cmd.Data.LocalUser = &localUser
+
+	cmd.Output("login successful!", RESULT)
+	cmd.Output(fmt.Sprintf("Welcome, %s", username), INFO)
+	getPerms()
+	pendingPrint(verifReply, cmd)
+
+	if cmd.Data.Server.TLS {
+		cmd.Data.SetToken(string(decrypted))
+	}
+
+	cmd.emit(CommandEvent{
+		Type:     EVENT_LOGGED_IN,
+		Op:       spec.LOGIN,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
+	return nil
+}
+
+// Logs out a user from a server.
+func LOGOUT(ctx context.Context, cmd Command) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	_, err := cmd.Request(ctx, spec.LOGOUT, spec.EmptyInfo)
+	if err != nil {
+		return err
+	}
+
+	// Empties the user value in Data
+	username := cmd.Data.LocalUser.User.Username
+	cmd.Data.LocalUser = nil
+	cmd.Data.ClearLastMessage()
+
+	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
+	cmd.Output("logged out", RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_LOGGED_OUT,
+		Op:       spec.LOGOUT,
+		Server:   cmd.Data.Server.Name,
+		Username: username,
+	})
+	return nil
+}
+
+// Disconnects a client from a server.
+func DISCN(cmd Command) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	err := cmd.Data.Conn.Close()
+	if err != nil {
+		return err
+	}
+
+	// Closes the client session
+	cmd.Data.Conn = nil
+	cmd.Data.State = nil
+	cmd.Data.LocalUser = nil
+	cmd.Data.Waitlist.Cancel(cmd.Data.Logout)
+	cmd.Data.Waitlist.Clear()
+	cmd.Data.ClearTrace()
+	cmd.Data.ClearLastMessage()
+	cmd.Output("sucessfully disconnected from the server", RESULT)
+
+	event := CommandEvent{
+		Type: EVENT_DISCONNECTED,
+	}
+	if cmd.Data.Server != nil {
+		event.Server = cmd.Data.Server.Name
+	}
+	cmd.emit(event)
+
+	return nil
+}
+
+// Shows the last request sent to the server and its reply.
+// Arguments that are not printable, such as ciphertexts,
+// are shown as their length and hexadecimal prefix.
+func LASTPACKET(cmd Command) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	trace, ok := cmd.Data.LastTrace()
+	if !ok {
+		return ErrorNoTrace
+	}
+
+	sent := printableArgs(trace.Sent)
+	cmd.Output("Sent packet:\n"+sent.Contents(), RESULT)
+
+	if !trace.Replied {
+		cmd.Output("no reply has been received for this packet", RESULT)
+		return nil
+	}
+
+	received := printableArgs(trace.Received)
+	cmd.Output("Received reply:\n"+received.Contents(), RESULT)
+	return nil
+}
+
+// Shows the error codes defined by the protocol along with
+// their name and meaning. If a code is given, which may be
+// either a number or the name of the error, only that one
+// is shown.
+func ERRORS(cmd Command, code string) error {
+	list := spec.ErrorList()
+
+	if code != "" {
+		name := strings.ToUpper(code)
+		if !strings.HasPrefix(name, "ERR_") {
+			name = "ERR_" + name
+		}
+
+		num, numErr := strconv.ParseUint(code, 0, 8)
+		found := slices.IndexFunc(list, func(e spec.SpecError) bool {
+			if numErr == nil {
+				return uint64(e.Code) == num
+			}
+			return e.Text == name
+		})
+		if found == -1 {
+			return ErrorUnknownErrorCode
+		}
+
+		list = list[found : found+1]
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "%-6s %-15s %s\n", "CODE", "NAME", "DESCRIPTION")
+	for _, v := range list {
+		fmt.Fprintf(&output,
+			"0x%02X   %-15s %s\n",
+			v.Code, v.Text, v.Description,
+		)
+	}
+
+	cmd.Output(strings.TrimSuffix(output.String(), "\n"), RESULT)
+	return nil
+}
+
+// Changes the name of a saved server, which must not be used
+// by any other server. If the server is the one in Data, its
+// name is updated as well so that it can stay connected.
+func RENAMESERVER(cmd Command, old, new string) (db.Server, error) {
+	if new == "" {
+		return db.Server{}, ErrorInsuficientArgs
+	}
+
+	exists, err := db.ServerExistsByName(cmd.Static.DB, new)
+	if err != nil {
+		return db.Server{}, err
+	}
+	if exists {
+		return db.Server{}, ErrorServerNameTaken
+	}
+
+	server, err := db.GetServerByName(cmd.Static.DB, old)
+	if err != nil {
+		return db.Server{}, ErrorServerNotFound
+	}
+
+	err = db.UpdateServer(cmd.Static.DB, &server, "name", new)
+	if err != nil {
+		return db.Server{}, err
+	}
+	server.Name = new
+
+	if cmd.Data != nil && cmd.Data.Server != nil && cmd.Data.Server.ServerID == server.ServerID {
+		cmd.Data.Server.Name = new
+	}
+
+	cmd.Output(fmt.Sprintf("server %s renamed to %s", old, new), RESULT)
+	return server, nil
+}
+
+// Shows the operations defined by the protocol along
+// with their code and a description of what they do.
+func OPERATIONS(cmd Command) error {
+	var output strings.Builder
+	fmt.Fprintf(&output, "%-6s %-9s %s\n", "CODE", "NAME", "DESCRIPTION")
+	for _, v := range spec.ActionList() {
+		fmt.Fprintf(&output,
+			"0x%02X   %-9s %s\n",
+			spec.IDToCode(v), spec.CodeToString(v), spec.OperationDescription(v),
+		)
+	}
+
+	cmd.Output(strings.TrimSuffix(output.String(), "\n"), RESULT)
+	return nil
+}
+
+// Shows the versions last advertised by the server, which
+// are unknown if the server has never sent them.
+func SERVERVERSION(cmd Command) error {
+	if cmd.Data.Server == nil {
+		return ErrorNotConnected
+	}
+
+	server, err := db.GetServer(
+		cmd.Static.DB,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return err
+	}
+
+	version := server.Version
+	if version == "" {
+		version = "unknown"
+	}
+
+	protocol := "unknown"
+	if server.Protocol != 0 {
+		protocol = fmt.Sprintf("v%d", server.Protocol)
+	}
+
+	cmd.Output(fmt.Sprintf(
+		"server version: %s, protocol version: %s",
+		version, protocol,
+	), RESULT)
+
+	return nil
+}
+
+// Shows the security details of the current connection, including
+// the negotiated TLS parameters and whether a reusable token is held.
+func SECINFO(cmd Command) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	_, hasToken := cmd.Data.GetToken()
+	state := cmd.Data.State
+
+	if state == nil {
+		cmd.Output(
+			"connection is not using TLS, the channel is unencrypted at the transport layer!",
+			ERROR,
+		)
+		cmd.Output(fmt.Sprintf("* Reusable token: %t", hasToken), RESULT)
+		return nil
+	}
+
+	fingerprint := "none"
+	if len(state.PeerCertificates) != 0 {
+		fingerprint = formatFingerprint(state.PeerCertificates[0].Raw)
+	}
+
+	var output strings.Builder
+	fmt.Fprintln(&output, "connection is secured using TLS:")
+	fmt.Fprintf(&output, "* Version: %s\n", tls.VersionName(state.Version))
+	fmt.Fprintf(&output, "* Cipher suite: %s\n", tls.CipherSuiteName(state.CipherSuite))
+	fmt.Fprintf(&output, "* Server name: %s\n", state.ServerName)
+	fmt.Fprintf(&output, "* Certificate fingerprint (SHA256): %s\n", fingerprint)
+	fmt.Fprintf(&output, "* Reusable token: %t", hasToken)
+
+	cmd.Output(output.String(), RESULT)
+	return nil
+}
+
+// Encrypts a known text with the public key of the logged in user and
+// decrypts it again with its private key, without involving the server.
+// This checks that the stored key pair is usable for messaging.
+func SELFTEST(cmd Command) error {
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	verbosePrint("parsing private key...", cmd)
+	prvKey, err := spec.PEMToPrivkey([]byte(cmd.Data.LocalUser.PrvKey))
+	if err != nil {
+		return fmt.Errorf("private key parsing failed: %w", err)
+	}
+
+	verbosePrint("parsing public key...", cmd)
+	pubPEM, err := spec.PubkeytoPEM(&prvKey.PublicKey)
+	if err != nil {
+		return fmt.Errorf("public key encoding failed: %w", err)
+	}
+
+	pubKey, err := spec.PEMToPubkey(pubPEM)
+	if err != nil {
+		return fmt.Errorf("public key parsing failed: %w", err)
+	}
+
+	verbosePrint("encrypting test text...", cmd)
+	enc, err := spec.EncryptText([]byte(selfTestText), pubKey)
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	verbosePrint("decrypting test text...", cmd)
+	dec, err := spec.DecryptText(enc, prvKey)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	if !bytes.Equal(dec, []byte(selfTestText)) {
+		return ErrorSelfTestMismatch
+	}
+
+	str := fmt.Sprintf(
+		"key pair of %s works correctly (RSA %d bits, OAEP with SHA256)",
+		cmd.Data.LocalUser.User.Username,
+		prvKey.N.BitLen(),
+	)
+	cmd.Output(str, RESULT)
+	return nil
+}
+
+// Appends a line to the local notes of an external user, or
+// removes all of them if clear is set. Notes are never sent
+// to the server. Any "\n" sequence in the text is stored as
+// a line break to allow multiline notes.
+func NOTE(cmd Command, username, text string, clear bool) error {
+	externalUser, err := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return ErrorUserNotFound
+	}
+
+	notes := ""
+	if !clear {
+		text = strings.ReplaceAll(text, "\\n", "\n")
+		if strings.TrimSpace(text) == "" {
+			return ErrorEmptyNote
+		}
+
+		notes = externalUser.Notes
+		if notes != "" {
+			notes += "\n"
+		}
+		notes += text
+	}
+
+	err = db.SetExternalUserNotes(
+		cmd.Static.DB,
+		username,
+		notes,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return err
+	}
+
+	if clear {
+		cmd.Output(fmt.Sprintf("notes about %s removed", username), RESULT)
+	} else {
+		cmd.Output(fmt.Sprintf("note about %s saved", username), RESULT)
+	}
+	return nil
+}
+
+// Prints the local notes of an external user.
+func NOTES(cmd Command, username string) error {
+	externalUser, err := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return ErrorUserNotFound
+	}
+
+	if externalUser.Notes == "" {
+		cmd.Output(fmt.Sprintf("there are no notes about %s", username), RESULT)
+		return nil
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "notes about %s:\n", username)
+	for _, v := range strings.Split(externalUser.Notes, "\n") {
+		fmt.Fprintf(&output, "* %s\n", v)
+	}
+
+	cmd.Output(strings.TrimSuffix(output.String(), "\n"), RESULT)
+	return nil
+}
+
+// Sends opaque signaling data to an online user through the
+// server, which only relays it. Signals of the same connection
+// are delivered in the order they are sent and are lost if
+// the user is not online.
+func SIGNAL(ctx context.Context, cmd Command, username string, data []byte) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	if username == cmd.Data.LocalUser.User.Username {
+		return ErrorSignalToSelf
+	}
+
+	_, err := cmd.Request(
+		ctx, spec.SIGNAL, spec.EmptyInfo,
+		[]byte(username),
+		spec.SignalToBytes(data),
+	)
+	if err != nil {
+		return err
+	}
+
+	cmd.Output(fmt.Sprintf("signal sent to %s", username), RESULT)
+	return nil
+}
+
+// Waits for the next signaling data relayed by the server,
+// which must be done constantly while logged in so that
+// incoming signals do not pile up. Signals whose data
+// is not valid base64 are discarded with an error.
+func ReceiveSignal(ctx context.Context, cmd Command) (Signal, error) {
+	pct, err := cmd.Data.Waitlist.Get(
+		ctx,
+		Find(spec.NullID, spec.SIGNAL),
+	)
+	if err != nil {
+		return Signal{}, err
+	}
+
+	data, err := spec.BytesToSignal(pct.Args[1])
+	if err != nil {
+		return Signal{}, err
+	}
+
+	signal := Signal{
+		Sender: string(pct.Args[0]),
+		Data:   data,
+	}
+
+	event := CommandEvent{
+		Type:     EVENT_SIGNALED,
+		Op:       spec.SIGNAL,
+		Username: signal.Sender,
+	}
+	if cmd.Data.Server != nil {
+		event.Server = cmd.Data.Server.Name
+	}
+	cmd.emit(event)
+
+	return signal, nil
+}
+
+// Changes the profile bio of the logged in user in the server,
+// which other users get when requesting them. An empty text
+// removes the bio.
+func PROFILE(ctx context.Context, cmd Command, text string) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	text = strings.TrimSpace(text)
+	if len(text) > spec.MaxBio {
+		return ErrorBioTooLong
+	}
+
+	args := make([][]byte, 0, 1)
+	if text != "" {
+		args = append(args, []byte(text))
+	}
+
+	_, err := cmd.Request(ctx, spec.PROFILE, spec.EmptyInfo, args...)
+	if err != nil {
+		return err
+	}
+
+	if text == "" {
+		cmd.Output("profile bio removed", RESULT)
+	} else {
+		cmd.Output("profile bio updated", RESULT)
+	}
+	return nil
+}
+
+// Returns the profile bio of an external user as it was
+// received the last time the user was requested, which
+// is empty if the user has not set one.
+func USERPROFILE(cmd Command, username string) (string, error) {
+	externalUser, err := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return "", ErrorUserNotFound
+	}
+
+	return externalUser.Bio, nil
+}
+
+// Decrypts again the ciphertext a message was received as
+// with the private key of the logged in user, to compare it
+// with the stored plaintext. The ciphertext is only kept when
+// running in verbose mode, otherwise only the plaintext is given.
+func INSPECT(cmd Command, id uint) (Inspection, error) {
+	if !cmd.Data.IsLoggedIn() {
+		return Inspection{}, ErrorNotLoggedIn
+	}
+
+	msg, err := db.GetMessage(cmd.Static.DB, id)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	// Sent messages are encrypted with the key of the other user,
+	// except for the copies received from the other devices
+	local := cmd.Data.LocalUser.UserID
+	copied := msg.SourceID == local && msg.Ciphertext != nil
+	if msg.DestinationID != local && !copied {
+		return Inspection{}, ErrorNotReceived
+	}
+
+	inspection := Inspection{
+		Stored:     msg.Text,
+		Ciphertext: msg.Ciphertext,
+	}
+	if msg.Ciphertext == nil {
+		return inspection, nil
+	}
+
+	prvKey, err := spec.PEMToPrivkey([]byte(cmd.Data.LocalUser.PrvKey))
+	if err != nil {
+		inspection.Err = err
+		return inspection, nil
+	}
+
+	decrypted, err := spec.DecryptText(msg.Ciphertext, prvKey)
+	if err != nil {
+		inspection.Err = err
+		return inspection, nil
+	}
+
+	inspection.Decrypted = string(decrypted)
+	return inspection, nil
+}
+
+// Returns the public keys seen for an external user, starting
+// by the oldest one. The stored key is always the last one, even
+// if it was requested before keys started being recorded.
+func KEYHISTORY(cmd Command, username string) ([]KeyRecord, error) {
+	externalUser, err := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return nil, ErrorUserNotFound
+	}
+
+	current, err := keyFingerprint([]byte(externalUser.PubKey))
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := db.GetKeyHistory(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]KeyRecord, 0, len(history)+1)
+	for _, v := range history {
+		records = append(records, KeyRecord{
+			Fingerprint: v.Fingerprint,
+			FirstSeen:   v.FirstSeen,
+		})
+	}
+
+	last := len(records) - 1
+	if last < 0 || records[last].Fingerprint != current {
+		records = append(records, KeyRecord{Fingerprint: current})
+		last += 1
+	}
+
+	records[last].Current = true
+	return records, nil
+}
+
+// Sends a message of the given type to a user with the current time stamp and stores
+// it in the database, returning the message as it was stored.
+func MSG(ctx context.Context, cmd Command, username, message string, msgType spec.MsgType) (Message, error) {
+	if !cmd.Data.IsConnected() {
+		return Message{}, ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return Message{}, ErrorNotLoggedIn
+	}
+
+	// Stores the message before encrypting to store it in the database
+	plainMessage := make([]byte, len(message))
+	copy(plainMessage, message)
+
+	reqErr := AutoREQ(ctx, cmd, username)
+	if reqErr != nil {
+		return Message{}, reqErr
+	}
+	// Retrieves the public key in PEM format to encrypt the message
+	externalUser, externalUserErr := db.GetExternalUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if externalUserErr != nil {
+		return Message{}, externalUserErr
+	}
+	pubKey, pemErr := spec.PEMToPubkey([]byte(externalUser.PubKey))
+	if pemErr != nil {
+		return Message{}, pemErr
+	}
+	if len(message) > spec.MaxEncryptSize(pubKey) {
+		return Message{}, ErrorMessageTooLong
+	}
 	// Encrypts the text
 	encrypted, encryptErr := spec.EncryptText([]byte(message), pubKey)
 	if encryptErr != nil {
-		return encryptErr
+		return Message{}, encryptErr
 	}
 
 	// Generates the packet, using the current UNIX timestamp
 	stamp := time.Now().Round(time.Second)
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(
-		spec.MSG, id,
-		spec.EmptyInfo,
+	_, err := cmd.Request(
+		ctx, spec.MSG, msgTypeInfo(msgType),
 		[]byte(username),
 		spec.UnixStampToBytes(stamp),
 		encrypted,
 	)
-	if pctErr != nil {
-		return pctErr
+	if err != nil {
+		return Message{}, err
+	}
+
+	cmd.Output("message sent correctly", RESULT)
+
+	// The message has already been sent so it is kept even if this fails
+	if cmd.Static.SelfCopy {
+		copyErr := sendCopy(ctx, cmd, username, plainMessage, stamp, msgType)
+		if copyErr != nil {
+			cmd.Output(fmt.Sprintf("could not send a copy for your other devices: %s", copyErr), ERROR)
+		}
+	}
+
+	src, srcErr := db.GetUser(
+		cmd.Static.DB,
+		cmd.Data.LocalUser.User.Username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if srcErr != nil {
+		return Message{}, srcErr
+	}
+
+	dst, dstErr := db.GetUser(
+		cmd.Static.DB,
+		username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if dstErr != nil {
+		return Message{}, dstErr
+	}
+
+	stored, storeErr := db.StoreMessage(
+		cmd.Static.DB,
+		src.Username,
+		dst.Username,
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+		string(plainMessage),
+		stamp,
+		0,
+		uint8(msgType),
+	)
+	if storeErr != nil {
+		return Message{}, storeErr
+	}
+
+	cmd.emit(CommandEvent{
+		Type:     EVENT_SENT,
+		Op:       spec.MSG,
+		Server:   cmd.Data.Server.Name,
+		Username: dst.Username,
+		Message:  stored.MessageID,
+	})
+
+	sent := Message{
+		ID:        stored.MessageID,
+		Sender:    src.Username,
+		Content:   string(plainMessage),
+		Timestamp: stamp,
+		Type:      msgType,
+	}
+	cmd.Data.setLastMessage(sent, dst.Username)
+	return sent, nil
+}
+
+// Sends the last message sent in the session again to another
+// user, returning the new message as it was stored.
+func RESEND(ctx context.Context, cmd Command, username string) (Message, error) {
+	if !cmd.Data.IsConnected() {
+		return Message{}, ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return Message{}, ErrorNotLoggedIn
+	}
+
+	last, ok := cmd.Data.LastMessage()
+	if !ok {
+		return Message{}, ErrorNoLastMessage
+	}
+
+	sent, err := MSG(ctx, cmd, username, last.Content, last.Type)
+	if err != nil {
+		return Message{}, err
+	}
+
+	cmd.Output(fmt.Sprintf(
+		"message sent to %s at %s resent to %s: %s",
+		last.Recipient, last.Timestamp.Format(time.TimeOnly),
+		username, previewText(last.Content),
+	), RESULT)
+	return sent, nil
+}
+
+// Asks the server to retrieve all messages while the user was offline.
+// This function is not responsible for receiving the messages, only request them.
+func RECIV(ctx context.Context, cmd Command) error {
+	_, err := cmd.Request(ctx, spec.RECIV, spec.EmptyInfo)
+	if err != nil {
+		return err
+	}
+
+	cmd.Output("messages queried correctly", RESULT)
+	return nil
+}
+
+// Requests a list of users depending on the type specified, which may or not
+// require an active connection.
+// Returns a the received usernames in an array if the request was correct.
+func USRS(ctx context.Context, cmd Command, usrsType USRSType) ([][]byte, error) {
+	// We check for local listing
+	switch usrsType {
+	case LOCAL_ALL:
+		users, err := printAllLocalUsers(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return users, nil
+	case REQUESTED:
+		users, err := printExternalUsers(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return users, nil
+	case LOCAL_SERVER:
+		users, err := printServerLocalUsers(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return users, nil
+	}
+
+	// We send the usrs petition to the server now
+
+	if !cmd.Data.IsLoggedIn() {
+		return nil, ErrorNotLoggedIn
+	}
+
+	// Avoid requesting lists the server would refuse
+	need := cmd.Data.Listing.Required(spec.Userlist(usrsType))
+	if need > 0 {
+		perms, err := GetPermissions(ctx, cmd, cmd.Data.LocalUser.User.Username)
+		if err == nil && perms < need {
+			return nil, ErrorRestrictedListing
+		}
+	}
+
+	reply, err := cmd.Request(ctx, spec.USRS, byte(usrsType))
+	if err != nil {
+		return nil, err
+	}
+
+	optionString := "unknown"
+	switch usrsType {
+	case ALL:
+		optionString = "all"
+	case ONLINE:
+		optionString = "online"
+	case ALLPERMS:
+		optionString = "all with permissions"
+	case ONLINEPERMS:
+		optionString = "online with permissions"
+	}
+
+	cmd.Output(fmt.Sprintf("%s users:", optionString), USRSRESPONSE)
+	cmd.Output(string(reply.Args[0]), USRSRESPONSE)
+	split := bytes.Split(reply.Args[0], []byte("\n"))
+
+	return split, nil
+}
+
+// Requests the information of an external user to add it to the client database.
+// Returns the arguments of a REQ as by specification.
+func REQ(ctx context.Context, cmd Command, username string) ([][]byte, error) {
+	if !cmd.Data.IsConnected() {
+		return nil, ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return nil, ErrorNotConnected
+	}
+
+	if username == cmd.Data.LocalUser.User.Username {
+		return nil, ErrorRequestToSelf
+	}
+
+	reply, err := cmd.Request(
+		ctx, spec.REQ, spec.EmptyInfo,
+		[]byte(username),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, dbErr := db.AddExternalUser(
+		cmd.Static.DB,
+		string(reply.Args[0]),
+		string(reply.Args[1]),
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if dbErr != nil {
+		return nil, dbErr
+	}
+
+	dbErr = db.SetExternalUserBio(
+		cmd.Static.DB,
+		string(reply.Args[0]),
+		replyBio(reply),
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if dbErr != nil {
+		return nil, dbErr
+	}
+
+	dbErr = recordKey(cmd, string(reply.Args[0]), reply.Args[1], time.Now())
+	if dbErr != nil {
+		return nil, dbErr
+	}
+
+	cmd.Output(fmt.Sprintf("external user %s successfully added to the database", username), RESULT)
+	cmd.emit(CommandEvent{
+		Type:     EVENT_REQUESTED,
+		Op:       spec.REQ,
+		Server:   cmd.Data.Server.Name,
+		Username: string(reply.Args[0]),
+	})
+	return reply.Args, nil
+}
+
+// Requests the information of every online user whose public key
+// is not already stored in the client database, skipping the logged
+// in user. Requests are sent concurrently up to a limit and the amount
+// of users that have been added is returned.
+func REQALL(ctx context.Context, cmd Command) (uint, error) {
+	if !cmd.Data.IsConnected() {
+		return 0, ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return 0, ErrorNotLoggedIn
+	}
+
+	// Used to avoid printing intermediate output
+	quiet := Command{
+		Output: func(string, OutputType) {},
+		Static: cmd.Static,
+		Data:   cmd.Data,
+	}
+
+	online, err := USRS(ctx, quiet, ONLINE)
+	if err != nil {
+		return 0, err
+	}
+
+	self := cmd.Data.LocalUser.User.Username
+	pending := make([]string, 0, len(online))
+	for _, v := range online {
+		uname := string(v)
+		if uname == "" || uname == self {
+			continue
+		}
+
+		exists, err := db.ExternalUserExists(
+			cmd.Static.DB,
+			uname,
+			cmd.Data.Server.Address,
+			cmd.Data.Server.Port,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			pending = append(pending, uname)
+		}
+	}
+
+	if len(pending) == 0 {
+		cmd.Output("all online users have already been requested", RESULT)
+		return 0, nil
 	}
 
-	packetPrint(pct, cmd)
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	var done, added uint
+	limit := models.NewCounter(MaxConcurrentRequests)
+
+	for _, v := range pending {
+		limit.Inc()
+		wg.Add(1)
+		go func(uname string) {
+			defer wg.Done()
+			defer limit.Dec()
+
+			_, err := REQ(ctx, quiet, uname)
+
+			mut.Lock()
+			defer mut.Unlock()
+			done += 1
+			if err != nil {
+				cmd.Output(fmt.Sprintf(
+					"(%d/%d) failed to request %s: %s",
+					done, len(pending), uname, err,
+				), ERROR)
+				return
+			}
 
-	// Sends the packet
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return wErr
+			added += 1
+			cmd.Output(fmt.Sprintf(
+				"(%d/%d) requested %s",
+				done, len(pending), uname,
+			), INTERMEDIATE)
+		}(v)
 	}
 
-	// Listens for response
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
-	if err != nil {
-		return err
+	wg.Wait()
+
+	cmd.Output(fmt.Sprintf(
+		"%d out of %d external users successfully added to the database",
+		added, len(pending),
+	), RESULT)
+	return added, nil
+}
+
+// Requests the information of an external user that is already stored
+// in the client database and compares the public key sent by the server
+// with the stored one, replacing it if it has changed. Returns whether
+// the key has changed.
+func RESYNC(ctx context.Context, cmd Command, username string) (bool, error) {
+	if !cmd.Data.IsConnected() {
+		return false, ErrorNotConnected
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	if !cmd.Data.IsLoggedIn() {
+		return false, ErrorNotLoggedIn
 	}
 
-	cmd.Output("message sent correctly", RESULT)
-	src, srcErr := db.GetUser(
-		cmd.Static.DB,
-		cmd.Data.LocalUser.User.Username,
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if srcErr != nil {
-		return srcErr
+	if username == cmd.Data.LocalUser.User.Username {
+		return false, ErrorRequestToSelf
 	}
 
-	dst, dstErr := db.GetUser(
+	exists, err := db.ExternalUserExists(
 		cmd.Static.DB,
 		username,
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
 	)
-	if dstErr != nil {
-		return dstErr
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, ErrorUserNotFound
 	}
 
-	_, storeErr := db.StoreMessage(
+	stored, err := db.GetExternalUser(
 		cmd.Static.DB,
-		src.Username,
-		dst.Username,
+		username,
 		cmd.Data.Server.Address,
 		cmd.Data.Server.Port,
-		string(plainMessage),
-		stamp,
 	)
-	if storeErr != nil {
-		return storeErr
-	}
-
-	return nil
-}
-
-// Asks the server to retrieve all messages while the user was offline.
-// This function is not responsible for receiving the messages, only request them.
-func RECIV(ctx context.Context, cmd Command) error {
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(spec.RECIV, id, spec.EmptyInfo)
-	if pctErr != nil {
-		return pctErr
-	}
-
-	packetPrint(pct, cmd)
-
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return wErr
+	if err != nil {
+		return false, err
 	}
 
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
+	reply, err := cmd.Request(
+		ctx, spec.REQ, spec.EmptyInfo,
+		[]byte(username),
 	)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	// The bio is kept up to date even if the key is not
+	err = db.SetExternalUserBio(
+		cmd.Static.DB,
+		username,
+		replyBio(reply),
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
+	)
+	if err != nil {
+		return false, err
 	}
 
-	cmd.Output("messages queried correctly", RESULT)
-	return nil
-}
-
-// Requests a list of users depending on the type specified, which may or not
-// require an active connection.
-// Returns a the received usernames in an array if the request was correct.
-func USRS(ctx context.Context, cmd Command, usrsType USRSType) ([][]byte, error) {
-	// We check for local listing
-	switch usrsType {
-	case LOCAL_ALL:
-		users, err := printAllLocalUsers(cmd)
-		if err != nil {
-			return nil, err
-		}
-		return users, nil
-	case REQUESTED:
-		users, err := printExternalUsers(cmd)
-		if err != nil {
-			return nil, err
-		}
-		return users, nil
-	case LOCAL_SERVER:
-		users, err := printServerLocalUsers(cmd)
-		if err != nil {
-			return nil, err
-		}
-		return users, nil
+	old, err := keyFingerprint([]byte(stored.PubKey))
+	if err != nil {
+		return false, err
 	}
 
-	// We send the usrs petition to the server now
-
-	if !cmd.Data.IsLoggedIn() {
-		return nil, ErrorNotLoggedIn
+	new, err := keyFingerprint(reply.Args[1])
+	if err != nil {
+		return false, err
 	}
 
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(spec.USRS, id, byte(usrsType))
-	if pctErr != nil {
-		return nil, pctErr
+	if old == new {
+		cmd.Output(fmt.Sprintf(
+			"public key of %s is up to date (%s)",
+			username, new,
+		), RESULT)
+		return false, nil
 	}
 
-	packetPrint(pct, cmd)
-
-	// Sends the packet
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return nil, wErr
-	}
+	cmd.Output(fmt.Sprintf(
+		"public key of %s has changed from %s to %s!",
+		username, old, new,
+	), ERROR)
 
-	// Listens for response
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.USRS, spec.ERR),
+	dbErr := db.UpdateExternalUserKey(
+		cmd.Static.DB,
+		username,
+		string(reply.Args[1]),
+		cmd.Data.Server.Address,
+		cmd.Data.Server.Port,
 	)
-	if err != nil {
-		return nil, err
+	if dbErr != nil {
+		return true, dbErr
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return nil, spec.ErrorCodeToError(reply.HD.Info)
+	// Keys stored before the history existed were seen at an unknown time
+	dbErr = recordKey(cmd, username, []byte(stored.PubKey), time.Time{})
+	if dbErr != nil {
+		return true, dbErr
 	}
 
-	optionString := "unknown"
-	switch usrsType {
-	case ALL:
-		optionString = "all"
-	case ONLINE:
-		optionString = "online"
-	case ALLPERMS:
-		optionString = "all with permissions"
-	case ONLINEPERMS:
-		optionString = "online with permissions"
+	dbErr = recordKey(cmd, username, reply.Args[1], time.Now())
+	if dbErr != nil {
+		return true, dbErr
 	}
 
-	cmd.Output(fmt.Sprintf("%s users:", optionString), USRSRESPONSE)
-	cmd.Output(string(reply.Args[0]), USRSRESPONSE)
-	split := bytes.Split(reply.Args[0], []byte("\n"))
-
-	return split, nil
+	cmd.Output(fmt.Sprintf("public key of %s successfully updated in the database", username), RESULT)
+	return true, nil
 }
 
-// Requests the information of an external user to add it to the client database.
-// Returns the arguments of a REQ as by specification.
-func REQ(ctx context.Context, cmd Command, username string) ([][]byte, error) {
+// Resyncs every external user of the current server that is stored
+// in the client database. Requests are sent concurrently up to a limit
+// and the amount of users whose key has changed is returned.
+func RESYNCALL(ctx context.Context, cmd Command) (uint, error) {
 	if !cmd.Data.IsConnected() {
-		return nil, ErrorNotConnected
+		return 0, ErrorNotConnected
 	}
 
 	if !cmd.Data.IsLoggedIn() {
-		return nil, ErrorNotConnected
+		return 0, ErrorNotLoggedIn
 	}
 
-	if username == cmd.Data.LocalUser.User.Username {
-		return nil, ErrorRequestToSelf
+	stored, err := db.GetRequestedUsers(cmd.Static.DB)
+	if err != nil {
+		return 0, err
 	}
 
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(
-		spec.REQ, id,
-		spec.EmptyInfo, []byte(username),
-	)
-	if pctErr != nil {
-		return nil, pctErr
+	pending := make([]string, 0, len(stored))
+	for _, v := range stored {
+		sv := v.User.Server
+		if sv.Address != cmd.Data.Server.Address || sv.Port != cmd.Data.Server.Port {
+			continue
+		}
+		pending = append(pending, v.User.Username)
 	}
 
-	packetPrint(pct, cmd)
-
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return nil, wErr
+	if len(pending) == 0 {
+		cmd.Output("there are no external users stored for this server", RESULT)
+		return 0, nil
 	}
 
-	// Awaits a response
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.REQ, spec.ERR),
-	)
-	if err != nil {
-		return nil, err
+	// Only key changes are printed for each user
+	quiet := Command{
+		Output: func(text string, out OutputType) {
+			if out == ERROR {
+				cmd.Output(text, out)
+			}
+		},
+		Static: cmd.Static,
+		Data:   cmd.Data,
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return nil, spec.ErrorCodeToError(reply.HD.Info)
-	}
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	var done, changed uint
+	limit := models.NewCounter(MaxConcurrentRequests)
 
-	_, dbErr := db.AddExternalUser(
-		cmd.Static.DB,
-		string(reply.Args[0]),
-		string(reply.Args[1]),
-		cmd.Data.Server.Address,
-		cmd.Data.Server.Port,
-	)
-	if dbErr != nil {
-		return nil, dbErr
+	for _, v := range pending {
+		limit.Inc()
+		wg.Add(1)
+		go func(uname string) {
+			defer wg.Done()
+			defer limit.Dec()
+
+			diff, err := RESYNC(ctx, quiet, uname)
+
+			mut.Lock()
+			defer mut.Unlock()
+			done += 1
+			if err != nil {
+				cmd.Output(fmt.Sprintf(
+					"(%d/%d) failed to resync %s: %s",
+					done, len(pending), uname, err,
+				), ERROR)
+				return
+			}
+
+			if diff {
+				changed += 1
+			}
+			cmd.Output(fmt.Sprintf(
+				"(%d/%d) resynced %s",
+				done, len(pending), uname,
+			), INTERMEDIATE)
+		}(v)
 	}
 
-	cmd.Output(fmt.Sprintf("external user %s successfully added to the database", username), RESULT)
-	return reply.Args, nil
+	wg.Wait()
+
+	cmd.Output(fmt.Sprintf(
+		"%d out of %d external users had a new public key",
+		changed, len(pending),
+	), RESULT)
+	return changed, nil
 }
 
 // Sends an ADMIN packet that performs an specific ADMIN operation.
@@ -1175,7 +2592,7 @@ func ADMIN(ctx context.Context, cmd Command, op string, args ...[]byte) error {
 		return ErrorInsuficientArgs
 	}
 
-	arr := make([][]byte, 0, len(args)-1)
+	arr := make([][]byte, 0, len(args))
 
 	switch admin {
 	case spec.AdminShutdown:
@@ -1190,10 +2607,27 @@ func ADMIN(ctx context.Context, cmd Command, op string, args ...[]byte) error {
 		unix := spec.UnixStampToBytes(shutdown)
 
 		arr = append(arr, unix)
+
+		// Minutes before the shutdown to warn users
+		for _, v := range args[1:] {
+			mins, err := strconv.ParseUint(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+			arr = append(arr, spec.CountToBytes(mins))
+		}
 	case spec.AdminDeregister:
 		arr = append(arr, args[0])
 	case spec.AdminDisconnect:
 		arr = append(arr, args[0])
+		if len(args) > 1 {
+			reason := bytes.Join(args[1:], []byte(" "))
+			arr = append(arr, reason)
+		}
+	case spec.AdminGetPerms:
+		arr = append(arr, args[0])
+	case spec.AdminResetPerms:
+		arr = append(arr, args[0])
 	case spec.AdminChangePerms:
 		num, err := strconv.Atoi(string(args[1]))
 		if err != nil {
@@ -1210,31 +2644,34 @@ func ADMIN(ctx context.Context, cmd Command, op string, args ...[]byte) error {
 	case spec.AdminBroadcast:
 		message := bytes.Join(args, []byte(" "))
 		arr = append(arr, message)
-	}
-
-	id := cmd.Data.NextID()
-	pct, pctErr := spec.NewPacket(spec.ADMIN, id, uint8(admin), arr...)
-	if pctErr != nil {
-		return pctErr
-	}
-
-	packetPrint(pct, cmd)
+	case spec.AdminExpire:
+		// Only the tokens of one user are expired
+		if len(args) > 0 {
+			arr = append(arr, args[0])
+		}
+	case spec.AdminPrune:
+		days, err := strconv.ParseUint(string(args[0]), 10, 64)
+		if err != nil {
+			return err
+		}
 
-	_, wErr := cmd.Data.Conn.Write(pct)
-	if wErr != nil {
-		return wErr
+		arr = append(arr, spec.CountToBytes(days))
+		if len(args) > 1 && string(args[1]) == "-dry" {
+			arr = append(arr, []byte{1})
+		}
 	}
 
-	verbosePrint("awaiting response...", cmd)
-	reply, err := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
+	reply, err := cmd.Request(ctx, spec.ADMIN, uint8(admin), arr...)
 	if err != nil {
 		return err
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	if admin == spec.AdminPrune {
+		printPruned(reply, len(arr) > 1, cmd)
+	}
+
+	if admin == spec.AdminGetPerms {
+		printPerms(reply, string(args[0]), cmd)
 	}
 
 	cmd.Output(
@@ -1262,32 +2699,9 @@ func SUB(ctx context.Context, cmd Command, name string) error {
 
 	str := fmt.Sprintf("subscribing to event %s...", name)
 	verbosePrint(str, cmd)
-	id := cmd.Data.NextID()
-	hookPct, hookPctErr := spec.NewPacket(
-		spec.SUB, id,
-		byte(hook),
-	)
-	if hookPctErr != nil {
-		return hookPctErr
-	}
-
-	packetPrint(hookPct, cmd)
-
-	_, hookWErr := cmd.Data.Conn.Write(hookPct)
-	if hookWErr != nil {
-		return hookWErr
-	}
-
-	verbosePrint("awaiting response...", cmd)
-	reply, replyErr := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
-	if replyErr != nil {
-		return replyErr
-	}
-
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	_, err := cmd.Request(ctx, spec.SUB, byte(hook))
+	if err != nil {
+		return err
 	}
 
 	cmd.Output("succesfully subscribed!", RESULT)
@@ -1311,34 +2725,157 @@ func UNSUB(ctx context.Context, cmd Command, name string) error {
 
 	str := fmt.Sprintf("unsubscribing to event %s...", name)
 	verbosePrint(str, cmd)
-	id := cmd.Data.NextID()
-	hookPct, hookPctErr := spec.NewPacket(
-		spec.UNSUB, id,
-		byte(hook),
-	)
-	if hookPctErr != nil {
-		return hookPctErr
+	_, err := cmd.Request(ctx, spec.UNSUB, byte(hook))
+	if err != nil {
+		return err
+	}
+
+	cmd.Output("succesfully unsubscribed!", RESULT)
+	return nil
+}
+
+// Requests the hooks the client is currently subscribed to on
+// the server and returns them in order.
+func SUBLIST(ctx context.Context, cmd Command) ([]spec.Hook, error) {
+	if !cmd.Data.IsConnected() {
+		return nil, ErrorNotConnected
+	}
+
+	if !cmd.Data.IsLoggedIn() {
+		return nil, ErrorNotLoggedIn
 	}
 
-	packetPrint(hookPct, cmd)
+	reply, err := cmd.Request(ctx, spec.SUBLIST, spec.EmptyInfo)
+	if err != nil {
+		if errors.Is(err, spec.ErrorEmpty) {
+			cmd.Output("not subscribed to any hook", RESULT)
+			return []spec.Hook{}, nil
+		}
+		return nil, err
+	}
 
-	_, hookWErr := cmd.Data.Conn.Write(hookPct)
-	if hookWErr != nil {
-		return hookWErr
+	hooks := make([]spec.Hook, 0, len(reply.Args[0]))
+	var output strings.Builder
+	fmt.Fprintln(&output, "subscribed hooks:")
+	for _, v := range reply.Args[0] {
+		hook := spec.Hook(v)
+		hooks = append(hooks, hook)
+		fmt.Fprintf(&output, "* %s\n", spec.HookString(hook))
 	}
 
-	verbosePrint("awaiting response...", cmd)
-	reply, replyErr := cmd.Data.Waitlist.Get(
-		ctx, Find(id, spec.OK, spec.ERR),
-	)
-	if replyErr != nil {
-		return replyErr
+	cmd.Output(strings.TrimSuffix(output.String(), "\n"), RESULT)
+	return hooks, nil
+}
+
+// Sends the given amount of maximum size ECHO packets to the server,
+// one after another, and prints the average round trip time and the
+// throughput of the link. The amount is capped by the specification.
+func SPEEDTEST(ctx context.Context, cmd Command, count int) error {
+	if !cmd.Data.IsConnected() {
+		return ErrorNotConnected
 	}
 
-	if reply.HD.Op == spec.ERR {
-		return spec.ErrorCodeToError(reply.HD.Info)
+	if !cmd.Data.IsLoggedIn() {
+		return ErrorNotLoggedIn
 	}
 
-	cmd.Output("succesfully unsubscribed!", RESULT)
+	if count <= 0 {
+		return ErrorInvalidCount
+	}
+	count = min(count, spec.MaxEchoes)
+
+	// Payload cannot contain the argument delimiter
+	size := spec.MaxArgSize - 2
+	payload := bytes.Repeat([]byte{'x'}, size)
+
+	var total, fastest, slowest time.Duration
+	for i := range count {
+		start := time.Now()
+		reply, err := cmd.Request(ctx, spec.ECHO, spec.EmptyInfo, payload)
+		if err != nil {
+			return err
+		}
+		rtt := time.Since(start)
+
+		if !bytes.Equal(reply.Args[0], payload) {
+			return ErrorEchoMismatch
+		}
+
+		total += rtt
+		if i == 0 || rtt < fastest {
+			fastest = rtt
+		}
+		slowest = max(slowest, rtt)
+
+		cmd.Output(fmt.Sprintf(
+			"(%d/%d) echo received in %s",
+			i+1, count, rtt.Round(time.Microsecond),
+		), INTERMEDIATE)
+	}
+
+	// The payload travels in both directions
+	bits := float64(2*size*count) * 8
+	mbps := bits / total.Seconds() / 1e6
+	avg := total / time.Duration(count)
+
+	cmd.Output(fmt.Sprintf(
+		"%d echoes of %d bytes: %.2f Mbps, average RTT %s (min %s, max %s)",
+		count, size, mbps,
+		avg.Round(time.Microsecond),
+		fastest.Round(time.Microsecond),
+		slowest.Round(time.Microsecond),
+	), RESULT)
+	return nil
+}
+
+// Measures how long the local cryptographic operations take
+// on this machine, printing each step as soon as it finishes.
+// Only the "crypto" target is currently available.
+func BENCHMARK(cmd Command, target string) error {
+	if target != BenchmarkCrypto {
+		return ErrorUnknownBenchmark
+	}
+
+	step := func(name string, start time.Time) {
+		cmd.Output(fmt.Sprintf(
+			"%s: %d ms", name,
+			time.Since(start).Milliseconds(),
+		), RESULT)
+	}
+
+	cmd.Output(fmt.Sprintf(
+		"benchmarking crypto operations using %d CPUs...",
+		runtime.GOMAXPROCS(0),
+	), INTERMEDIATE)
+
+	start := time.Now()
+	key, err := rsa.GenerateKey(rand.Reader, spec.RSABitSize)
+	if err != nil {
+		return err
+	}
+	step(fmt.Sprintf("RSA key generation (%d bits)", spec.RSABitSize), start)
+
+	start = time.Now()
+	enc, err := spec.EncryptText([]byte(selfTestText), &key.PublicKey)
+	if err != nil {
+		return err
+	}
+	dec, err := spec.DecryptText(enc, key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(dec, []byte(selfTestText)) {
+		return ErrorSelfTestMismatch
+	}
+	step("RSA encryption and decryption", start)
+
+	// Same cost used when hashing account passwords
+	start = time.Now()
+	_, err = bcrypt.GenerateFromPassword([]byte(selfTestText), 12)
+	if err != nil {
+		return err
+	}
+	step("bcrypt hash (cost 12)", start)
+
 	return nil
 }
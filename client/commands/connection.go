@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/Sprinter05/gochat/client/db"
@@ -19,57 +20,81 @@ import (
 
 // Specifies a message that is going through the connection
 type Message struct {
-	Sender    string    // Who is sending the message
-	Content   string    // What the message contains
-	Timestamp time.Time // When the message was sent
+	ID        uint         // Identifier of the message in the database
+	Sender    string       // Who is sending the message
+	Content   string       // What the message contains
+	Timestamp time.Time    // When the message was sent
+	Sequence  uint64       // Order given by the server if it was cached
+	Recipient string       // Who it was sent to if it is a copy of a sent message
+	Type      spec.MsgType // How the content should be understood
+}
+
+// Signaling data relayed by the server from another user,
+// which is never interpreted nor stored by the client.
+type Signal struct {
+	Sender string // Who is sending the data
+	Data   []byte // Opaque signaling data
+}
+
+// Reason given by the server for closing the connection on its own
+type KickError struct {
+	Reason spec.Kick // Why the connection was closed
+	Detail string    // Explanation given along with it, if any
+}
+
+func (e KickError) Error() string {
+	desc := spec.KickDescription(e.Reason)
+	if e.Detail == "" {
+		return desc
+	}
+
+	return desc + ": " + e.Detail
 }
 
 /* CONNECTION FUNCTIONS */
 
-// Performs the socket connection to the server.
-func SocketConnect(address string, port uint16, useTLS bool, noVerify bool) (con net.Conn, err error) {
+// Performs the socket connection to the server. If the connection
+// uses TLS, the state of the negotiated connection is also returned,
+// otherwise it will be nil.
+func SocketConnect(address string, port uint16, useTLS bool, noVerify bool) (net.Conn, *tls.ConnectionState, error) {
 	socket := net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10))
 
 	if useTLS {
-		con, err = tls.Dial("tcp", socket, &tls.Config{
+		con, err := tls.Dial("tcp", socket, &tls.Config{
 			InsecureSkipVerify: noVerify,
 		})
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		return con, nil
+		state := con.ConnectionState()
+		return con, &state, nil
 	}
 
 	// Default to non-TLS
-	con, err = net.Dial("tcp", socket)
+	con, err := net.Dial("tcp", socket)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return con, nil
+	return con, nil, nil
 }
 
 // Listens for a HELLO packet from the server when starting the connection,
 // which determines that the client/server connection was started successfully.
 func WaitConnect(data Command, endpoint net.Conn, server db.Server) error {
-	cmd := new(spec.Command)
-
-	conn := spec.Connection{
-		Conn: endpoint,
-		TLS:  server.TLS,
-	}
+	conn := spec.NewConnection(endpoint, server.TLS)
 
-	// Header listen
-	hdErr := cmd.ListenHeader(conn)
-	if hdErr != nil {
-		return hdErr
+	// Packet listen
+	cmd, err := conn.ReadPacket()
+	if err != nil {
+		return err
 	}
 
-	// Header check
-	chErr := cmd.HD.ClientCheck()
+	// Whole command check
+	chErr := spec.ValidateClientCommand(cmd)
 	if chErr != nil {
-		data.Output("Incorrect header from server!", ERROR)
+		data.Output("Incorrect packet from server!", ERROR)
 		return chErr
 	}
 
@@ -77,18 +102,46 @@ func WaitConnect(data Command, endpoint net.Conn, server db.Server) error {
 		data.Output(cmd.Contents(), PACKET)
 	}
 
-	// Payload listen
-	pldErr := cmd.ListenPayload(conn)
-	if pldErr != nil {
-		return pldErr
-	}
-
 	if cmd.HD.Op != spec.HELLO {
 		data.Output("invalid initial packet from the server", ERROR)
 		return spec.ErrorUndefined
 	}
 	data.Output("succesfully connected to the server", RESULT)
 
+	// The banner is shown first as it may be a legal notice
+	if len(cmd.Args) > 1 && len(cmd.Args[1]) > 0 {
+		str := fmt.Sprintf(
+			"Server notice:\n%s",
+			cmd.Args[1],
+		)
+		data.Output(str, INFO)
+		storeAnnouncement(data, server, db.BannerAnnouncement, "", string(cmd.Args[1]))
+	}
+
+	// Older servers do not advertise their version
+	var version string
+	if len(cmd.Args) > 2 {
+		version = string(cmd.Args[2])
+	}
+
+	// Nor who can list users, which means anyone
+	data.Data.Listing = spec.Listing{}
+	if len(cmd.Args) > 3 {
+		list, err := spec.BytesToListing(cmd.Args[3])
+		if err == nil {
+			data.Data.Listing = list
+		}
+	}
+
+	verErr := db.SetServerVersion(
+		data.Static.DB,
+		server.Address, server.Port,
+		version, cmd.HD.Ver,
+	)
+	if verErr != nil {
+		data.Output(fmt.Sprintf("failed to store server version: %s", verErr), ERROR)
+	}
+
 	motd := string(cmd.Args[0])
 	if motd == "" {
 		return nil
@@ -99,15 +152,142 @@ func WaitConnect(data Command, endpoint net.Conn, server db.Server) error {
 		motd,
 	)
 	data.Output(str, INFO)
+	storeAnnouncement(data, server, db.MotdAnnouncement, "", motd)
 
 	return nil
 }
 
+/* REQUEST FUNCTIONS */
+
+// Amount of times a request will be sent again
+// with a new ID if writing it fails temporarily
+const RequestRetries int = 2
+
+// Replies that the server can send for each
+// action sent by the client, as by specification.
+var replyLookup = map[spec.Action][]spec.Action{
+	spec.REG:     {spec.OK, spec.ERR},
+	spec.LOGIN:   {spec.VERIF, spec.OK, spec.ERR},
+	spec.VERIF:   {spec.OK, spec.ERR},
+	spec.REQ:     {spec.REQ, spec.ERR},
+	spec.USRS:    {spec.USRS, spec.ERR},
+	spec.LOGOUT:  {spec.OK, spec.ERR},
+	spec.DEREG:   {spec.OK, spec.ERR},
+	spec.MSG:     {spec.OK, spec.ERR},
+	spec.RECIV:   {spec.OK, spec.ERR},
+	spec.SUB:     {spec.OK, spec.ERR},
+	spec.UNSUB:   {spec.OK, spec.ERR},
+	spec.ADMIN:   {spec.OK, spec.ERR},
+	spec.SUBLIST: {spec.SUBLIST, spec.ERR},
+	spec.ECHO:    {spec.ECHO, spec.ERR},
+	spec.PROFILE: {spec.OK, spec.ERR},
+	spec.SIGNAL:  {spec.OK, spec.ERR},
+}
+
+// Whether an error when writing to the connection
+// is temporary and the write can be attempted again.
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR)
+}
+
+// Sends a packet to the server with a newly allocated ID
+// and waits for the reply asocciated to the given action.
+// If writing fails due to a transient error before any byte
+// was written, the packet is sent again with a new ID up to a
+// limited amount of times. A partial write leaves the stream
+// broken, so the error is returned instead.
+// If the server replies with ERR, the reply is returned
+// together with the error it specifies.
+func (cmd Command) Request(ctx context.Context, op spec.Action, info byte, args ...[]byte) (spec.Command, error) {
+	replies, ok := replyLookup[op]
+	if !ok {
+		return spec.Command{}, spec.ErrorInvalid
+	}
+
+	var id spec.ID
+	var pct []byte
+	for i := 0; ; i++ {
+		var err error
+		id = cmd.Data.NextID()
+		pct, err = spec.NewPacket(op, id, info, args...)
+		if err != nil {
+			return spec.Command{}, err
+		}
+
+		packetPrint(pct, cmd)
+
+		n, err := cmd.Data.Conn.Write(pct)
+		if err == nil {
+			break
+		}
+
+		if n != 0 || i == RequestRetries || !isTransient(err) {
+			return spec.Command{}, err
+		}
+
+		verbosePrint("failed to send packet, retrying...", cmd)
+	}
+
+	sent := Trace{Sent: redactedArgs(spec.ParsePacket(pct))}
+	cmd.Data.setTrace(sent)
+
+	verbosePrint("awaiting response...", cmd)
+	reply, err := cmd.Data.Waitlist.Get(
+		ctx, Find(id, replies...),
+	)
+	if err != nil {
+		return spec.Command{}, err
+	}
+
+	sent.Received = reply
+	sent.Replied = true
+	cmd.Data.setTrace(sent)
+
+	if reply.HD.Op == spec.ERR {
+		err := spec.ErrorCodeToError(reply.HD.Info, reply.Args...)
+		event := CommandEvent{
+			Type: EVENT_ERROR,
+			Op:   op,
+			Code: reply.HD.Info,
+			Err:  err,
+		}
+		if cmd.Data.Server != nil {
+			event.Server = cmd.Data.Server.Name
+		}
+		cmd.emit(event)
+		return reply, err
+	}
+
+	return reply, nil
+}
+
 /* LISTENING FUNCTIONS */
 
 // Checks for a final error the server might have
 // sent when closing the connection.
 func closeError(cmd Command) error {
+	kick, ok := cmd.Data.Waitlist.TryGet(
+		Find(spec.NullID, spec.KICK),
+	)
+
+	if ok {
+		var detail string
+		if len(kick.Args) > 0 {
+			detail = cleanAnnouncement(string(kick.Args[0]))
+		}
+
+		return KickError{
+			Reason: spec.Kick(kick.HD.Info),
+			Detail: detail,
+		}
+	}
+
 	reply, ok := cmd.Data.Waitlist.TryGet(
 		Find(spec.NullID, spec.ERR),
 	)
@@ -116,17 +296,15 @@ func closeError(cmd Command) error {
 		return nil
 	}
 
-	err := spec.ErrorCodeToError(reply.HD.Info)
-	if err != nil {
-		str := fmt.Sprintf(
-			"server closed the connection due to %s",
-			err,
-		)
-		cmd.Output(str, ERROR)
-		return err
-	}
+	return spec.ErrorCodeToError(reply.HD.Info, reply.Args...)
+}
 
-	return nil
+// Whether the reason a connection was closed is transient,
+// meaning that connecting again is likely to succeed.
+// Connections closed manually are never transient.
+func Reconnectable(reason error) bool {
+	return errors.Is(reason, spec.ErrorConnection) ||
+		errors.Is(reason, spec.ErrorIdle)
 }
 
 // Sends a KEEP packet every x time
@@ -153,34 +331,47 @@ func PreventIdle(ctx context.Context, data *Data, d time.Duration) {
 
 // Listens for incoming server packets. When a packet
 // is received, it is stored in the packet waitlist
-// A cleanup function that cleans up resources can be passed.
-func ListenPackets(cmd Command, cleanup func()) {
+// A cleanup function that cleans up resources can be passed,
+// which receives the reason why the connection was closed,
+// being the error sent by the server if there was one.
+func ListenPackets(cmd Command, cleanup func(reason error)) {
 	info := func(text string) {
 		if cmd.Static.Verbose {
 			cmd.Output(text, INFO)
 		}
 	}
 
+	var reason error
 	defer func() {
 		if cmd.Data.Conn != nil {
 			cmd.Data.Conn.Close()
 		}
 
 		cmd.Data.Conn = nil
+		cmd.Data.State = nil
 		cmd.Data.LocalUser = nil
 		cmd.Data.ClearToken()
+		cmd.Data.ClearTrace()
+		cmd.Data.ClearLastMessage()
 
 		info("No longer listening for packets")
-		cleanup()
+		cleanup(reason)
 	}()
 
 	exit := func(prompt string, err error) {
+		reason = err
 		if errors.Is(err, spec.ErrorDisconnected) {
 			info("Connection manually closed")
 			return
 		}
 
-		if cmd.Static.Verbose && closeError(cmd) == nil {
+		// The server may have sent why it closed the connection
+		if srvErr := closeError(cmd); srvErr != nil {
+			reason = srvErr
+			return
+		}
+
+		if cmd.Static.Verbose {
 			cmd.Output(
 				fmt.Sprintf(
 					"%s: %s",
@@ -190,35 +381,24 @@ func ListenPackets(cmd Command, cleanup func()) {
 		}
 	}
 
-	conn := spec.Connection{
-		Conn: cmd.Data.Conn,
-		TLS:  cmd.Data.Server.TLS,
-	}
+	conn := spec.NewConnection(cmd.Data.Conn, cmd.Data.Server.TLS)
 
 	for {
 		if cmd.Data.Conn == nil {
 			return
 		}
-		pct := spec.Command{}
 
-		// Header listen
-		hdErr := pct.ListenHeader(conn)
-		if hdErr != nil {
-			exit("error in header listen", hdErr)
+		// Packet listen
+		pct, err := conn.ReadPacket()
+		if err != nil {
+			exit("error in packet listen", err)
 			return
 		}
 
-		// Header check
-		chErr := pct.HD.ClientCheck()
+		// Whole command check
+		chErr := spec.ValidateClientCommand(pct)
 		if chErr != nil {
-			exit("incorrect header from server", hdErr)
-			return
-		}
-
-		// Payload listen
-		pldErr := pct.ListenPayload(conn)
-		if pldErr != nil {
-			exit("error in payload listen", pldErr)
+			exit("incorrect packet from server", chErr)
 			return
 		}
 
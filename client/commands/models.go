@@ -4,6 +4,7 @@ package commands
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	mrand "math/rand/v2"
 	"net"
@@ -38,31 +39,63 @@ func Version(major float32) string {
 // Commands may alter the data if necessary
 type Data struct {
 	Conn     net.Conn                      // Specifies the connection to the server
+	State    *tls.ConnectionState          // Specifies the TLS state of the connection, nil if not using TLS
 	Logout   context.CancelFunc            // Specifies the function to call on a logout for context propagation
 	Waitlist models.Waitlist[spec.Command] // Stores all packets to be retrieved later
 
+	// Advertised by the server when connecting
+	Listing spec.Listing // Specifies the permissions needed to list users
+
 	// Using pointers so that "nil" can be used
 	Server    *db.Server    // Specifies the database server
 	LocalUser *db.LocalUser // Specifies the logged in user
 
 	token string  // Reusable token in case of TLS usage
 	next  spec.ID // Specifies the next ID that should be used when sending a packet
+	trace *Trace   // Last request sent to the server, nil if none
+	last  *Message // Last message sent in the session, nil if none
+
+	mut sync.RWMutex // Specifies the mutex protecting token, next, trace and last
+}
 
-	mut sync.RWMutex // Specifies the mutex protecting token and next
+// Request sent to the server and the reply to it
+type Trace struct {
+	Sent     spec.Command // Packet sent by the client
+	Received spec.Command // Reply of the server, empty if none arrived
+	Replied  bool         // Whether a reply has arrived
 }
 
 // Static data that should only be assigned
 // in specific cases
 type StaticData struct {
-	Verbose bool     // Whether or not to print detailed information
-	DB      *gorm.DB // Connection to the database
+	Verbose     bool     // Whether or not to print detailed information
+	DB          *gorm.DB // Connection to the database
+	AutoRequest bool     // Whether MSG requests unknown users on its own
+	SelfCopy    bool     // Whether MSG also sends a copy for the other devices of the user
 }
 
 // Specifies all structs necessary for a command
 type Command struct {
-	Output OutputFunc  // Custom output-printing function
-	Static *StaticData // Static Data (mostly)
-	Data   *Data       // Modifiable Data
+	Output OutputFunc          // Custom output-printing function
+	Static *StaticData         // Static Data (mostly)
+	Data   *Data               // Modifiable Data
+	Events chan<- CommandEvent // Optional typed results, nil if not used
+}
+
+/* COMMAND FUNCTIONS */
+
+// Sends an event if the command has a channel for them.
+// Events are dropped instead of blocking the command if
+// the channel is full, so it should be buffered.
+func (cmd Command) emit(event CommandEvent) {
+	if cmd.Events == nil {
+		return
+	}
+
+	select {
+	case cmd.Events <- event:
+	default:
+	}
 }
 
 /* DATA FUNCTIONS */
@@ -88,6 +121,57 @@ func (d *Data) ClearToken() {
 	d.token = ""
 }
 
+// Returns the last request sent to the server
+// and its reply, if any request has been sent.
+func (d *Data) LastTrace() (Trace, bool) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	if d.trace == nil {
+		return Trace{}, false
+	}
+	return *d.trace, true
+}
+
+// Stores the last request sent to the server
+func (d *Data) setTrace(t Trace) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.trace = &t
+}
+
+// Empties the last request sent to the server
+func (d *Data) ClearTrace() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.trace = nil
+}
+
+// Returns the last message sent in the session,
+// with the user it was sent to as its recipient.
+func (d *Data) LastMessage() (Message, bool) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	if d.last == nil {
+		return Message{}, false
+	}
+	return *d.last, true
+}
+
+// Stores the last message sent in the session
+func (d *Data) setLastMessage(msg Message, recipient string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	msg.Recipient = recipient
+	d.last = &msg
+}
+
+// Empties the last message sent in the session
+func (d *Data) ClearLastMessage() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.last = nil
+}
+
 // Creates a new empty but initialised struct for Data
 func NewEmptyData() Data {
 	initial := mrand.IntN(int(spec.MaxID))
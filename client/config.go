@@ -0,0 +1,327 @@
+package main
+
+// Allows showing and editing the configuration file while the client runs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Sprinter05/gochat/client/ui"
+)
+
+/* ERRORS */
+
+var (
+	ErrorUnknownOption = errors.New("unknown configuration option")               // unknown configuration option
+	ErrorInvalidValue  = errors.New("invalid value for the configuration option") // invalid value for the configuration option
+)
+
+/* CONSTANTS */
+
+// Fields whose value is never shown, matched
+// against every part of the path of an option
+var redactedFields = []string{"password", "token", "secret"}
+
+/* TYPES */
+
+// Configuration file of a running client. Options that
+// can be applied right away have a function that copies
+// them from the new configuration and applies them.
+type editableConfig struct {
+	path   string                  // Location of the file
+	config *Config                 // Configuration currently in use
+	live   map[string]func(Config) // Options applied without restarting by their path
+}
+
+/* FUNCTIONS */
+
+// Creates the editable configuration used by the TUI,
+// with the options the TUI can apply without restarting.
+func newEditableConfig(path string, config *Config, t *ui.TUI) *editableConfig {
+	c := &editableConfig{
+		path:   path,
+		config: config,
+	}
+
+	c.live = map[string]func(Config){
+		"ui_config.verbose": func(new Config) {
+			c.config.UIConfig.Verbose = new.UIConfig.Verbose
+			t.SetVerbose(new.UIConfig.Verbose || verbosePrint)
+		},
+		"ui_config.permission_styles": func(new Config) {
+			c.config.UIConfig.Permissions = new.UIConfig.Permissions
+			t.SetPermissionStyles(new.UIConfig.Permissions)
+		},
+		"ui_config.buffer_sort": func(new Config) {
+			c.config.UIConfig.BufferSort = new.UIConfig.BufferSort
+			t.SetBufferSort(new.UIConfig.BufferSort)
+		},
+		"ui_config.quick_commands": func(new Config) {
+			c.config.UIConfig.QuickCommands = new.UIConfig.QuickCommands
+			t.SetQuickCommands(new.UIConfig.QuickCommands)
+		},
+		"ui_config.name_length": func(new Config) {
+			c.config.UIConfig.NameLength = new.UIConfig.NameLength
+			t.SetNameLength(new.UIConfig.NameLength)
+		},
+		"ui_config.unicode": func(new Config) {
+			c.config.UIConfig.Unicode = new.UIConfig.Unicode
+			if new.UIConfig.Unicode != nil {
+				t.SetUnicode(*new.UIConfig.Unicode)
+			}
+		},
+		"ui_config.history_size": func(new Config) {
+			c.config.UIConfig.HistorySize = new.UIConfig.HistorySize
+			if new.UIConfig.HistorySize != nil {
+				t.SetHistorySize(*new.UIConfig.HistorySize)
+			}
+		},
+		"ui_config.history_dedup": func(new Config) {
+			c.config.UIConfig.HistoryDedup = new.UIConfig.HistoryDedup
+			t.SetHistoryDedup(new.UIConfig.HistoryDedup)
+		},
+		"ui_config.nick_colors": func(new Config) {
+			c.config.UIConfig.NickColors = new.UIConfig.NickColors
+			if new.UIConfig.NickColors != nil {
+				t.SetNickColors(*new.UIConfig.NickColors)
+			}
+		},
+		"ui_config.nick_palette": func(new Config) {
+			c.config.UIConfig.NickPalette = new.UIConfig.NickPalette
+			t.SetNickPalette(new.UIConfig.NickPalette)
+		},
+		"ui_config.confirm_quit": func(new Config) {
+			c.config.UIConfig.ConfirmQuit = new.UIConfig.ConfirmQuit
+			if new.UIConfig.ConfirmQuit != nil {
+				t.SetConfirmQuit(*new.UIConfig.ConfirmQuit)
+			}
+		},
+	}
+
+	return c
+}
+
+// Returns the options in use, redacting the sensitive ones.
+func (c *editableConfig) Entries() ([]ui.ConfigEntry, error) {
+	tree, err := toTree(*c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ui.ConfigEntry, 0)
+	flattenTree("", tree, func(path string, value any) {
+		enc, _ := json.Marshal(value)
+		entry := ui.ConfigEntry{
+			Path:  path,
+			Value: string(enc),
+		}
+
+		if redacted(path) {
+			entry.Value = "<redacted>"
+		}
+
+		_, entry.Live = c.live[optionName(path)]
+		entries = append(entries, entry)
+	})
+
+	return entries, nil
+}
+
+// Changes an option in the file, which is replaced at once so
+// that it is never left half written. The value is read as JSON
+// or as a plain string otherwise. Returns whether the option
+// was applied or if it needs a restart.
+func (c *editableConfig) Set(path string, value string) (bool, error) {
+	known, err := toTree(*c.config)
+	if err != nil {
+		return false, err
+	}
+
+	// The section and option must exist, anything
+	// below them is checked when decoding it
+	option := optionName(path)
+	found := false
+	flattenTree("", known, func(p string, _ any) {
+		if p == option || strings.HasPrefix(p, option+".") {
+			found = true
+		}
+	})
+	if !found || !strings.Contains(path, ".") {
+		return false, ErrorUnknownOption
+	}
+
+	// Options not written in the file are kept as they are
+	var tree any
+	file, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		tree = known
+	} else if err != nil {
+		return false, err
+	} else if err := json.Unmarshal(file, &tree); err != nil {
+		return false, err
+	}
+
+	var val any
+	if err := json.Unmarshal([]byte(value), &val); err != nil {
+		val = value
+	}
+
+	tree, err = setTree(tree, strings.Split(path, "."), val)
+	if err != nil {
+		return false, err
+	}
+
+	out, err := json.MarshalIndent(tree, "", "    ")
+	if err != nil {
+		return false, err
+	}
+
+	var new Config
+	if err := json.Unmarshal(out, &new); err != nil {
+		return false, fmt.Errorf("%w: %s", ErrorInvalidValue, err)
+	}
+
+	err = writeAtomic(c.path, out)
+	if err != nil {
+		return false, err
+	}
+
+	apply, live := c.live[option]
+	if live {
+		apply(new)
+	}
+
+	return live, nil
+}
+
+/* AUXILIARY FUNCTIONS */
+
+// Returns the "section.option" part of a path
+func optionName(path string) string {
+	parts := strings.SplitN(path, ".", 3)
+	return strings.Join(parts[:min(len(parts), 2)], ".")
+}
+
+// Whether any part of the path refers to a sensitive field
+func redacted(path string) bool {
+	lower := strings.ToLower(path)
+	return slices.ContainsFunc(redactedFields, func(s string) bool {
+		return strings.Contains(lower, s)
+	})
+}
+
+// Turns a configuration into the generic tree of its JSON
+func toTree(config Config) (any, error) {
+	enc, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree any
+	err = json.Unmarshal(enc, &tree)
+	return tree, err
+}
+
+// Calls a function with the path and value of every leaf
+// of a JSON tree, with objects sorted by their keys.
+func flattenTree(path string, node any, fun func(string, any)) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := node.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			fun(path, v)
+			return
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			flattenTree(join(k), v[k], fun)
+		}
+	case []any:
+		if len(v) == 0 {
+			fun(path, v)
+			return
+		}
+
+		for i, e := range v {
+			flattenTree(join(strconv.Itoa(i)), e, fun)
+		}
+	default:
+		fun(path, v)
+	}
+}
+
+// Replaces the value found in a path of a JSON tree, creating
+// the objects that are missing. Arrays are indexed by number.
+func setTree(node any, path []string, val any) (any, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+
+	switch v := node.(type) {
+	case map[string]any:
+		child, err := setTree(v[path[0]], path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		v[path[0]] = child
+		return v, nil
+	case []any:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, ErrorUnknownOption
+		}
+
+		child, err := setTree(v[i], path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = child
+		return v, nil
+	case nil:
+		return setTree(map[string]any{}, path, val)
+	default:
+		return nil, ErrorUnknownOption
+	}
+}
+
+// Writes a file by replacing it with a complete
+// temporary copy, so it is never left half written.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
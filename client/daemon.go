@@ -0,0 +1,445 @@
+package main
+
+// Allows running the TUI in the background, keeping its connections
+// and sessions, and attaching a terminal to it through a local socket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/gdamore/tcell/v2/terminfo"
+	"github.com/rivo/tview"
+	"golang.org/x/term"
+)
+
+/* ERRORS */
+
+var (
+	ErrorDaemonRunning = errors.New("a daemon is already listening on the socket") // a daemon is already listening on the socket
+	ErrorNoDaemon      = errors.New("no daemon is listening on the socket")        // no daemon is listening on the socket
+	ErrorNotTerminal   = errors.New("standard input is not a terminal")            // standard input is not a terminal
+	ErrorInvalidFrame  = errors.New("invalid frame received")                      // invalid frame received
+)
+
+/* CONSTANTS */
+
+// Kinds of frames sent by an attached terminal
+const (
+	frameHello  byte = 'h' // Size and name of the terminal, sent first
+	frameInput  byte = 'i' // Input typed in the terminal
+	frameResize byte = 'r' // New size of the terminal
+)
+
+// Key that detaches a terminal from the daemon (Ctrl-])
+const detachKey byte = 0x1d
+
+// Miliseconds between checks of the size of an attached terminal
+const resizeInterval uint = 500
+
+// Seconds an attaching terminal has to identify itself
+const helloTimeout uint = 5
+
+// Seconds to wait for the daemon to restore the terminal when detaching
+const detachTimeout uint = 2
+
+/* TYPES */
+
+// Terminal attached to the daemon, which the TUI uses as if it
+// was its own. Whatever the TUI draws is written to the socket
+// and the input is read from the frames sent through it.
+type attachedTty struct {
+	conn    net.Conn
+	close   sync.Once     // Closes the connection only once
+	closed  chan struct{} // Closed along with the connection
+	input   chan []byte   // Input received from the terminal
+	pending []byte        // Input not read yet by the TUI
+	mut     sync.Mutex    // Protects the fields below
+	size    [2]uint16     // Width and height of the terminal
+	resize  func()        // Notifies the TUI of a new size
+	drain   chan struct{} // Closed to stop reading the input
+}
+
+// Wraps the writes of the attach client into frames
+type frameWriter struct {
+	mut  sync.Mutex
+	conn net.Conn
+}
+
+/* FRAMES */
+
+// Writes a frame made of its kind, the length
+// of its payload as 2 bytes and the payload itself
+func (w *frameWriter) write(kind byte, payload []byte) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	frame := make([]byte, 3, 3+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(payload)))
+	_, err := w.conn.Write(append(frame, payload...))
+	return err
+}
+
+// Reads the next frame sent by an attached terminal
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+// Encodes the size of a terminal
+func sizeToBytes(width, height int) []byte {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint16(size, uint16(width))
+	binary.BigEndian.PutUint16(size[2:], uint16(height))
+	return size
+}
+
+// Decodes the size of a terminal
+func bytesToSize(b []byte) ([2]uint16, error) {
+	if len(b) < 4 {
+		return [2]uint16{}, ErrorInvalidFrame
+	}
+
+	return [2]uint16{
+		binary.BigEndian.Uint16(b),
+		binary.BigEndian.Uint16(b[2:]),
+	}, nil
+}
+
+/* TTY */
+
+// Creates a terminal for an attached connection with its initial size
+func newAttachedTty(conn net.Conn, size [2]uint16) *attachedTty {
+	return &attachedTty{
+		conn:   conn,
+		closed: make(chan struct{}),
+		input:  make(chan []byte, 16),
+		size:   size,
+		drain:  make(chan struct{}),
+	}
+}
+
+// Reads frames from the connection until it is closed,
+// passing the input and resizes to the TUI.
+func (t *attachedTty) serve(r io.Reader) {
+	for {
+		kind, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		switch kind {
+		case frameInput:
+			select {
+			case t.input <- payload:
+			case <-t.closed:
+				return
+			}
+		case frameResize:
+			size, err := bytesToSize(payload)
+			if err != nil {
+				continue
+			}
+
+			t.mut.Lock()
+			t.size = size
+			resize := t.resize
+			t.mut.Unlock()
+
+			if resize != nil {
+				resize()
+			}
+		}
+	}
+}
+
+func (t *attachedTty) Start() error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.drain = make(chan struct{})
+	return nil
+}
+
+func (t *attachedTty) Stop() error {
+	return nil
+}
+
+// Wakes up the TUI if it is waiting for input
+func (t *attachedTty) Drain() error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	select {
+	case <-t.drain:
+	default:
+		close(t.drain)
+	}
+
+	return nil
+}
+
+func (t *attachedTty) NotifyResize(cb func()) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.resize = cb
+}
+
+func (t *attachedTty) WindowSize() (tcell.WindowSize, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return tcell.WindowSize{
+		Width:  int(t.size[0]),
+		Height: int(t.size[1]),
+	}, nil
+}
+
+// Returns the input of the terminal, or nothing once drained
+// so that the TUI can stop reading without an error.
+func (t *attachedTty) Read(b []byte) (int, error) {
+	if len(t.pending) == 0 {
+		t.mut.Lock()
+		drain := t.drain
+		t.mut.Unlock()
+
+		select {
+		case t.pending = <-t.input:
+		case <-drain:
+			return 0, nil
+		}
+	}
+
+	n := copy(b, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *attachedTty) Write(b []byte) (int, error) {
+	return t.conn.Write(b)
+}
+
+func (t *attachedTty) Close() error {
+	var err error
+	t.close.Do(func() {
+		err = t.conn.Close()
+		close(t.closed)
+	})
+	return err
+}
+
+/* DAEMON */
+
+// Returns the screen used while no terminal is attached
+func detachedScreen() tcell.Screen {
+	return tcell.NewSimulationScreen("UTF-8")
+}
+
+// Runs the TUI without a terminal until it exits, letting
+// terminals attach to it through a local socket. Only one
+// terminal can be attached, a new one replaces the previous.
+func runDaemon(app *tview.Application, socket string) {
+	// A socket that cannot be connected to is left over
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		log.Fatal(ErrorDaemonRunning)
+	}
+	os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(socket)
+	defer listener.Close()
+
+	var mut sync.Mutex
+	var current *attachedTty
+
+	go func() {
+		// Screens can only be replaced once the TUI is running
+		app.QueueUpdate(func() {})
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				r := bufio.NewReader(conn)
+				size, ti, err := readHello(conn, r)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				tty := newAttachedTty(conn, size)
+				screen, err := tcell.NewTerminfoScreenFromTtyTerminfo(tty, ti)
+				if err != nil {
+					tty.Close()
+					return
+				}
+
+				mut.Lock()
+				current = tty
+				app.SetScreen(screen)
+				mut.Unlock()
+
+				tty.serve(r)
+
+				// Replaced terminals have already been closed
+				mut.Lock()
+				defer mut.Unlock()
+				if current == tty {
+					current = nil
+					app.SetScreen(detachedScreen())
+				}
+			}()
+		}
+	}()
+
+	app.SetScreen(detachedScreen())
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Waits for a terminal to identify itself, returning
+// its size and the capabilities of its type.
+func readHello(conn net.Conn, r io.Reader) ([2]uint16, *terminfo.Terminfo, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Duration(helloTimeout) * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	kind, payload, err := readFrame(r)
+	if err != nil {
+		return [2]uint16{}, nil, err
+	}
+
+	if kind != frameHello {
+		return [2]uint16{}, nil, ErrorInvalidFrame
+	}
+
+	size, err := bytesToSize(payload)
+	if err != nil {
+		return [2]uint16{}, nil, err
+	}
+
+	ti, err := terminfo.LookupTerminfo(string(payload[4:]))
+	if err != nil {
+		return [2]uint16{}, nil, err
+	}
+
+	return size, ti, nil
+}
+
+/* ATTACH */
+
+// Attaches the terminal to a running daemon until the detach
+// key is pressed or the daemon closes the connection.
+func runAttach(socket string) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) {
+		return ErrorNotTerminal
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorNoDaemon, err)
+	}
+	defer conn.Close()
+
+	width, height, err := term.GetSize(out)
+	if err != nil {
+		return err
+	}
+
+	w := &frameWriter{conn: conn}
+	hello := append(sizeToBytes(width, height), os.Getenv("TERM")...)
+	if err := w.write(frameHello, hello); err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, state)
+
+	// Screen drawn by the daemon, until it closes the connection
+	output := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(output)
+	}()
+
+	// Input sent until the detach key is pressed
+	go func() {
+		buf := make([]byte, 128)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			input := buf[:n]
+			i := bytes.IndexByte(input, detachKey)
+			if i != -1 {
+				input = input[:i]
+			}
+
+			if len(input) > 0 {
+				if err := w.write(frameInput, input); err != nil {
+					return
+				}
+			}
+
+			// The daemon restores the terminal before closing
+			if i != -1 {
+				conn.(*net.UnixConn).CloseWrite()
+				time.AfterFunc(time.Duration(detachTimeout)*time.Second, func() {
+					conn.Close()
+				})
+				return
+			}
+		}
+	}()
+
+	// Size checked periodically to work on every platform
+	go func() {
+		ticker := time.NewTicker(time.Duration(resizeInterval) * time.Millisecond)
+		defer ticker.Stop()
+		for range ticker.C {
+			nw, nh, err := term.GetSize(out)
+			if err != nil || (nw == width && nh == height) {
+				continue
+			}
+
+			width, height = nw, nh
+			if err := w.write(frameResize, sizeToBytes(width, height)); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-output
+	return nil
+}
@@ -51,7 +51,9 @@ func GetDBLogger(logLevel uint8, logPath string) logger.Interface {
 /* ERRORS */
 
 var (
-	ErrorInvalidObject error = fmt.Errorf("provided object is not of the correct type")
+	ErrorInvalidObject  error = fmt.Errorf("provided object is not of the correct type")
+	ErrorUnexpectedRows error = fmt.Errorf("unexpected amount of rows affected")
+	ErrorInvalidData    error = fmt.Errorf("encrypted data is malformed")
 )
 
 /* CONNECTION */
@@ -64,7 +66,7 @@ func OpenDatabase(path string, logger logger.Interface) *gorm.DB {
 	}
 
 	// Makes migrations
-	clientDB.AutoMigrate(Server{}, User{}, LocalUser{}, ExternalUser{}, Message{})
+	clientDB.AutoMigrate(Server{}, User{}, LocalUser{}, ExternalUser{}, Message{}, MessageTag{}, QuickCommand{}, Draft{}, KeyHistory{}, Announcement{})
 	return clientDB
 }
 
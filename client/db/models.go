@@ -30,6 +30,8 @@ type LocalUser struct {
 type ExternalUser struct {
 	UserID uint   `gorm:"primaryKey;not null"`
 	PubKey string `gorm:"not null"`
+	Notes  string // Local notes that are never transmitted
+	Bio    string // Profile bio of the user in the server
 
 	User User `gorm:"foreignKey:UserID;OnDelete:CASCADE"`
 }
@@ -40,12 +42,87 @@ type Message struct {
 	SourceID      uint
 	DestinationID uint
 	Stamp         time.Time
+	Sequence      uint64
 	Text          string
+	Pinned        bool   `gorm:"not null;default:false"`
+	Type          uint8  `gorm:"not null;default:0"` // Type of message given by the protocol
+	Ciphertext    []byte // Only kept for received messages in verbose mode
 
 	SourceUser      User `gorm:"foreignKey:SourceID;references:UserID;OnDelete:RESTRICT"`
 	DestinationUser User `gorm:"foreignKey:DestinationID;references:UserID;OnDelete:RESTRICT"`
 }
 
+// Local label given to a message, which
+// is never transmitted to the server.
+type MessageTag struct {
+	MessageID uint   `gorm:"primaryKey;autoIncrement:false;not null"`
+	Label     string `gorm:"primaryKey;not null"`
+
+	Message Message `gorm:"foreignKey:MessageID;references:MessageID;constraint:OnDelete:CASCADE"`
+}
+
+// Command saved for a server so that it can be
+// ran from a list instead of typing it again.
+type QuickCommand struct {
+	ServerID uint   `gorm:"primaryKey;autoIncrement:false;not null"`
+	Name     string `gorm:"primaryKey;not null"`
+	Command  string `gorm:"not null"`
+
+	Server Server `gorm:"foreignKey:ServerID;references:ServerID;constraint:OnDelete:CASCADE"`
+}
+
+// Message that was being typed in a buffer and has
+// not been sent yet, kept in case the client exits.
+type Draft struct {
+	ServerID uint      `gorm:"primaryKey;autoIncrement:false;not null"`
+	Buffer   string    `gorm:"primaryKey;not null"`
+	Text     string    `gorm:"not null"`
+	Stamp    time.Time `gorm:"not null"`
+
+	Server Server `gorm:"foreignKey:ServerID;references:ServerID;constraint:OnDelete:CASCADE"`
+}
+
+// Fingerprint of a public key seen for an external user.
+// Entries are only ever added so that past keys can be audited.
+type KeyHistory struct {
+	KeyID       uint      `gorm:"primaryKey;autoIncrement;not null"`
+	UserID      uint      `gorm:"not null"`
+	Fingerprint string    `gorm:"not null"`
+	FirstSeen   time.Time `gorm:"not null"`
+
+	User User `gorm:"foreignKey:UserID;references:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// Keeps the name of the table singular
+func (KeyHistory) TableName() string {
+	return "key_history"
+}
+
+// Identifies how an announcement was sent by the server
+type AnnouncementKind string
+
+const (
+	MotdAnnouncement      AnnouncementKind = "motd"      // Message of the day sent when connecting
+	BannerAnnouncement    AnnouncementKind = "banner"    // Notice sent when connecting
+	BroadcastAnnouncement AnnouncementKind = "broadcast" // Message sent by an administrator to everyone online
+)
+
+// Maximum amount of announcements kept for each server
+const MaxAnnouncements int = 100
+
+// Message of the day, banner or broadcast received from a
+// server, kept so that the ones missed can be reviewed later.
+type Announcement struct {
+	AnnouncementID uint             `gorm:"primaryKey;autoIncrement;not null"`
+	ServerID       uint             `gorm:"not null"`
+	Kind           AnnouncementKind `gorm:"not null"`
+	Sender         string           // Only known for broadcasts
+	Text           string           `gorm:"not null"`
+	Stamp          time.Time        `gorm:"not null"`
+
+	Server Server `gorm:"foreignKey:ServerID;references:ServerID;constraint:OnDelete:CASCADE"`
+}
+
 // Server indentifier that allows a multi-server platform.
 type Server struct {
 	Address  string `gorm:"primaryKey;autoIncrement:false;not null"`
@@ -53,4 +130,11 @@ type Server struct {
 	TLS      bool   `gorm:"not null"`
 	ServerID uint   `gorm:"autoIncrement:false;not null"`
 	Name     string `gorm:"unique;not null"`
+
+	// Skip certificate verification on TLS connections
+	SkipVerify bool `gorm:"column:skipverify;not null;default:false"`
+
+	// Last versions advertised by the server, empty or 0 if unknown
+	Version  string `gorm:"not null;default:''"`
+	Protocol uint8  `gorm:"not null;default:0"`
 }
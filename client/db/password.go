@@ -3,44 +3,104 @@ package db
 // Contains the functions that work with passwords
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
+/* ENCRYPTION FORMAT */
+
+// Identifies the format used to encrypt a piece of data.
+// Every version except the legacy one is stored after
+// a fixed prefix, followed by the salt, nonce and ciphertext.
+type DataVersion uint8
+
+const (
+	LegacyData   DataVersion = 0 // scrypt and AES-GCM, salt at the end and no prefix
+	ArgonData    DataVersion = 1 // Argon2id and AES-GCM
+	CurrentData  DataVersion = ArgonData
+	dataSaltSize int         = 32
+)
+
+// Prefix that precedes the version byte of the encrypted data.
+var dataPrefix = []byte("gochat")
+
+// Splits encrypted data into its version and the rest of the data.
+// Data without the prefix or with an unknown version is legacy data.
+func dataVersion(data []byte) (DataVersion, []byte) {
+	if !bytes.HasPrefix(data, dataPrefix) || len(data) <= len(dataPrefix) {
+		return LegacyData, data
+	}
+
+	v := DataVersion(data[len(dataPrefix)])
+	switch v {
+	case ArgonData:
+		return v, data[len(dataPrefix)+1:]
+	default:
+		return LegacyData, data
+	}
+}
+
+// Returns true if the data is not encrypted
+// with the current version of the format.
+func OutdatedData(data []byte) bool {
+	v, _ := dataVersion(data)
+	return v != CurrentData
+}
+
+/* KEY DERIVATION */
+
 // Returns a password expanded to fit the necessary bits for encryption
-func extendPassword(pswd []byte, salt []byte) ([]byte, []byte, error) {
+// according to the version, creating a new salt if none is given.
+func extendPassword(v DataVersion, pswd []byte, salt []byte) ([]byte, []byte, error) {
 	if salt == nil {
-		salt = make([]byte, 32)
+		salt = make([]byte, dataSaltSize)
 		_, err := rand.Read(salt)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	key, err := scrypt.Key(pswd, salt, 32768, 8, 1, 32)
-	if err != nil {
-		return nil, nil, err
+	switch v {
+	case LegacyData:
+		key, err := scrypt.Key(pswd, salt, 32768, 8, 1, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, salt, nil
+	case ArgonData:
+		key := argon2.IDKey(pswd, salt, 1, 64*1024, 4, 32)
+		return key, salt, nil
+	default:
+		return nil, nil, ErrorInvalidData
 	}
-
-	return key, salt, nil
 }
 
-// Encrypts a piece of data with a password.
-func EncryptData(key, data []byte) ([]byte, error) {
-	key, salt, err := extendPassword(key, nil)
+/* ENCRYPTION */
+
+// Creates the AES-GCM cipher used by every version.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := aes.NewCipher(key)
+	return cipher.NewGCM(block)
+}
+
+// Encrypts a piece of data with a password
+// using the current version of the format.
+func EncryptData(key, data []byte) ([]byte, error) {
+	key, salt, err := extendPassword(CurrentData, key, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -50,37 +110,81 @@ func EncryptData(key, data []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	ciphertext = append(ciphertext, salt...)
+	header := append(bytes.Clone(dataPrefix), byte(CurrentData))
+	header = append(header, salt...)
+	header = append(header, nonce...)
 
+	ciphertext := gcm.Seal(header, nonce, data, nil)
 	return ciphertext, nil
 }
 
-// Decrypts data using a password.
+// Decrypts data using a password, in any
+// version of the format it may be in.
 func DecryptData(key, data []byte) ([]byte, error) {
-	salt, data := data[len(data)-32:], data[:len(data)-32]
+	v, body := dataVersion(data)
+	if v == LegacyData {
+		return decryptLegacy(key, data)
+	}
 
-	key, _, err := extendPassword(key, salt)
+	plaintext, err := decryptVersion(v, key, body)
 	if err != nil {
+		// Legacy data may start with the prefix by chance
+		legacy, legacyErr := decryptLegacy(key, data)
+		if legacyErr == nil {
+			return legacy, nil
+		}
 		return nil, err
 	}
 
-	block, err := aes.NewCipher(key)
+	return plaintext, nil
+}
+
+// Decrypts data with a salt, nonce and ciphertext in that order.
+func decryptVersion(v DataVersion, key, data []byte) ([]byte, error) {
+	if len(data) < dataSaltSize {
+		return nil, ErrorInvalidData
+	}
+	salt, data := data[:dataSaltSize], data[dataSaltSize:]
+
+	key, _, err := extendPassword(v, key, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrorInvalidData
+	}
 	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// Decrypts data with a nonce, ciphertext and salt in that order.
+func decryptLegacy(key, data []byte) ([]byte, error) {
+	if len(data) < dataSaltSize {
+		return nil, ErrorInvalidData
+	}
+	salt, data := data[len(data)-dataSaltSize:], data[:len(data)-dataSaltSize]
+
+	key, _, err := extendPassword(LegacyData, key, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	return plaintext, nil
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrorInvalidData
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
 }
@@ -3,7 +3,11 @@ package db
 // Contains the queries needed to complete command functionality
 
 import (
+	"cmp"
+	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -48,6 +52,7 @@ func AddServer(db *gorm.DB, address string, port uint16, name string, tls bool)
 		server.ServerID = newServer.ServerID
 		server.Name = name
 		server.TLS = tls
+		server.SkipVerify = newServer.SkipVerify
 		result := db.Save(&server)
 		if result.Error != nil {
 			return Server{}, result.Error
@@ -153,6 +158,18 @@ func UpdateServer(db *gorm.DB, data any, column string, value any) error {
 	return result.Error
 }
 
+// Updates the versions last advertised by a server.
+func SetServerVersion(db *gorm.DB, address string, port uint16, version string, protocol uint8) error {
+	result := db.Model(&Server{}).
+		Where("address = ? AND port = ?", address, port).
+		Updates(map[string]any{
+			"version":  version,
+			"protocol": protocol,
+		})
+
+	return result.Error
+}
+
 // Updates TLS data about a server.
 func ChangeServerTLS(db *gorm.DB, address string, port uint16, tls bool) error {
 	sv, err := GetServer(db, address, port)
@@ -317,6 +334,58 @@ func AddLocalUser(db *gorm.DB, username string, hashPass string, prvKeyPEM strin
 	return localUser, result.Error
 }
 
+// Updates both the password hash and the encrypted private
+// key of a local user in a single transaction.
+func ChangeLocalUserPassword(db *gorm.DB, username string, hashPass string, prvKeyPEM string, address string, port uint16) error {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&LocalUser{}).
+			Where("user_id = ?", user.UserID).
+			Updates(map[string]any{
+				"password": hashPass,
+				"prv_key":  prvKeyPEM,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected != 1 {
+			return ErrorUnexpectedRows
+		}
+
+		return nil
+	})
+}
+
+// Updates the password hash and the encrypted private key of
+// several local users in a single transaction, none of them
+// are modified if any of the updates fails.
+func ReplaceLocalUserKeys(db *gorm.DB, users []LocalUser) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, v := range users {
+			result := tx.Model(&LocalUser{}).
+				Where("user_id = ?", v.UserID).
+				Updates(map[string]any{
+					"password": v.Password,
+					"prv_key":  v.PrvKey,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected != 1 {
+				return ErrorUnexpectedRows
+			}
+		}
+
+		return nil
+	})
+}
+
 // Adds a local user autoincrementally
 // in the database and then returns it.
 func DeleteLocalUser(db *gorm.DB, username string, address string, port uint16) error {
@@ -407,10 +476,56 @@ func ExternalUserExists(db *gorm.DB, username string, address string, port uint1
 	return found, result.Error
 }
 
+// Replaces the local notes of an external user.
+func SetExternalUserNotes(db *gorm.DB, username string, notes string, address string, port uint16) error {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&ExternalUser{}).
+		Where("user_id = ?", user.UserID).
+		Update("notes", notes)
+
+	return result.Error
+}
+
+// Replaces the profile bio of an external user
+// with the one last sent by the server.
+func SetExternalUserBio(db *gorm.DB, username string, bio string, address string, port uint16) error {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&ExternalUser{}).
+		Where("user_id = ?", user.UserID).
+		Update("bio", bio)
+
+	return result.Error
+}
+
+// Replaces the public key of an external user, used
+// when the user has rotated their key in the server.
+func UpdateExternalUserKey(db *gorm.DB, username string, pubKeyPEM string, address string, port uint16) error {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&ExternalUser{}).
+		Where("user_id = ?", user.UserID).
+		Update("pub_key", pubKeyPEM)
+
+	return result.Error
+}
+
 /* MESSAGES */
 
-// Adds a message to the database and returns it.
-func StoreMessage(db *gorm.DB, src, dst string, address string, port uint16, text string, stamp time.Time) (Message, error) {
+// Adds a message to the database and returns it. The sequence
+// should be zero if the server did not provide one, and the
+// type is the one given to the message by the protocol.
+func StoreMessage(db *gorm.DB, src, dst string, address string, port uint16, text string, stamp time.Time, seq uint64, msgType uint8) (Message, error) {
 	source, err := GetUser(db, src, address, port)
 	if err != nil {
 		return Message{}, nil
@@ -437,6 +552,8 @@ func StoreMessage(db *gorm.DB, src, dst string, address string, port uint16, tex
 		DestinationID: destination.UserID,
 		Text:          text,
 		Stamp:         stamp,
+		Sequence:      seq,
+		Type:          msgType,
 	}
 
 	if !ok {
@@ -444,11 +561,303 @@ func StoreMessage(db *gorm.DB, src, dst string, address string, port uint16, tex
 		if result.Error != nil {
 			return Message{}, result.Error
 		}
+		return msg, nil
+	}
+
+	// Return the one already stored to keep its identifier
+	result := db.Where(
+		"source_id = ? AND destination_id = ? AND stamp = ? AND text = ?",
+		source.UserID, destination.UserID, stamp, text,
+	).First(&msg)
+	if result.Error != nil {
+		return Message{}, result.Error
+	}
+
+	return msg, nil
+}
+
+// Summary of the conversation between a local
+// user and an external user of the same server.
+type ConversationSummary struct {
+	Username string    // Name of the external user
+	Messages int64     // Amount of messages exchanged
+	Last     time.Time // Timestamp of the last message, zero if there are none
+}
+
+// Returns a summary of the conversation of a local user with every
+// external user of a server, sorted by the most recent message.
+// External users without messages are left at the end by name.
+func ConversationSummaries(db *gorm.DB, local string, address string, port uint16) ([]ConversationSummary, error) {
+	lu, err := GetUser(db, local, address, port)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages are grouped by the other user of the conversation,
+	// numbering them so that only the most recent one is joined
+	var rows []struct {
+		Username string
+		Messages int64
+		Last     *time.Time
+	}
+
+	result := db.Raw(
+		`WITH conversations AS (
+			SELECT
+				CASE WHEN m.source_id = @local THEN m.destination_id ELSE m.source_id END AS other,
+				m.stamp,
+				ROW_NUMBER() OVER (
+					PARTITION BY CASE WHEN m.source_id = @local THEN m.destination_id ELSE m.source_id END
+					ORDER BY m.stamp DESC, m.sequence DESC, m.message_id DESC
+				) AS position,
+				COUNT(*) OVER (
+					PARTITION BY CASE WHEN m.source_id = @local THEN m.destination_id ELSE m.source_id END
+				) AS total
+			FROM messages m
+			WHERE m.source_id = @local OR m.destination_id = @local
+		)
+		SELECT u.username, COALESCE(c.total, 0) AS messages, c.stamp AS last
+		FROM users u JOIN external_users eu ON u.user_id = eu.user_id
+			LEFT JOIN conversations c ON c.other = u.user_id AND c.position = 1
+		WHERE u.server_id = @server`,
+		sql.Named("local", lu.UserID),
+		sql.Named("server", lu.ServerID),
+	).Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	summaries := make([]ConversationSummary, 0, len(rows))
+	for _, v := range rows {
+		summary := ConversationSummary{
+			Username: v.Username,
+			Messages: v.Messages,
+		}
+
+		if v.Last != nil {
+			summary.Last = *v.Last
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	slices.SortFunc(summaries, func(a, b ConversationSummary) int {
+		if c := b.Last.Compare(a.Last); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	return summaries, nil
+}
+
+// Summary of every conversation that the local
+// users of a server have with its external users.
+type ServerSummary struct {
+	Server        Server              // Server the conversations belong to
+	Contacts      int                 // External users with at least one message
+	Messages      int64               // Messages exchanged in every conversation
+	Conversations []LocalConversation // Conversations with messages, most active first
+}
+
+// Conversation summary together with the
+// local user that takes part in it.
+type LocalConversation struct {
+	Local string // Name of the local user
+	ConversationSummary
+}
+
+// Returns a summary of the conversations of every server,
+// aggregating the conversation summaries of all its local users.
+// Servers are sorted by their identifier.
+func ServerSummaries(db *gorm.DB) ([]ServerSummary, error) {
+	servers, err := GetAllServers(db)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ServerSummary, 0, len(servers))
+	for _, sv := range servers {
+		users, err := GetServerLocalUsers(db, sv.Address, sv.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		summary := ServerSummary{Server: sv}
+		contacts := make(map[string]struct{})
+		for _, lu := range users {
+			list, err := ConversationSummaries(
+				db,
+				lu.User.Username,
+				sv.Address,
+				sv.Port,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, v := range list {
+				if v.Messages == 0 {
+					continue
+				}
+
+				contacts[v.Username] = struct{}{}
+				summary.Messages += v.Messages
+				summary.Conversations = append(summary.Conversations, LocalConversation{
+					Local:               lu.User.Username,
+					ConversationSummary: v,
+				})
+			}
+		}
+		summary.Contacts = len(contacts)
+
+		slices.SortStableFunc(summary.Conversations, func(a, b LocalConversation) int {
+			if c := cmp.Compare(b.Messages, a.Messages); c != 0 {
+				return c
+			}
+			return b.Last.Compare(a.Last)
+		})
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// Returns a message by its identifier.
+func GetMessage(db *gorm.DB, id uint) (Message, error) {
+	var msg Message
+	result := db.Where("message_id = ?", id).First(&msg)
+	if result.Error != nil {
+		return Message{}, result.Error
 	}
 
 	return msg, nil
 }
 
+// Keeps the ciphertext a message was received as.
+func SetMessageCiphertext(db *gorm.DB, id uint, ciphertext []byte) error {
+	result := db.Model(&Message{}).
+		Where("message_id = ?", id).
+		Update("ciphertext", ciphertext)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return ErrorUnexpectedRows
+	}
+
+	return nil
+}
+
+// Marks or unmarks a message as pinned.
+func SetPinned(db *gorm.DB, id uint, pinned bool) error {
+	result := db.Model(&Message{}).
+		Where("message_id = ?", id).
+		Update("pinned", pinned)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return ErrorUnexpectedRows
+	}
+
+	return nil
+}
+
+/* TAG QUERIES */
+
+// Adds a label to a message if it did not have it
+// or removes it otherwise, returning whether it is
+// tagged with the label afterwards.
+func ToggleMessageTag(db *gorm.DB, id uint, label string) (bool, error) {
+	tag := MessageTag{
+		MessageID: id,
+		Label:     label,
+	}
+
+	result := db.Delete(&tag)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected != 0 {
+		return false, nil
+	}
+
+	result = db.Create(&tag)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return true, nil
+}
+
+// Returns the labels of each of the given
+// messages, sorted alphabetically.
+func GetMessageTags(db *gorm.DB, ids []uint) (map[uint][]string, error) {
+	var tags []MessageTag
+
+	result := db.Where("message_id IN ?", ids).
+		Order("label ASC").
+		Find(&tags)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	labels := make(map[uint][]string)
+	for _, v := range tags {
+		labels[v.MessageID] = append(labels[v.MessageID], v.Label)
+	}
+
+	return labels, nil
+}
+
+// Returns every message of a server that has been tagged
+// with the given label, with the users of each one filled.
+func GetTaggedMessages(db *gorm.DB, label string, address string, port uint16) ([]Message, error) {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	result := db.Raw(
+		`SELECT m.*
+			FROM messages m JOIN message_tags t ON m.message_id = t.message_id
+			WHERE t.label = ? AND m.source_id IN
+				(SELECT user_id FROM users WHERE server_id = ?)
+		ORDER BY m.stamp ASC, m.sequence ASC, m.message_id ASC`,
+		label, server.ServerID,
+	).Scan(&messages)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// Users are shared between most of the messages
+	users := make(map[uint]User)
+	for i, v := range messages {
+		for _, id := range []uint{v.SourceID, v.DestinationID} {
+			if _, ok := users[id]; ok {
+				continue
+			}
+
+			user, err := getUserByID(db, id)
+			if err != nil {
+				return nil, err
+			}
+			users[id] = user
+		}
+
+		messages[i].SourceUser = users[v.SourceID]
+		messages[i].DestinationUser = users[v.DestinationID]
+	}
+
+	return messages, nil
+}
+
 // Returns a slice with every message between
 // two users until a certain point in time.
 func GetUsersMessagesLimit(db *gorm.DB, src, dst string, address string, port uint16, limit time.Time) ([]Message, error) {
@@ -497,7 +906,7 @@ func GetAllUsersMessages(db *gorm.DB, src, dst string, address string, port uint
 		(source_id = ? AND destination_id = ?)`,
 		source.UserID, destination.UserID,
 		destination.UserID, source.UserID,
-	).Order("stamp ASC").Find(&messages)
+	).Order("stamp ASC, sequence ASC, message_id ASC").Find(&messages)
 
 	for i, v := range messages {
 		if v.SourceID == source.UserID {
@@ -516,31 +925,390 @@ func GetAllUsersMessages(db *gorm.DB, src, dst string, address string, port uint
 	return messages, nil
 }
 
-// Deletes all messages between two specified users in a same server.
-func DeleteConversation(db *gorm.DB, src, dst string, address string, port uint16) error {
+// Returns the first message exchanged between two users
+// of the same server whose stamp is not before the given
+// time, or gorm.ErrRecordNotFound if there is none.
+func GetFirstMessageSince(db *gorm.DB, src, dst string, address string, port uint16, since time.Time) (Message, error) {
+	var message Message
+
 	source, err := GetUser(db, src, address, port)
 	if err != nil {
-		return err
+		return message, err
 	}
 
 	destination, err := GetUser(db, dst, address, port)
 	if err != nil {
-		return err
+		return message, err
 	}
 
 	result := db.Where(
+		`((source_id = ? AND destination_id = ?) 
+		OR 
+		(source_id = ? AND destination_id = ?))
+		AND stamp >= ?`,
+		source.UserID, destination.UserID,
+		destination.UserID, source.UserID,
+		since,
+	).Order("stamp ASC, sequence ASC, message_id ASC").First(&message)
+
+	return message, result.Error
+}
+
+// Returns whether two users of the same server have
+// exchanged any message.
+func HasMessages(db *gorm.DB, src, dst string, address string, port uint16) (bool, error) {
+	source, err := GetUser(db, src, address, port)
+	if err != nil {
+		return false, err
+	}
+
+	destination, err := GetUser(db, dst, address, port)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	result := db.Model(&Message{}).Where(
 		`(source_id = ? AND destination_id = ?) 
 		OR 
 		(source_id = ? AND destination_id = ?)`,
 		source.UserID, destination.UserID,
 		destination.UserID, source.UserID,
-	).Delete(&Message{})
+	).Count(&count)
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+// Deletes all messages between two specified users in a same server,
+// along with their tags.
+func DeleteConversation(db *gorm.DB, src, dst string, address string, port uint16) error {
+	source, err := GetUser(db, src, address, port)
+	if err != nil {
+		return err
+	}
+
+	destination, err := GetUser(db, dst, address, port)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		messages := tx.Model(&Message{}).Select("message_id").Where(
+			`(source_id = ? AND destination_id = ?) 
+			OR 
+			(source_id = ? AND destination_id = ?)`,
+			source.UserID, destination.UserID,
+			destination.UserID, source.UserID,
+		)
+
+		result := tx.Where("message_id IN (?)", messages).Delete(&MessageTag{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Where("message_id IN (?)", messages).Delete(&Message{}).Error
+	})
+}
+
+// Deletes every message of a server that is older than the given
+// time, except for pinned ones, along with their tags. Returns the
+// amount of deleted messages.
+func PurgeOldMessages(db *gorm.DB, olderThan time.Time, address string, port uint16) (int64, error) {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		messages := tx.Model(&Message{}).Select("message_id").Where(
+			`stamp < ? AND pinned = ? AND source_id IN
+				(SELECT user_id FROM users WHERE server_id = ?)`,
+			olderThan, false, server.ServerID,
+		)
+
+		result := tx.Where("message_id IN (?)", messages).Delete(&MessageTag{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Where("message_id IN (?)", messages).Delete(&Message{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+
+	return deleted, err
+}
+
+/* QUICK COMMAND QUERIES */
+
+// Saves a quick command for a server, replacing
+// the command if the name was already in use.
+func SetQuickCommand(db *gorm.DB, name string, command string, address string, port uint16) error {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Save(&QuickCommand{
+		ServerID: server.ServerID,
+		Name:     name,
+		Command:  command,
+	})
+
+	return result.Error
+}
+
+// Removes a quick command from a server.
+func RemoveQuickCommand(db *gorm.DB, name string, address string, port uint16) error {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Where(
+		"server_id = ? AND name = ?",
+		server.ServerID, name,
+	).Delete(&QuickCommand{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return ErrorUnexpectedRows
+	}
+
+	return nil
+}
+
+// Returns the quick commands of a server sorted by name.
+func GetQuickCommands(db *gorm.DB, address string, port uint16) ([]QuickCommand, error) {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []QuickCommand
+	result := db.Where("server_id = ?", server.ServerID).
+		Order("name ASC").
+		Find(&list)
 
+	return list, result.Error
+}
+
+/* DRAFT QUERIES */
+
+// Saves the unsent text of a buffer, replacing
+// the previous draft of the buffer if any.
+func SaveDraft(db *gorm.DB, buffer string, text string, address string, port uint16) error {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Save(&Draft{
+		ServerID: server.ServerID,
+		Buffer:   buffer,
+		Text:     text,
+		Stamp:    time.Now(),
+	})
+
+	return result.Error
+}
+
+// Removes the draft of a buffer if there is one.
+func DeleteDraft(db *gorm.DB, buffer string, address string, port uint16) error {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return err
+	}
+
+	result := db.Where(
+		"server_id = ? AND buffer = ?",
+		server.ServerID, buffer,
+	).Delete(&Draft{})
+
+	return result.Error
+}
+
+// Returns the most recently saved draft along
+// with its server, and whether there was any.
+func GetLatestDraft(db *gorm.DB) (Draft, bool, error) {
+	var drafts []Draft
+	result := db.Preload("Server").
+		Order("stamp DESC").
+		Limit(1).
+		Find(&drafts)
+	if result.Error != nil {
+		return Draft{}, false, result.Error
+	}
+
+	if len(drafts) == 0 {
+		return Draft{}, false, nil
+	}
+
+	return drafts[0], true, nil
+}
+
+// Removes the drafts of every server.
+func ClearDrafts(db *gorm.DB) error {
+	result := db.Where("1 = 1").Delete(&Draft{})
 	return result.Error
 }
 
+/* KEY HISTORY QUERIES */
+
+// Records the fingerprint of a key seen for an external user,
+// unless it is the last one that was recorded. The time it was
+// first seen may be zero if it is unknown.
+func AddKeyFingerprint(db *gorm.DB, username string, fingerprint string, seen time.Time, address string, port uint16) error {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return err
+	}
+
+	var last KeyHistory
+	result := db.Where("user_id = ?", user.UserID).
+		Order("key_id DESC").
+		Limit(1).
+		Find(&last)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 1 && last.Fingerprint == fingerprint {
+		return nil
+	}
+
+	result = db.Create(&KeyHistory{
+		UserID:      user.UserID,
+		Fingerprint: fingerprint,
+		FirstSeen:   seen,
+	})
+	return result.Error
+}
+
+// Returns the fingerprints recorded for an external
+// user, starting by the first one that was seen.
+func GetKeyHistory(db *gorm.DB, username string, address string, port uint16) ([]KeyHistory, error) {
+	user, err := GetUser(db, username, address, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []KeyHistory
+	result := db.Where("user_id = ?", user.UserID).
+		Order("key_id ASC").
+		Find(&history)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return history, nil
+}
+
+/* ANNOUNCEMENT QUERIES */
+
+// Stores an announcement of a server and removes the oldest ones
+// over the limit. A message of the day or banner equal to the last
+// one of its kind is skipped, as they are sent on every connection.
+// Returns whether the announcement was stored.
+func AddAnnouncement(db *gorm.DB, ann Announcement, address string, port uint16) (bool, error) {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return false, err
+	}
+
+	if ann.Kind != BroadcastAnnouncement {
+		var last Announcement
+		result := db.Where("server_id = ? AND kind = ?", server.ServerID, ann.Kind).
+			Order("announcement_id DESC").
+			Limit(1).
+			Find(&last)
+		if result.Error != nil {
+			return false, result.Error
+		}
+
+		if result.RowsAffected == 1 && last.Text == ann.Text {
+			return false, nil
+		}
+	}
+
+	ann.ServerID = server.ServerID
+	result := db.Create(&ann)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	result = db.Where(
+		`server_id = ? AND announcement_id NOT IN (
+			SELECT announcement_id FROM announcements
+			WHERE server_id = ?
+			ORDER BY announcement_id DESC
+			LIMIT ?
+		)`,
+		server.ServerID, server.ServerID, MaxAnnouncements,
+	).Delete(&Announcement{})
+
+	return true, result.Error
+}
+
+// Returns the announcements stored for a server,
+// starting by the oldest one.
+func GetAnnouncements(db *gorm.DB, address string, port uint16) ([]Announcement, error) {
+	server, err := GetServer(db, address, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Announcement
+	result := db.Where("server_id = ?", server.ServerID).
+		Order("announcement_id ASC").
+		Find(&list)
+
+	return list, result.Error
+}
+
 /* RECOVERY FUNCTIONS */
 
+// Local user that no longer belongs to any server
+// along with the amount of messages it has.
+type DanglingUser struct {
+	Username string // Name of the local user
+	ServerID uint   // Identifier of the deleted server
+	Messages int64  // Amount of messages sent or received
+}
+
+// Returns all local users not belonging to any server,
+// sorted by username and then by their deleted server.
+func GetDanglingUsers(db *gorm.DB) ([]DanglingUser, error) {
+	var users []DanglingUser
+	result := db.Raw(
+		`SELECT u.username, u.server_id,
+			(SELECT COUNT(*)
+				FROM messages m
+				WHERE m.source_id = u.user_id
+					OR m.destination_id = u.user_id
+			) AS messages
+		FROM local_users lu JOIN users u ON lu.user_id = u.user_id
+		WHERE u.server_id NOT IN (
+			SELECT server_id
+			FROM servers
+		)
+		ORDER BY u.username, u.server_id`,
+	).Scan(&users)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return users, nil
+}
+
 // Tries to recover all local users not belonging to any server
 // given a username
 func RecoverUsers(db *gorm.DB, username string) ([]LocalUser, error) {
@@ -601,7 +1369,7 @@ func RecoverMessages(db *gorm.DB, lu LocalUser) ([][]Message, error) {
 			FROM messages
 			WHERE (source_id = ? AND destination_id = ?) 
 				OR (source_id = ? AND destination_id = ?)
-			ORDER BY stamp ASC`,
+			ORDER BY stamp ASC, sequence ASC, message_id ASC`,
 			lu.UserID, v,
 			v, lu.UserID,
 		).Scan(&messages)
@@ -651,3 +1419,174 @@ func CleanupUser(db *gorm.DB, lu LocalUser) error {
 
 	return nil
 }
+
+/* STATISTICS */
+
+// Amount of rows stored in each table of the database.
+type Stats struct {
+	Servers       int64
+	Users         int64
+	LocalUsers    int64
+	ExternalUsers int64
+	Messages      int64
+}
+
+// Returns the amount of rows of every table in the database.
+func GetStats(db *gorm.DB) (stats Stats, err error) {
+	counts := []struct {
+		model any
+		dest  *int64
+	}{
+		{Server{}, &stats.Servers},
+		{User{}, &stats.Users},
+		{LocalUser{}, &stats.LocalUsers},
+		{ExternalUser{}, &stats.ExternalUsers},
+		{Message{}, &stats.Messages},
+	}
+
+	for _, v := range counts {
+		result := db.Model(v.model).Count(v.dest)
+		if result.Error != nil {
+			return stats, result.Error
+		}
+	}
+
+	return stats, nil
+}
+
+// Returns the size in bytes the database takes on disk,
+// as reported by SQLite.
+func GetDatabaseSize(db *gorm.DB) (int64, error) {
+	var size int64
+	result := db.Raw(
+		`SELECT page_count * page_size
+		FROM pragma_page_count(), pragma_page_size()`,
+	).Scan(&size)
+	return size, result.Error
+}
+
+/* INTEGRITY */
+
+// Rows that reference users which no longer exist,
+// identified by their primary key.
+type Orphans struct {
+	LocalUsers    []uint // Local users without a user
+	ExternalUsers []uint // External users without a user
+	Messages      []uint // Messages whose sender or recipient does not exist
+}
+
+// Total amount of orphaned rows.
+func (o Orphans) Count() int {
+	return len(o.LocalUsers) + len(o.ExternalUsers) + len(o.Messages)
+}
+
+// Runs the SQLite integrity check and returns the problems
+// it reports, which means an empty slice if there are none.
+func CheckIntegrity(db *gorm.DB) ([]string, error) {
+	var lines []string
+	result := db.Raw("PRAGMA integrity_check").Scan(&lines)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	problems := make([]string, 0)
+	for _, v := range lines {
+		if v != "ok" {
+			problems = append(problems, v)
+		}
+	}
+
+	return problems, nil
+}
+
+// Returns the rows that reference users that do not exist.
+// Nothing is modified in the database.
+func GetOrphans(db *gorm.DB) (orphans Orphans, err error) {
+	queries := []struct {
+		query string
+		dest  *[]uint
+	}{
+		{
+			`SELECT user_id
+			FROM local_users
+			WHERE user_id NOT IN (SELECT user_id FROM users)
+			ORDER BY user_id`,
+			&orphans.LocalUsers,
+		},
+		{
+			`SELECT user_id
+			FROM external_users
+			WHERE user_id NOT IN (SELECT user_id FROM users)
+			ORDER BY user_id`,
+			&orphans.ExternalUsers,
+		},
+		{
+			`SELECT message_id
+			FROM messages
+			WHERE source_id NOT IN (SELECT user_id FROM users)
+				OR destination_id NOT IN (SELECT user_id FROM users)
+			ORDER BY message_id`,
+			&orphans.Messages,
+		},
+	}
+
+	for _, v := range queries {
+		result := db.Raw(v.query).Scan(v.dest)
+		if result.Error != nil {
+			return orphans, result.Error
+		}
+	}
+
+	return orphans, nil
+}
+
+// Deletes the given orphaned rows at once, along with the tags
+// of the deleted messages, and returns how many were deleted.
+func RemoveOrphans(db *gorm.DB, orphans Orphans) (int64, error) {
+	var total int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(orphans.Messages) > 0 {
+			result := tx.Where("message_id IN ?", orphans.Messages).Delete(&MessageTag{})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			result = tx.Where("message_id IN ?", orphans.Messages).Delete(&Message{})
+			if result.Error != nil {
+				return result.Error
+			}
+			total += result.RowsAffected
+		}
+
+		if len(orphans.LocalUsers) > 0 {
+			result := tx.Where("user_id IN ?", orphans.LocalUsers).Delete(&LocalUser{})
+			if result.Error != nil {
+				return result.Error
+			}
+			total += result.RowsAffected
+		}
+
+		if len(orphans.ExternalUsers) > 0 {
+			result := tx.Where("user_id IN ?", orphans.ExternalUsers).Delete(&ExternalUser{})
+			if result.Error != nil {
+				return result.Error
+			}
+			total += result.RowsAffected
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// Rebuilds the database to reclaim the space left by deleted rows.
+// It cannot be ran inside a transaction.
+func VacuumDatabase(db *gorm.DB) error {
+	result := db.Exec("VACUUM")
+	return result.Error
+}
@@ -3,6 +3,7 @@ package main
 // Main gochat client package
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"github.com/Sprinter05/gochat/client/cli"
 	"github.com/Sprinter05/gochat/client/commands"
@@ -33,6 +35,10 @@ type Config struct {
 		Port       uint16 `json:"port"`
 		TLS        bool   `json:"use_tls"`
 		VerifyCert bool   `json:"verify_tls"`
+		Poll       uint   `json:"poll_seconds"` // 0 to disable
+		Confirm    bool   `json:"confirm_first_message"`
+		AutoReq    bool   `json:"auto_request"`
+		SelfCopy   bool   `json:"self_copy"`
 	} `json:"shell_server"`
 	Database struct {
 		Path     string `json:"path"`
@@ -40,7 +46,20 @@ type Config struct {
 		LogLevel uint8  `json:"log_level"` // From 1 to 4
 	} `json:"database"`
 	UIConfig struct {
-		DebugBuffer bool `json:"debug_buffer"`
+		DebugBuffer   bool                         `json:"debug_buffer"`
+		Verbose       bool                         `json:"verbose"` // Also enabled by the flag
+		Permissions   []ui.PermissionStyle         `json:"permission_styles"`
+		Retention     ui.RetentionPolicy           `json:"retention"`
+		BufferSort    string                       `json:"buffer_sort"`
+		QuickCommands map[string]map[string]string `json:"quick_commands"` // By server name
+		NameLength    uint                         `json:"name_length"`
+		Unicode       *bool                        `json:"unicode"` // Detected if missing
+		SelfCopy      bool                         `json:"self_copy"`
+		HistorySize   *uint                        `json:"history_size"` // Default used if missing
+		HistoryDedup  string                       `json:"history_dedup"`
+		NickColors    *bool                        `json:"nick_colors"` // Enabled if missing
+		NickPalette   []string                     `json:"nick_palette"`
+		ConfirmQuit   *bool                        `json:"confirm_quit"` // Enabled if missing
 	} `json:"ui_config"`
 }
 
@@ -94,6 +113,12 @@ var (
 	configFile   string
 	useShell     bool
 	verbosePrint bool
+	scriptFile   string
+	scriptGoOn   bool
+	scriptExit   bool
+	runAsDaemon  bool
+	attachTo     bool
+	socketFile   string
 )
 
 // Function that is ran every time the program is started
@@ -103,8 +128,19 @@ func init() {
 	flag.StringVar(&configFile, "config", "config.json", "Configuration file to use. Must be in JSON format.")
 	flag.BoolVar(&useShell, "shell", false, "Whether to use a shell instead of a TUI.")
 	flag.BoolVar(&verbosePrint, "verbose", false, "Whether or not to print verbose output information.")
+	flag.StringVar(&scriptFile, "exec", "", "Script of shell commands to run at startup. Implies -shell.")
+	flag.BoolVar(&scriptGoOn, "continue", false, "Whether the script keeps running after a command fails.")
+	flag.BoolVar(&scriptExit, "exit", false, "Whether to exit after the script instead of staying in the shell.")
+	flag.BoolVar(&runAsDaemon, "daemon", false, "Whether to run the TUI in the background so that a terminal can be attached later.")
+	flag.BoolVar(&attachTo, "attach", false, "Whether to attach the terminal to a running daemon instead of starting a client.")
+	flag.StringVar(&socketFile, "socket", "gochat.sock", "Local socket used by the daemon and attached terminals.")
 	flag.Parse()
 
+	// Scripts are only run by the shell
+	if scriptFile != "" {
+		useShell = true
+	}
+
 	folders := []string{
 		"export",
 		"import",
@@ -128,6 +164,14 @@ func init() {
 
 // Main client function
 func main() {
+	// Nothing else is needed to attach a terminal
+	if attachTo {
+		if err := runAttach(socketFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Reads configuration file
 	config := getConfig()
 
@@ -144,10 +188,38 @@ func main() {
 
 // Function that creates a new TUI and executes it
 func setupTUI(config Config, dbconn *gorm.DB) {
-	_, app := ui.New(commands.StaticData{
-		Verbose: verbosePrint,
-		DB:      dbconn,
-	}, config.UIConfig.DebugBuffer && verbosePrint)
+	verbose := verbosePrint || config.UIConfig.Verbose
+	t, app := ui.New(commands.StaticData{
+		Verbose:  verbose,
+		DB:       dbconn,
+		SelfCopy: config.UIConfig.SelfCopy,
+	}, config.UIConfig.DebugBuffer && verbose, config.UIConfig.Retention)
+	t.SetPermissionStyles(config.UIConfig.Permissions)
+	t.SetBufferSort(config.UIConfig.BufferSort)
+	t.SetQuickCommands(config.UIConfig.QuickCommands)
+	t.SetNameLength(config.UIConfig.NameLength)
+	if config.UIConfig.Unicode != nil {
+		t.SetUnicode(*config.UIConfig.Unicode)
+	}
+	if config.UIConfig.HistorySize != nil {
+		t.SetHistorySize(*config.UIConfig.HistorySize)
+	}
+	t.SetHistoryDedup(config.UIConfig.HistoryDedup)
+	if config.UIConfig.NickColors != nil {
+		t.SetNickColors(*config.UIConfig.NickColors)
+	}
+	t.SetNickPalette(config.UIConfig.NickPalette)
+	if config.UIConfig.ConfirmQuit != nil {
+		t.SetConfirmQuit(*config.UIConfig.ConfirmQuit)
+	}
+	t.SetConfigFile(newEditableConfig(configFile, &config, t))
+
+	if runAsDaemon {
+		// Editors cannot use the terminal of an attached client
+		os.Unsetenv("EDITOR")
+		runDaemon(app, socketFile)
+		return
+	}
 
 	if err := app.Run(); err != nil {
 		log.Fatal(err)
@@ -160,12 +232,13 @@ func setupShell(config Config, dbconn *gorm.DB) {
 	port := config.ShellServer.Port
 
 	var conn net.Conn
+	var state *tls.ConnectionState
 	var server db.Server
 
 	// Connect automatically if shell server exists
 	if address != "" {
 		var conErr error
-		conn, conErr = commands.SocketConnect(
+		conn, state, conErr = commands.SocketConnect(
 			address, port,
 			config.ShellServer.TLS,
 			config.ShellServer.VerifyCert,
@@ -177,9 +250,30 @@ func setupShell(config Config, dbconn *gorm.DB) {
 	}
 
 	args := cli.New(commands.StaticData{
-		Verbose: verbosePrint,
-		DB:      dbconn,
-	}, conn, server)
+		Verbose:     verbosePrint,
+		DB:          dbconn,
+		AutoRequest: config.ShellServer.AutoReq,
+		SelfCopy:    config.ShellServer.SelfCopy,
+	}, conn, state, server)
+
+	// Opt-in as scripts cannot answer the prompt
+	cli.ConfirmContacts = config.ShellServer.Confirm
+
+	// Opt-in to avoid unrequested output in scripts
+	if config.ShellServer.Poll > 0 {
+		interval := time.Duration(config.ShellServer.Poll) * time.Second
+		go cli.Poll(args, interval)
+	}
+
+	if scriptFile != "" {
+		exit, err := cli.RunScript(args, scriptFile, scriptGoOn)
+		if scriptExit || exit {
+			if err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
 	cli.Run(args)
 }
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+
+	"github.com/Sprinter05/gochat/client/db"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypts data in the format used before versioning
+// was introduced: nonce, ciphertext and salt.
+func legacyEncrypt(t *testing.T, pass, data []byte) []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+
+	key, err := scrypt.Key(pass, salt, 32768, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	enc := gcm.Seal(nonce, nonce, data, nil)
+	return append(enc, salt...)
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	pass := []byte("password123")
+	data := []byte("private key")
+
+	enc := legacyEncrypt(t, pass, data)
+	if !db.OutdatedData(enc) {
+		t.Fatal("legacy data not detected as outdated")
+	}
+
+	dec, err := db.DecryptData(pass, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dec, data) {
+		t.Fail()
+	}
+}
+
+func TestDecryptCurrent(t *testing.T) {
+	pass := []byte("password123")
+	data := []byte("private key")
+
+	enc, err := db.EncryptData(pass, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.OutdatedData(enc) {
+		t.Fatal("current data detected as outdated")
+	}
+
+	dec, err := db.DecryptData(pass, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dec, data) {
+		t.Fail()
+	}
+
+	_, err = db.DecryptData([]byte("wrong"), enc)
+	if err == nil {
+		t.Fatal("decrypted with the wrong password")
+	}
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	_, err := db.DecryptData([]byte("password123"), []byte("short"))
+	if err == nil {
+		t.Fail()
+	}
+}
@@ -2,11 +2,13 @@ package ui
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	cmds "github.com/Sprinter05/gochat/client/commands"
 	"github.com/Sprinter05/gochat/internal/models"
 	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
 )
 
 /* STRUCTS */
@@ -18,6 +20,8 @@ type tab struct {
 	name     string // Identifies the name
 	creation int    // Identifies the internal buffer list order
 
+	activity time.Time // Timestamp of the last message in the buffer
+
 	messages models.Slice[Message] // Messages stored in the buffer
 
 	connected bool // Whether its asocciated to a server endpoint or not
@@ -46,6 +50,14 @@ func ascii(num int) int32 {
 	return int32(offset)
 }
 
+// Updates the last activity of the buffer if the
+// timestamp is more recent than the current one.
+func (b *tab) touch(stamp time.Time) {
+	if stamp.After(b.activity) {
+		b.activity = stamp
+	}
+}
+
 // Returns the currently active tab
 // in the current server. Only returns
 // the name and not the actual data.
@@ -211,7 +223,7 @@ func (t *TUI) addBuffer(name string, system bool) {
 	if i == -1 {
 		return
 	}
-	t.comp.buffers.AddItem(name, "", r, nil)
+	t.comp.buffers.AddItem(t.shortName(name), name, r, nil)
 
 	// We try to request the user first
 	empty := func(string, cmds.OutputType) {}
@@ -228,6 +240,43 @@ func (t *TUI) addBuffer(name string, system bool) {
 	t.changeBuffer(i)
 }
 
+// Rebuilds the buffer list of the active server in the configured
+// order, keeping the currently selected buffer selected.
+func (t *TUI) reorderBuffers() {
+	s := t.Active()
+	curr := t.Buffer()
+
+	t.comp.buffers.Clear()
+	for _, v := range sortTabs(s.Buffers(), t.params.BufferSort) {
+		if v.index != -1 {
+			t.comp.buffers.AddItem(t.shortName(v.name), v.name, ascii(v.index), nil)
+		}
+	}
+
+	i, ok := t.findBuffer(curr)
+	if ok {
+		t.comp.buffers.SetCurrentItem(i)
+	}
+}
+
+// Reorders the buffer list after a short delay, so that
+// several messages in a row only reorder it once.
+func (t *TUI) scheduleReorder() {
+	if t.params.BufferSort != sortActivity {
+		return
+	}
+
+	if !t.status.reordering.CompareAndSwap(false, true) {
+		return
+	}
+
+	time.AfterFunc(time.Duration(sortDelay)*time.Millisecond, func() {
+		t.status.reordering.Store(false)
+		t.reorderBuffers()
+		t.app.Draw()
+	})
+}
+
 // Changes the TUI component according to the internal
 // index of the list and then renders the buffer.
 func (t *TUI) changeBuffer(i int) {
@@ -236,7 +285,7 @@ func (t *TUI) changeBuffer(i int) {
 	}
 
 	t.comp.buffers.SetCurrentItem(i)
-	text, _ := t.comp.buffers.GetItemText(i)
+	_, text := t.comp.buffers.GetItemText(i)
 	t.renderBuffer(text)
 }
 
@@ -244,7 +293,8 @@ func (t *TUI) changeBuffer(i int) {
 // in the TUI component. Returns whether it was found
 // or not as well.
 func (t *TUI) findBuffer(name string) (int, bool) {
-	l := t.comp.buffers.FindItems(name, "", true, false)
+	// The full name is kept as the hidden secondary text
+	l := t.comp.buffers.FindItems("", name, true, false)
 
 	if len(l) != 0 {
 		return l[0], true
@@ -319,6 +369,16 @@ func (t *TUI) renderBuffer(buf string) {
 		t.comp.buffers.SetSelectedTextColor(tcell.ColorPurple)
 	}
 
+	// Truncated names are shown in full in the title
+	title := "Messages"
+	if t.shortName(buf) != buf {
+		title = fmt.Sprintf("Messages (%s)", buf)
+	}
+	t.comp.text.SetTitle(tview.Escape(title))
+
+	// Restores a pending draft of this buffer
+	t.restoreDraft()
+
 	if t.status.showingHelp {
 		return
 	}
@@ -343,5 +403,11 @@ func (t *TUI) renderBuffer(buf string) {
 			)
 		}
 	}
+
+	// Keep the selected message highlighted
+	if t.status.selected != 0 {
+		t.comp.text.Highlight(strconv.FormatUint(uint64(t.status.selected), 10))
+	}
+
 	t.updateNotifications()
 }
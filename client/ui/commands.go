@@ -2,16 +2,25 @@ package ui
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	cmds "github.com/Sprinter05/gochat/client/commands"
 	"github.com/Sprinter05/gochat/client/db"
 	"github.com/Sprinter05/gochat/internal/spec"
 	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"gorm.io/gorm"
 )
 
 /* TYPES */
@@ -42,6 +51,26 @@ var commands map[string]operation = map[string]operation{
 		nArgs:  0,
 		format: "/version",
 	},
+	"serverversion": {
+		fun:    showServerVersion,
+		nArgs:  0,
+		format: "/serverversion",
+	},
+	"lastpacket": {
+		fun:    showLastPacket,
+		nArgs:  0,
+		format: "/lastpacket",
+	},
+	"errors": {
+		fun:    showErrors,
+		nArgs:  0,
+		format: "/errors (code)",
+	},
+	"operations": {
+		fun:    showOperations,
+		nArgs:  0,
+		format: "/operations",
+	},
 	"servers": {
 		fun:    listServers,
 		nArgs:  0,
@@ -57,16 +86,126 @@ var commands map[string]operation = map[string]operation{
 		nArgs:  0,
 		format: "/clear",
 	},
+	"decode": {
+		fun:    decodePacket,
+		nArgs:  1,
+		format: "/decode <hexbytes>",
+	},
+	"probe": {
+		fun:    probeServer,
+		nArgs:  1,
+		format: "/probe <address:port> (-tls)",
+	},
+	"pingall": {
+		fun:    pingAllServers,
+		nArgs:  0,
+		format: "/pingall",
+	},
+	"raw": {
+		fun:    rawPacket,
+		nArgs:  2,
+		format: "/raw <op> <info> (args...)",
+	},
+	"goroutines": {
+		fun:    listGoroutines,
+		nArgs:  0,
+		format: "/goroutines",
+	},
+	"inspect": {
+		fun:    inspectMessage,
+		nArgs:  0,
+		format: "/inspect",
+	},
+	"benchmark": {
+		fun:    benchmark,
+		nArgs:  1,
+		format: "/benchmark <crypto>",
+	},
+	"inbox": {
+		fun:    showInbox,
+		nArgs:  0,
+		format: "/inbox",
+	},
+	"graph": {
+		fun:    showGraph,
+		nArgs:  0,
+		format: "/graph",
+	},
+	"pin": {
+		fun:    pinMessage,
+		nArgs:  0,
+		format: "/pin",
+	},
+	"pinned": {
+		fun:    listPinned,
+		nArgs:  0,
+		format: "/pinned",
+	},
+	"jump": {
+		fun:    jumpToDate,
+		nArgs:  1,
+		format: "/jump <date>",
+	},
+	"reply": {
+		fun:    replyMessage,
+		nArgs:  0,
+		format: "/reply",
+	},
+	"tag": {
+		fun:    tagMessage,
+		nArgs:  1,
+		format: "/tag <label>",
+	},
+	"tagged": {
+		fun:    listTagged,
+		nArgs:  1,
+		format: "/tagged <label>",
+	},
+	"compose": {
+		fun:    composeMessage,
+		nArgs:  0,
+		format: "/compose",
+	},
+	"me": {
+		fun:    sendAction,
+		nArgs:  1,
+		format: "/me <action>",
+	},
+	"resend": {
+		fun:    resendMessage,
+		nArgs:  1,
+		format: "/resend <user>",
+	},
+	"dbinfo": {
+		fun:    databaseInfo,
+		nArgs:  0,
+		format: "/dbinfo",
+	},
+	"dbvacuum": {
+		fun:    databaseVacuum,
+		nArgs:  0,
+		format: "/dbvacuum",
+	},
+	"dbcheck": {
+		fun:    databaseCheck,
+		nArgs:  0,
+		format: "/dbcheck (-repair)",
+	},
 	"config": {
 		fun:    showConfig,
 		nArgs:  0,
-		format: "/config",
+		format: "/config (show/set <option> <value>)",
 	},
 	"set": {
 		fun:    setConfig,
 		nArgs:  2,
 		format: "/set <option> <value>",
 	},
+	"rename-server": {
+		fun:    renameServer,
+		nArgs:  2,
+		format: "/rename-server <old> <new>",
+	},
 	"connect": {
 		fun:    connectServer,
 		nArgs:  0,
@@ -87,11 +226,31 @@ var commands map[string]operation = map[string]operation{
 		nArgs:  2,
 		format: "/import <username> <path>",
 	},
+	"keyinfo": {
+		fun:    keyInfo,
+		nArgs:  1,
+		format: "/keyinfo <path>",
+	},
 	"export": {
 		fun:    exportKey,
 		nArgs:  1,
 		format: "/export <username>",
 	},
+	"export-html": {
+		fun:    exportHTML,
+		nArgs:  2,
+		format: "/export-html <username> <path>",
+	},
+	"passwd": {
+		fun:    changePassword,
+		nArgs:  1,
+		format: "/passwd <username>",
+	},
+	"rekeyall": {
+		fun:    rekeyAll,
+		nArgs:  0,
+		format: "/rekeyall (-master)",
+	},
 	"login": {
 		fun:    loginUser,
 		nArgs:  1,
@@ -107,11 +266,76 @@ var commands map[string]operation = map[string]operation{
 		nArgs:  0,
 		format: "/disconnect",
 	},
+	"leave": {
+		fun:    leaveServer,
+		nArgs:  0,
+		format: "/leave",
+	},
+	"cancel-reconnect": {
+		fun:    cancelReconnect,
+		nArgs:  0,
+		format: "/cancel-reconnect",
+	},
+	"secinfo": {
+		fun:    securityInfo,
+		nArgs:  0,
+		format: "/secinfo",
+	},
 	"users": {
 		fun:    listUsers,
 		nArgs:  2,
 		format: "/users <remote/local> <all/online/server> (-perms)",
 	},
+	"refresh": {
+		fun:    refreshUserlist,
+		nArgs:  0,
+		format: "/refresh",
+	},
+	"reqall": {
+		fun:    requestAllUsers,
+		nArgs:  0,
+		format: "/reqall",
+	},
+	"resync": {
+		fun:    resyncUser,
+		nArgs:  1,
+		format: "/resync <user>",
+	},
+	"resyncall": {
+		fun:    resyncAllUsers,
+		nArgs:  0,
+		format: "/resyncall",
+	},
+	"announcements": {
+		fun:    listAnnouncements,
+		nArgs:  0,
+		format: "/announcements",
+	},
+	"keyhistory": {
+		fun:    showKeyHistory,
+		nArgs:  1,
+		format: "/keyhistory <user>",
+	},
+	"selftest": {
+		fun:    selfTest,
+		nArgs:  0,
+		format: "/selftest",
+	},
+	"note": {
+		fun:    addNote,
+		nArgs:  2,
+		format: "/note <username> <text/-clear>",
+	},
+	"notes": {
+		fun:    showNotes,
+		nArgs:  1,
+		format: "/notes <username>",
+	},
+	"profile": {
+		fun:    editProfile,
+		nArgs:  1,
+		format: "/profile (set <text>/clear/show <username>)",
+	},
 	"subscribe": {
 		fun:    subEvent,
 		nArgs:  1,
@@ -122,18 +346,67 @@ var commands map[string]operation = map[string]operation{
 		nArgs:  1,
 		format: "/unsubscribe <hook>",
 	},
+	"speedtest": {
+		fun:    speedTest,
+		nArgs:  0,
+		format: "/speedtest (count)",
+	},
+	"subscribed": {
+		fun:    listSubscribed,
+		nArgs:  0,
+		format: "/subscribed",
+	},
 	"admin": {
 		fun:    adminOperation,
 		nArgs:  1,
 		format: "/admin <operation> <arg_1> <arg_2> ... <arg_n>",
 	},
+	"dangling": {
+		fun:    listDangling,
+		nArgs:  0,
+		format: "/dangling",
+	},
 	"recover": {
 		fun:    recoverData,
 		nArgs:  1,
-		format: "/recover <username> (-cleanup)",
+		format: "/recover <username> (-cleanup) (-format <text|json>) (-style <plain|ansi|markdown>) (-tz <timezone>)",
 	},
 }
 
+// Commands that run other commands must be added
+// here to avoid an initialization cycle.
+func init() {
+	commands["history"] = operation{
+		fun:    commandHistory,
+		nArgs:  0,
+		format: "/history (run <index>)",
+	}
+	commands["quick"] = operation{
+		fun:    quickCommands,
+		nArgs:  0,
+		format: "/quick (add <name> <command>/remove <name>)",
+	}
+}
+
+// Adds a command to the history following the deduplication
+// policy and removes the oldest ones that go over the limit.
+// Browsing the history starts again from the newest entry.
+func (t *TUI) addHistory(text string) {
+	switch t.params.HistoryDedup {
+	case dedupConsecutive:
+		last, ok := t.history.Get(uint(t.history.Len() - 1))
+		if ok && last == text {
+			return
+		}
+	case dedupFront:
+		t.history.Remove(text)
+	}
+
+	t.history.Add(text)
+	t.history.KeepLast(t.params.HistorySize)
+	t.next = 0
+}
+
 // Parses a shell command to be ran
 func (t *TUI) parseCommand(text string) {
 	parts := strings.Split(text, " ")
@@ -143,7 +416,7 @@ func (t *TUI) parseCommand(text string) {
 		return
 	}
 
-	t.history.Add(text)
+	t.addHistory(text)
 
 	cmd := Command{
 		Operation: parts[0],
@@ -172,8 +445,11 @@ func (t *TUI) parseCommand(text string) {
 		return
 	}
 
-	// Run concurrently
+	// Run concurrently while showing its progress
 	go func() {
+		done := t.startProgress(cmd.Operation)
+		defer done()
+
 		err := op.fun(t, cmd)
 		if err != nil {
 			cmd.print(err.Error(), cmds.ERROR)
@@ -192,6 +468,33 @@ func (c Command) createCmd(t *TUI, d *cmds.Data) (cmds.Command, []string) {
 	}, c.Arguments
 }
 
+// Runs an editor on a file with the TUI suspended until it exits.
+// It is done from the event loop so that nothing else can draw
+// on the terminal while the editor is using it.
+func (t *TUI) runEditor(editor []string, file string) error {
+	var err error
+	suspended := false
+	t.app.QueueUpdate(func() {
+		suspended = t.app.Suspend(func() {
+			proc := exec.Command(editor[0], append(editor[1:], file)...)
+			proc.Stdin = os.Stdin
+			proc.Stdout = os.Stdout
+			proc.Stderr = os.Stderr
+			err = proc.Run()
+		})
+	})
+
+	if !suspended {
+		return ErrorEditorFailed
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorEditorFailed, err)
+	}
+
+	return nil
+}
+
 // Asks for a new password by asking for the password
 // and repeating it
 func askForNewPassword(t *TUI) (string, error) {
@@ -212,6 +515,34 @@ func askForNewPassword(t *TUI) (string, error) {
 	return pswd, nil
 }
 
+// Returns whether a label can be used to tag messages,
+// which only allows letters, digits, dashes and underscores.
+func validTag(label string) bool {
+	if label == "" || len(label) > maxTagSize {
+		return false
+	}
+
+	for _, r := range label {
+		ok := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_'
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Renders again everything that depends on the parameters
+func refreshParams(t *TUI) {
+	renderLayout(t)
+	t.history.KeepLast(t.params.HistorySize)
+	applyBorders(t.params.Unicode)
+	t.reorderBuffers()
+	if t.status.userlist.Len() > 0 {
+		t.comp.users.SetText(t.status.userlistRender(t.params))
+	}
+}
+
 // Returns the list of structs to be shown in the configuration
 func configList(t *TUI, s Server) []cmds.ConfigObj {
 	data, _ := s.Online()
@@ -221,8 +552,7 @@ func configList(t *TUI, s Server) []cmds.ConfigObj {
 		Prefix: "TUI",
 		Object: &t.params,
 		Finish: func() {
-			renderBuflist(t)
-			renderUserlist(t)
+			refreshParams(t)
 		},
 	})
 
@@ -258,6 +588,49 @@ func showVersion(t *TUI, cmd Command) error {
 	return nil
 }
 
+func showServerVersion(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	return cmds.SERVERVERSION(c)
+}
+
+func showLastPacket(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	return cmds.LASTPACKET(c)
+}
+
+func showErrors(t *TUI, cmd Command) error {
+	var code string
+	if len(cmd.Arguments) > 0 {
+		code = cmd.Arguments[0]
+	}
+
+	return cmds.ERRORS(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+	}, code)
+}
+
+func showOperations(t *TUI, cmd Command) error {
+	return cmds.OPERATIONS(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+	})
+}
+
 func listServers(t *TUI, cmd Command) error {
 	var list strings.Builder
 	servs, err := db.GetAllServers(t.db)
@@ -277,9 +650,16 @@ func listServers(t *TUI, cmd Command) error {
 			Port:    v.Port,
 		}
 
+		verify := ""
+		if v.TLS && v.SkipVerify {
+			verify = " - [red::i]No verification[-::-]"
+		} else if v.TLS {
+			verify = " - [green::i]Verified TLS[-::-]"
+		}
+
 		str := fmt.Sprintf(
-			"\n- [yellow::b]%s[-::-] ([red]%s[-])%s",
-			v.Name, addr.String(), hidden,
+			"\n- [yellow::b]%s[-::-] ([red]%s[-])%s%s",
+			v.Name, addr.String(), verify, hidden,
 		)
 
 		list.WriteString(str)
@@ -293,7 +673,7 @@ func listServers(t *TUI, cmd Command) error {
 func listBuffers(t *TUI, cmd Command) error {
 	var list strings.Builder
 	bufs := cmd.serv.Buffers()
-	l := bufs.tabs.GetAll()
+	l := sortTabs(bufs, t.params.BufferSort)
 
 	if len(l) == 0 {
 		cmd.print("no buffers to show", cmds.RESULT)
@@ -307,9 +687,14 @@ func listBuffers(t *TUI, cmd Command) error {
 			hidden = " - [gray::i]Hidden[-::-]"
 		}
 
+		active := "no activity"
+		if !v.activity.IsZero() {
+			active = "last active " + v.activity.Format(time.DateTime)
+		}
+
 		str := fmt.Sprintf(
-			"\n[green]%d:[-::-] %s%s",
-			i+1, v.name, hidden,
+			"\n[green]%d:[-::-] %s [gray](%s)[-]%s",
+			i+1, v.name, active, hidden,
 		)
 
 		list.WriteString(str)
@@ -320,6 +705,63 @@ func listBuffers(t *TUI, cmd Command) error {
 	return nil
 }
 
+// Whether a history entry should not be listed or ran again,
+// either because it is a history command or because it may
+// contain a password.
+func historyHidden(entry string) bool {
+	op, _, _ := strings.Cut(entry, " ")
+	if op == "history" {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(entry), "pass")
+}
+
+func commandHistory(t *TUI, cmd Command) error {
+	if len(cmd.Arguments) > 0 {
+		if cmd.Arguments[0] != "run" || len(cmd.Arguments) < 2 {
+			return ErrorInvalidArgument
+		}
+
+		i, err := strconv.ParseUint(cmd.Arguments[1], 10, 0)
+		if err != nil {
+			return ErrorInvalidArgument
+		}
+
+		entry, ok := t.history.Get(uint(i))
+		if !ok || historyHidden(entry) {
+			return ErrorNotFound
+		}
+
+		cmd.print("running /"+entry, cmds.INTERMEDIATE)
+		t.parseCommand(entry)
+		return nil
+	}
+
+	list := t.history.Copy(0)
+
+	var builder strings.Builder
+	shown := 0
+	for i := len(list) - 1; i >= 0 && shown < int(historyShown); i-- {
+		if historyHidden(list[i]) {
+			continue
+		}
+
+		// Entries are shown from newest to oldest
+		str := fmt.Sprintf("\n[green]%d:[-::-] /%s", i, list[i])
+		builder.WriteString(str)
+		shown += 1
+	}
+
+	if shown == 0 {
+		cmd.print("no commands to show", cmds.RESULT)
+		return nil
+	}
+
+	cmd.print("showing last commands:"+builder.String(), cmds.RESULT)
+	return nil
+}
+
 func clearSystem(t *TUI, cmd Command) error {
 	buf := cmd.serv.Buffers().current
 	tab, ok := cmd.serv.Buffers().tabs.Get(buf)
@@ -347,107 +789,1425 @@ func clearSystem(t *TUI, cmd Command) error {
 	return nil
 }
 
-func showConfig(t *TUI, cmd Command) error {
-	objs := configList(t, cmd.serv)
-	list := cmds.CONFIG(objs...)
+func probeServer(t *TUI, cmd Command) error {
+	addr, num, err := net.SplitHostPort(cmd.Arguments[0])
+	if err != nil {
+		return ErrorInvalidAddress
+	}
 
-	if len(list) == 0 {
-		cmd.print("No configuration options to show", cmds.RESULT)
+	port, err := strconv.ParseUint(num, 10, 16)
+	if err != nil || port == 0 {
+		return ErrorInvalidAddress
+	}
+
+	useTLS := slices.Contains(cmd.Arguments[1:], "-tls")
+	cmd.print("probing server...", cmds.INTERMEDIATE)
+	return cmds.PROBE(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+	}, addr, uint16(port), useTLS)
+}
+
+// Probes every stored server and shows a table with the
+// reachable ones first, without touching any session
+func pingAllServers(t *TUI, cmd Command) error {
+	cmd.print("probing all servers...", cmds.INTERMEDIATE)
+	results, err := cmds.PROBEALL(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		cmd.print("there are no servers stored", cmds.RESULT)
 		return nil
 	}
 
-	var str strings.Builder
-	str.WriteString("Showing configuration options:")
-	for _, v := range list {
-		name, val, _ := strings.Cut(string(v), " = ")
+	slices.SortStableFunc(results, func(a, b cmds.ProbeResult) int {
+		if (a.Err == nil) != (b.Err == nil) {
+			if a.Err == nil {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
 
-		format := fmt.Sprintf(
-			"\n- [pink::i]%s[-::-] = [blue::b]%s[-::-]",
-			name, val,
+	reachable := 0
+	var table strings.Builder
+	for _, v := range results {
+		name := tview.Escape(v.Name)
+		if v.Err != nil {
+			fmt.Fprintf(&table,
+				"\n- [red]unreachable[-] [yellow]%s[-] (%s): %s",
+				name, v.Socket, tview.Escape(v.Err.Error()),
+			)
+			continue
+		}
+
+		reachable += 1
+		version := v.Version
+		if version == "" {
+			version = "unknown"
+		}
+		fmt.Fprintf(&table,
+			"\n- [green]reachable[-] [yellow]%s[-] (%s): %s, version %s",
+			name, v.Socket, v.Latency.Round(time.Millisecond), tview.Escape(version),
 		)
-		str.WriteString(format)
 	}
 
-	cmd.print(str.String(), cmds.RESULT)
+	cmd.print(fmt.Sprintf(
+		"%d out of %d servers are reachable:%s",
+		reachable, len(results), table.String(),
+	), cmds.RESULT)
 	return nil
 }
 
-func setConfig(t *TUI, cmd Command) error {
-	data, _ := cmd.serv.Online()
-	c, args := cmd.createCmd(t, data)
+func decodePacket(t *TUI, cmd Command) error {
+	// Allows both contiguous and space separated bytes
+	str := strings.Join(cmd.Arguments, "")
+	str = strings.TrimPrefix(strings.ToLower(str), "0x")
 
-	extra := args[1:]
-	extended := strings.Join(extra, " ")
+	raw, err := hex.DecodeString(str)
+	if err != nil {
+		return ErrorInvalidArgument
+	}
 
-	objs := configList(t, cmd.serv)
-	err := cmds.SET(c, args[0], extended, objs...)
+	pct, err := spec.DecodePacket(raw)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to decode packet: %w", err)
 	}
 
+	cmd.print(
+		"Decoded packet:\n"+tview.Escape(strings.TrimSuffix(pct.Contents(), "\n")),
+		cmds.RESULT,
+	)
 	return nil
 }
 
-func connectServer(t *TUI, cmd Command) error {
-	addr := cmd.serv.Source()
-	if addr == nil {
-		return ErrorLocalServer
+func listGoroutines(t *TUI, cmd Command) error {
+	if !t.params.Verbose {
+		return ErrorNotVerbose
 	}
 
-	data, ok := cmd.serv.Online()
-	if ok {
-		return ErrorAlreadyOnline
+	t.routines.mut.Lock()
+	keys := make([]routineKey, 0, len(t.routines.count))
+	var tracked uint
+	for k, v := range t.routines.count {
+		keys = append(keys, k)
+		tracked += v
 	}
 
-	c, args := cmd.createCmd(t, data)
+	slices.SortFunc(keys, func(a, b routineKey) int {
+		if c := strings.Compare(a.server, b.server); c != 0 {
+			return c
+		}
+		return strings.Compare(a.kind, b.kind)
+	})
 
-	var noVerify bool
-	if slices.Contains(args, "-noverify") {
-		noVerify = true
-	} else {
-		noVerify = false
+	var list strings.Builder
+	fmt.Fprintf(&list, "%d goroutines running, %d of them tracked:", runtime.NumGoroutine(), tracked)
+	for _, k := range keys {
+		fmt.Fprintf(&list,
+			"\n- [yellow]%s[-]: %d %s",
+			tview.Escape(k.server), t.routines.count[k], k.kind,
+		)
 	}
+	t.routines.mut.Unlock()
 
-	cmd.print("attempting to connect...", cmds.INTERMEDIATE)
-	err := cmds.CONN(c, *c.Data.Server, noVerify)
-	if err != nil {
+	cmd.print(list.String(), cmds.RESULT)
+	return nil
+}
+
+// Shows the stored plaintext of the selected message next
+// to the result of decrypting its ciphertext again
+func inspectMessage(t *TUI, cmd Command) error {
+	if !t.params.Verbose {
+		return ErrorNotVerbose
+	}
+
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	tab := t.Active().Buffers().Current()
+	if tab == nil || t.status.selected == 0 {
+		return ErrorNoSelection
+	}
+
+	msg, ok := tab.messages.Find(func(m Message) bool {
+		return m.ID == t.status.selected
+	})
+	if !ok {
+		return ErrorNoSelection
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	inspection, err := cmds.INSPECT(c, msg.ID)
+	if err != nil {
 		return err
 	}
 
-	cmd.serv.Context().Create(context.Background())
-	t.comp.servers.SetSelectedTextColor(tcell.ColorGreen)
+	var text strings.Builder
+	fmt.Fprintf(&text, "stored: %s", tview.Escape(inspection.Stored))
+	if inspection.Ciphertext == nil {
+		text.WriteString("\nthe ciphertext was not kept, it is only kept for messages received in verbose mode")
+		cmd.print(text.String(), cmds.RESULT)
+		return nil
+	}
 
-	c.Output = t.systemMessage("", defaultBuffer)
-	go cmds.ListenPackets(c, func() {
-		cmd.serv.Buffers().Offline()
-		c.Data.Waitlist.Cancel(data.Logout)
-		c.Data.Waitlist.Cancel(cmd.serv.Context().Cancel)
+	excerpt := inspection.Ciphertext[:min(len(inspection.Ciphertext), 16)]
+	fmt.Fprintf(&text,
+		"\nciphertext: %d bytes starting with %s",
+		len(inspection.Ciphertext), hex.EncodeToString(excerpt),
+	)
 
-		t.comp.input.SetLabel(defaultLabel)
-		t.comp.servers.SetSelectedTextColor(tcell.ColorPurple)
+	switch {
+	case inspection.Err != nil:
+		fmt.Fprintf(&text, "\n[red]could not decrypt it again[-]: %s", tview.Escape(inspection.Err.Error()))
+	case inspection.Matches():
+		fmt.Fprintf(&text, "\ndecrypted: %s\n[green]both plaintexts match[-]", tview.Escape(inspection.Decrypted))
+	default:
+		fmt.Fprintf(&text, "\ndecrypted: %s\n[red]the plaintexts do not match[-]", tview.Escape(inspection.Decrypted))
+	}
 
-		cleanupSession(t, cmd.serv)
-		cmd.serv.Notifications().Clear()
+	cmd.print(text.String(), cmds.RESULT)
+	return nil
+}
 
-		discn := t.systemMessage()
-		discn("You are no longer connected to this server!", cmds.INFO)
+func rawPacket(t *TUI, cmd Command) error {
+	// Malformed packets may break the session
+	if !t.params.Verbose {
+		return ErrorNotVerbose
+	}
+
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	name := strings.ToUpper(cmd.Arguments[0])
+	op := spec.StringToCode(name)
+	if op == spec.NullOp || spec.ServerArgs(op) == -1 {
+		return fmt.Errorf("%s cannot be sent to the server: %w", name, ErrorInvalidArgument)
+	}
+
+	info, err := strconv.ParseUint(cmd.Arguments[1], 0, 8)
+	if err != nil {
+		return fmt.Errorf("info must be a byte: %w", ErrorInvalidArgument)
+	}
+
+	args := make([][]byte, 0, len(cmd.Arguments)-2)
+	for _, v := range cmd.Arguments[2:] {
+		args = append(args, []byte(v))
+	}
+
+	if len(args) < spec.ServerArgs(op) {
+		return ErrorArguments
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+
+	// Errors sent by the server are also shown as replies
+	reply, err := c.Request(ctx, op, byte(info), args...)
+	if reply.HD.Op == spec.NullOp {
+		return err
+	}
+
+	cmd.print(
+		"Received reply:\n"+tview.Escape(strings.TrimSuffix(reply.Contents(), "\n")),
+		cmds.RESULT,
+	)
+	return nil
+}
+
+func benchmark(t *TUI, cmd Command) error {
+	// Only local operations are measured
+	c, args := cmd.createCmd(t, nil)
+	return cmds.BENCHMARK(c, strings.ToLower(args[0]))
+}
+
+func showInbox(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if !ok {
+		return ErrorOffline
+	}
+
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	list, err := db.ConversationSummaries(
+		t.db,
+		data.LocalUser.User.Username,
+		data.Server.Address,
+		data.Server.Port,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		cmd.print("there are no conversations in this server", cmds.RESULT)
+		return nil
+	}
+
+	inboxWindow(t, cmd.serv, list)
+	return nil
+}
+
+func showGraph(t *TUI, cmd Command) error {
+	list, err := db.ServerSummaries(t.db)
+	if err != nil {
+		return err
+	}
+
+	var graph strings.Builder
+	var contacts int
+	var messages int64
+	for _, v := range list {
+		contacts += v.Contacts
+		messages += v.Messages
+
+		addr := Source{
+			Address: v.Server.Address,
+			Port:    v.Server.Port,
+		}
+
+		graph.WriteString(fmt.Sprintf(
+			"\n[yellow::b]%s[-::-] ([red]%s[-]): %d contacts, %d messages",
+			tview.Escape(v.Server.Name), addr.String(), v.Contacts, v.Messages,
+		))
+
+		for i, c := range v.Conversations {
+			if i == graphShown {
+				graph.WriteString(fmt.Sprintf(
+					"\n  [gray::i]and %d more conversations[-::-]",
+					len(v.Conversations)-graphShown,
+				))
+				break
+			}
+
+			graph.WriteString(fmt.Sprintf(
+				"\n  - [blue]%s[-] with [green]%s[-]: %d messages, last on %s",
+				tview.Escape(c.Local), tview.Escape(c.Username),
+				c.Messages, c.Last.Local().Format(time.DateTime),
+			))
+		}
+	}
+
+	graph.WriteString(fmt.Sprintf(
+		"\n[::b]Total[::-]: %d servers, %d contacts, %d messages",
+		len(list), contacts, messages,
+	))
+
+	// Shown in the local server so that it works offline
+	if t.focus != localServer {
+		t.renderServer(localServer)
+	}
+
+	l, _ := t.servers.Get(localServer)
+	if tab, ok := l.Buffers().tabs.Get(graphBuffer); ok {
+		// Refreshing replaces the previous graph
+		tab.messages.Clear()
+	}
+
+	if i, ok := t.findBuffer(graphBuffer); ok {
+		t.changeBuffer(i)
+	} else {
+		t.addBuffer(graphBuffer, true)
+	}
+
+	t.sendMessage(Message{
+		Buffer:    graphBuffer,
+		Sender:    "System",
+		Content:   "Conversation graph:" + graph.String(),
+		Timestamp: time.Now(),
+		Source:    localServer,
 	})
 
-	// Prevent idle
-	if slices.Contains(args, "-noidle") {
-		cmd.print("running hook to prevent idle disconnection", cmds.SECONDARY)
+	return nil
+}
+
+func quickCommands(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if len(cmd.Arguments) == 0 {
+		return t.openQuickCommands(cmd.serv)
+	}
+
+	addr := data.Server.Address
+	port := data.Server.Port
+
+	switch cmd.Arguments[0] {
+	case "add":
+		if len(cmd.Arguments) < 3 {
+			return ErrorInvalidArgument
+		}
+
+		name := cmd.Arguments[1]
+		command := strings.Join(cmd.Arguments[2:], " ")
+		err := db.SetQuickCommand(
+			t.db, name, strings.TrimPrefix(command, "/"),
+			addr, port,
+		)
+		if err != nil {
+			return err
+		}
+
+		cmd.print(fmt.Sprintf("quick command %s saved!", name), cmds.RESULT)
+	case "remove":
+		if len(cmd.Arguments) < 2 {
+			return ErrorInvalidArgument
+		}
+
+		name := cmd.Arguments[1]
+		err := db.RemoveQuickCommand(t.db, name, addr, port)
+		if errors.Is(err, db.ErrorUnexpectedRows) {
+			return ErrorNoQuickCommands
+		}
+		if err != nil {
+			return err
+		}
+
+		cmd.print(fmt.Sprintf("quick command %s removed!", name), cmds.RESULT)
+	default:
+		return ErrorInvalidArgument
+	}
+
+	return nil
+}
+
+func pinMessage(t *TUI, cmd Command) error {
+	pinned, err := t.togglePin()
+	if err != nil {
+		return err
+	}
+
+	if pinned {
+		cmd.print("message pinned!", cmds.RESULT)
+	} else {
+		cmd.print("message unpinned!", cmds.RESULT)
+	}
+
+	return nil
+}
+
+func listPinned(t *TUI, cmd Command) error {
+	buf := cmd.serv.Buffers().current
+	msgs := cmd.serv.Messages(buf)
+
+	var list strings.Builder
+	list.WriteString("Pinned messages in this buffer:")
+	count := 0
+	for _, v := range msgs {
+		if !v.Pinned {
+			continue
+		}
+
+		count += 1
+		list.WriteString(fmt.Sprintf(
+			"\n- [%s] %s: %s",
+			v.Timestamp.Format(time.DateTime),
+			tview.Escape(v.Sender), tview.Escape(v.Content),
+		))
+	}
+
+	if count == 0 {
+		cmd.print("there are no pinned messages in this buffer", cmds.RESULT)
+		return nil
+	}
+
+	cmd.print(list.String(), cmds.RESULT)
+	return nil
+}
+
+// Scrolls to the first message of the current
+// buffer that is on or after the given date
+func jumpToDate(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	tab := cmd.serv.Buffers().Current()
+	if tab == nil {
+		return ErrorNoRemoteUser
+	}
+
+	if tab.system {
+		return ErrorSystemBuf
+	}
+
+	date, err := time.ParseInLocation(time.DateOnly, cmd.Arguments[0], time.Local)
+	if err != nil {
+		return ErrorInvalidDate
+	}
+
+	msg, err := db.GetFirstMessageSince(
+		t.db,
+		data.LocalUser.User.Username,
+		tab.name,
+		data.Server.Address,
+		data.Server.Port,
+		date,
+	)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrorNoMessagesAfter
+	}
+	if err != nil {
+		return err
+	}
+
+	// Printing scrolls to the end so it must go first
+	if stamp := msg.Stamp.In(time.Local); stamp.Format(time.DateOnly) != cmd.Arguments[0] {
+		cmd.print(fmt.Sprintf(
+			"no messages on %s, jumping to %s",
+			cmd.Arguments[0], stamp.Format(time.DateOnly),
+		), cmds.RESULT)
+	}
+
+	return t.jumpToMessage(msg.MessageID)
+}
+
+func replyMessage(t *TUI, cmd Command) error {
+	return t.startReply()
+}
+
+func tagMessage(t *TUI, cmd Command) error {
+	label := cmd.Arguments[0]
+	if !validTag(label) {
+		return ErrorInvalidTag
+	}
+
+	tagged, err := t.toggleTag(label)
+	if err != nil {
+		return err
+	}
+
+	if tagged {
+		cmd.print("message tagged with "+label+"!", cmds.RESULT)
+	} else {
+		cmd.print("removed "+label+" from message!", cmds.RESULT)
+	}
+
+	return nil
+}
+
+func listTagged(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	label := cmd.Arguments[0]
+	if !validTag(label) {
+		return ErrorInvalidTag
+	}
+
+	msgs, err := db.GetTaggedMessages(
+		t.db, label,
+		data.Server.Address,
+		data.Server.Port,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(msgs) == 0 {
+		cmd.print("there are no messages tagged with "+label+" in this server", cmds.RESULT)
+		return nil
+	}
+
+	var list strings.Builder
+	list.WriteString("Messages tagged with " + label + ":")
+	for _, v := range msgs {
+		list.WriteString(fmt.Sprintf(
+			"\n- [%s] %s -> %s: %s",
+			v.Stamp.Format(time.DateTime),
+			v.SourceUser.Username,
+			v.DestinationUser.Username,
+			tview.Escape(v.Text),
+		))
+	}
+
+	cmd.print(list.String(), cmds.RESULT)
+	return nil
+}
+
+func showConfig(t *TUI, cmd Command) error {
+	if len(cmd.Arguments) > 0 {
+		return editConfigFile(t, cmd)
+	}
+
+	objs := configList(t, cmd.serv)
+	list := cmds.CONFIG(objs...)
+
+	if len(list) == 0 {
+		cmd.print("No configuration options to show", cmds.RESULT)
+		return nil
+	}
+
+	var str strings.Builder
+	str.WriteString("Showing configuration options:")
+	for _, v := range list {
+		name, val, _ := strings.Cut(string(v), " = ")
+
+		format := fmt.Sprintf(
+			"\n- [pink::i]%s[-::-] = [blue::b]%s[-::-]",
+			name, val,
+		)
+		str.WriteString(format)
+	}
+
+	cmd.print(str.String(), cmds.RESULT)
+	return nil
+}
+
+// Shows or edits the configuration file
+func editConfigFile(t *TUI, cmd Command) error {
+	if t.config == nil {
+		return ErrorNoConfigFile
+	}
+
+	switch cmd.Arguments[0] {
+	case "show":
+		entries, err := t.config.Entries()
+		if err != nil {
+			return err
+		}
+
+		var str strings.Builder
+		str.WriteString("Showing configuration file, options marked with [yellow]*[-] need a restart:")
+		for _, v := range entries {
+			restart := " [yellow]*[-]"
+			if v.Live {
+				restart = ""
+			}
+
+			fmt.Fprintf(&str,
+				"\n- [pink::i]%s[-::-] = [blue::b]%s[-::-]%s",
+				tview.Escape(v.Path), tview.Escape(v.Value), restart,
+			)
+		}
+
+		cmd.print(str.String(), cmds.RESULT)
+	case "set":
+		if len(cmd.Arguments) < 3 {
+			return ErrorInvalidArgument
+		}
+
+		path := cmd.Arguments[1]
+		value := strings.Join(cmd.Arguments[2:], " ")
+		live, err := t.config.Set(path, value)
+		if err != nil {
+			return err
+		}
+
+		if !live {
+			cmd.print(fmt.Sprintf("%s saved, restart the client to apply it", path), cmds.RESULT)
+			return nil
+		}
+
+		refreshParams(t)
+		cmd.print(fmt.Sprintf("%s saved and applied!", path), cmds.RESULT)
+	default:
+		return ErrorInvalidArgument
+	}
+
+	return nil
+}
+
+func setConfig(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	c, args := cmd.createCmd(t, data)
+
+	extra := args[1:]
+	extended := strings.Join(extra, " ")
+
+	objs := configList(t, cmd.serv)
+	err := cmds.SET(c, args[0], extended, objs...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func connectServer(t *TUI, cmd Command) error {
+	addr := cmd.serv.Source()
+	if addr == nil {
+		return ErrorLocalServer
+	}
+
+	data, ok := cmd.serv.Online()
+	if ok {
+		return ErrorAlreadyOnline
+	}
+
+	c, args := cmd.createCmd(t, data)
+
+	var noVerify bool
+	if slices.Contains(args, "-noverify") {
+		noVerify = true
+	} else {
+		noVerify = false
+	}
+
+	// The banner and MOTD are shown in the default buffer
+	info := t.systemMessage("", defaultBuffer)
+	c.Output = func(text string, out cmds.OutputType) {
+		if out == cmds.INFO {
+			info(text, out)
+			return
+		}
+		cmd.print(text, out)
+	}
+
+	cmd.print("attempting to connect...", cmds.INTERMEDIATE)
+	err := cmds.CONN(c, *c.Data.Server, noVerify)
+	if err != nil {
+		return err
+	}
+
+	cmd.serv.Context().Create(context.Background())
+	t.comp.servers.SetSelectedTextColor(tcell.ColorGreen)
+
+	c.Output = t.systemMessage("", defaultBuffer)
+	onDisconnect := func(reason error) {
+		cmd.serv.Buffers().Offline()
+		c.Data.Waitlist.Cancel(data.Logout)
+		c.Data.Waitlist.Cancel(cmd.serv.Context().Cancel)
+
+		t.comp.input.SetLabel(defaultLabel)
+		t.comp.servers.SetSelectedTextColor(tcell.ColorPurple)
+
+		cleanupSession(t, cmd.serv)
+		cmd.serv.Notifications().Clear()
+
+		discn := t.systemMessage()
+		if reason == nil || errors.Is(reason, spec.ErrorDisconnected) {
+			discn("You are no longer connected to this server!", cmds.INFO)
+			return
+		}
+
+		// Forced logouts are never retried
+		var kick cmds.KickError
+		if errors.As(reason, &kick) {
+			discn(fmt.Sprintf("You were %s!", tview.Escape(kick.Error())), cmds.INFO)
+			return
+		}
+
+		discn(fmt.Sprintf(
+			"You are no longer connected to this server due to %s!",
+			reason,
+		), cmds.INFO)
+
+		if t.params.Reconnect && cmds.Reconnectable(reason) {
+			t.spawn(cmd.serv.Name(), "reconnect", func() { reconnectServer(t, cmd) })
+		}
+	}
+
+	listen := func() { cmds.ListenPackets(c, onDisconnect) }
+	t.spawn(cmd.serv.Name(), "listener", listen)
+
+	// Prevent idle
+	if slices.Contains(args, "-noidle") {
+		cmd.print("running hook to prevent idle disconnection", cmds.SECONDARY)
+
+		ctx := cmd.serv.Context().Get()
+		t.spawn(cmd.serv.Name(), "idle", func() {
+			cmds.PreventIdle(
+				ctx, c.Data,
+				time.Duration(spec.ReadTimeout-1)*time.Minute,
+			)
+		})
+	}
+
+	return nil
+}
+
+func registerUser(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	pswd, err := askForNewPassword(t)
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	err = cmds.REG(ctx, c, args[0], pswd)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func deregisterUser(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	pswd, err := newPasswordPopup(t, "Enter the account's password...")
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	err = cmds.DEREG(ctx, c, args[0], pswd)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func keyInfo(t *TUI, cmd Command) error {
+	c, args := cmd.createCmd(t, nil)
+	return cmds.KEYINFO(c, args[0])
+}
+
+func importKey(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	pswd, err := askForNewPassword(t)
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	err = cmds.IMPORT(c, args[0], pswd, args[1])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func exportKey(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	pswd, err := newPasswordPopup(t, "Enter the account's password...")
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	err = cmds.EXPORT(c, args[0], pswd)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Writes a message in an external editor and sends it to
+// the current buffer once the editor is closed
+func composeMessage(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	tab := cmd.serv.Buffers().Current()
+	if tab == nil || !tab.connected {
+		return ErrorNoRemoteUser
+	}
+
+	if tab.system {
+		return ErrorSystemBuf
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		return ErrorNoEditor
+	}
+
+	file, err := os.CreateTemp("", "gochat-*.txt")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	before, err := os.Stat(file.Name())
+	if err != nil {
+		return err
+	}
+
+	err = t.runEditor(editor, file.Name())
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(file.Name())
+	if err != nil {
+		return err
+	}
+
+	// Closing the editor without saving discards the message
+	if after.ModTime().Equal(before.ModTime()) {
+		cmd.print("message discarded as it was not saved", cmds.RESULT)
+		return nil
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+
+	// Editors usually end the file with a newline
+	text := strings.TrimRight(string(content), "\r\n")
+	if strings.TrimSpace(text) == "" {
+		return ErrorNoText
+	}
+
+	// Kept in the input so that it can be shortened
+	if len(text) > cmds.MaxMessageSize {
+		t.app.QueueUpdateDraw(func() {
+			t.comp.input.SetText(text, true)
+		})
+		return fmt.Errorf(
+			"%w (%d out of %d bytes), it has been left in the input",
+			cmds.ErrorMessageTooLong, len(text), cmds.MaxMessageSize,
+		)
+	}
+
+	msg := Message{
+		Sender:    selfSender,
+		Buffer:    tab.name,
+		Content:   text,
+		Timestamp: time.Now(),
+		Source:    cmd.serv.Name(),
+	}
+	t.sendMessage(msg)
+	t.remoteMessage(msg)
+
+	return nil
+}
+
+func sendAction(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	tab := cmd.serv.Buffers().Current()
+	if tab == nil || !tab.connected {
+		return ErrorNoRemoteUser
+	}
+
+	if tab.system {
+		return ErrorSystemBuf
+	}
+
+	msg := Message{
+		Sender:    selfSender,
+		Buffer:    tab.name,
+		Content:   strings.Join(cmd.Arguments, " "),
+		Timestamp: time.Now(),
+		Source:    cmd.serv.Name(),
+		Type:      spec.MsgAction,
+	}
+	t.sendMessage(msg)
+	t.remoteMessage(msg)
+
+	return nil
+}
+
+func resendMessage(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	c, args := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	sent, err := cmds.RESEND(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+
+	// Only shown if the buffer of the user is open
+	t.sendMessage(Message{
+		Sender:    selfSender,
+		Buffer:    args[0],
+		Content:   sent.Content,
+		Timestamp: sent.Timestamp,
+		Source:    cmd.serv.Name(),
+		ID:        sent.ID,
+		Type:      sent.Type,
+	})
+
+	return nil
+}
+
+func databaseInfo(t *TUI, cmd Command) error {
+	c, _ := cmd.createCmd(t, nil)
+	err := cmds.DBINFO(c)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func databaseVacuum(t *TUI, cmd Command) error {
+	c, _ := cmd.createCmd(t, nil)
+	err := cmds.DBVACUUM(c)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func databaseCheck(t *TUI, cmd Command) error {
+	c, _ := cmd.createCmd(t, nil)
+	repair := slices.Contains(cmd.Arguments, "-repair")
+	err := cmds.DBCHECK(c, repair)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func exportHTML(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	c, args := cmd.createCmd(t, data)
+	err := cmds.EXPORTHTML(c, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func changePassword(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	old, err := newPasswordPopup(t, "Enter the account's current password...")
+	if err != nil {
+		return err
+	}
+
+	pswd, err := askForNewPassword(t)
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	err = cmds.PASSWD(c, args[0], old, pswd)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func rekeyAll(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+
+	var master string
+	if slices.Contains(cmd.Arguments, "-master") {
+		pswd, err := askForNewPassword(t)
+		if err != nil {
+			return err
+		}
+		master = pswd
+	}
+
+	ask := func(lu db.LocalUser) (string, error) {
+		return newPasswordPopup(t, fmt.Sprintf(
+			"Enter the password of %s in %s...",
+			lu.User.Username, lu.User.Server.Name,
+		))
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	err := cmds.REKEYALL(c, ask, master)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loginUser(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if data.IsLoggedIn() {
+		return ErrorLoggedIn
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	pswd, err := newPasswordPopup(t, "Enter the account's password...")
+	if err != nil {
+		return err
+	}
+
+	c, args := cmd.createCmd(t, data)
+	lCtx, lCancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(lCancel)
+	err = cmds.LOGIN(lCtx, c, args[0], pswd)
+	if err != nil {
+		return err
+	}
+
+	uname := data.LocalUser.User.Username
+	t.comp.input.SetLabel(unameLabel(uname))
+	if !t.status.showingUsers {
+		toggleUserlist(t)
+	}
+
+	ctx, cancel := context.WithCancel(cmd.serv.Context().Get())
+	data.Logout = cancel
+
+	catch := newCatchUp()
+	report := t.systemMessage("login", defaultBuffer)
+	name := cmd.serv.Name()
+	t.spawn(name, "messages", func() { t.receiveMessages(ctx, cmd.serv, catch) })
+	t.spawn(name, "hooks", func() { t.receiveHooks(ctx, cmd.serv) })
+	t.spawn(name, "shutdown", func() { t.waitShutdown(ctx, cmd.serv) })
+	t.spawn(name, "signals", func() { t.receiveSignals(ctx, cmd.serv) })
+
+	cmd.print("recovering messages...", cmds.INTERMEDIATE)
+	rCtx, rCancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(rCancel)
+	err = cmds.RECIV(rCtx, c)
+	empty := errors.Is(err, spec.ErrorEmpty)
+	if err != nil {
+		if empty {
+			cmd.print("No new messages have been received", cmds.INFO)
+		} else {
+			return err
+		}
+	}
+
+	cmd.print("subscribing to relevant events...", cmds.INTERMEDIATE)
+
+	output := cmd.print
+	if !t.params.Verbose {
+		output = func(string, cmds.OutputType) {}
+	}
+
+	defaultSubscribe(t, cmd.serv, output)
+
+	// Cached messages arrive before the subscription is confirmed,
+	// but storing them may still take a while
+	if !empty {
+		catch.wait(ctx, time.Duration(catchUpDelay)*time.Millisecond)
+	}
+	if summary := catch.finish(); summary != "" {
+		report(summary, cmds.INFO)
+	}
+
+	return nil
+}
+
+func logoutUser(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	err := cmds.LOGOUT(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	t.comp.input.SetLabel(defaultLabel)
+	cleanupSession(t, cmd.serv)
+
+	return nil
+}
+
+func renameServer(t *TUI, cmd Command) error {
+	old, name := cmd.Arguments[0], cmd.Arguments[1]
+	if old == localServer {
+		return ErrorLocalServer
+	}
+	if name == localServer {
+		return ErrorExists
+	}
+
+	// Servers that are not shown only change in the database
+	var data *cmds.Data
+	if s, ok := t.servers.Get(old); ok {
+		data, _ = s.Online()
+	}
+
+	_, err := cmds.RENAMESERVER(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+		Data:   data,
+	}, old, name)
+	if err != nil {
+		return err
+	}
+
+	updateServers(t)
+	return nil
+}
+
+func disconnectServer(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	err := cmds.DISCN(c)
+	if err != nil {
+		return err
+	}
+
+	t.comp.input.SetLabel(defaultLabel)
+	t.comp.servers.SetSelectedTextColor(tcell.ColorPurple)
+
+	return nil
+}
+
+// Logs out and disconnects from the current server before
+// hiding it, keeping everything stored about it in the database
+func leaveServer(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	// A pending reconnection would bring it back online
+	_ = t.cancelReconnect(cmd.serv)
+
+	if ok {
+		c, _ := cmd.createCmd(t, data)
+		if data.IsLoggedIn() {
+			ctx, cancel := timeout(cmd.serv, c.Data)
+			err := cmds.LOGOUT(ctx, c)
+			c.Data.Waitlist.Cancel(cancel)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := cmds.DISCN(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := cmd.serv.Name()
+	t.hideServer(name)
+
+	print := t.systemMessage()
+	print(fmt.Sprintf("left %s, create it again to show it with its history", tview.Escape(name)), cmds.RESULT)
+
+	// Closing the connection must have stopped all of them
+	if left := t.serverRoutines(name); left > 0 && t.params.Verbose {
+		print(fmt.Sprintf("%d goroutines of %s are still running", left, tview.Escape(name)), cmds.ERROR)
+	}
+
+	return nil
+}
+
+func cancelReconnect(t *TUI, cmd Command) error {
+	err := t.cancelReconnect(cmd.serv)
+	if err != nil {
+		return err
+	}
+
+	cmd.print("pending reconnection cancelled!", cmds.RESULT)
+	return nil
+}
+
+func securityInfo(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	err := cmds.SECINFO(c)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func listUsers(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	opt := cmd.Arguments[0] + "|" + cmd.Arguments[1]
+	if data == nil && opt != "local|all" {
+		return ErrorLocalServer
+	}
+
+	c, args := cmd.createCmd(t, data)
+
+	queryPerms := false
+	if len(args) > 2 &&
+		args[0] == "remote" &&
+		slices.Contains(args[2:], "-perms") {
+		queryPerms = true
+	}
+
+	var usrs cmds.USRSType
+	switch opt {
+	case "remote|all":
+		if queryPerms {
+			usrs = cmds.ALLPERMS
+		} else {
+			usrs = cmds.ALL
+		}
+	case "remote|online":
+		if queryPerms {
+			usrs = cmds.ONLINEPERMS
+		} else {
+			usrs = cmds.ONLINE
+		}
+	case "local|all":
+		usrs = cmds.LOCAL_ALL
+	case "local|server":
+		usrs = cmds.LOCAL_SERVER
+	default:
+		return ErrorInvalidArgument
+	}
+
+	ctx := context.Background()
+	if opt != "local|all" {
+		var cancel context.CancelFunc
+		ctx, cancel = timeout(cmd.serv, c.Data)
+		defer c.Data.Waitlist.Cancel(cancel)
+	}
+	reply, err := cmds.USRS(ctx, c, usrs)
+	if err != nil {
+		return err
+	}
+
+	var list strings.Builder
+	mode := fmt.Sprintf("%s %s", args[1], args[0])
+	if queryPerms {
+		list.WriteString("Showing " + mode + " users with permissions:\n")
+	} else {
+		list.WriteString("Showing " + mode + " users:\n")
+	}
+
+	if len(reply) == 0 {
+		list.WriteString("No users to be shown.\n")
+	}
+
+	for _, v := range reply {
+		uname, extra, ok := strings.Cut(string(v), " ")
+		var str string
+		if !ok {
+			str = fmt.Sprintf(
+				"- [pink::i]%s[-::-]\n",
+				tview.Escape(uname),
+			)
+		} else {
+			// Uses the same style as the userlist
+			tag := "[blue::b]" + tview.Escape(extra) + "[-::-]"
+			perms, err := strconv.ParseUint(extra, 10, 8)
+			if err == nil {
+				tag = permissionTag(uint(perms), t.params)
+			}
 
-		go cmds.PreventIdle(
-			cmd.serv.Context().Get(),
-			c.Data,
-			time.Duration(spec.ReadTimeout-1)*time.Minute,
-		)
+			str = fmt.Sprintf(
+				"- [pink::i]%s[-::-] | %s\n",
+				tview.Escape(uname), tag,
+			)
+		}
+		list.WriteString(str)
 	}
 
+	l := list.Len()
+	cmd.print(list.String()[:l-1], cmds.RESULT)
+
 	return nil
 }
 
-func registerUser(t *TUI, cmd Command) error {
+func refreshUserlist(t *TUI, cmd Command) error {
 	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
@@ -457,23 +2217,26 @@ func registerUser(t *TUI, cmd Command) error {
 		return ErrorOffline
 	}
 
-	pswd, err := askForNewPassword(t)
-	if err != nil {
-		return err
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
 	}
 
-	c, args := cmd.createCmd(t, data)
-	ctx, cancel := timeout(cmd.serv, c.Data)
-	defer c.Data.Waitlist.Cancel(cancel)
-	err = cmds.REG(ctx, c, args[0], pswd)
+	// Rebuilds the userlist from scratch using the server state
+	empty := func(string, cmds.OutputType) {}
+	err := updateOnlineUsers(t, cmd.serv, empty)
 	if err != nil {
 		return err
 	}
 
+	str := fmt.Sprintf(
+		"userlist refreshed with %d online users",
+		t.status.userlist.Len(),
+	)
+	cmd.print(str, cmds.RESULT)
 	return nil
 }
 
-func deregisterUser(t *TUI, cmd Command) error {
+func requestAllUsers(t *TUI, cmd Command) error {
 	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
@@ -483,15 +2246,16 @@ func deregisterUser(t *TUI, cmd Command) error {
 		return ErrorOffline
 	}
 
-	pswd, err := newPasswordPopup(t, "Enter the account's password...")
-	if err != nil {
-		return err
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
 	}
 
-	c, args := cmd.createCmd(t, data)
+	c, _ := cmd.createCmd(t, data)
 	ctx, cancel := timeout(cmd.serv, c.Data)
 	defer c.Data.Waitlist.Cancel(cancel)
-	err = cmds.DEREG(ctx, c, args[0], pswd)
+
+	cmd.print("requesting online users...", cmds.INTERMEDIATE)
+	_, err := cmds.REQALL(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -499,19 +2263,25 @@ func deregisterUser(t *TUI, cmd Command) error {
 	return nil
 }
 
-func importKey(t *TUI, cmd Command) error {
-	data, _ := cmd.serv.Online()
+func resyncUser(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
 	}
 
-	pswd, err := askForNewPassword(t)
-	if err != nil {
-		return err
+	if !ok {
+		return ErrorOffline
+	}
+
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
 	}
 
 	c, args := cmd.createCmd(t, data)
-	err = cmds.IMPORT(c, args[0], pswd, args[1])
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+
+	_, err := cmds.RESYNC(ctx, c, string(args[0]))
 	if err != nil {
 		return err
 	}
@@ -519,19 +2289,26 @@ func importKey(t *TUI, cmd Command) error {
 	return nil
 }
 
-func exportKey(t *TUI, cmd Command) error {
-	data, _ := cmd.serv.Online()
+func resyncAllUsers(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
 	}
 
-	pswd, err := newPasswordPopup(t, "Enter the account's password...")
-	if err != nil {
-		return err
+	if !ok {
+		return ErrorOffline
 	}
 
-	c, args := cmd.createCmd(t, data)
-	err = cmds.EXPORT(c, args[0], pswd)
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
+	c, _ := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+
+	cmd.print("resyncing stored users...", cmds.INTERMEDIATE)
+	_, err := cmds.RESYNCALL(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -539,193 +2316,224 @@ func exportKey(t *TUI, cmd Command) error {
 	return nil
 }
 
-func loginUser(t *TUI, cmd Command) error {
-	data, ok := cmd.serv.Online()
+// Lists the messages of the day, banners and broadcasts
+// received from the current server, starting by the oldest
+func listAnnouncements(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
 	}
 
-	if data.IsLoggedIn() {
-		return ErrorLoggedIn
-	}
-
-	if !ok {
-		return ErrorOffline
-	}
-
-	pswd, err := newPasswordPopup(t, "Enter the account's password...")
+	list, err := db.GetAnnouncements(t.db, data.Server.Address, data.Server.Port)
 	if err != nil {
 		return err
 	}
 
-	c, args := cmd.createCmd(t, data)
-	lCtx, lCancel := timeout(cmd.serv, c.Data)
-	defer c.Data.Waitlist.Cancel(lCancel)
-	err = cmds.LOGIN(lCtx, c, args[0], pswd)
-	if err != nil {
-		return err
+	if len(list) == 0 {
+		cmd.print("there are no announcements stored for this server", cmds.RESULT)
+		return nil
 	}
 
-	uname := data.LocalUser.User.Username
-	t.comp.input.SetLabel(unameLabel(uname))
-	if !t.status.showingUsers {
-		toggleUserlist(t)
+	var text strings.Builder
+	text.WriteString("Announcements received from this server:")
+	for _, v := range list {
+		from := string(v.Kind)
+		if v.Sender != "" {
+			from = fmt.Sprintf("%s from %s", v.Kind, tview.Escape(v.Sender))
+		}
+
+		// Lines after the first one are indented below it
+		content := strings.ReplaceAll(tview.Escape(v.Text), "\n", "\n  ")
+		fmt.Fprintf(&text,
+			"\n- [%s] [yellow]%s[-]: %s",
+			v.Stamp.Format(time.DateTime), from, content,
+		)
 	}
 
-	ctx, cancel := context.WithCancel(cmd.serv.Context().Get())
-	data.Logout = cancel
+	cmd.print(text.String(), cmds.RESULT)
+	return nil
+}
 
-	go t.receiveMessages(ctx, cmd.serv)
-	go t.receiveHooks(ctx, cmd.serv)
-	go t.waitShutdown(ctx, cmd.serv)
+// Lists every public key that has been seen for a user,
+// distinguishing the current one from the superseded ones
+func showKeyHistory(t *TUI, cmd Command) error {
+	data, _ := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
 
-	cmd.print("recovering messages...", cmds.INTERMEDIATE)
-	rCtx, rCancel := timeout(cmd.serv, c.Data)
-	defer c.Data.Waitlist.Cancel(rCancel)
-	err = cmds.RECIV(rCtx, c)
+	c, args := cmd.createCmd(t, data)
+	records, err := cmds.KEYHISTORY(c, args[0])
 	if err != nil {
-		if errors.Is(err, spec.ErrorEmpty) {
-			cmd.print("No new messages have been received", cmds.INFO)
-		} else {
-			return err
-		}
+		return err
 	}
 
-	cmd.print("subscribing to relevant events...", cmds.INTERMEDIATE)
+	var list strings.Builder
+	fmt.Fprintf(&list, "Public keys seen for [pink::i]%s[-::-]:", tview.Escape(args[0]))
+	for _, v := range records {
+		seen := "unknown"
+		if !v.FirstSeen.IsZero() {
+			seen = v.FirstSeen.Format(time.DateTime)
+		}
 
-	output := cmd.print
-	if !t.params.Verbose {
-		output = func(string, cmds.OutputType) {}
-	}
+		state := "[gray]superseded[-]"
+		if v.Current {
+			state = "[green]current[-]"
+		}
 
-	defaultSubscribe(t, cmd.serv, output)
+		fmt.Fprintf(&list, "\n- %s (first seen %s): %s", state, seen, v.Fingerprint)
+	}
 
+	cmd.print(list.String(), cmds.RESULT)
 	return nil
 }
 
-func logoutUser(t *TUI, cmd Command) error {
+func selfTest(t *TUI, cmd Command) error {
 	data, _ := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
 	}
 
+	if !data.IsLoggedIn() {
+		return ErrorNotLoggedIn
+	}
+
 	c, _ := cmd.createCmd(t, data)
-	ctx, cancel := timeout(cmd.serv, c.Data)
-	defer c.Data.Waitlist.Cancel(cancel)
-	err := cmds.LOGOUT(ctx, c)
+	err := cmds.SELFTEST(c)
 	if err != nil {
 		return err
 	}
 
-	t.comp.input.SetLabel(defaultLabel)
-	cleanupSession(t, cmd.serv)
-
 	return nil
 }
 
-func disconnectServer(t *TUI, cmd Command) error {
+func addNote(t *TUI, cmd Command) error {
 	data, _ := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
 	}
 
-	c, _ := cmd.createCmd(t, data)
-	err := cmds.DISCN(c)
+	c, args := cmd.createCmd(t, data)
+	text := strings.Join(args[1:], " ")
+	clear := text == "-clear"
+
+	err := cmds.NOTE(c, args[0], text, clear)
 	if err != nil {
 		return err
 	}
 
-	t.comp.input.SetLabel(defaultLabel)
-	t.comp.servers.SetSelectedTextColor(tcell.ColorPurple)
-
 	return nil
 }
 
-func listUsers(t *TUI, cmd Command) error {
+func showNotes(t *TUI, cmd Command) error {
 	data, _ := cmd.serv.Online()
-	opt := cmd.Arguments[0] + "|" + cmd.Arguments[1]
-	if data == nil && opt != "local|all" {
+	if data == nil {
 		return ErrorLocalServer
 	}
 
 	c, args := cmd.createCmd(t, data)
+	err := cmds.NOTES(c, args[0])
+	if err != nil {
+		return err
+	}
 
-	queryPerms := false
-	if len(args) > 2 &&
-		args[0] == "remote" &&
-		slices.Contains(args[2:], "-perms") {
-		queryPerms = true
+	return nil
+}
+
+// Changes the profile bio of the logged in user
+// or shows the one of another user
+func editProfile(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
 	}
 
-	var usrs cmds.USRSType
-	switch opt {
-	case "remote|all":
-		if queryPerms {
-			usrs = cmds.ALLPERMS
-		} else {
-			usrs = cmds.ALL
+	c, args := cmd.createCmd(t, data)
+	switch args[0] {
+	case "show":
+		if len(args) < 2 {
+			return ErrorInvalidArgument
 		}
-	case "remote|online":
-		if queryPerms {
-			usrs = cmds.ONLINEPERMS
+
+		bio, err := cmds.USERPROFILE(c, args[1])
+		if err != nil {
+			return err
+		}
+
+		// The bio comes from another user so it must not be parsed
+		if bio == "" {
+			cmd.print(fmt.Sprintf("%s has not set a bio", tview.Escape(args[1])), cmds.RESULT)
 		} else {
-			usrs = cmds.ONLINE
+			cmd.print(fmt.Sprintf(
+				"bio of [pink::i]%s[-::-]: %s",
+				tview.Escape(args[1]), tview.Escape(bio),
+			), cmds.RESULT)
 		}
-	case "local|all":
-		usrs = cmds.LOCAL_ALL
-	case "local|server":
-		usrs = cmds.LOCAL_SERVER
+		return nil
+	case "set", "clear":
+		if !ok {
+			return ErrorOffline
+		}
+
+		text := ""
+		if args[0] == "set" {
+			if len(args) < 2 {
+				return ErrorInvalidArgument
+			}
+			text = strings.Join(args[1:], " ")
+		}
+
+		ctx, cancel := timeout(cmd.serv, c.Data)
+		defer c.Data.Waitlist.Cancel(cancel)
+		return cmds.PROFILE(ctx, c, text)
 	default:
 		return ErrorInvalidArgument
 	}
+}
 
-	ctx := context.Background()
-	if opt != "local|all" {
-		var cancel context.CancelFunc
-		ctx, cancel = timeout(cmd.serv, c.Data)
-		defer c.Data.Waitlist.Cancel(cancel)
+func subEvent(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
 	}
-	reply, err := cmds.USRS(ctx, c, usrs)
+
+	if !ok {
+		return ErrorOffline
+	}
+
+	c, args := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	err := cmds.SUB(ctx, c, args[0])
 	if err != nil {
 		return err
 	}
 
-	var list strings.Builder
-	mode := fmt.Sprintf("%s %s", args[1], args[0])
-	if queryPerms {
-		list.WriteString("Showing " + mode + " users with permissions:\n")
-	} else {
-		list.WriteString("Showing " + mode + " users:\n")
-	}
+	return nil
+}
 
-	if len(reply) == 0 {
-		list.WriteString("No users to be shown.\n")
+func unsubEvent(t *TUI, cmd Command) error {
+	data, ok := cmd.serv.Online()
+	if data == nil {
+		return ErrorLocalServer
 	}
 
-	for _, v := range reply {
-		uname, extra, ok := strings.Cut(string(v), " ")
-		var str string
-		if !ok {
-			str = fmt.Sprintf(
-				"- [pink::i]%s[-::-]\n",
-				uname,
-			)
-		} else {
-			str = fmt.Sprintf(
-				"- [pink::i]%s[-::-] | [blue::b]%s[-::-]\n",
-				uname, extra,
-			)
-		}
-		list.WriteString(str)
+	if !ok {
+		return ErrorOffline
 	}
 
-	l := list.Len()
-	cmd.print(list.String()[:l-1], cmds.RESULT)
+	c, args := cmd.createCmd(t, data)
+	ctx, cancel := timeout(cmd.serv, c.Data)
+	defer c.Data.Waitlist.Cancel(cancel)
+	err := cmds.UNSUB(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func subEvent(t *TUI, cmd Command) error {
+func speedTest(t *TUI, cmd Command) error {
 	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
@@ -735,10 +2543,19 @@ func subEvent(t *TUI, cmd Command) error {
 		return ErrorOffline
 	}
 
-	c, args := cmd.createCmd(t, data)
+	count := cmds.DefaultSpeedtest
+	if len(cmd.Arguments) > 0 {
+		n, err := strconv.Atoi(cmd.Arguments[0])
+		if err != nil {
+			return err
+		}
+		count = n
+	}
+
+	c, _ := cmd.createCmd(t, data)
 	ctx, cancel := timeout(cmd.serv, c.Data)
 	defer c.Data.Waitlist.Cancel(cancel)
-	err := cmds.SUB(ctx, c, args[0])
+	err := cmds.SPEEDTEST(ctx, c, count)
 	if err != nil {
 		return err
 	}
@@ -746,7 +2563,7 @@ func subEvent(t *TUI, cmd Command) error {
 	return nil
 }
 
-func unsubEvent(t *TUI, cmd Command) error {
+func listSubscribed(t *TUI, cmd Command) error {
 	data, ok := cmd.serv.Online()
 	if data == nil {
 		return ErrorLocalServer
@@ -756,10 +2573,10 @@ func unsubEvent(t *TUI, cmd Command) error {
 		return ErrorOffline
 	}
 
-	c, args := cmd.createCmd(t, data)
+	c, _ := cmd.createCmd(t, data)
 	ctx, cancel := timeout(cmd.serv, c.Data)
 	defer c.Data.Waitlist.Cancel(cancel)
-	err := cmds.UNSUB(ctx, c, args[0])
+	_, err := cmds.SUBLIST(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -792,22 +2609,29 @@ func adminOperation(t *TUI, cmd Command) error {
 	return nil
 }
 
+func listDangling(t *TUI, cmd Command) error {
+	return cmds.DANGLING(cmds.Command{
+		Static: t.static(),
+		Output: cmd.print,
+	})
+}
+
 func recoverData(t *TUI, cmd Command) error {
 	uname := cmd.Arguments[0]
-	pswd, err := newPasswordPopup(t, "Please enter the account's password...")
+	opts, err := cmds.ParseRecoverOptions(cmd.Arguments[1:])
 	if err != nil {
 		return err
 	}
 
-	cleanup := false
-	if slices.Contains(cmd.Arguments[1:], "-cleanup") {
-		cleanup = true
+	pswd, err := newPasswordPopup(t, "Please enter the account's password...")
+	if err != nil {
+		return err
 	}
 
 	err = cmds.RECOVER(cmds.Command{
 		Static: t.static(),
 		Output: cmd.print,
-	}, uname, pswd, cleanup)
+	}, uname, pswd, opts)
 	if err != nil {
 		return err
 	}
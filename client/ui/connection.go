@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"net"
@@ -348,20 +349,10 @@ func (t *TUI) renderServer(name string) {
 	}
 
 	// Sort buffers before showing them
-	tabs := s.Buffers().tabs.GetAll()
-	slices.SortFunc(tabs, func(a, b *tab) int {
-		if a.creation < b.creation {
-			return -1
-		} else if a.creation > b.creation {
-			return 1
-		}
-
-		return 0 // Equal
-	})
-
+	tabs := sortTabs(s.Buffers(), t.params.BufferSort)
 	for _, v := range tabs {
 		if v.index != -1 {
-			t.comp.buffers.AddItem(v.name, "", ascii(v.index), nil)
+			t.comp.buffers.AddItem(t.shortName(v.name), v.name, ascii(v.index), nil)
 		}
 	}
 
@@ -410,14 +401,20 @@ func (s *RemoteServer) Messages(name string) []Message {
 		return nil
 	}
 
+	// Stable sort to keep arrival order on ties
 	msgs := t.messages.Copy(0)
-	slices.SortFunc(msgs, func(a, b Message) int {
+	slices.SortStableFunc(msgs, func(a, b Message) int {
 		if a.Timestamp.Before(b.Timestamp) {
 			return -1
 		} else if a.Timestamp.After(b.Timestamp) {
 			return 1
 		}
 
+		// Only cached messages have a sequence
+		if a.Sequence != 0 && b.Sequence != 0 {
+			return cmp.Compare(a.Sequence, b.Sequence)
+		}
+
 		return 0
 	})
 
@@ -442,6 +439,7 @@ func (s *RemoteServer) Receive(msg Message) (bool, error) {
 	}
 
 	b.messages.Add(msg)
+	b.touch(msg.Timestamp)
 	return true, nil
 }
 
@@ -525,6 +523,7 @@ func (l *LocalServer) Receive(msg Message) (bool, error) {
 	}
 
 	b.messages.Add(msg)
+	b.touch(msg.Timestamp)
 	return true, nil
 }
 
@@ -25,11 +25,23 @@ const Logo string = `
 
 `
 
+// ASCII replacements of the symbols shown in the TUI,
+// used when the terminal cannot render Unicode.
+var asciiSymbols = map[string]string{
+	nameEllipsis: "...",
+	"♛":          "~",
+	"📌":          "*",
+}
+
+// Borders drawn by tview when Unicode is enabled.
+var unicodeBorders = tview.Borders
+
 const (
 	tuiVersion      float32 = 0.4       // Current client TUI version
 	selfSender      string  = "You"     // Self sender of a message
 	systemBuffer    string  = "System"  // System buffer name
 	debugBuffer     string  = "Debug"   // Buffer where packets will be shown
+	graphBuffer     string  = "Graph"   // Buffer where the conversation graph is shown
 	defaultBuffer   string  = "Default" // Default server system buffer
 	localServer     string  = "Local"   // Local server name
 	defaultLabel    string  = " > "     // Default prompt
@@ -37,6 +49,8 @@ const (
 	inputSize       int     = 4         // Default size of the text input bar (fixed)
 	errorSize       int     = 1         // Default size of the error bar (fixed)
 	notifSize       int     = 2         // Default size of the notif bar (fixed)
+	replySize       int     = 1         // Default size of the reply bar (fixed)
+	replyPreview    int     = 50        // Maximum characters shown of a replied message
 	textSize        int     = 30        // Default size of the text window
 	errorMessage    uint    = 3         // Amount of seconds the error text shows up
 	asciiNumbers    int     = 0x30      // Start of ASCII for number 1
@@ -45,11 +59,48 @@ const (
 	maxServers      uint    = 9         // Maximum amount of allowed servers
 	cmdTimeout      uint    = 15        // Max seconds to wait for a command to finish
 	msgDelay        uint    = 300       // Miliseconds between sending messages
+	spinnerDelay    uint    = 500       // Miliseconds before a running command shows progress
+	spinnerRate     uint    = 100       // Miliseconds between spinner frames
 	rootBuffer      uint    = 0         // Number of the root buffer
+	historyShown    uint    = 20        // Maximum amount of commands listed in the history
+	graphShown      int     = 5         // Most active conversations shown per server in the graph
+	historySize     uint    = 500       // Default maximum amount of commands kept in the history
+	reconnectDelay  uint    = 3         // Seconds between reconnection attempts
+	reconnectTries  uint    = 3         // Times to try reconnecting after a transient disconnection
+	purgeInterval   uint    = 24        // Default hours between purges of old messages
+	maxTagSize      int     = 24        // Maximum length of a message label
+	nameEllipsis    string  = "…"       // Shown at the end of truncated names
+	sortDelay       uint    = 500       // Miliseconds to wait before reordering the buffer list
+	draftDelay      uint    = 1000      // Miliseconds to wait before saving the input as a draft
+	catchUpDelay    uint    = 1000      // Miliseconds without new messages before a catch up is done
 	textPage        string  = "Text"    // Name of the text page
 	helpPage        string  = "Help"    // Name of the help page
 )
 
+// Userlist text used if the server does not allow listing online users
+const restrictedUserlist string = "(Restricted)"
+
+// Orders in which the buffer list can be sorted
+const (
+	sortCreation string = "creation" // Oldest buffers first
+	sortActivity string = "activity" // Most recently active buffers first
+)
+
+// Colors given to senders by default, readable on both light
+// and dark terminals. Those of the user, the system and the
+// timestamps are left out so that they stand out.
+var nickPalette = []string{
+	"royalblue", "seagreen", "crimson", "darkorange",
+	"teal", "deeppink", "steelblue", "olivedrab",
+}
+
+// Policies for repeated commands in the history
+const (
+	dedupNone        string = "none"        // Every command is kept
+	dedupConsecutive string = "consecutive" // Repeating the last command is ignored
+	dedupFront       string = "front"       // Repeated commands are moved to the newest position
+)
+
 var (
 	ErrorSystemBuf        = errors.New("performing action on system buffer")          // performing action on system buffer
 	ErrorLocalServer      = errors.New("performing action on local server")           // performing action on local server
@@ -74,6 +125,17 @@ var (
 	ErrorInvalidArgument  = errors.New("provided argument is incorrect")              // provided argument is incorrect
 	ErrorMessageFromSelf  = errors.New("received message from self")                  // received message from self
 	ErrorInvalidAddress   = errors.New("address of server is not valid")              // address of server is not valid
+	ErrorNoSelection      = errors.New("no message is selected in this buffer")       // no message is selected in this buffer
+	ErrorNotVerbose       = errors.New("command is only available in verbose mode")   // command is only available in verbose mode
+	ErrorInvalidTag       = errors.New("label has invalid characters or is too long") // label has invalid characters or is too long
+	ErrorNoQuickCommands  = errors.New("no quick commands saved for this server")     // no quick commands saved for this server
+	ErrorNoReconnection   = errors.New("not reconnecting to this server")             // not reconnecting to this server
+	ErrorNoConfigFile     = errors.New("configuration file cannot be edited")         // configuration file cannot be edited
+	ErrorInvalidDate      = errors.New("invalid date, use the YYYY-MM-DD format")     // invalid date, use the YYYY-MM-DD format
+	ErrorNoMessagesAfter  = errors.New("no messages on or after that date")           // no messages on or after that date
+	ErrorMessageNotLoaded = errors.New("message is not loaded in this buffer")        // message is not loaded in this buffer
+	ErrorNoEditor         = errors.New("no editor has been set in $EDITOR")           // no editor has been set in $EDITOR
+	ErrorEditorFailed     = errors.New("editor did not run correctly")                // editor did not run correctly
 )
 
 // Identifies the areas where components are located.
@@ -94,6 +156,7 @@ type components struct {
 	text   *tview.TextView // shows messages
 	help   *tview.TextView
 	errors *tview.TextView // shows TUI errors
+	reply  *tview.TextView // shows the message being replied to
 	input  *tview.TextArea // input area to type
 
 	users *tview.TextView // list of users
@@ -111,6 +174,22 @@ func defaultParams() Parameters {
 			Relative: true,
 			Size:     1,
 		},
+		Input: ComponentSize{
+			Relative: false,
+			Size:     uint(inputSize),
+		},
+		Permissions: []PermissionStyle{
+			{Color: "", Symbol: ""},        // User
+			{Color: "orange", Symbol: "@"}, // Admin
+			{Color: "red", Symbol: "♛"},    // Owner
+		},
+		BufferSort:   sortCreation,
+		Unicode:      detectUnicode(),
+		HistorySize:  historySize,
+		HistoryDedup: dedupConsecutive,
+		NickColors:   true,
+		NickPalette:  nickPalette,
+		ConfirmQuit:  true,
 	}
 }
 
@@ -124,6 +203,7 @@ func setupLayout() (areas, components) {
 		text:    tview.NewTextView(),
 		help:    tview.NewTextView(),
 		errors:  tview.NewTextView(),
+		reply:   tview.NewTextView(),
 		input:   tview.NewTextArea(),
 		users:   tview.NewTextView(),
 	}
@@ -136,6 +216,7 @@ func setupLayout() (areas, components) {
 		AddItem(comps.notifs, 0, 0, false).
 		AddItem(comps.pages, 0, textSize, false).
 		AddItem(comps.errors, 0, 0, false).
+		AddItem(comps.reply, 0, 0, false).
 		AddItem(comps.input, inputSize, 0, true)
 	bottom.SetBackgroundColor(tcell.ColorDefault)
 
@@ -164,6 +245,7 @@ func setupLayout() (areas, components) {
 func setupStyle(t *TUI) {
 	t.comp.text.
 		SetDynamicColors(true).
+		SetRegions(true).
 		SetWrap(true).
 		SetWordWrap(true).
 		SetScrollable(true).
@@ -245,6 +327,12 @@ func setupStyle(t *TUI) {
 		SetWordWrap(true).
 		SetBackgroundColor(tcell.ColorDefault).
 		SetBorder(false)
+
+	t.comp.reply.
+		SetDynamicColors(true).
+		SetWrap(false).
+		SetBackgroundColor(tcell.ColorDefault).
+		SetBorder(false)
 }
 
 // Sets up the handling functions for each component.
@@ -261,7 +349,7 @@ func setupHandlers(t *TUI) {
 
 	// Runs when selecting a buffer
 	t.comp.buffers.SetSelectedFunc(func(i int, s1, s2 string, r rune) {
-		t.renderBuffer(s1)
+		t.renderBuffer(s2)
 		t.app.SetFocus(t.comp.input)
 	})
 
@@ -330,6 +418,11 @@ func setupHandlers(t *TUI) {
 	t.comp.notifs.SetChangedFunc(func() {
 		t.app.Draw()
 	})
+
+	// Forces a redraw when new text shows up
+	t.comp.reply.SetChangedFunc(func() {
+		t.app.Draw()
+	})
 }
 
 // Sets up main input capture (run command, send text, newline).
@@ -340,6 +433,8 @@ func setupInput(t *TUI) {
 		if text == "" {
 			t.next = 0
 		}
+
+		t.scheduleDraft()
 	})
 
 	// Text window keybinds
@@ -352,6 +447,27 @@ func setupInput(t *TUI) {
 			} else {
 				t.comp.text.ScrollToEnd()
 			}
+		case tcell.KeyLeft: // Select previous message
+			t.selectMessage(-1)
+			return nil
+		case tcell.KeyRight: // Select next message
+			t.selectMessage(1)
+			return nil
+		case tcell.KeyRune:
+			if event.Rune() == 'p' { // Pin selected message
+				if _, err := t.togglePin(); err != nil {
+					t.showError(err)
+				}
+				return nil
+			}
+			if event.Rune() == 'r' { // Reply to selected message
+				if err := t.startReply(); err != nil {
+					t.showError(err)
+					return nil
+				}
+				t.app.SetFocus(t.comp.input)
+				return nil
+			}
 		}
 		return event
 	})
@@ -361,9 +477,10 @@ func setupInput(t *TUI) {
 		switch event.Key() {
 		case tcell.KeyCtrlU: // Override
 			return nil
-		case tcell.KeyESC: // Clear text and history
+		case tcell.KeyESC: // Clear text, history and reply
 			t.comp.input.SetText("", false)
 			t.next = 0
+			t.cancelReply()
 			return nil
 		case tcell.KeyUp: // Go back in history
 			text := t.comp.input.GetText()
@@ -424,18 +541,27 @@ func setupInput(t *TUI) {
 
 			// Send the message
 			s := t.Active()
-			t.sendMessage(Message{
+			content, msgType := t.quoteReply(text)
+			msg := Message{
 				Sender:    selfSender,
 				Buffer:    t.Buffer(),
-				Content:   text,
+				Content:   content,
 				Timestamp: time.Now(),
 				Source:    s.Name(),
-			})
+				Type:      msgType,
+			}
+			t.sendMessage(msg)
 
-			go t.remoteMessage(text)
+			go func() {
+				// Kept until the message is delivered
+				t.saveDraft(s, msg.Buffer, text)
+				t.remoteMessage(msg)
+			}()
 
+			t.cancelReply()
 			t.status.lastMsg = time.Now()
 			t.comp.input.SetText("", false)
+			t.cancelDraft()
 			return nil
 		}
 		return event
@@ -447,7 +573,8 @@ func setupKeybinds(t *TUI) {
 	t.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		switch event.Key() {
 		case tcell.KeyCtrlQ: // Exit program
-			t.app.Stop()
+			quitWindow(t)
+			return nil
 		case tcell.KeyCtrlC: // Override to nothing
 			return nil
 		case tcell.KeyCtrlU: // Show/Hide user list
@@ -484,6 +611,26 @@ func setupKeybinds(t *TUI) {
 			if !t.status.blockCond() {
 				newQuickSwitchPopup(t)
 			}
+		case tcell.KeyCtrlO: // Quick commands
+			if t.status.blockCond() {
+				break
+			}
+
+			err := t.openQuickCommands(t.Active())
+			if err != nil {
+				t.showError(err)
+			}
+			return nil
+		case tcell.KeyCtrlY: // Cancel reconnection
+			if t.status.blockCond() {
+				break
+			}
+
+			err := t.cancelReconnect(t.Active())
+			if err != nil {
+				t.showError(err)
+			}
+			return nil
 		case tcell.KeyCtrlK: // Choose a buffer
 			if t.status.blockCond() {
 				break
@@ -534,10 +681,11 @@ func setupKeybinds(t *TUI) {
 
 // Creates a new TUI and tview application by its given static data.
 // This is needed to run the program in TUI mode.
-func New(static cmds.StaticData, debug bool) (*TUI, *tview.Application) {
+func New(static cmds.StaticData, debug bool, retention RetentionPolicy) (*TUI, *tview.Application) {
 	areas, comps := setupLayout()
 	t := &TUI{
 		servers: models.NewTable[string, Server](0),
+		retries: models.NewTable[string, *retry](0),
 		comp:    comps,
 		area:    areas,
 		params:  defaultParams(),
@@ -559,6 +707,7 @@ func New(static cmds.StaticData, debug bool) (*TUI, *tview.Application) {
 	}
 
 	t.params.Verbose = static.Verbose
+	t.params.SelfCopy = static.SelfCopy
 
 	// Create the tview application
 	app := tview.NewApplication().
@@ -612,14 +761,80 @@ func New(static cmds.StaticData, debug bool) (*TUI, *tview.Application) {
 	// Set userlist
 	t.comp.users.SetText(defaultUserlist)
 
+	applyBorders(t.params.Unicode)
+
 	// Change to syste, buffer and restore servers
 	t.changeBuffer(int(rootBuffer))
 	t.restoreSession()
 	t.renderServer(localServer)
+	offerDraftWindow(t)
+
+	// Only purge if the user opted in
+	if retention.enabled() {
+		go purgeLoop(t, retention)
+	}
 
 	return t, app
 }
 
+// Deletes old messages according to the retention
+// policy right away and then periodically.
+func purgeLoop(t *TUI, retention RetentionPolicy) {
+	interval := retention.Interval
+	if interval == 0 {
+		interval = purgeInterval
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purgeMessages(t, retention)
+		<-ticker.C
+	}
+}
+
+// Deletes the messages of every server that are older
+// than its retention policy allows, notifying the user.
+func purgeMessages(t *TUI, retention RetentionPolicy) {
+	notify := func(s string) {
+		t.sendMessage(Message{
+			Buffer:    systemBuffer,
+			Sender:    "System",
+			Content:   s,
+			Timestamp: time.Now(),
+			Source:    localServer,
+		})
+	}
+
+	list, err := db.GetAllServers(t.db)
+	if err != nil {
+		notify(fmt.Sprintf("Failed to purge old messages: %s", err))
+		return
+	}
+
+	for _, v := range list {
+		days := retention.days(v.Name)
+		if days == 0 {
+			continue
+		}
+
+		limit := time.Now().AddDate(0, 0, -int(days))
+		n, err := db.PurgeOldMessages(t.db, limit, v.Address, v.Port)
+		if err != nil {
+			notify(fmt.Sprintf("Failed to purge old messages in %s: %s", v.Name, err))
+			continue
+		}
+
+		if n > 0 {
+			notify(fmt.Sprintf(
+				"Purged %d messages older than %d days in %s",
+				n, days, v.Name,
+			))
+		}
+	}
+}
+
 // Restores all database server entries that are relevant.
 func (t *TUI) restoreSession() {
 	// Restore servers
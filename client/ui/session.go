@@ -1,10 +1,14 @@
 package ui
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	cmds "github.com/Sprinter05/gochat/client/commands"
@@ -62,6 +66,80 @@ func cleanupSession(t *TUI, s Server) {
 	t.comp.notifs.SetText("")
 }
 
+// Tries to connect again to a server that closed the connection
+// due to a transient problem, waiting between each attempt.
+func reconnectServer(t *TUI, cmd Command) {
+	print := t.systemMessage()
+
+	// Only one reconnection per server can be pending
+	if old, ok := t.retries.Get(cmd.serv.Name()); ok {
+		old.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &retry{cancel: cancel}
+	t.retries.Add(cmd.serv.Name(), r)
+	defer func() {
+		cancel()
+
+		// The server may have been renamed meanwhile
+		name := cmd.serv.Name()
+		if v, ok := t.retries.Get(name); ok && v == r {
+			t.retries.Remove(name)
+		}
+		t.app.QueueUpdateDraw(t.updateNotifications)
+	}()
+
+	for i := range reconnectTries {
+		r.attempt.Store(uint32(i + 1))
+		for left := reconnectDelay; left > 0; left-- {
+			r.left.Store(uint32(left))
+			t.app.QueueUpdateDraw(t.updateNotifications)
+
+			select {
+			case <-ctx.Done():
+				print("Reconnection cancelled", cmds.INFO)
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		r.left.Store(0)
+		t.app.QueueUpdateDraw(t.updateNotifications)
+
+		// Another connection may have been opened meanwhile
+		if _, ok := cmd.serv.Online(); ok {
+			return
+		}
+
+		print(fmt.Sprintf(
+			"Reconnecting to the server (%d/%d)...",
+			i+1, reconnectTries,
+		), cmds.INFO)
+
+		err := connectServer(t, cmd)
+		if err == nil {
+			print("Connected to the server again, you need to log in again", cmds.INFO)
+			return
+		}
+
+		t.showError(err)
+	}
+
+	print("Could not reconnect to the server", cmds.INFO)
+}
+
+// Stops the pending reconnection to a server,
+// which is left disconnected.
+func (t *TUI) cancelReconnect(s Server) error {
+	r, ok := t.retries.Get(s.Name())
+	if !ok {
+		return ErrorNoReconnection
+	}
+
+	r.cancel()
+	return nil
+}
+
 /* USERS */
 
 // Requests a user's public key on buffer connection
@@ -198,7 +276,22 @@ func (t *TUI) updateNotifications() {
 	// connected to the server
 	_, ok := s.Online()
 	if !ok {
-		t.area.bottom.ResizeItem(t.comp.notifs, 0, 0)
+		r, retrying := t.retries.Get(s.Name())
+		if !retrying {
+			t.area.bottom.ResizeItem(t.comp.notifs, 0, 0)
+			return
+		}
+
+		ellipsis := t.symbol(nameEllipsis)
+		status := "Reconnecting" + ellipsis
+		if left := r.left.Load(); left > 0 {
+			status = fmt.Sprintf("Reconnecting in %ds%s", left, ellipsis)
+		}
+		t.comp.notifs.SetText(fmt.Sprintf(
+			"\n [yellow::b]%s[-::-] (attempt %d/%d) | [green]Ctrl-Y[-] to cancel",
+			status, r.attempt.Load(), reconnectTries,
+		))
+		t.area.bottom.ResizeItem(t.comp.notifs, notifSize, 0)
 		return
 	}
 
@@ -232,10 +325,102 @@ func (t *TUI) updateNotifications() {
 	t.area.bottom.ResizeItem(t.comp.notifs, notifSize, 0)
 }
 
+/* CATCH UP */
+
+// Counts the messages received from each user while catching
+// up after logging in, so that a summary can be shown later
+type catchUp struct {
+	mut     sync.Mutex
+	senders map[string]uint // Messages received by sender, nil once finished
+	stored  chan struct{}   // Notified whenever a message is counted
+}
+
+// Creates an empty catch up ready to count messages
+func newCatchUp() *catchUp {
+	return &catchUp{
+		senders: make(map[string]uint),
+		stored:  make(chan struct{}, 1),
+	}
+}
+
+// Counts a message received from a user, unless
+// the catch up has already finished
+func (c *catchUp) add(sender string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.senders == nil {
+		return
+	}
+
+	c.senders[sender] += 1
+	select {
+	case c.stored <- struct{}{}:
+	default:
+	}
+}
+
+// Waits until no messages have been counted for the given time
+func (c *catchUp) wait(ctx context.Context, quiet time.Duration) {
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stored:
+			timer.Reset(quiet)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Stops counting messages and returns a summary of the ones
+// received, with the users that sent the most first. The
+// summary is empty if no messages were received.
+func (c *catchUp) finish() string {
+	c.mut.Lock()
+	senders := c.senders
+	c.senders = nil
+	c.mut.Unlock()
+
+	if len(senders) == 0 {
+		return ""
+	}
+
+	var total uint
+	users := make([]string, 0, len(senders))
+	for k, v := range senders {
+		users = append(users, k)
+		total += v
+	}
+
+	slices.SortFunc(users, func(a, b string) int {
+		if senders[a] != senders[b] {
+			return cmp.Compare(senders[b], senders[a])
+		}
+		return strings.Compare(a, b)
+	})
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d new messages from %d contacts:", total, len(users))
+	for _, v := range users {
+		fmt.Fprintf(&text, "\n- [yellow]%s[-]: %d", v, senders[v])
+	}
+
+	return text.String()
+}
+
 /* MESSAGES */
 
-// Sends a message to the remote connection if possible
-func (t *TUI) remoteMessage(content string) {
+// Sends a message to the remote connection if possible,
+// updating the rendered message once it has been stored.
+func (t *TUI) remoteMessage(msg Message) {
+	t.status.sending.Add(1)
+	defer t.status.sending.Add(-1)
+
 	print := t.systemMessage("message")
 
 	s := t.Active()
@@ -265,14 +450,25 @@ func (t *TUI) remoteMessage(content string) {
 
 	ctx, cancel := timeout(s, cmd.Data)
 	defer cmd.Data.Waitlist.Cancel(cancel)
-	err := cmds.MSG(ctx, cmd, tab.name, content)
+	stored, err := cmds.MSG(ctx, cmd, tab.name, msg.Content, msg.Type)
 	if err != nil {
 		print("failed to send message: "+err.Error(), cmds.ERROR)
+		return
+	}
+
+	// Delivered so the draft is no longer needed
+	t.saveDraft(s, tab.name, "")
+
+	// Allows selecting the message
+	sent := msg
+	sent.ID = stored.ID
+	if tab.messages.Replace(msg, sent) && t.Buffer() == tab.name {
+		t.renderBuffer(tab.name)
 	}
 }
 
 // Waits for new messages to be sent to the logged in user
-func (t *TUI) receiveMessages(ctx context.Context, s Server) {
+func (t *TUI) receiveMessages(ctx context.Context, s Server, catch *catchUp) {
 	defer func() {
 		// Clear session buffers and notifications
 		s.Buffers().Offline()
@@ -322,20 +518,30 @@ func (t *TUI) receiveMessages(ctx context.Context, s Server) {
 			continue
 		}
 
-		// Update notifications
-		s.Notifications().Notify(msg.Sender)
-		t.updateNotifications()
-
-		if msg.Sender == data.LocalUser.User.Username {
-			print(ErrorMessageFromSelf.Error())
+		// Copies of sent messages go to the buffer of their recipient
+		buffer := msg.Sender
+		if msg.Recipient != "" {
+			buffer = msg.Recipient
+		} else {
+			// Update notifications
+			s.Notifications().Notify(msg.Sender)
+			t.updateNotifications()
+			catch.add(msg.Sender)
+
+			if msg.Sender == data.LocalUser.User.Username {
+				print(ErrorMessageFromSelf.Error())
+			}
 		}
 
 		t.sendMessage(Message{
-			Buffer:    msg.Sender,
+			Buffer:    buffer,
 			Sender:    msg.Sender,
 			Content:   msg.Content,
 			Timestamp: msg.Timestamp,
+			Sequence:  msg.Sequence,
 			Source:    s.Name(),
+			ID:        msg.ID,
+			Type:      msg.Type,
 		})
 	}
 }
@@ -356,28 +562,74 @@ func (t *TUI) waitShutdown(ctx context.Context, s Server) {
 		}
 	}
 
-	// We only wait for the packet once
-	cmd, err := data.Waitlist.Get(
-		ctx, cmds.Find(spec.NullID, spec.SHTDWN),
-	)
-	if err != nil {
-		print(err.Error())
-		return
+	warn := t.systemMessage()
+	for {
+		// A shutdown may be scheduled and cancelled several times
+		cmd, err := data.Waitlist.Get(
+			ctx, cmds.Find(spec.NullID, spec.SHTDWN),
+		)
+		if err != nil {
+			print(err.Error())
+			return
+		}
+
+		if len(cmd.Args) == 0 {
+			warn("Scheduled server shutdown has been cancelled!", cmds.INFO)
+			continue
+		}
+
+		stamp, err := spec.BytesToUnixStamp(cmd.Args[0])
+		if err != nil {
+			print(err.Error())
+			continue
+		}
+
+		str := fmt.Sprintf(
+			"Server shutdown scheduled at %s!",
+			stamp.String(),
+		)
+		warn(str, cmds.INFO)
 	}
+}
 
-	stamp, err := spec.BytesToUnixStamp(cmd.Args[0])
-	if err != nil {
-		print(err.Error())
-		return
+// Waits for signaling data relayed from other users. There
+// is no media support so they are only reported in verbose mode.
+func (t *TUI) receiveSignals(ctx context.Context, s Server) {
+	data, _ := s.Online()
+	output := t.systemMessage("signal", defaultBuffer)
+
+	print := func(msg string) {
+		if t.params.Verbose {
+			// We wait some miliseconds to prevent race condition
+			<-time.After(50 * time.Millisecond)
+			output(msg, cmds.ERROR)
+		}
 	}
 
-	str := fmt.Sprintf(
-		"Server shutdown scheduled at %s!",
-		stamp.String(),
-	)
+	c := cmds.Command{
+		Output: func(string, cmds.OutputType) {},
+		Static: t.static(),
+		Data:   data,
+	}
 
-	warn := t.systemMessage()
-	warn(str, cmds.INFO)
+	for {
+		signal, err := cmds.ReceiveSignal(ctx, c)
+		if errors.Is(err, spec.ErrorArguments) {
+			print("discarded malformed signaling data")
+			continue
+		}
+		if err != nil {
+			print(err.Error())
+			return
+		}
+
+		if t.params.Verbose {
+			output(fmt.Sprintf(
+				"received %d bytes of signaling data from %s",
+				len(signal.Data), signal.Sender,
+			), cmds.INFO)
+		}
+	}
 }
 
 // Waits for new notifications of hooks from the server
@@ -422,12 +674,6 @@ func (t *TUI) receiveHooks(ctx context.Context, s Server) {
 			continue
 		}
 
-		// Check if it has enough arguments
-		if int(cmd.HD.Args) < min {
-			print("hook with invalid arguments received, ignoring")
-			continue
-		}
-
 		switch hook {
 		case spec.HookPermsChange: // User permissions changed
 			uname := string(cmd.Args[0])
@@ -460,6 +706,15 @@ func (t *TUI) receiveHooks(ctx context.Context, s Server) {
 				string(cmd.Args[0]),
 				uint(perms),
 			)
+
+			// Only administrators are told where logins come from
+			if len(cmd.Args) > min {
+				str := fmt.Sprintf(
+					"%s logged in from %s",
+					cmd.Args[0], cmd.Args[min],
+				)
+				output(str, cmds.INFO)
+			}
 		case spec.HookNewLogout: // Someone logged out from the server
 			t.status.userlistRemove(
 				string(cmd.Args[0]),
@@ -472,7 +727,7 @@ func (t *TUI) receiveHooks(ctx context.Context, s Server) {
 			hook == spec.HookPermsChange
 
 		if refresh && t.Active().Name() == s.Name() {
-			t.comp.users.SetText(t.status.userlistRender())
+			t.comp.users.SetText(t.status.userlistRender(t.params))
 		}
 	}
 }
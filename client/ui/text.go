@@ -2,12 +2,17 @@ package ui
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	cmds "github.com/Sprinter05/gochat/client/commands"
 	"github.com/Sprinter05/gochat/client/db"
+	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/rivo/tview"
 )
 
 /* TEXT */
@@ -17,13 +22,16 @@ const KeybindHelp string = `
 
 [yellow::b]Ctrl-Alt-L/Ctrl-Shift-L[-::-]: Show/Hide help window
 
-[yellow::b]Ctrl-Q[-::-]: Exit program
+[yellow::b]Ctrl-Q[-::-]: Exit program, asking first if messages are being sent or commands are running
 
 [yellow::b]Ctrl-T[-::-]: Focus chat/input window
 	- In the [-::b]chat window[-::-] use [green]Up/Down[-::-] to move
 	- In the [-::b]chat window[-::-] use [green]ESC[-::-] to scroll down to the end
 	- In the [-::b]chat window[-::-] use [green]Shift-ESC/Alt-ESC[-::-] to scroll up to the beggining
-	- In the [-::b]input window[-::-] use [green]ESC[-::-] to clear the text
+	- In the [-::b]chat window[-::-] use [green]Left/Right[-::-] to select the previous/next message
+	- In the [-::b]chat window[-::-] use [green]p[-::-] to pin/unpin the selected message
+	- In the [-::b]chat window[-::-] use [green]r[-::-] to reply to the selected message
+	- In the [-::b]input window[-::-] use [green]ESC[-::-] to clear the text and cancel a reply
 	- In the [-::b]input window[-::-] use [green]Alt-Enter/Shift-Enter[-::-] to add a newline
 	- In the [-::b]input window[-::-] use [green]Up[-::-] to browse through the history of commands ran.
 
@@ -57,6 +65,13 @@ const KeybindHelp string = `
 	- This will allow you to jump to a desired buffer by typing its name
 	- It includes an autocomplete that you can fill using [green]Tab[-::-]
 	
+[yellow::b]Ctrl-O[-::-]: Open the quick commands of the current server
+	- Selecting one with [green]Enter[-::-] runs it, [green]ESC[-::-] closes the list
+	- Quick commands are created with [yellow]/quick add[-]
+	
+[yellow::b]Ctrl-Y[-::-]: Cancel the pending reconnection to the current server
+	- Only available while "TUI.Reconnect" is waiting to connect again
+	
 [yellow::b]Alt-Up/Down[-::-]: Go to next/previous buffer
 
 [yellow::b]Shift-Up/Down[-::-]: Go to next/previous server
@@ -73,29 +88,168 @@ const CommandHelp string = `
 
 [yellow::b]/version[-::-]: Displays the current version of the client and protocol
 
+[yellow::b]/serverversion[-::-]: Displays the versions last advertised by the currently active server
+	- Servers that do not advertise their version are shown as unknown
+
+[yellow::b]/lastpacket[-::-]: Displays the last packet sent to the currently active server and its reply
+	- Arguments that are not text, such as encrypted messages, are shown as their length and first bytes
+	- The challenge answer of [yellow]VERIF[-] and the token of [yellow]LOGIN[-] are never shown, only their length
+	- It is a lighter alternative to the debug buffer and it is forgotten when disconnecting
+
+[yellow::b]/errors[-::-] [blue](code)[-]: Displays the error codes defined by the protocol and their meaning
+	- The code can be given as a number (e.g. "0x05") or as its name (e.g. "ERR_ARGS" or "args")
+	- Without a code the whole table is shown
+
+[yellow::b]/operations[-::-]: Displays the operations defined by the protocol and what they do
+	- The same descriptions are shown next to the operation of every packet in the debug buffer
+
 [yellow::b]/servers[-::-]: Displays the list of all servers that are in the database
+	- TLS servers also show whether their certificates are verified
 
 [yellow::b]/buffers[-::-]: Displays a list of all buffers in the current server
 	- Those that have been hidden will also be displayed
+	- Buffers are shown in the same order as the buffer list, along with their last activity
 	
+[yellow::b]/history[-::-] [blue](run <index>)[-]: Lists the last commands that have been ran
+	- Each command is shown with its index, starting by the most recent one
+	- Using "run" with an index will run that command again
+	- Only the last "TUI.HistorySize" commands are kept, and "TUI.HistoryDedup" decides what happens with repeated ones
+	- Commands that may contain a password are never shown nor ran again
+
 [yellow::b]/clear[-::-]: Clears all system messages in the current buffer
 
-[yellow::b]/config[-::-]: Shows all current configuration options
+[yellow::b]/probe[-::-] [green]<address:port>[-] [blue](-tls)[-]: Checks whether a server is reachable and speaks the gochat protocol
+	- Only the greeting of the server is read, then the connection is closed
+	- It shows the versions and policies advertised by the server, nothing is stored
+	- Reports whether the server is unreachable, is not a gochat server or uses an incompatible version
+
+[yellow::b]/pingall[-::-]: Probes every stored server at the same time and shows which ones are reachable
+	- Works like [yellow]/probe[-] using the address and TLS settings stored for each server
+	- Reachable servers are listed first with their latency and version
+	- Sessions are never affected, not even for servers that are connected
+
+[yellow::b]/decode[-::-] [green]<hexbytes>[-]: Decodes a packet given as an hexadecimal dump
+	- Bytes can be given either contiguous or separated by spaces
+	- It shows the header and arguments of the packet, nothing is sent to the server
+
+[yellow::b]/raw[-::-] [green]<op> <info>[-] [blue](args...)[-]: Sends a hand-crafted packet to the server and shows its reply
+	- The operation is given by name and the info byte can be decimal or hexadecimal
+	- Arguments are separated by spaces and must be at least the ones required by the operation
+	- Only available in verbose mode, malformed packets may break the current session
+
+[yellow::b]/goroutines[-::-]: Displays how many goroutines are running, for finding leaks
+	- Listeners and other background tasks of each server are tracked and shown separately
+	- After disconnecting from a server none of its goroutines should be left, except a pending reconnection
+	- Only available in verbose mode
+
+[yellow::b]/inspect[-::-]: Compares the selected message with its ciphertext decrypted again, for diagnosing garbled messages
+	- The ciphertext is only kept for messages received while running in verbose mode
+	- It is decrypted with the current private key of the logged in user, which is never shown
+	- Only available in verbose mode and for received messages
+
+[yellow::b]/benchmark[-::-] [green]<crypto>[-]: Measures how long cryptographic operations take on this machine
+	- Times RSA key generation, an encryption round trip and password hashing
+	- Each step is shown as soon as it finishes, nothing is sent to the server
+
+[yellow::b]/inbox[-::-]: Lists every conversation in the current server, starting by the most recent
+	- The amount of unread messages and the time of the last message are shown for each one
+	- Users without messages are shown at the end
+	- Selecting a conversation with [green]Enter[-::-] opens its buffer, [green]ESC[-::-] closes the list
+
+[yellow::b]/graph[-::-]: Summarizes the conversations stored for every server in the "Graph" buffer of the local server
+	- Shows the amount of contacts and messages of each server and its most active conversations
+	- Totals across all servers are shown at the bottom
+	- Only the local database is read, so it also works while offline
+	- Running it again refreshes the summary
+
+[yellow::b]/quick[-::-] [blue](add <name> <command>)[-] [blue](remove <name>)[-]: Manages the quick commands of the current server
+	- Without arguments it opens the list of quick commands, as [yellow]Ctrl-O[-] does
+	- The command is given without the leading "/" (e.g. "/quick add kick admin kick bob")
+	- Quick commands can also be given for each server name in the "quick_commands" section of the configuration file, which are only saved if the server has none stored
+
+[yellow::b]/pin[-::-]: Pins the selected message or unpins it if it was already pinned
+	- Messages are selected with [green]Left/Right[-::-] in the chat window, where [green]p[-::-] also pins them
+	- Pins are only stored locally
+
+[yellow::b]/pinned[-::-]: Lists the pinned messages of the current buffer
+
+[yellow::b]/jump[-::-] [green]<date>[-]: Scrolls to the first message of the current buffer sent on a date
+	- The date uses the YYYY-MM-DD format (e.g. "/jump 2025-03-14")
+	- If there are no messages on that date it jumps to the next message after it
+	- The message is selected so it can be pinned, tagged or replied to
+
+[yellow::b]/reply[-::-]: Replies to the selected message with the next message sent
+	- A preview of the message is shown above the input until it is sent
+	- The reply starts with a quote of the first line of the message
+	- Use [green]ESC[-::-] in the input window to cancel it
+
+[yellow::b]/tag[-::-] [green]<label>[-]: Tags the selected message with a label or removes it if it already had it
+	- Labels can only contain letters, digits, dashes and underscores
+	- Tags are only stored locally
+
+[yellow::b]/tagged[-::-] [green]<label>[-]: Lists every message of the current server tagged with a label
+
+[yellow::b]/compose[-::-]: Writes a message in the editor set in $EDITOR and sends it to the current buffer
+	- The TUI is hidden until the editor is closed, and the message is sent once it is saved
+	- Closing the editor without saving or leaving the file empty discards the message
+	- Messages that are too long are left in the input so that they can be shortened
+
+[yellow::b]/me[-::-] [green]<action>[-]: Sends an action to the current buffer, shown in italics after an asterisk
+	- Clients that do not know about actions show it as a plain message
+
+[yellow::b]/resend[-::-] [green]<user>[-]: Sends the last message you sent in this session again to another user
+	- Useful if the message was sent to the wrong buffer, the original one is kept
+	- The user must have been requested before unless automatic requests are enabled
+
+[yellow::b]/dbinfo[-::-]: Shows the amount of data stored in the local database
+	- Row counts for servers, users, local users, external users and messages are shown
+	- The size the database takes on disk is also shown
+
+[yellow::b]/dbvacuum[-::-]: Reclaims the space left in the local database by deleted data
+	- The size before and after the operation will be shown
+	- It may take a while if the database is big
+
+[yellow::b]/dbcheck[-::-] [blue](-repair)[-]: Checks the local database for corruption and broken references
+	- Runs the integrity check of SQLite and looks for users and messages that reference users which no longer exist
+	- Nothing is changed unless [blue]-repair[-] is given, which deletes those rows if the database is not corrupted
+	- Local users whose server was deleted are only reported, use [yellow]/dangling[-] and [yellow]/recover[-] to get their data back
+
+[yellow::b]/config[-::-] [blue](show/set <option> <value>)[-]: Shows all current configuration options
 	- It will display both the name and value of the option
 	- It will only display those available in the current server
+	- Use "/config show" to see the options of the configuration file, sensitive values are redacted
+	- Use "/config set <option> <value>" to change an option of the file, such as "/config set ui_config.name_length 16"
+	- Values are read as JSON, options marked with [yellow]*[-] are only applied after restarting the client
 
 [yellow::b]/set[-::-] [green]<option>[-] [green]<value>[-]: Updates a value in the configuration
 	- The option name is case sensitive
 	- The option name must follow the same format as the configuration shows
+	- Use "/set TUI.Swapped true" to place the buffer list on the right and the user list on the left
+	- Use "/set TUI.HideBuflist true" or "/set TUI.HideUserlist true" to never show those lists
+	- Relative sizes of "TUI.Input" are proportional to a size of 30 for the messages
+	- Use "/set TUI.BufferSort activity" to show the most recently active buffers first, or "creation" to go back
+	- Use "/set TUI.NameLength <length>" to shorten long names in the buffer and user lists, or 0 to show them in full
+	- Use "/set TUI.Unicode on/off" to draw symbols and borders with Unicode or only with ASCII characters
+	- Use "/set TUI.HistorySize <amount>" to limit the commands kept in the history, or 0 to keep all of them
+	- Use "/set TUI.HistoryDedup none/consecutive/front" to keep repeated commands, ignore repeating the last one or move them to the newest position
+	- Use "/set TUI.NickColors on/off" to show each sender with its own color or all of them in blue
+	- Use "/set TUI.ConfirmQuit on/off" to ask or not before quitting while messages are being sent or commands are running
 	
+[yellow::b]/rename-server[-::-] [green]<old>[-] [green]<new>[-]: Changes the name of a saved server
+	- The new name cannot be used by any other server
+	- The server can be renamed while connected, it stays connected afterwards
+
 [yellow::b]/connect[-::-] [blue](-noverify)[-] [blue](-noidle)[-]: Connects to the currently active server using its address
 	- This will fail if the server is local
 	- If the connection is TLS and "-noverify" is used, certificates will not be checked
+	- Use "/set Server.SkipVerify true" to never check the certificates of the server
 	- If "-noidle" is used, the client will try to avoid being disconnected for inactivity
+	- Use "/set TUI.Reconnect true" to connect again after losing the connection, unless it was closed manually
 
 [yellow::b]/register[-::-] [green]<username>[-]: Creates a new account in the currently active server
 	- A popup asking for a password to register will show up when creating a new account
 	- No two accounts with the same name can exist in one single server
+	- If the server does not confirm the registration, running it again with the same password retries it with the same keys
 	- You need an active connection to use this command
 	
 [yellow::b]/deregister[-::-] [green]<username>[-]: Deletes the specified account	
@@ -107,14 +261,35 @@ const CommandHelp string = `
 	- The provided private key must be RSA 4096 bits in PEM PKCS1 format
 	- A popup asking for a password for the imported account will show up
 
+[yellow::b]/keyinfo[-::-] [green]<path>[-]: Checks a key before importing it, showing its size and fingerprint
+	- The path is looked up in the same directory used by [yellow::b]/import[-::-]
+	- No password is needed and nothing is imported
+
 [yellow::b]/export[-::-] [green]<username>[-]: Exports the private key of an existing local user
 	- The specified user must be registered on the server on which the command is ran	
 	- A popup asking for the password asocciated to the account will show up
 	- The key will be put in a file in the directory from which the program was ran
 	- The fill will be called <username>.priv and will be in PEM PKCS1 format (RSA 4096 bits)
 
+[yellow::b]/export-html[-::-] [green]<username>[-] [green]<path>[-]: Exports a conversation as an HTML transcript
+	- The conversation between your account and the specified user will be exported
+	- The path provided must be related to the directory from which the program was ran
+	- The file does not reference any external resource so it can be shared as is
+	- You need to be logged in to use this command
+
+[yellow::b]/passwd[-::-] [green]<username>[-]: Changes the password of an existing local user
+	- A popup asking for the current password of the account will show up
+	- Two more popups asking for the new password will show up
+	- The private key is encrypted again with the new password, the server is not contacted
+
+[yellow::b]/rekeyall[-::-] [blue](-master)[-]: Encrypts again the private keys of every local user
+	- A popup asking for the password of each account will show up
+	- If [blue]-master[-] is given, the new passphrase becomes the password of every account
+	- No key is modified unless all of them are encrypted again correctly
+
 [yellow::b]/login[-::-] [green]<username>[-]: Tries to login in the server with an account
 	- A popup asking for the password asocciated to the account will show up
+	- Once the messages received while offline are stored, a summary with the amount sent by each user is shown in the default buffer
 	- You need an active connection to use this command
 
 [yellow::b]/logout[-::-]: Logs out of your account in the currently active server
@@ -123,13 +298,78 @@ const CommandHelp string = `
 [yellow::b]/disconnect[-::-]: Interrumps the connection with the currently active server
 	- You need an active connection to use this command
 
+[yellow::b]/leave[-::-]: Logs out, disconnects and hides the currently active server in one step
+	- Unlike [green]/disconnect[-::-], the server is no longer shown in the server list
+	- Unlike deleting the server, its messages and users are kept in the database
+	- It can be shown again with all of its history by typing its name when creating a new server
+
+[yellow::b]/cancel-reconnect[-::-]: Stops trying to reconnect to the currently active server
+	- The countdown until the next attempt is shown in the notification bar
+	- The server is left disconnected, the same can be done with [yellow]Ctrl-Y[-]
+
+[yellow::b]/secinfo[-::-]: Shows the security details of the connection with the currently active server
+	- If using TLS, it displays the version, cipher suite and certificate fingerprint
+	- It also shows whether a reusable token is currently held for the session
+	- You need an active connection to use this command
+
 [yellow::b]/users[-::-] [green]<remote/local>[-] [green]<all/online/server>[-] [blue](-perms)[-]: Shows a list of users according to the specified filter
 	- [cyan]"remote all"[-] will display all users registered on the remote server (requires connection)
 	- [cyan]"remote online"[-] will display all connected accounts in the server (requires connection)
 	- [cyan]"local all"[-] will display accounts created for for all servers on this client
 	- [cyan]"local server"[-] will display all local accounts for that server
 	- For the [cyan]"remote"[-] options you can optionally pass "-perms" to show permission levels
+	- Servers may only allow users with enough permissions to list remote users
 	
+[yellow::b]/refresh[-::-]: Fetches the online users again and redraws the userlist
+	- Any difference between the userlist and the server will be corrected
+	- Only the currently active server is refreshed
+	- You need to be logged in to use this command
+
+[yellow::b]/reqall[-::-]: Requests the public key of every online user in the server
+	- Users that have already been requested and yourself will be skipped
+	- Progress will be shown as each user gets requested
+	- You need to be logged in to use this command
+
+[yellow::b]/resync[-::-] [green]<user>[-]: Requests again the public key of a user that is already stored
+	- The fingerprints of both keys are compared and a warning is shown if the key has changed
+	- A changed key replaces the stored one so that messages can still be sent to the user
+	- You need to be logged in to use this command
+
+[yellow::b]/resyncall[-::-]: Resyncs the public key of every stored user in the server
+	- Only users whose key has changed are reported apart from the progress
+	- You need to be logged in to use this command
+
+[yellow::b]/announcements[-::-]: Lists the messages of the day, banners and broadcasts received from the current server
+	- They are kept across restarts so that the ones sent while you were away can be reviewed
+	- A message of the day or banner is only stored again if it has changed
+	- Only the last 100 announcements of each server are kept
+
+[yellow::b]/keyhistory[-::-] [green]<user>[-]: Lists the fingerprints of every public key seen for a user
+	- Keys are recorded when the user is requested and when a resync finds that the key has changed
+	- The stored key is shown as current and the ones it replaced as superseded
+	- Keys stored before they started being recorded are shown as first seen at an unknown time
+
+[yellow::b]/note[-::-] [green]<username>[-] [green]<text/-clear>[-]: Adds a private note about a user
+	- The note is appended as a new line to the existing notes of that user
+	- Use "\n" inside the text to write a note with several lines
+	- Using "-clear" instead of a text removes all notes about that user
+	- Notes are only stored locally and are never sent to the server
+	- The public key of the user must have been requested before
+
+[yellow::b]/notes[-::-] [green]<username>[-]: Shows the private notes about a user
+
+[yellow::b]/profile[-::-] [blue](set <text>/clear/show <username>)[-]: Manages profile bios
+	- Using "set" changes the bio other users get when requesting you
+	- Using "clear" removes your bio from the server
+	- Using "show" prints the bio of a user as it was last requested
+	- The bio of a user is updated every time the user is requested or resynced
+	- You need to be logged in to change your bio
+
+[yellow::b]/selftest[-::-]: Checks that the keys of your account can encrypt and decrypt messages
+	- A known text is encrypted with your public key and decrypted with your private key
+	- The server is not involved in this process
+	- You need to be logged in to use this command
+
 [yellow::b]/subscribe[-::-] [green]<hook>[-]: Subscribes to a specific event in the server
 	- [cyan]"new_login"[-] will update the userlist whenever a new user logs in
 	- [cyan]"new_logout"[-] will update the userlist whenever a user logs out
@@ -140,30 +380,55 @@ const CommandHelp string = `
 [yellow::b]/unsubscribe[-::-] [green]<hook>[-]: Unsubscribes from a specific event in the server
 	- Available options are the same as for [yellow::b]/subscribe[-::-]
 
+[yellow::b]/speedtest[-::-] [blue](count)[-]: Measures the latency and throughput of the link with the server
+	- Sends the given amount of maximum size packets that the server echoes back (10 by default)
+	- The server only allows a limited amount of echoes per minute
+	- You need to be logged in to use this command
+
+[yellow::b]/subscribed[-::-]: Lists the events you are currently subscribed to in the server
+	- The list is provided by the server, not by the client
+
 [yellow::b]/admin[-::-] [green]<operation>[-] [blue](...)[-]: Performs an administrative operation
-	- [cyan]"shutdown <offset>"[-] will perform a shutdown in the current time + offset (in minutes)
+	- [cyan]"shutdown <offset> (warnings...)"[-] will perform a shutdown in the current time + offset (in minutes), broadcasting a warning the given minutes before it
 	- [cyan]"broadcast <message>[-] will send a message to all online users of the server
 	- [cyan]"ban <username>"[-] will ban the specified user from the server
-	- [cyan]"kick <username>"[-] will disconnect the specified user from the server
+	- [cyan]"kick <username> (reason)"[-] will disconnect the specified user from the server, telling them the reason if given
 	- [cyan]"setperms <username> <permissions>[-] will set the permission level of the new user
 	- [cyan]"motd <motd>"[-] will set a new MOTD (message of the day) for the server
+	- [cyan]"cancel"[-] will cancel a previously scheduled shutdown
+	- [cyan]"expire (username)"[-] will expire all reusable tokens, or only those of the specified user, also logging them out if online
+	- [cyan]"prune <days> (-dry)"[-] will deregister all users not seen in the given days, "-dry" only lists them
+	- [cyan]"getperms <username>"[-] will show the permission level of the specified user
+	- [cyan]"resetperms <username>"[-] will set the permission level of the specified user back to the default
+
+[yellow::b]/dangling[-::-]: Lists the local users whose server has been deleted
+	- Shows the identifier the deleted server had and how many messages each user has
+	- Any of these users can be recovered with [yellow]/recover[-]
 
-[yellow::b]/recover[-::-] [green]<user>[-] [blue](-cleanup)[-]: Recovers data from a dangling user
+[yellow::b]/recover[-::-] [green]<user>[-] [blue](-cleanup)[-] [blue](-format <text|json>)[-] [blue](-style <plain|ansi|markdown>)[-] [blue](-tz <timezone>)[-]: Recovers data from a dangling user
 	- If a user has become dangling (server is "Unknown"), this can be used to recover its data
 	- This command will only work with dangling users
 	- A popup asking for the password of the account to recover will appear
 	- If "-cleanup" is used, the user will be deleted from the database after recovery
+	- Messages are exported as text by default, use "-format json" for machine-readable output
+	- Text exports can tell sent and received messages apart with "-style markdown", or "-style ansi" to read them with "less -R"
+	- Timestamps include their offset from UTC and use the local timezone unless "-tz" is given (e.g. "-tz UTC")
 `
 
 /* MESSAGES */
 
 // Identifies a TUI message.
 type Message struct {
-	Buffer    string    // Buffer to store it in
-	Sender    string    // Who sends it
-	Content   string    // Message text
-	Timestamp time.Time // Time when it occurred
-	Source    string    // Destination name
+	Buffer    string       // Buffer to store it in
+	Sender    string       // Who sends it
+	Content   string       // Message text
+	Timestamp time.Time    // Time when it occurred
+	Sequence  uint64       // Server order for messages with the same timestamp
+	Source    string       // Destination name
+	ID        uint         // Identifier in the database, 0 if not stored
+	Pinned    bool         // Whether the message has been pinned
+	Tags      string       // Local labels of the message separated by spaces
+	Type      spec.MsgType // How the content should be understood
 }
 
 // Returns the TLS secondary text for servers
@@ -294,6 +559,17 @@ func getOldMessages(t *TUI, s Server, username string) {
 		})
 	}
 
+	ids := make([]uint, 0, len(msgs))
+	for _, v := range msgs {
+		ids = append(ids, v.MessageID)
+	}
+
+	// Messages are still shown without their tags
+	tags, err := db.GetMessageTags(t.db, ids)
+	if err != nil {
+		print("failed to get message tags due to "+err.Error(), cmds.ERROR)
+	}
+
 	uname := data.LocalUser.User.Username
 	for _, v := range msgs {
 		sender := v.SourceUser.Username
@@ -306,7 +582,12 @@ func getOldMessages(t *TUI, s Server, username string) {
 			Sender:    sender,
 			Content:   v.Text,
 			Timestamp: v.Stamp,
+			Sequence:  v.Sequence,
 			Source:    s.Name(),
+			ID:        v.MessageID,
+			Type:      spec.MsgType(v.Type),
+			Pinned:    v.Pinned,
+			Tags:      strings.Join(tags[v.MessageID], " "),
 		})
 	}
 }
@@ -331,6 +612,11 @@ func (t *TUI) sendMessage(msg Message) {
 	if ok && t.Buffer() == msg.Buffer {
 		t.renderMsg(msg)
 	}
+
+	// Only the active server shows its buffers
+	if ok && t.focus == msg.Source {
+		t.scheduleReorder()
+	}
 }
 
 /* RENDERING */
@@ -383,8 +669,45 @@ func (t *TUI) renderMsg(msg Message) {
 	n := strings.Count(msg.Content, "\n")
 	content := strings.Replace(msg.Content, "\n", "\n\t\t\t   "+pad, n)
 
+	switch msg.Type {
+	case spec.MsgAction:
+		content = "[::i]* " + content + "[::-]"
+	case spec.MsgReaction:
+		content = "[gray]reacted with[-] " + content
+	case spec.MsgEdit:
+		content = content + " [gray](edited)[-]"
+	case spec.MsgBroadcast:
+		content = "[red::b]Broadcast:[-::-] " + content
+	case spec.MsgReply:
+		// The quote is always the first line
+		quote, text, ok := strings.Cut(content, "\n")
+		if ok {
+			content = "[gray]" + quote + "[-]\n" + text
+		}
+	}
+
+	// Only stored messages can be selected
+	region, end := "", ""
+	if msg.ID != 0 {
+		region, end = fmt.Sprintf("[\"%d\"]", msg.ID), "[\"\"]"
+	}
+
+	pin := ""
+	if msg.Pinned {
+		pin = "[yellow]" + t.symbol("📌") + "[-] "
+	}
+
+	// Labels can only contain safe characters
+	tags := ""
+	if msg.Tags != "" {
+		tags = " [darkcyan]#" + strings.ReplaceAll(msg.Tags, " ", " #") + "[-]"
+	}
+
 	f := msg.Timestamp.Format(format)
 	color := "[blue::b]"
+	if t.params.NickColors {
+		color = fmt.Sprintf("[%s::b]", nickColor(msg.Sender, t.params.NickPalette))
+	}
 	if msg.Sender == selfSender {
 		color = "[yellow::b]"
 	}
@@ -394,10 +717,11 @@ func (t *TUI) renderMsg(msg Message) {
 
 	_, err := fmt.Fprintf(
 		t.comp.text,
-		"[%s%s%s] at %s%07s%s: %s\n",
+		"%s%s[%s%s%s] at %s%07s%s: %s%s%s\n",
+		region, pin,
 		color, msg.Sender, "[-::-]",
 		"[gray::u]", f, "[-::-]",
-		content,
+		content, tags, end,
 	)
 
 	if err != nil {
@@ -408,6 +732,209 @@ func (t *TUI) renderMsg(msg Message) {
 	t.comp.text.ScrollToEnd()
 }
 
+// Picks the color of a sender from a palette by hashing
+// its name, so that it is always shown with the same one.
+func nickColor(name string, palette []string) string {
+	if len(palette) == 0 {
+		return "blue"
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return palette[hash.Sum32()%uint32(len(palette))]
+}
+
+/* SELECTION */
+
+// Moves the selected message of the current buffer by the given
+// offset, starting from the last message if none is selected.
+// Only messages stored in the database can be selected.
+func (t *TUI) selectMessage(offset int) {
+	s := t.Active()
+	msgs := s.Messages(t.Buffer())
+
+	ids := make([]uint, 0, len(msgs))
+	for _, v := range msgs {
+		if v.ID != 0 {
+			ids = append(ids, v.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	i := slices.Index(ids, t.status.selected)
+	if i == -1 {
+		i = len(ids)
+	}
+
+	i = max(0, min(len(ids)-1, i+offset))
+	t.status.selected = ids[i]
+	t.comp.text.
+		Highlight(strconv.FormatUint(uint64(ids[i]), 10)).
+		ScrollToHighlight()
+}
+
+// Selects a message of the current buffer and scrolls to it,
+// leaving the date rendered before it in view too.
+func (t *TUI) jumpToMessage(id uint) error {
+	tab := t.Active().Buffers().Current()
+	if tab == nil {
+		return ErrorMessageNotLoaded
+	}
+
+	_, ok := tab.messages.Find(func(m Message) bool {
+		return m.ID == id
+	})
+	if !ok {
+		return ErrorMessageNotLoaded
+	}
+
+	// The highlight is centered so the date above is shown
+	t.status.selected = id
+	t.comp.text.
+		Highlight(strconv.FormatUint(uint64(id), 10)).
+		ScrollToHighlight()
+	return nil
+}
+
+// Pins the selected message of the current buffer if it was
+// not pinned or unpins it otherwise, returning the new state.
+func (t *TUI) togglePin() (bool, error) {
+	tab := t.Active().Buffers().Current()
+	if tab == nil || t.status.selected == 0 {
+		return false, ErrorNoSelection
+	}
+
+	msg, ok := tab.messages.Find(func(m Message) bool {
+		return m.ID == t.status.selected
+	})
+	if !ok {
+		return false, ErrorNoSelection
+	}
+
+	err := db.SetPinned(t.db, msg.ID, !msg.Pinned)
+	if err != nil {
+		return false, err
+	}
+
+	pinned := msg
+	pinned.Pinned = !msg.Pinned
+	tab.messages.Replace(msg, pinned)
+
+	t.renderBuffer(tab.name)
+	t.comp.text.ScrollToHighlight()
+	return pinned.Pinned, nil
+}
+
+// Adds a label to the selected message of the current buffer
+// if it did not have it or removes it otherwise, returning
+// whether the message has the label afterwards.
+func (t *TUI) toggleTag(label string) (bool, error) {
+	tab := t.Active().Buffers().Current()
+	if tab == nil || t.status.selected == 0 {
+		return false, ErrorNoSelection
+	}
+
+	msg, ok := tab.messages.Find(func(m Message) bool {
+		return m.ID == t.status.selected
+	})
+	if !ok {
+		return false, ErrorNoSelection
+	}
+
+	tagged, err := db.ToggleMessageTag(t.db, msg.ID, label)
+	if err != nil {
+		return false, err
+	}
+
+	labels := strings.Fields(msg.Tags)
+	if tagged {
+		labels = append(labels, label)
+		slices.Sort(labels)
+	} else {
+		labels = slices.DeleteFunc(labels, func(s string) bool {
+			return s == label
+		})
+	}
+
+	updated := msg
+	updated.Tags = strings.Join(labels, " ")
+	tab.messages.Replace(msg, updated)
+
+	t.renderBuffer(tab.name)
+	t.comp.text.ScrollToHighlight()
+	return tagged, nil
+}
+
+/* REPLIES */
+
+// Starts a reply to the selected message of the current
+// buffer, showing a preview of it above the input.
+func (t *TUI) startReply() error {
+	tab := t.Active().Buffers().Current()
+	if tab == nil || t.status.selected == 0 {
+		return ErrorNoSelection
+	}
+
+	msg, ok := tab.messages.Find(func(m Message) bool {
+		return m.ID == t.status.selected
+	})
+	if !ok {
+		return ErrorNoSelection
+	}
+
+	t.status.replying = &msg
+	t.comp.reply.SetText(fmt.Sprintf(
+		" [gray]Replying to[-] [::b]%s[::-][gray]: %s (ESC to cancel)[-]",
+		tview.Escape(msg.Sender),
+		tview.Escape(replyExcerpt(msg.Content)),
+	))
+	t.area.bottom.ResizeItem(t.comp.reply, replySize, 0)
+	return nil
+}
+
+// Stops replying to a message and hides the preview.
+func (t *TUI) cancelReply() {
+	if t.status.replying == nil {
+		return
+	}
+
+	t.status.replying = nil
+	t.comp.reply.Clear()
+	t.area.bottom.ResizeItem(t.comp.reply, 0, 0)
+}
+
+// Prepends a quote of the message being replied to, as long
+// as it belongs to the current buffer of the active server,
+// and returns the type the message must be sent with.
+func (t *TUI) quoteReply(text string) (string, spec.MsgType) {
+	msg := t.status.replying
+	if msg == nil || msg.Buffer != t.Buffer() || msg.Source != t.Active().Name() {
+		return text, spec.MsgText
+	}
+
+	quote := fmt.Sprintf("> %s: %s\n%s", msg.Sender, replyExcerpt(msg.Content), text)
+	return quote, spec.MsgReply
+}
+
+// Returns the first line of a message, shortened so
+// that it fits in the reply preview.
+func replyExcerpt(content string) string {
+	line, _, cut := strings.Cut(content, "\n")
+
+	runes := []rune(line)
+	if len(runes) > replyPreview {
+		return string(runes[:replyPreview]) + "..."
+	}
+	if cut {
+		return line + "..."
+	}
+
+	return line
+}
+
 // Displays or hides the help window by also showing
 // or hiding the input.
 func (t *TUI) toggleHelp() {
@@ -418,7 +945,7 @@ func (t *TUI) toggleHelp() {
 		t.app.SetFocus(t.comp.help)
 	} else {
 		t.status.showingHelp = false
-		t.area.bottom.ResizeItem(t.comp.input, inputSize, 0)
+		renderInput(t)
 		t.comp.pages.SwitchToPage(textPage)
 		t.app.SetFocus(t.comp.input)
 	}
@@ -426,13 +953,217 @@ func (t *TUI) toggleHelp() {
 
 // Displays an error in the error bar temporarily.
 func (t *TUI) showError(err error) {
+	t.progress.mut.Lock()
+	t.progress.errors += 1
+	t.progress.mut.Unlock()
+
 	t.comp.errors.Clear()
 	t.area.bottom.ResizeItem(t.comp.errors, errorSize, 0)
 	fmt.Fprintf(t.comp.errors, " [red]Error: %s![-:-]", err)
 
 	go func() {
 		<-time.After(time.Duration(errorMessage) * time.Second)
-		t.comp.errors.Clear()
-		t.area.bottom.ResizeItem(t.comp.errors, 0, 0)
+
+		t.progress.mut.Lock()
+		defer t.progress.mut.Unlock()
+		t.progress.errors -= 1
+		if t.progress.errors == 0 {
+			t.comp.errors.Clear()
+			t.area.bottom.ResizeItem(t.comp.errors, 0, 0)
+		}
 	}()
 }
+
+/* PROGRESS */
+
+// Frames of the spinner shown for running commands
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// Registers a command as running, showing a spinner in the
+// error bar while it lasts. The returned function must be
+// called once the command finishes.
+func (t *TUI) startProgress(name string) func() {
+	t.progress.mut.Lock()
+	defer t.progress.mut.Unlock()
+
+	id := t.progress.next
+	t.progress.next += 1
+	t.progress.running = append(t.progress.running, inflight{
+		id:    id,
+		name:  name,
+		start: time.Now(),
+	})
+
+	// Only the first command starts the spinner
+	if len(t.progress.running) == 1 {
+		go t.spinProgress()
+	}
+
+	return func() {
+		t.progress.mut.Lock()
+		defer t.progress.mut.Unlock()
+		t.progress.running = slices.DeleteFunc(
+			t.progress.running,
+			func(v inflight) bool { return v.id == id },
+		)
+	}
+}
+
+// Describes what would be lost by quitting now, which are
+// the messages not confirmed yet and the running commands.
+func (t *TUI) pendingWork() []string {
+	var pending []string
+	if n := t.status.sending.Load(); n > 0 {
+		pending = append(pending, fmt.Sprintf("Messages still being sent: %d", n))
+	}
+
+	t.progress.mut.Lock()
+	defer t.progress.mut.Unlock()
+	if len(t.progress.running) > 0 {
+		names := make([]string, 0, len(t.progress.running))
+		for _, v := range t.progress.running {
+			names = append(names, "/"+v.name)
+		}
+		pending = append(pending, "Commands running: "+strings.Join(names, ", "))
+	}
+
+	return pending
+}
+
+// Renders the spinner periodically until no commands are running.
+// Errors take priority over the spinner and it is not shown while
+// a password is being typed, as the command is not running yet.
+func (t *TUI) spinProgress() {
+	ticker := time.NewTicker(time.Duration(spinnerRate) * time.Millisecond)
+	defer ticker.Stop()
+
+	shown := false
+	frame := 0
+	for range ticker.C {
+		t.progress.mut.Lock()
+
+		if len(t.progress.running) == 0 {
+			if shown && t.progress.errors == 0 {
+				t.comp.errors.Clear()
+				t.area.bottom.ResizeItem(t.comp.errors, 0, 0)
+			}
+			t.progress.mut.Unlock()
+			return
+		}
+
+		// The command timeout starts after the password is typed
+		if t.status.typingPassword {
+			for i := range t.progress.running {
+				t.progress.running[i].start = time.Now()
+			}
+		}
+
+		oldest := t.progress.running[0]
+		elapsed := time.Since(oldest.start)
+		delay := time.Duration(spinnerDelay) * time.Millisecond
+		if t.progress.errors != 0 || elapsed < delay {
+			t.progress.mut.Unlock()
+			continue
+		}
+
+		var status string
+		left := time.Duration(cmdTimeout)*time.Second - elapsed
+		if left > 0 {
+			status = fmt.Sprintf("%ds left", int(left.Seconds())+1)
+		} else {
+			status = "timing out"
+		}
+
+		var extra string
+		if l := len(t.progress.running); l > 1 {
+			extra = fmt.Sprintf(" (+%d more)", l-1)
+		}
+
+		t.comp.errors.Clear()
+		t.area.bottom.ResizeItem(t.comp.errors, errorSize, 0)
+		fmt.Fprintf(
+			t.comp.errors,
+			" [yellow]%s[-] Running [green]/%s[-]%s: %s...",
+			spinnerFrames[frame%len(spinnerFrames)],
+			oldest.name, extra, status,
+		)
+		shown = true
+		frame += 1
+
+		t.progress.mut.Unlock()
+	}
+}
+
+/* DRAFTS */
+
+// Saves the input as the draft of the current buffer after
+// a short delay, so that typing does not save it every time.
+func (t *TUI) scheduleDraft() {
+	t.cancelDraft()
+
+	s := t.Active()
+	buf := t.Buffer()
+	text := t.comp.input.GetText()
+	t.status.drafting = time.AfterFunc(
+		time.Duration(draftDelay)*time.Millisecond,
+		func() { t.saveDraft(s, buf, text) },
+	)
+}
+
+// Saves the input as a draft right away if it was going to
+// be saved, so that it is not lost when quitting.
+func (t *TUI) keepDraft() {
+	if t.status.drafting != nil && t.status.drafting.Stop() {
+		t.saveDraft(t.Active(), t.Buffer(), t.comp.input.GetText())
+	}
+}
+
+// Stops the pending save of the input, if any.
+func (t *TUI) cancelDraft() {
+	if t.status.drafting != nil {
+		t.status.drafting.Stop()
+	}
+}
+
+// Stores the text as the draft of a buffer of a remote server.
+// The draft is removed instead if there is no text to keep.
+func (t *TUI) saveDraft(s Server, buf string, text string) {
+	data, _ := s.Online()
+	if data == nil || data.Server == nil {
+		return
+	}
+
+	tab, ok := s.Buffers().tabs.Get(buf)
+	if !ok || tab.system {
+		return
+	}
+
+	addr := data.Server.Address
+	port := data.Server.Port
+	if strings.TrimSpace(text) == "" || text[0] == '/' {
+		db.DeleteDraft(t.db, buf, addr, port)
+		return
+	}
+
+	db.SaveDraft(t.db, buf, text, addr, port)
+}
+
+// Puts the draft pending to be restored in the input if its
+// buffer is the current one and nothing is being typed.
+func (t *TUI) restoreDraft() {
+	draft := t.status.draft
+	if draft == nil {
+		return
+	}
+
+	if t.Active().Name() != draft.Server.Name || t.Buffer() != draft.Buffer {
+		return
+	}
+
+	if t.comp.input.GetText() != "" {
+		return
+	}
+
+	t.status.draft = nil
+	t.comp.input.SetText(draft.Text, true)
+}
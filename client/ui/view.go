@@ -1,12 +1,17 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	cmds "github.com/Sprinter05/gochat/client/commands"
 	"github.com/Sprinter05/gochat/client/db"
@@ -24,27 +29,77 @@ type userlistUser struct {
 	perms uint   // Permission level of the user
 }
 
+// Command that is currently running
+type inflight struct {
+	id    uint      // Identifier of the command
+	name  string    // Name of the command
+	start time.Time // When the command started
+}
+
+// Tracks the commands that are running to
+// show their progress in the error bar.
+type progress struct {
+	mut     sync.Mutex // Protects the fields
+	running []inflight // Commands currently running
+	next    uint       // Identifier of the next command
+	errors  uint       // Errors currently being shown
+}
+
+// Counts the long running goroutines started for each
+// server, which helps finding the ones that are leaked.
+type routines struct {
+	mut   sync.Mutex          // Protects the map
+	count map[routineKey]uint // Goroutines currently running
+}
+
+// Pending reconnection to a server, the
+// counters are updated by the goroutine retrying.
+type retry struct {
+	cancel  context.CancelFunc // Stops the reconnection attempts
+	attempt atomic.Uint32      // Current attempt
+	left    atomic.Uint32      // Seconds until the current attempt
+}
+
+// Identifies a kind of goroutine of a server
+type routineKey struct {
+	server string
+	kind   string
+}
+
 // Identifies conditions that may in any moment
 // block another action from being performed, or
 // gives instructions on how to render another element.
 type state struct {
 	showingUsers bool // Showing user list component
 	showingBufs  bool // Showing buffer list component
+	swapped      bool // Buffer list is currently on the right
 
 	creatingBuf        bool // Creating a new buffer
 	creatingServer     bool // Creating a new server
 	typingPassword     bool // Inputting a password
 	showingHelp        bool // Showing the help window
 	showingQuickswitch bool // Showing the quickswitch input
+	showingInbox       bool // Showing the inbox window
+	showingQuick       bool // Showing the quick commands window
 
 	deletingServer bool // Currently choosing to delete server
 	deletingBuffer bool // Currently choosing to delete buffer
+	restoringDraft bool // Currently choosing to restore a draft
+	confirmingQuit bool // Currently choosing to quit
 
 	userlist      models.Slice[userlistUser] // Used for displaying users in the user bar
 	serverIndexes []int                      // Used to track deleted elements
 
+	reordering atomic.Bool  // Whether the buffer list will be reordered soon
+	sending    atomic.Int32 // Messages waiting for the server to confirm them
+
 	lastDate time.Time // Last rendered date in the current buffer
 	lastMsg  time.Time // last message sent
+	selected uint      // Database identifier of the selected message
+	replying *Message  // Message being replied to, nil if none
+
+	drafting *time.Timer // Saves the input as a draft after a delay
+	draft    *db.Draft   // Draft to restore once its buffer is opened
 }
 
 // Used to change size of a specific component
@@ -53,13 +108,87 @@ type ComponentSize struct {
 	Relative bool // Specifies whether its relative to the other components
 }
 
+// Used to display a permission level, the
+// color must be a valid tview color name
+type PermissionStyle struct {
+	Color  string `json:"color"`  // Color of the level, default if empty
+	Symbol string `json:"symbol"` // Shown before the level
+}
+
+// Specifies how long messages are kept in the local
+// database, a value of 0 days keeps them forever.
+type RetentionPolicy struct {
+	Days     uint            `json:"days"`           // Days to keep messages for
+	Servers  map[string]uint `json:"servers"`        // Days to keep messages for by server name
+	Interval uint            `json:"interval_hours"` // Hours between purges, 24 if 0
+}
+
+// Returns the amount of days messages are kept
+// in a server, giving priority to its own policy.
+func (r RetentionPolicy) days(server string) uint {
+	if v, ok := r.Servers[server]; ok {
+		return v
+	}
+
+	return r.Days
+}
+
+// Returns whether any message will ever be purged.
+func (r RetentionPolicy) enabled() bool {
+	if r.Days != 0 {
+		return true
+	}
+
+	for _, v := range r.Servers {
+		if v != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Used to modify the sizes of the components
 // in the TUI for its configuration.
 // Must be exported for external modification
 type Parameters struct {
-	Buflist  ComponentSize // Size of left bar
-	Userlist ComponentSize // Size of right bar
-	Verbose  bool          // Whether to print verbose or not
+	Buflist      ComponentSize     // Size of the buffer list
+	Userlist     ComponentSize     // Size of the user list
+	Input        ComponentSize     // Size of the input, relative to the messages if relative
+	Swapped      bool              // Whether the buffer list is on the right and the user list on the left
+	HideBuflist  bool              // Whether the buffer list is never shown
+	HideUserlist bool              // Whether the user list is never shown
+	Verbose      bool              // Whether to print verbose or not
+	Permissions  []PermissionStyle // Style of each permission level by index
+	Reconnect    bool              // Whether to connect again after a transient disconnection
+	BufferSort   string            // Order of the buffer list, either by creation or by activity
+	NameLength   uint              // Maximum characters of a name in the lists, 0 for no limit
+	Unicode      bool              // Whether symbols and borders use Unicode or only ASCII
+	SelfCopy     bool              // Whether sent messages are also copied for the other devices
+	HistorySize  uint              // Maximum commands kept in the history, 0 for no limit
+	HistoryDedup string            // How repeated commands are kept in the history
+	NickColors   bool              // Whether each sender is shown with its own color
+	NickPalette  []string          // Colors given to senders, picked by their name
+	ConfirmQuit  bool              // Whether to ask before quitting if something would be lost
+}
+
+// Option of the configuration file, its value
+// is encoded as JSON unless it has been redacted.
+type ConfigEntry struct {
+	Path  string // Path of the option separated by dots
+	Value string // Value currently in use
+	Live  bool   // Whether changes apply without restarting
+}
+
+// Configuration file that can be shown and edited
+// from the TUI while it is running.
+type ConfigFile interface {
+	// Returns the options currently in use.
+	Entries() ([]ConfigEntry, error)
+
+	// Changes an option in the file, returning
+	// whether it has already been applied.
+	Set(path string, value string) (bool, error)
 }
 
 // Identifies the main TUI with all its
@@ -76,18 +205,261 @@ type TUI struct {
 	history models.Slice[string] // Stores previously ran commands
 	next    uint                 // Last history
 
+	progress progress                     // Commands currently running
+	routines routines                     // Goroutines currently running
+	retries  models.Table[string, *retry] // Pending reconnections by server
+
 	servers models.Table[string, Server] // Table storing servers
 	focus   string                       // Currently active server
+
+	config ConfigFile // Configuration file, nil if it cannot be edited
+}
+
+// Runs a function in a new goroutine that is
+// tracked as a given kind for the given server.
+func (t *TUI) spawn(server string, kind string, fun func()) {
+	key := routineKey{server, kind}
+
+	t.routines.mut.Lock()
+	if t.routines.count == nil {
+		t.routines.count = make(map[routineKey]uint)
+	}
+	t.routines.count[key] += 1
+	t.routines.mut.Unlock()
+
+	go func() {
+		defer func() {
+			t.routines.mut.Lock()
+			defer t.routines.mut.Unlock()
+
+			t.routines.count[key] -= 1
+			if t.routines.count[key] == 0 {
+				delete(t.routines.count, key)
+			}
+		}()
+
+		fun()
+	}()
+}
+
+// Returns how many tracked goroutines of a server are running
+func (t *TUI) serverRoutines(server string) uint {
+	t.routines.mut.Lock()
+	defer t.routines.mut.Unlock()
+
+	var count uint
+	for k, v := range t.routines.count {
+		if k.server == server {
+			count += v
+		}
+	}
+
+	return count
 }
 
 // Returns a static data for use on a command
 func (t *TUI) static() *cmds.StaticData {
 	return &cmds.StaticData{
-		DB:      t.db,
-		Verbose: t.params.Verbose,
+		DB:       t.db,
+		Verbose:  t.params.Verbose,
+		SelfCopy: t.params.SelfCopy,
+	}
+}
+
+// Replaces the styles used for each permission level,
+// the default ones are kept if none are given.
+func (t *TUI) SetPermissionStyles(styles []PermissionStyle) {
+	if len(styles) == 0 {
+		return
+	}
+
+	t.params.Permissions = styles
+}
+
+// Changes the order of the buffer list, which
+// must be either by creation or by activity.
+func (t *TUI) SetBufferSort(order string) {
+	if order != sortCreation && order != sortActivity {
+		return
+	}
+
+	t.params.BufferSort = order
+}
+
+// Saves the quick commands given for each server by its
+// name. Servers that do not exist or that already have
+// quick commands stored are ignored.
+func (t *TUI) SetQuickCommands(list map[string]map[string]string) {
+	for name, commands := range list {
+		serv, err := db.GetServerByName(t.db, name)
+		if err != nil {
+			continue
+		}
+
+		stored, err := db.GetQuickCommands(t.db, serv.Address, serv.Port)
+		if err != nil || len(stored) > 0 {
+			continue
+		}
+
+		for k, v := range commands {
+			db.SetQuickCommand(
+				t.db, k, strings.TrimPrefix(v, "/"),
+				serv.Address, serv.Port,
+			)
+		}
 	}
 }
 
+// Changes whether verbose output is shown, which
+// applies to every command run from then on.
+func (t *TUI) SetVerbose(verbose bool) {
+	t.params.Verbose = verbose
+}
+
+// Allows showing and editing the configuration
+// file with the config command.
+func (t *TUI) SetConfigFile(file ConfigFile) {
+	t.config = file
+}
+
+// Changes the maximum amount of characters of a name
+// shown in the lists, 0 meaning they are never truncated.
+func (t *TUI) SetNameLength(length uint) {
+	t.params.NameLength = length
+}
+
+// Changes whether each sender is shown with its own
+// color instead of the same one for all of them.
+func (t *TUI) SetNickColors(enabled bool) {
+	t.params.NickColors = enabled
+}
+
+// Changes whether quitting asks for confirmation when
+// messages are being sent or commands are running.
+func (t *TUI) SetConfirmQuit(enabled bool) {
+	t.params.ConfirmQuit = enabled
+}
+
+// Replaces the colors given to senders, ignoring the
+// unknown ones. The default ones are kept if none are valid.
+func (t *TUI) SetNickPalette(colors []string) {
+	palette := make([]string, 0, len(colors))
+	for _, v := range colors {
+		if tcell.GetColor(v) != tcell.ColorDefault {
+			palette = append(palette, v)
+		}
+	}
+
+	if len(palette) == 0 {
+		return
+	}
+
+	t.params.NickPalette = palette
+}
+
+// Changes the maximum amount of commands kept in
+// the history, 0 meaning it is never trimmed.
+func (t *TUI) SetHistorySize(size uint) {
+	t.params.HistorySize = size
+	t.history.KeepLast(size)
+}
+
+// Changes how repeated commands are kept in the history,
+// which must be either none, consecutive or front.
+func (t *TUI) SetHistoryDedup(policy string) {
+	if policy != dedupNone && policy != dedupConsecutive && policy != dedupFront {
+		return
+	}
+
+	t.params.HistoryDedup = policy
+}
+
+// Changes whether symbols and borders are drawn with
+// Unicode characters or replaced by ASCII ones.
+func (t *TUI) SetUnicode(unicode bool) {
+	t.params.Unicode = unicode
+	applyBorders(unicode)
+}
+
+// Returns a symbol as it should be shown,
+// depending on whether Unicode is enabled.
+func (t *TUI) symbol(s string) string {
+	return symbol(s, t.params.Unicode)
+}
+
+// Returns the ASCII replacement of a symbol if
+// Unicode is disabled and there is one.
+func symbol(s string, unicode bool) string {
+	if unicode {
+		return s
+	}
+
+	if v, ok := asciiSymbols[s]; ok {
+		return v
+	}
+	return s
+}
+
+// Changes the borders drawn by all components,
+// which are global to every tview primitive.
+func applyBorders(unicode bool) {
+	if unicode {
+		tview.Borders = unicodeBorders
+		return
+	}
+
+	b := unicodeBorders
+	b.Horizontal, b.HorizontalFocus = '-', '='
+	b.Vertical, b.VerticalFocus = '|', '|'
+	b.TopLeft, b.TopRight, b.BottomLeft, b.BottomRight = '+', '+', '+', '+'
+	b.TopLeftFocus, b.TopRightFocus, b.BottomLeftFocus, b.BottomRightFocus = '+', '+', '+', '+'
+	b.LeftT, b.RightT, b.TopT, b.BottomT, b.Cross = '+', '+', '+', '+', '+'
+	tview.Borders = b
+}
+
+// Guesses whether the terminal can render Unicode
+// from the locale and the type of terminal.
+func detectUnicode() bool {
+	switch os.Getenv("TERM") {
+	case "linux", "dumb", "vt100", "vt220":
+		return false
+	}
+
+	locale := os.Getenv("LC_ALL")
+	if locale == "" {
+		locale = os.Getenv("LC_CTYPE")
+	}
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+
+	locale = strings.ToLower(locale)
+	return strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8")
+}
+
+// Returns a name as shown in the lists, truncating it with an
+// ellipsis if it is longer than allowed. It never cuts a name in
+// the middle of a character, even if it is multibyte.
+func (t *TUI) shortName(name string) string {
+	return truncateName(name, t.params.NameLength, t.symbol(nameEllipsis))
+}
+
+// Truncates a name to the given amount of characters, including
+// the ellipsis, unless the maximum is 0.
+func truncateName(name string, max uint, ellipsis string) string {
+	if max == 0 || uint(utf8.RuneCountInString(name)) <= max {
+		return name
+	}
+
+	runes := []rune(name)
+	size := uint(utf8.RuneCountInString(ellipsis))
+	if max <= size {
+		return string(runes[:max])
+	}
+
+	return string(runes[:max-size]) + ellipsis
+}
+
 // Condition that prevents another operation from being performed
 // depending on the state of the TUI.
 func (s *state) blockCond() bool {
@@ -97,13 +469,36 @@ func (s *state) blockCond() bool {
 		s.typingPassword ||
 		s.deletingServer ||
 		s.deletingBuffer ||
-		s.showingQuickswitch
+		s.restoringDraft ||
+		s.confirmingQuit ||
+		s.showingQuickswitch ||
+		s.showingInbox ||
+		s.showingQuick
 }
 
 /* USERLIST */
 
+// Formats a permission level with the style given for it,
+// levels without a style use the default color.
+func permissionTag(perms uint, params Parameters) string {
+	var style PermissionStyle
+	if perms < uint(len(params.Permissions)) {
+		style = params.Permissions[perms]
+	}
+
+	color := style.Color
+	if color == "" {
+		color = "-"
+	}
+
+	return fmt.Sprintf(
+		"[%s::b]%s%d[-::-]",
+		color, tview.Escape(symbol(style.Symbol, params.Unicode)), perms,
+	)
+}
+
 // Renders the userlist of whatever is saved as the current state
-func (s *state) userlistRender() string {
+func (s *state) userlistRender(params Parameters) string {
 	var list strings.Builder
 
 	if s.userlist.Len() == 0 {
@@ -124,8 +519,9 @@ func (s *state) userlistRender() string {
 
 	for _, v := range copy {
 		str := fmt.Sprintf(
-			"[[purple::i]%d[-::-]] %s\n",
-			v.perms, v.name,
+			"%s %s\n",
+			permissionTag(v.perms, params),
+			tview.Escape(truncateName(v.name, params.NameLength, symbol(nameEllipsis, params.Unicode))),
 		)
 		list.WriteString(str)
 	}
@@ -189,7 +585,7 @@ func createPopup(t *TUI, cond *bool, title string) (*tview.InputField, func()) {
 
 	exit := func() {
 		t.area.bottom.RemoveItem(input)
-		t.area.bottom.ResizeItem(t.comp.input, inputSize, 0)
+		renderInput(t)
 		t.app.SetFocus(t.comp.input)
 		t.app.EnableMouse(true)
 		*cond = false
@@ -434,6 +830,40 @@ func createConfirmWindow(t *TUI, cond *bool, title string) (*tview.Modal, func()
 	return window, exit
 }
 
+// Confirmation window to quit the TUI while messages are being
+// sent or commands are running. Quitting again while it is shown
+// does not wait for an answer, in case a command never finishes.
+func quitWindow(t *TUI) {
+	if t.status.confirmingQuit {
+		t.app.Stop()
+		return
+	}
+
+	// Other windows would be left behind the confirmation
+	pending := t.pendingWork()
+	if !t.params.ConfirmQuit || len(pending) == 0 || t.status.blockCond() {
+		t.keepDraft()
+		t.app.Stop()
+		return
+	}
+
+	window, exit := createConfirmWindow(t,
+		&t.status.confirmingQuit,
+		fmt.Sprintf(
+			"Do you want to quit anyway?\n%s\nPress Ctrl-Q again to quit right away.",
+			strings.Join(pending, "\n"),
+		),
+	)
+
+	window.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+		exit()
+		if buttonLabel == "Yes" {
+			t.keepDraft()
+			t.app.Stop()
+		}
+	})
+}
+
 // Confirmation window to delete a server from the TUI
 // and also from the database.
 func deleteServWindow(t *TUI) {
@@ -474,11 +904,240 @@ func deleteBufWindow(t *TUI) {
 	})
 }
 
+// Confirmation window to restore the last message that was
+// being typed before the client exited. The message is put in
+// the input once its buffer is opened.
+func offerDraftWindow(t *TUI) {
+	draft, ok, err := db.GetLatestDraft(t.db)
+	if err != nil || !ok {
+		return
+	}
+
+	// Older drafts are no longer relevant
+	db.ClearDrafts(t.db)
+
+	window, exit := createConfirmWindow(t,
+		&t.status.restoringDraft,
+		fmt.Sprintf(
+			"Do you want to restore the\nunsent message to %s\nin %s?",
+			draft.Buffer, draft.Server.Name,
+		),
+	)
+
+	window.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+		exit()
+		if buttonLabel != "Yes" {
+			return
+		}
+
+		// Kept in case the client exits again
+		db.SaveDraft(
+			t.db, draft.Buffer, draft.Text,
+			draft.Server.Address, draft.Server.Port,
+		)
+		t.status.draft = &draft
+
+		if i, ok := t.findServer(draft.Server.Name); ok {
+			t.changeServer(i)
+		}
+
+		print := t.systemMessage()
+		print(fmt.Sprintf(
+			"The unsent message will be restored when the buffer of %s is opened",
+			draft.Buffer,
+		), cmds.INFO)
+		t.restoreDraft()
+	})
+}
+
+/* SELECTION WINDOWS */
+
+// Window that lists the conversations of the logged in user
+// with their unread messages and last activity. Selecting one
+// changes to its buffer, creating it if it is not open.
+func inboxWindow(t *TUI, s Server, list []db.ConversationSummary) {
+	t.status.showingInbox = true
+
+	window := tview.NewList().
+		SetSelectedTextColor(tcell.ColorPurple).
+		SetSecondaryTextColor(tcell.ColorGray)
+	window.SetBackgroundColor(tcell.ColorDefault).
+		SetBorder(true).
+		SetTitle("Inbox")
+
+	for _, v := range list {
+		main := tview.Escape(v.Username)
+		if unread := s.Notifications().Query(v.Username); unread > 0 {
+			main += fmt.Sprintf(" [orange](%d unread)[-]", unread)
+		}
+
+		secondary := "No messages yet"
+		if v.Messages > 0 {
+			secondary = fmt.Sprintf(
+				"%d messages, last on %s",
+				v.Messages, v.Last.Local().Format(time.DateTime),
+			)
+		}
+
+		window.AddItem(main, secondary, 0, nil)
+	}
+
+	t.area.main.AddItem(window, 0, 0, true)
+	t.app.SetFocus(window)
+	t.app.EnableMouse(false)
+
+	exit := func() {
+		t.area.main.RemoveItem(window)
+		t.app.SetFocus(t.comp.input)
+		t.app.EnableMouse(true)
+		t.status.showingInbox = false
+	}
+
+	window.SetDoneFunc(exit)
+	window.SetSelectedFunc(func(i int, _ string, _ string, _ rune) {
+		exit()
+
+		name := list[i].Username
+		if j, ok := t.findBuffer(name); ok {
+			t.changeBuffer(j)
+			return
+		}
+
+		t.addBuffer(name, false)
+	})
+}
+
+// Opens the list of quick commands saved for a server.
+func (t *TUI) openQuickCommands(s Server) error {
+	data, _ := s.Online()
+	if data == nil {
+		return ErrorLocalServer
+	}
+
+	list, err := db.GetQuickCommands(
+		t.db,
+		data.Server.Address,
+		data.Server.Port,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		return ErrorNoQuickCommands
+	}
+
+	quickCommandsWindow(t, list)
+	return nil
+}
+
+// Window that lists the quick commands of a server.
+// Selecting one runs it as if it had been typed.
+func quickCommandsWindow(t *TUI, list []db.QuickCommand) {
+	t.status.showingQuick = true
+
+	window := tview.NewList().
+		SetSelectedTextColor(tcell.ColorPurple).
+		SetSecondaryTextColor(tcell.ColorGray)
+	window.SetBackgroundColor(tcell.ColorDefault).
+		SetBorder(true).
+		SetTitle("Quick commands")
+
+	for _, v := range list {
+		window.AddItem(tview.Escape(v.Name), tview.Escape("/"+v.Command), 0, nil)
+	}
+
+	t.area.main.AddItem(window, 0, 0, true)
+	t.app.SetFocus(window)
+	t.app.EnableMouse(false)
+
+	exit := func() {
+		t.area.main.RemoveItem(window)
+		t.app.SetFocus(t.comp.input)
+		t.app.EnableMouse(true)
+		t.status.showingQuick = false
+	}
+
+	window.SetDoneFunc(exit)
+	window.SetSelectedFunc(func(i int, _ string, _ string, _ rune) {
+		exit()
+		t.parseCommand(list[i].Command)
+	})
+}
+
 /* BARS */
 
+// Returns the buffers of a server in the given order. Buffers are
+// sorted by creation unless the order is by activity, in which case
+// the most recently active ones go first.
+func sortTabs(b *Buffers, order string) []*tab {
+	tabs := b.tabs.GetAll()
+	slices.SortFunc(tabs, func(x, y *tab) int {
+		if order == sortActivity {
+			if c := y.activity.Compare(x.activity); c != 0 {
+				return c
+			}
+		}
+
+		if x.creation < y.creation {
+			return -1
+		} else if x.creation > y.creation {
+			return 1
+		}
+
+		return 0 // Equal
+	})
+
+	return tabs
+}
+
+// Applies every layout parameter, placing the lists on
+// the configured side and resizing all components. The
+// input is left as is while it is replaced by a popup.
+func renderLayout(t *TUI) {
+	if t.status.swapped != t.params.Swapped {
+		var first, last tview.Primitive = t.area.left, t.comp.users
+		if t.params.Swapped {
+			first, last = last, first
+		}
+
+		// The sizes are restored right after
+		t.area.main.RemoveItem(t.area.left)
+		t.area.main.RemoveItem(t.comp.users)
+		t.area.main.RemoveItem(t.area.bottom)
+		t.area.main.
+			AddItem(first, 0, 0, false).
+			AddItem(t.area.bottom, 0, 6, true).
+			AddItem(last, 0, 0, false)
+		t.status.swapped = t.params.Swapped
+	}
+
+	renderBuflist(t)
+	renderUserlist(t)
+
+	if !t.status.blockCond() {
+		renderInput(t)
+	}
+}
+
+// Renders the input depending on the size and mode,
+// using the default size if none is set.
+func renderInput(t *TUI) {
+	size := t.params.Input
+	if size.Size == 0 {
+		size = ComponentSize{Size: uint(inputSize)}
+	}
+
+	if size.Relative {
+		t.area.bottom.ResizeItem(t.comp.input, 0, int(size.Size))
+	} else {
+		t.area.bottom.ResizeItem(t.comp.input, int(size.Size), 0)
+	}
+}
+
 // Renders the bufferlist depending on the size and mode
 func renderBuflist(t *TUI) {
-	if t.status.showingBufs {
+	if t.status.showingBufs && !t.params.HideBuflist {
 		if t.params.Buflist.Relative {
 			t.area.main.ResizeItem(
 				t.area.left,
@@ -500,7 +1159,7 @@ func renderBuflist(t *TUI) {
 
 // Renders the userlist depending on the size and mode
 func renderUserlist(t *TUI) {
-	if t.status.showingUsers {
+	if t.status.showingUsers && !t.params.HideUserlist {
 		if t.params.Userlist.Relative {
 			t.area.main.ResizeItem(
 				t.comp.users,
@@ -560,6 +1219,12 @@ func updateServers(t *TUI) {
 			if t.focus == v {
 				t.focus = name
 			}
+
+			// Pending reconnections are found by name
+			if r, ok := t.retries.Get(v); ok {
+				t.retries.Remove(v)
+				t.retries.Add(name, r)
+			}
 		}
 
 		// Get the TUI object
@@ -579,14 +1244,15 @@ func updateServers(t *TUI) {
 	}
 }
 
-// Updates the list of online users when connected to a server
-func updateOnlineUsers(t *TUI, s Server, output cmds.OutputFunc) {
+// Updates the list of online users when connected to a server,
+// the error is both printed and returned.
+func updateOnlineUsers(t *TUI, s Server, output cmds.OutputFunc) error {
 	data, ok := s.Online()
 	t.status.userlist.Clear()
 
 	if data == nil || !ok {
 		t.comp.users.SetText(defaultUserlist)
-		return
+		return nil
 	}
 
 	cmd := cmds.Command{
@@ -599,9 +1265,14 @@ func updateOnlineUsers(t *TUI, s Server, output cmds.OutputFunc) {
 	defer data.Waitlist.Cancel(cancel)
 	reply, err := cmds.USRS(ctx, cmd, cmds.ONLINEPERMS)
 
+	if errors.Is(err, cmds.ErrorRestrictedListing) {
+		t.comp.users.SetText(restrictedUserlist)
+		return nil
+	}
+
 	if err != nil {
 		output(err.Error(), cmds.ERROR)
-		return
+		return err
 	}
 
 	for _, v := range reply {
@@ -613,5 +1284,6 @@ func updateOnlineUsers(t *TUI, s Server, output cmds.OutputFunc) {
 		t.status.userlistChange(name, uint(val))
 	}
 
-	t.comp.users.SetText(t.status.userlistRender())
+	t.comp.users.SetText(t.status.userlistRender(t.params))
+	return nil
 }
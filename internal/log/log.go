@@ -6,6 +6,8 @@ package log
 import (
 	"log"
 	"net"
+	"runtime/debug"
+	"sync/atomic"
 
 	"github.com/Sprinter05/gochat/internal/spec"
 )
@@ -16,10 +18,11 @@ import (
 // that is not standard output or using another variable.
 type Logging uint
 
-// Global variable that represents the level.
+// Global level, which can be changed while other
+// goroutines are logging so it is accessed atomically.
 // This allows use between packages.
 // Default level is FATAL.
-var Level Logging = FATAL
+var level atomic.Uint32
 
 const (
 	FATAL Logging = iota // [X] Logs only when it crashes the program
@@ -28,6 +31,16 @@ const (
 	ALL                  // [-] Logs every single packet
 )
 
+// Changes the global log level.
+func SetLevel(lv Logging) {
+	level.Store(uint32(lv))
+}
+
+// Returns the global log level.
+func Level() Logging {
+	return Logging(level.Load())
+}
+
 // Logs in any level [*]
 //
 // Notifies any generic server message.
@@ -42,7 +55,7 @@ func Notice(msg string) {
 //
 // Informs of a missing configuration option.
 func Config(opt string) {
-	if Level < FATAL {
+	if Level() < FATAL {
 		return
 	}
 	log.Fatalf(
@@ -55,7 +68,7 @@ func Config(opt string) {
 //
 // Generic fatal error.
 func Fatal(msg string, err error) {
-	if Level < FATAL {
+	if Level() < FATAL {
 		return
 	}
 	log.Fatalf(
@@ -69,7 +82,7 @@ func Fatal(msg string, err error) {
 //
 // Consistency error on the database.
 func DBFatal(data string, user string, err error) {
-	if Level < FATAL {
+	if Level() < FATAL {
 		return
 	}
 	log.Fatalf(
@@ -84,7 +97,7 @@ func DBFatal(data string, user string, err error) {
 //
 // Generic error.
 func Error(msg string, err error) {
-	if Level < ERROR {
+	if Level() < ERROR {
 		return
 	}
 	log.Printf(
@@ -98,7 +111,7 @@ func Error(msg string, err error) {
 //
 // Notifies an error on a connection from an IP.
 func IP(msg string, ip net.Addr) {
-	if Level < ERROR {
+	if Level() < ERROR {
 		return
 	}
 	log.Printf(
@@ -108,11 +121,28 @@ func IP(msg string, ip net.Addr) {
 	)
 }
 
+// Requires ERROR or higher
+//
+// Recovered panic while running an operation,
+// including the stack trace of the goroutine.
+func Panic(op string, ip string, reason any) {
+	if Level() < ERROR {
+		return
+	}
+	log.Printf(
+		"[E] Recovered from panic running %s from %s due to %v\n%s\n",
+		op,
+		ip,
+		reason,
+		debug.Stack(),
+	)
+}
+
 // Requires ERROR or higher
 //
 // Internal database problem.
 func DBError(err error) {
-	if Level < ERROR {
+	if Level() < ERROR {
 		return
 	}
 	log.Printf(
@@ -125,7 +155,7 @@ func DBError(err error) {
 //
 // Problem running a SQL statement.
 func DB(data string, err error) {
-	if Level < ERROR {
+	if Level() < ERROR {
 		return
 	}
 	log.Printf(
@@ -139,7 +169,7 @@ func DB(data string, err error) {
 //
 // Problem when creating packet.
 func Packet(op spec.Action, err error) {
-	if Level < ERROR {
+	if Level() < ERROR {
 		return
 	}
 	log.Printf(
@@ -153,7 +183,7 @@ func Packet(op spec.Action, err error) {
 //
 // Timeout of an operation.
 func Timeout(user string, msg string) {
-	if Level < INFO {
+	if Level() < INFO {
 		return
 	}
 	log.Printf(
@@ -163,11 +193,39 @@ func Timeout(user string, msg string) {
 	)
 }
 
+// Requires INFO or higher
+//
+// Connection refused from an address.
+func Refused(ip string, reason string) {
+	if Level() < INFO {
+		return
+	}
+	log.Printf(
+		"[I] Refused connection from %s due to %s\n",
+		ip,
+		reason,
+	)
+}
+
+// Requires INFO or higher
+//
+// Successful login of a user and where it comes from.
+func Login(user string, origin string) {
+	if Level() < INFO {
+		return
+	}
+	log.Printf(
+		"[I] User %s logged in from %s\n",
+		user,
+		origin,
+	)
+}
+
 // Requires INFO or higher
 //
 // Error with data related to a user.
 func User(user string, data string, err error) {
-	if Level < INFO {
+	if Level() < INFO {
 		return
 	}
 	log.Printf(
@@ -182,7 +240,7 @@ func User(user string, data string, err error) {
 //
 // Problem when reading from a socket.
 func Read(subj string, ip string, err error) {
-	if Level < INFO {
+	if Level() < INFO {
 		return
 	}
 	log.Printf(
@@ -197,7 +255,7 @@ func Read(subj string, ip string, err error) {
 //
 // Invalid operation trying to be performed.
 func Invalid(op string, user string) {
-	if Level < INFO {
+	if Level() < INFO {
 		return
 	}
 	log.Printf(
@@ -211,7 +269,7 @@ func Invalid(op string, user string) {
 //
 // Prints a new connection.
 func Connection(ip string, closed bool) {
-	if Level < ALL {
+	if Level() < ALL {
 		return
 	}
 	if closed {
@@ -227,11 +285,26 @@ func Connection(ip string, closed bool) {
 	}
 }
 
+// Requires ALL
+//
+// Prints the bytes that went through a closed connection.
+func Traffic(ip string, read uint64, written uint64) {
+	if Level() < ALL {
+		return
+	}
+	log.Printf(
+		"[-] Connection from %s read %d bytes and wrote %d bytes",
+		ip,
+		read,
+		written,
+	)
+}
+
 // Requires ALL
 //
 // Prints packet information.
 func Request(ip string, cmd spec.Command) {
-	if Level < ALL {
+	if Level() < ALL {
 		return
 	}
 	log.Printf(
@@ -86,6 +86,34 @@ func (s *Slice[T]) Find(find func(T) bool) (T, bool) {
 	return s.data[i], true
 }
 
+// Replaces the first element equal to the old one
+// with the new one, returning whether it was found.
+func (s *Slice[T]) Replace(old T, new T) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	i := slices.Index(s.data, old)
+	if i == -1 {
+		return false
+	}
+
+	s.data[i] = new
+	return true
+}
+
+// Removes the oldest elements so that only the
+// last "n" are kept. Nothing is removed if "n" is 0.
+func (s *Slice[T]) KeepLast(n uint) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if n == 0 || uint(len(s.data)) <= n {
+		return
+	}
+
+	s.data = slices.Delete(s.data, 0, len(s.data)-int(n))
+}
+
 // Clears all elements from the slice.
 func (s *Slice[T]) Clear() {
 	s.mut.Lock()
@@ -13,11 +13,14 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
 	"time"
 )
@@ -52,7 +55,11 @@ func (cmd *Command) Contents() string {
 	var output strings.Builder
 	fmt.Fprintln(&output, "-------- HEADERS --------")
 	fmt.Fprintf(&output, "* Version: %d\n", cmd.HD.Ver)
-	fmt.Fprintf(&output, "* Action: 0x%02x (%s)\n", cmd.HD.Op, CodeToString(cmd.HD.Op))
+	fmt.Fprintf(&output, "* Action: 0x%02x (%s)", cmd.HD.Op, CodeToString(cmd.HD.Op))
+	if desc := OperationDescription(cmd.HD.Op); desc != "" {
+		fmt.Fprintf(&output, " - %s", desc)
+	}
+	fmt.Fprintln(&output)
 	fmt.Fprintf(&output, "* Info: 0x%02x ", cmd.HD.Info)
 
 	switch cmd.HD.Op {
@@ -68,6 +75,11 @@ func (cmd *Command) Contents() string {
 	case SUB, UNSUB, HOOK:
 		hook := Hook(cmd.HD.Info)
 		fmt.Fprintf(&output, "(%s)\n", HookString(hook))
+	case MSG, RECIV:
+		msgType := InfoToMsgType(cmd.HD.Info)
+		fmt.Fprintf(&output, "(%s)\n", MsgTypeString(msgType))
+	case KICK:
+		fmt.Fprintf(&output, "(%s)\n", KickString(Kick(cmd.HD.Info)))
 	default:
 		fmt.Fprint(&output, "[Empty]\n")
 	}
@@ -137,6 +149,8 @@ func (hd Header) ClientCheck() error {
 		hd.Op == RECIV ||
 		hd.Op == HOOK ||
 		hd.Op == HELLO ||
+		hd.Op == SIGNAL ||
+		hd.Op == KICK ||
 		hd.Op == ERR
 
 	if !check && hd.ID == NullID {
@@ -144,7 +158,7 @@ func (hd Header) ClientCheck() error {
 	}
 
 	// These operations cannot have empty information
-	info := hd.Op == HOOK || hd.Op == ERR
+	info := hd.Op == HOOK || hd.Op == ERR || hd.Op == KICK
 	if info && hd.Info == EmptyInfo {
 		return ErrorHeader
 	}
@@ -179,9 +193,74 @@ func PermissionToBytes(perm uint) []byte {
 // argument of a command and returns the unsigned integer
 // asocciated to said array or an error if the reading failed.
 func BytesToPermission(perm []byte) (uint, error) {
+	if len(perm) == 0 {
+		return 0, ErrorArguments
+	}
+
 	return uint(perm[0]), nil
 }
 
+// Minimum permission levels needed to list users,
+// which the server advertises when connecting.
+type Listing struct {
+	All    uint // Needed for USRS_ALL and USRS_ALLPERMS
+	Online uint // Needed for USRS_ONLINE and USRS_ONLINEPERMS
+}
+
+// Returns the permission level needed for a type of list.
+func (l Listing) Required(u Userlist) uint {
+	switch u {
+	case UsersAll, UsersAllPerms:
+		return l.All
+	case UsersOnline, UsersOnlinePerms:
+		return l.Online
+	default:
+		return 0
+	}
+}
+
+// Turns a listing policy into a byte slice
+// with one permission level per byte.
+func ListingToBytes(l Listing) []byte {
+	return []byte{byte(l.All), byte(l.Online)}
+}
+
+// Reads a byte slice as a listing policy,
+// returning an error if it is too short.
+func BytesToListing(b []byte) (Listing, error) {
+	if len(b) < 2 {
+		return Listing{}, ErrorArguments
+	}
+
+	return Listing{
+		All:    uint(b[0]),
+		Online: uint(b[1]),
+	}, nil
+}
+
+/* SIGNAL FUNCTIONS */
+
+// Encodes signaling data as standard base64 so that
+// line endings inside of it (such as the ones used by
+// session descriptions) do not split the argument.
+func SignalToBytes(data []byte) []byte {
+	enc := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(enc, data)
+	return enc
+}
+
+// Decodes signaling data encoded as standard base64,
+// returning an error if it is not valid base64.
+func BytesToSignal(b []byte) ([]byte, error) {
+	dec := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+	n, err := base64.StdEncoding.Decode(dec, b)
+	if err != nil {
+		return nil, ErrorArguments
+	}
+
+	return dec[:n], nil
+}
+
 /* UNIX STAMP FUNCTIONS */
 
 // Turns a time type into its unix timestamp
@@ -208,6 +287,52 @@ func BytesToUnixStamp(b []byte) (t time.Time, e error) {
 	return time.Unix(stamp, 0), nil
 }
 
+/* SEQUENCE FUNCTIONS */
+
+// Turns a sequence number into a byte slice
+// using a variable length encoding.
+func SequenceToBytes(seq uint64) []byte {
+	// Preallocation
+	p := make([]byte, 0, binary.Size(seq))
+	p = binary.AppendUvarint(p, seq)
+	return p
+}
+
+// Reads a byte slice as a variable length encoded
+// sequence number, returning an error if it fails.
+func BytesToSequence(b []byte) (uint64, error) {
+	buf := bytes.NewBuffer(b)
+	seq, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return 0, ErrorArguments
+	}
+
+	return seq, nil
+}
+
+/* COUNT FUNCTIONS */
+
+// Turns an amount of items into a byte
+// slice using a variable length encoding.
+func CountToBytes(count uint64) []byte {
+	// Preallocation
+	p := make([]byte, 0, binary.Size(count))
+	p = binary.AppendUvarint(p, count)
+	return p
+}
+
+// Reads a byte slice as a variable length encoded
+// amount of items, returning an error if it fails.
+func BytesToCount(b []byte) (uint64, error) {
+	buf := bytes.NewBuffer(b)
+	count, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return 0, ErrorArguments
+	}
+
+	return count, nil
+}
+
 /* PACKET FUNCTIONS */
 
 // Returns the command asocciated to a byte slice without
@@ -221,6 +346,41 @@ func ParsePacket(p []byte) Command {
 	}
 }
 
+// Returns the command asocciated to a byte slice, checking
+// that the packet is well formed instead of panicking. The
+// header is not validated for either the client or the server.
+func DecodePacket(p []byte) (Command, error) {
+	if len(p) < HeaderSize+2 {
+		return Command{}, ErrorHeader
+	}
+
+	if !bytes.Equal(p[HeaderSize:HeaderSize+2], []byte("\r\n")) {
+		return Command{}, ErrorHeader
+	}
+
+	hd := NewHeader(p[:HeaderSize])
+	payload := p[HeaderSize+2:]
+	if len(payload) != int(hd.Len) {
+		return Command{}, ErrorMaxSize
+	}
+
+	// Split generates an extra empty argument so we get rid of it
+	split := bytes.Split(payload, []byte("\r\n"))
+	if len(split) <= int(hd.Args) {
+		return Command{}, ErrorArguments
+	}
+
+	cmd := Command{
+		HD:   hd,
+		Args: split[:hd.Args],
+	}
+	if err := cmd.CheckArgs(); err != nil {
+		return Command{}, err
+	}
+
+	return cmd, nil
+}
+
 // Checks the arguments of a command to validate sizes.
 func (cmd *Command) CheckArgs() error {
 	// Incorrect amount of arguments according to header
@@ -254,6 +414,52 @@ func (cmd *Command) CheckArgs() error {
 	return nil
 }
 
+// Validates an entire command sent to the server by checking
+// the header, the arguments and any minimum amount of arguments
+// specific to the operation, in that order.
+func ValidateServerCommand(cmd Command) error {
+	if err := cmd.HD.ServerCheck(); err != nil {
+		return err
+	}
+
+	if err := cmd.CheckArgs(); err != nil {
+		return err
+	}
+
+	// Unknown operations are left for the caller to handle
+	if cmd.HD.Op == ADMIN {
+		min := AdminArgs(Admin(cmd.HD.Info))
+		if min != -1 && len(cmd.Args) < min {
+			return ErrorArguments
+		}
+	}
+
+	return nil
+}
+
+// Validates an entire command sent to the client by checking
+// the header, the arguments and any minimum amount of arguments
+// specific to the operation, in that order.
+func ValidateClientCommand(cmd Command) error {
+	if err := cmd.HD.ClientCheck(); err != nil {
+		return err
+	}
+
+	if err := cmd.CheckArgs(); err != nil {
+		return err
+	}
+
+	// Unknown hooks are left for the caller to handle
+	if cmd.HD.Op == HOOK {
+		min := HookArgs(Hook(cmd.HD.Info))
+		if min != -1 && len(cmd.Args) < min {
+			return ErrorArguments
+		}
+	}
+
+	return nil
+}
+
 // Creates a packet ready to be sent through a TCP connection with all header fields,
 // arguments, and delimiters. Arguments are optional and an error will be returned if
 // any of the function parameters are malformed.
@@ -387,6 +593,13 @@ func PEMToPubkey(pubPEM []byte) (*rsa.PublicKey, error) {
 	return nil, errors.New("key type is not RSA")
 }
 
+// Compares two secrets in constant time, so that the time
+// taken does not reveal how much of them matched. Slices
+// with different lengths are never equal.
+func SecureCompare(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
 // Encrypts a text using a public key and the OAEP method with SHA256.
 func EncryptText(t []byte, pub *rsa.PublicKey) ([]byte, error) {
 	// Cypher the payload
@@ -407,3 +620,34 @@ func DecryptText(e []byte, priv *rsa.PrivateKey) ([]byte, error) {
 	}
 	return dec, nil
 }
+
+// Returns the maximum amount of bytes that EncryptText
+// can encrypt with the given public key.
+func MaxEncryptSize(pub *rsa.PublicKey) int {
+	return pub.Size() - 2*sha256.Size - 2
+}
+
+// Charset used by the random text generator
+const randTextCharset string = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%&*+-?!"
+
+// Generates a random text of the given length using a secure
+// source, where each character is picked uniformly from a fixed
+// charset. Lengths below MinChallengeSize are rejected so that
+// the text cannot be guessed, which makes it usable for challenges.
+func RandText(length int) ([]byte, error) {
+	if length < MinChallengeSize {
+		return nil, fmt.Errorf("random text must have at least %d characters", MinChallengeSize)
+	}
+
+	max := big.NewInt(int64(len(randTextCharset)))
+	r := make([]byte, length)
+	for i := range r {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		r[i] = randTextCharset[n.Int64()]
+	}
+
+	return r, nil
+}
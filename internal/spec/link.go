@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,37 +20,92 @@ import (
 // Note that a established connection does not imply a
 // logged in user.
 type Connection struct {
-	Conn net.Conn // TCP connection
-	TLS  bool     // Whether it is connected through TLS
+	Conn    net.Conn      // TCP connection
+	TLS     bool          // Whether it is connected through TLS
+	Timeout time.Duration // Idle time allowed before each read, none if 0
+
+	traffic *Traffic // Bytes that went through the connection, nil if not counted
+}
+
+// Amount of bytes read from and written to a connection,
+// which is shared between all copies of the connection.
+type Traffic struct {
+	Read    atomic.Uint64 // Bytes read from the connection
+	Written atomic.Uint64 // Bytes written to the connection
+}
+
+// Wraps a connection so that every write to it is
+// counted, even the ones that do not use WritePacket().
+type countedConn struct {
+	net.Conn
+	traffic *Traffic
+}
+
+func (c *countedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.traffic.Written.Add(uint64(n))
+	return n, err
 }
 
 // Specifies a message that can be sent between clients
 // and that is either sent directly through the server
 // or stored in the database.
 type Message struct {
-	Sender  string    // Person that sent the message
-	Content []byte    // Encrypted content
-	Stamp   time.Time // Specifies when the message was sent
+	Sender    string    // Person that sent the message
+	Content   []byte    // Encrypted content
+	Stamp     time.Time // Specifies when the message was sent
+	Sequence  uint64    // Order in which it was cached, zero if it was not
+	Recipient string    // Destination of a copy addressed to its own sender, empty otherwise
+	Type      MsgType   // How the content should be understood
 }
 
 /* CONNECTION FUNCTIONS */
 
 // Returns a new TCP connection with a buffered reader and
 // TLS information using the connection from the [net]
-// package as a base.
+// package as a base. Every write to Conn is counted
+// in the traffic of the connection.
 func NewConnection(cl net.Conn, tls bool) Connection {
+	traffic := new(Traffic)
 	return Connection{
-		Conn: cl,
-		TLS:  tls,
+		Conn: &countedConn{
+			Conn:    cl,
+			traffic: traffic,
+		},
+		TLS:     tls,
+		traffic: traffic,
 	}
 }
 
-// Factory method that reads from a connection and modifies
-// the header values of the command accordingly.
-func (cmd *Command) ListenHeader(cl Connection) error {
-	// Read from the wire accounting for CRLF
-	b := make([]byte, HeaderSize+2)
-	_, err := io.ReadAtLeast(cl.Conn, b, HeaderSize+2)
+// Returns the connection that was given to NewConnection(),
+// without the wrapper that counts the bytes written.
+func (cl Connection) Raw() net.Conn {
+	if c, ok := cl.Conn.(*countedConn); ok {
+		return c.Conn
+	}
+
+	return cl.Conn
+}
+
+// Returns the amount of bytes read from and written to the
+// connection, which is always 0 if it was not created
+// with NewConnection().
+func (cl Connection) Traffic() (read uint64, written uint64) {
+	if cl.traffic == nil {
+		return 0, 0
+	}
+
+	return cl.traffic.Read.Load(), cl.traffic.Written.Load()
+}
+
+// Reads exactly the size of the buffer from the connection,
+// turning any failure into a specification error.
+func (cl Connection) read(b []byte) error {
+	n, err := io.ReadFull(cl.Conn, b)
+	if cl.traffic != nil {
+		cl.traffic.Read.Add(uint64(n))
+	}
+
 	if err != nil {
 		if errors.Is(err, os.ErrDeadlineExceeded) {
 			return ErrorIdle
@@ -59,6 +115,62 @@ func (cmd *Command) ListenHeader(cl Connection) error {
 		return ErrorConnection
 	}
 
+	return nil
+}
+
+// Reads a whole packet from the connection, renewing the
+// idle deadline if there is one. The payload is only read
+// if the header specifies arguments and must not be bigger
+// than the maximum allowed.
+func (cl Connection) ReadPacket() (cmd Command, err error) {
+	if cl.Timeout != 0 {
+		deadline := time.Now().Add(cl.Timeout)
+		if err := cl.Conn.SetReadDeadline(deadline); err != nil {
+			return cmd, ErrorConnection
+		}
+	}
+
+	if err := cmd.ListenHeader(cl); err != nil {
+		return cmd, err
+	}
+
+	if cmd.HD.Args == 0 || cmd.HD.Len == 0 {
+		return cmd, nil
+	}
+
+	if int(cmd.HD.Len) > MaxPayload {
+		return cmd, ErrorMaxSize
+	}
+
+	if err := cmd.ListenPayload(cl); err != nil {
+		return cmd, err
+	}
+
+	return cmd, nil
+}
+
+// Writes a whole packet to the connection in a single call,
+// creating it from the header fields and arguments given.
+func (cl Connection) WritePacket(cmd Command) error {
+	pak, err := NewPacket(cmd.HD.Op, cmd.HD.ID, cmd.HD.Info, cmd.Args...)
+	if err != nil {
+		return err
+	}
+
+	// Counted by the connection itself
+	_, err = cl.Conn.Write(pak)
+	return err
+}
+
+// Factory method that reads from a connection and modifies
+// the header values of the command accordingly.
+func (cmd *Command) ListenHeader(cl Connection) error {
+	// Read from the wire accounting for CRLF
+	b := make([]byte, HeaderSize+2)
+	if err := cl.read(b); err != nil {
+		return err
+	}
+
 	// Make sure the size is appropiate
 	// We add 2 due to CRLF
 	if len(b) < HeaderSize+2 {
@@ -76,18 +188,16 @@ func (cmd *Command) ListenHeader(cl Connection) error {
 func (cmd *Command) ListenPayload(cl Connection) error {
 	// Read from the wire "Len" bytes
 	b := make([]byte, cmd.HD.Len)
-	_, err := io.ReadAtLeast(cl.Conn, b, int(cmd.HD.Len))
-	if err != nil {
-		if errors.Is(err, os.ErrDeadlineExceeded) {
-			return ErrorIdle
-		} else if errors.Is(err, net.ErrClosed) {
-			return ErrorDisconnected
-		}
-		return ErrorConnection
+	if err := cl.read(b); err != nil {
+		return err
 	}
 
 	// Split generates an extra empty argument so we get rid of it
-	cmd.Args = (bytes.Split(b, []byte("\r\n")))[:cmd.HD.Args]
+	split := bytes.Split(b, []byte("\r\n"))
+	if len(split) <= int(cmd.HD.Args) {
+		return ErrorArguments
+	}
+	cmd.Args = split[:cmd.HD.Args]
 	if err := cmd.CheckArgs(); err != nil {
 		return err
 	}
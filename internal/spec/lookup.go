@@ -1,5 +1,10 @@
 package spec
 
+import (
+	"bytes"
+	"slices"
+)
+
 /* PREDEFINED VALUES */
 
 const (
@@ -18,9 +23,19 @@ const (
 	ReadTimeout      int    = 25                 // Timeout for a TCP read block in minutes
 	HandshakeTimeout int    = 20                 // Timeout for a connection handshake block in seconds
 	TokenExpiration  int    = 30                 // Deadline for a reusable token expiration in minutes
+	MaxEchoes        int    = 64                 // Max amount of echoes per connection in each window
+	EchoWindow       int    = 60                 // Duration of the echo limiting window in seconds
+	MaxBanner        int    = 1024               // Max size of the connection banner in bytes
+	MaxBio           int    = 256                // Max size of the profile bio of a user in bytes
+	ChallengeSize    int    = 128                // Default amount of characters of a login challenge
+	MinChallengeSize int    = 32                 // Min characters of a login challenge, over 180 bits of entropy
 	UsernameRegex    string = "^[0-9a-z]{0,32}$" // To check if a username is valid
 )
 
+// Precedes the text of the messages sent by an administrative broadcast,
+// so that clients that do not read the message type can still tell them apart
+const BroadcastPrefix string = "ADMINISTRATIVE BROADCAST:\n"
+
 /* ACTION CODES */
 
 // Specifies an operation to be performed.
@@ -46,6 +61,11 @@ const (
 	UNSUB
 	HOOK
 	HELLO
+	SUBLIST
+	ECHO
+	PROFILE
+	SIGNAL
+	KICK
 )
 
 // Identifies an operation to be performed
@@ -59,69 +79,85 @@ type lookup struct {
 	str   string // Operation code as string
 	sargs int8   // Minimum arguments to send to server
 	cargs int8   // Minimum arguments to send to client
+	desc  string // Human readable description
 }
 
 var (
-	okLookup     = lookup{OK, 0x01, "OK", -1, 0}
-	errLookup    = lookup{ERR, 0x02, "ERR", -1, 0}
-	keepLookup   = lookup{KEEP, 0x03, "KEEP", 0, -1}
-	regLookup    = lookup{REG, 0x04, "REG", 2, -1}
-	deregLookup  = lookup{DEREG, 0x05, "DEREG", 0, -1}
-	loginLookup  = lookup{LOGIN, 0x06, "LOGIN", 1, -1}
-	logoutLookup = lookup{LOGOUT, 0x07, "LOGOUT", 0, -1}
-	verifLookup  = lookup{VERIF, 0x08, "VERIF", 2, 1}
-	reqLookup    = lookup{REQ, 0x09, "REQ", 1, 3}
-	usrsLookup   = lookup{USRS, 0x0A, "USRS", 0, 1}
-	msgLookup    = lookup{MSG, 0x0B, "MSG", 3, -1}
-	recivLookup  = lookup{RECIV, 0x0C, "RECIV", 0, 3}
-	shtdwnLookup = lookup{SHTDWN, 0x0D, "SHTDWN", -1, 0}
-	adminLookup  = lookup{ADMIN, 0x0E, "ADMIN", 0, -1}
-	subLookup    = lookup{SUB, 0x0F, "SUB", 0, -1}
-	unsubLookup  = lookup{UNSUB, 0x10, "UNSUB", 0, -1}
-	hookLookup   = lookup{HOOK, 0x11, "HOOK", -1, 0}
-	helloLookup  = lookup{HELLO, 0x12, "HELLO", -1, 1}
+	okLookup      = lookup{OK, 0x01, "OK", -1, 0, "Request completed successfully"}
+	errLookup     = lookup{ERR, 0x02, "ERR", -1, 0, "Request failed with an error"}
+	keepLookup    = lookup{KEEP, 0x03, "KEEP", 0, -1, "Keep-alive to avoid an idle disconnection"}
+	regLookup     = lookup{REG, 0x04, "REG", 2, -1, "Registration of a new account"}
+	deregLookup   = lookup{DEREG, 0x05, "DEREG", 0, -1, "Deregistration of the current account"}
+	loginLookup   = lookup{LOGIN, 0x06, "LOGIN", 1, -1, "Start of a login"}
+	logoutLookup  = lookup{LOGOUT, 0x07, "LOGOUT", 0, -1, "Logout from the current session"}
+	verifLookup   = lookup{VERIF, 0x08, "VERIF", 2, 1, "Login challenge and its answer"}
+	reqLookup     = lookup{REQ, 0x09, "REQ", 1, 3, "Public key request of a user"}
+	usrsLookup    = lookup{USRS, 0x0A, "USRS", 0, 1, "List of users"}
+	msgLookup     = lookup{MSG, 0x0B, "MSG", 3, -1, "Outgoing message to a user"}
+	recivLookup   = lookup{RECIV, 0x0C, "RECIV", 0, 3, "Incoming message delivery"}
+	shtdwnLookup  = lookup{SHTDWN, 0x0D, "SHTDWN", -1, 0, "Server shutdown notice"}
+	adminLookup   = lookup{ADMIN, 0x0E, "ADMIN", 0, -1, "Administrative operation"}
+	subLookup     = lookup{SUB, 0x0F, "SUB", 0, -1, "Subscription to an event"}
+	unsubLookup   = lookup{UNSUB, 0x10, "UNSUB", 0, -1, "Unsubscription from an event"}
+	hookLookup    = lookup{HOOK, 0x11, "HOOK", -1, 0, "Notification of a subscribed event"}
+	helloLookup   = lookup{HELLO, 0x12, "HELLO", -1, 1, "Server greeting on connection"}
+	sublistLookup = lookup{SUBLIST, 0x13, "SUBLIST", 0, 1, "List of subscribed events"}
+	echoLookup    = lookup{ECHO, 0x14, "ECHO", 1, 1, "Echo of the given data"}
+	profileLookup = lookup{PROFILE, 0x15, "PROFILE", 0, -1, "Change of the profile bio"}
+	signalLookup  = lookup{SIGNAL, 0x16, "SIGNAL", 2, 2, "Signaling data relayed between users"}
+	kickLookup    = lookup{KICK, 0x17, "KICK", -1, 0, "Forced logout notice"}
 )
 
 var lookupByOperation map[Action]lookup = map[Action]lookup{
-	OK:     okLookup,
-	ERR:    errLookup,
-	KEEP:   keepLookup,
-	REG:    regLookup,
-	DEREG:  deregLookup,
-	LOGIN:  loginLookup,
-	LOGOUT: logoutLookup,
-	VERIF:  verifLookup,
-	REQ:    reqLookup,
-	USRS:   usrsLookup,
-	MSG:    msgLookup,
-	RECIV:  recivLookup,
-	SHTDWN: shtdwnLookup,
-	ADMIN:  adminLookup,
-	SUB:    subLookup,
-	UNSUB:  unsubLookup,
-	HOOK:   hookLookup,
-	HELLO:  helloLookup,
+	OK:      okLookup,
+	ERR:     errLookup,
+	KEEP:    keepLookup,
+	REG:     regLookup,
+	DEREG:   deregLookup,
+	LOGIN:   loginLookup,
+	LOGOUT:  logoutLookup,
+	VERIF:   verifLookup,
+	REQ:     reqLookup,
+	USRS:    usrsLookup,
+	MSG:     msgLookup,
+	RECIV:   recivLookup,
+	SHTDWN:  shtdwnLookup,
+	ADMIN:   adminLookup,
+	SUB:     subLookup,
+	UNSUB:   unsubLookup,
+	HOOK:    hookLookup,
+	HELLO:   helloLookup,
+	SUBLIST: sublistLookup,
+	ECHO:    echoLookup,
+	PROFILE: profileLookup,
+	SIGNAL:  signalLookup,
+	KICK:    kickLookup,
 }
 
 var lookupByString map[string]lookup = map[string]lookup{
-	"OK":     okLookup,
-	"ERR":    errLookup,
-	"KEEP":   keepLookup,
-	"REG":    regLookup,
-	"DEREG":  deregLookup,
-	"LOGIN":  loginLookup,
-	"LOGOUT": logoutLookup,
-	"VERIF":  verifLookup,
-	"REQ":    reqLookup,
-	"USRS":   usrsLookup,
-	"MSG":    msgLookup,
-	"RECIV":  recivLookup,
-	"SHTDWN": shtdwnLookup,
-	"ADMIN":  adminLookup,
-	"SUB":    subLookup,
-	"UNSUB":  unsubLookup,
-	"HOOK":   hookLookup,
-	"HELLO":  helloLookup,
+	"OK":      okLookup,
+	"ERR":     errLookup,
+	"KEEP":    keepLookup,
+	"REG":     regLookup,
+	"DEREG":   deregLookup,
+	"LOGIN":   loginLookup,
+	"LOGOUT":  logoutLookup,
+	"VERIF":   verifLookup,
+	"REQ":     reqLookup,
+	"USRS":    usrsLookup,
+	"MSG":     msgLookup,
+	"RECIV":   recivLookup,
+	"SHTDWN":  shtdwnLookup,
+	"ADMIN":   adminLookup,
+	"SUB":     subLookup,
+	"UNSUB":   unsubLookup,
+	"HOOK":    hookLookup,
+	"HELLO":   helloLookup,
+	"SUBLIST": sublistLookup,
+	"ECHO":    echoLookup,
+	"PROFILE": profileLookup,
+	"SIGNAL":  signalLookup,
+	"KICK":    kickLookup,
 }
 
 // Returns the operation code associated to a hex byte.
@@ -144,6 +180,28 @@ func IDToCode(a Action) byte {
 	return v.hex
 }
 
+// Returns a human readable description of an operation code.
+// Result is an empty string if not found.
+func OperationDescription(a Action) string {
+	v, ok := lookupByOperation[a]
+	if !ok {
+		return ""
+	}
+	return v.desc
+}
+
+// Returns all the operation codes defined
+// by the specification ordered by their code.
+func ActionList() []Action {
+	list := make([]Action, 0, len(lookupByOperation))
+	for k := range lookupByOperation {
+		list = append(list, k)
+	}
+
+	slices.Sort(list)
+	return list
+}
+
 // Returns the action code associated to a string.
 // Result is NullOp if not found.
 func StringToCode(s string) Action {
@@ -191,41 +249,60 @@ func ClientArgs(a Action) int {
 // Error that implements the error interface from
 // the [errors] package with specific information
 // that follows the protocol specification.
+//
+// The detail is optional context about the specific
+// failure and is sent as an extra argument in ERR packets.
 type SpecError struct {
 	Code        uint8
 	Text        string
 	Description string
+	Detail      string
 }
 
-// Returns the text asocciated to the error.
+// Returns the text asocciated to the error,
+// including the detail if there is one.
 func (err SpecError) Error() string {
-	return err.Description
+	if err.Detail == "" {
+		return err.Description
+	}
+	return err.Description + ": " + err.Detail
+}
+
+// Two spec errors are considered the same if they
+// share the same code, regardless of their detail.
+// This allows using [errors.Is] with detailed errors.
+func (err SpecError) Is(target error) bool {
+	v, ok := target.(SpecError)
+	if !ok {
+		return false
+	}
+	return v.Code == err.Code
 }
 
 var (
-	ErrorUndefined    error = SpecError{0x00, "ERR_UNDEFINED", "undefined problem occured"}             // undefined problem occured
-	ErrorInvalid      error = SpecError{0x01, "ERR_INVALID", "invalid operation performed"}             // invalid operation performed
-	ErrorNotFound     error = SpecError{0x02, "ERR_NOTFOUND", "content can not be found"}               // content can not be found
-	ErrorVersion      error = SpecError{0x03, "ERR_VERSION", "server and client versions do not match"} // server and client versions do not match
-	ErrorHandshake    error = SpecError{0x04, "ERR_HANDSHAKE", "handshake process failed"}              // handshake process failed
-	ErrorArguments    error = SpecError{0x05, "ERR_ARGS", "invalid arguments given"}                    // invalid arguments given
-	ErrorMaxSize      error = SpecError{0x06, "ERR_MAXSIZE", "data size is too big"}                    // data size is too big
-	ErrorHeader       error = SpecError{0x07, "ERR_HEADER", "invalid header provided"}                  // invalid header provided
-	ErrorNoSession    error = SpecError{0x08, "ERR_NOSESS", "user is not connected"}                    // user is not connected
-	ErrorLogin        error = SpecError{0x09, "ERR_LOGIN", "user can not be logged in"}                 // user can not be logged in
-	ErrorConnection   error = SpecError{0x0A, "ERR_CONN", "connection problem occured"}                 // connection problem occured
-	ErrorEmpty        error = SpecError{0x0B, "ERR_EMPTY", "queried data is empty"}                     // queried data is empty
-	ErrorPacket       error = SpecError{0x0C, "ERR_PACKET", "packet could not be delivered"}            // packet could not be delivered
-	ErrorPrivileges   error = SpecError{0x0D, "ERR_PERMS", "missing privileges to run"}                 // missing privileges to run
-	ErrorServer       error = SpecError{0x0E, "ERR_SERVER", "server operation failed"}                  // server operation failed
-	ErrorIdle         error = SpecError{0x0F, "ERR_IDLE", "user has been idle for too long"}            // user has been idle for too long
-	ErrorExists       error = SpecError{0x10, "ERR_EXISTS", "content already exists"}                   // content already exists
-	ErrorDeregistered error = SpecError{0x11, "ERR_DEREG", "user has been deleted"}                     // user has been deleted
-	ErrorDupSession   error = SpecError{0x12, "ERR_DUPSESS", "session exists in another endpoint"}      // session exists in another endpoint
-	ErrorUnsecure     error = SpecError{0x13, "ERR_NOSECURE", "secured connection required"}            // secure connection required
-	ErrorCorrupted    error = SpecError{0x14, "ERR_CORRUPTED", "queried data is currupted"}             // queried data is corrupted
-	ErrorOption       error = SpecError{0x15, "ERR_OPTION", "invalid option provided"}                  // invalid option provided
-	ErrorDisconnected error = SpecError{0x16, "ERR_DISCN", "connection was manually closed"}            // connection manually closed
+	ErrorUndefined    error = SpecError{0x00, "ERR_UNDEFINED", "undefined problem occured", ""}             // undefined problem occured
+	ErrorInvalid      error = SpecError{0x01, "ERR_INVALID", "invalid operation performed", ""}             // invalid operation performed
+	ErrorNotFound     error = SpecError{0x02, "ERR_NOTFOUND", "content can not be found", ""}               // content can not be found
+	ErrorVersion      error = SpecError{0x03, "ERR_VERSION", "server and client versions do not match", ""} // server and client versions do not match
+	ErrorHandshake    error = SpecError{0x04, "ERR_HANDSHAKE", "handshake process failed", ""}              // handshake process failed
+	ErrorArguments    error = SpecError{0x05, "ERR_ARGS", "invalid arguments given", ""}                    // invalid arguments given
+	ErrorMaxSize      error = SpecError{0x06, "ERR_MAXSIZE", "data size is too big", ""}                    // data size is too big
+	ErrorHeader       error = SpecError{0x07, "ERR_HEADER", "invalid header provided", ""}                  // invalid header provided
+	ErrorNoSession    error = SpecError{0x08, "ERR_NOSESS", "user is not connected", ""}                    // user is not connected
+	ErrorLogin        error = SpecError{0x09, "ERR_LOGIN", "user can not be logged in", ""}                 // user can not be logged in
+	ErrorConnection   error = SpecError{0x0A, "ERR_CONN", "connection problem occured", ""}                 // connection problem occured
+	ErrorEmpty        error = SpecError{0x0B, "ERR_EMPTY", "queried data is empty", ""}                     // queried data is empty
+	ErrorPacket       error = SpecError{0x0C, "ERR_PACKET", "packet could not be delivered", ""}            // packet could not be delivered
+	ErrorPrivileges   error = SpecError{0x0D, "ERR_PERMS", "missing privileges to run", ""}                 // missing privileges to run
+	ErrorServer       error = SpecError{0x0E, "ERR_SERVER", "server operation failed", ""}                  // server operation failed
+	ErrorIdle         error = SpecError{0x0F, "ERR_IDLE", "user has been idle for too long", ""}            // user has been idle for too long
+	ErrorExists       error = SpecError{0x10, "ERR_EXISTS", "content already exists", ""}                   // content already exists
+	ErrorDeregistered error = SpecError{0x11, "ERR_DEREG", "user has been deleted", ""}                     // user has been deleted
+	ErrorDupSession   error = SpecError{0x12, "ERR_DUPSESS", "session exists in another endpoint", ""}      // session exists in another endpoint
+	ErrorUnsecure     error = SpecError{0x13, "ERR_NOSECURE", "secured connection required", ""}            // secure connection required
+	ErrorCorrupted    error = SpecError{0x14, "ERR_CORRUPTED", "queried data is currupted", ""}             // queried data is corrupted
+	ErrorOption       error = SpecError{0x15, "ERR_OPTION", "invalid option provided", ""}                  // invalid option provided
+	ErrorDisconnected error = SpecError{0x16, "ERR_DISCN", "connection was manually closed", ""}            // connection manually closed
 )
 
 var codeToError map[byte]error = map[byte]error{
@@ -277,13 +354,58 @@ func ErrorString(err error) string {
 	}
 }
 
+// Returns the detail of a spec error. If the provided
+// error is not a spec error an empty string is returned.
+func ErrorDetail(err error) string {
+	switch v := err.(type) {
+	case SpecError:
+		return v.Detail
+	default:
+		return ""
+	}
+}
+
+// Returns a copy of a spec error with the given detail,
+// keeping the same code so it can still be handled
+// programatically. Any other error is returned as is.
+func ErrorWithDetail(err error, detail string) error {
+	switch v := err.(type) {
+	case SpecError:
+		v.Detail = detail
+		return v
+	default:
+		return err
+	}
+}
+
+// Returns all the errors defined by the
+// specification ordered by their code.
+func ErrorList() []SpecError {
+	list := make([]SpecError, 0, len(codeToError))
+	for _, v := range codeToError {
+		list = append(list, v.(SpecError))
+	}
+
+	slices.SortFunc(list, func(a, b SpecError) int {
+		return int(a.Code) - int(b.Code)
+	})
+	return list
+}
+
 // Returns the hex byte asocciated to an error.
+// The optional detail corresponds to the arguments
+// of an ERR packet and will be included in the error.
 // Result is nil if not found.
-func ErrorCodeToError(b byte) error {
+func ErrorCodeToError(b byte, detail ...[]byte) error {
 	v, ok := codeToError[b]
 	if !ok {
 		return nil
 	}
+
+	if len(detail) > 0 {
+		return ErrorWithDetail(v, string(bytes.Join(detail, []byte(" "))))
+	}
+
 	return v
 }
 
@@ -299,6 +421,11 @@ const (
 	AdminChangePerms Admin = 0x03 // Increase the permission level of a user
 	AdminDisconnect  Admin = 0x04 // Disconnect an online user
 	AdminMotd        Admin = 0x05 // Changes the MOTD of the server
+	AdminCancel      Admin = 0x06 // Cancels a scheduled shutdown
+	AdminExpire      Admin = 0x07 // Expires all reusable tokens or those of a user
+	AdminPrune       Admin = 0x08 // Deregisters all users inactive for some days
+	AdminGetPerms    Admin = 0x09 // Queries the permission level of a user
+	AdminResetPerms  Admin = 0x0A // Resets the permission level of a user to the default
 )
 
 var codeToAdmin map[Admin]string = map[Admin]string{
@@ -308,6 +435,11 @@ var codeToAdmin map[Admin]string = map[Admin]string{
 	AdminChangePerms: "ADMIN_CHGPERMS",
 	AdminDisconnect:  "ADMIN_KICK",
 	AdminMotd:        "ADMIN_MOTD",
+	AdminCancel:      "ADMIN_CNCLSHTDWN",
+	AdminExpire:      "ADMIN_EXPTOKENS",
+	AdminPrune:       "ADMIN_PRUNE",
+	AdminGetPerms:    "ADMIN_GETPERMS",
+	AdminResetPerms:  "ADMIN_RSTPERMS",
 }
 
 var adminToArgs map[Admin]int = map[Admin]int{
@@ -317,6 +449,11 @@ var adminToArgs map[Admin]int = map[Admin]int{
 	AdminChangePerms: 2,
 	AdminDisconnect:  1,
 	AdminMotd:        1,
+	AdminCancel:      0,
+	AdminExpire:      0,
+	AdminPrune:       1,
+	AdminGetPerms:    1,
+	AdminResetPerms:  1,
 }
 
 // Returns the admin string asocciated to a hex byte.
@@ -397,6 +534,95 @@ func HookArgs(h Hook) int {
 	return v
 }
 
+/* MESSAGE TYPES */
+
+// Specifies how the content of a message should be understood
+type MsgType uint8
+
+const (
+	MsgText      MsgType = 0x00 // Plain text, also used if no type is given
+	MsgAction    MsgType = 0x01 // Action performed by the sender
+	MsgReaction  MsgType = 0x02 // Reaction to a previous message
+	MsgEdit      MsgType = 0x03 // Correction of a previous message
+	MsgBroadcast MsgType = 0x04 // Administrative broadcast, only sent by the server
+	MsgReply     MsgType = 0x05 // Reply that starts with a quote of another message
+)
+
+var codeToMsgType map[MsgType]string = map[MsgType]string{
+	MsgText:      "MSG_TEXT",
+	MsgAction:    "MSG_ACTION",
+	MsgReaction:  "MSG_REACTION",
+	MsgEdit:      "MSG_EDIT",
+	MsgBroadcast: "MSG_BRDCAST",
+	MsgReply:     "MSG_REPLY",
+}
+
+// Returns the message type string asocciated to a hex byte.
+// Result is an empty string if not found.
+func MsgTypeString(t MsgType) string {
+	v, ok := codeToMsgType[t]
+	if !ok {
+		return ""
+	}
+	return v
+}
+
+// Returns the type of a message from the information field of
+// its packet. Missing or unknown types are treated as plain text.
+func InfoToMsgType(info byte) MsgType {
+	t := MsgType(info)
+	if _, ok := codeToMsgType[t]; !ok {
+		return MsgText
+	}
+	return t
+}
+
+/* FORCED LOGOUTS */
+
+// Specifies why the server is closing a connection on its own
+type Kick uint8
+
+const (
+	KickAdmin       Kick = 0x01 // Disconnected by an administrator
+	KickBanned      Kick = 0x02 // Account deregistered by an administrator
+	KickRevoked     Kick = 0x03 // Session revoked by the server
+	KickMaintenance Kick = 0x04 // Server shutting down
+)
+
+var codeToKick map[Kick]string = map[Kick]string{
+	KickAdmin:       "KICK_ADMIN",
+	KickBanned:      "KICK_BANNED",
+	KickRevoked:     "KICK_REVOKED",
+	KickMaintenance: "KICK_MAINTENANCE",
+}
+
+var kickToDesc map[Kick]string = map[Kick]string{
+	KickAdmin:       "kicked by an admin",
+	KickBanned:      "banned by an admin",
+	KickRevoked:     "logged out as your session was revoked",
+	KickMaintenance: "disconnected for server maintenance",
+}
+
+// Returns the kick string asocciated to a hex byte.
+// Result is an empty string if not found.
+func KickString(k Kick) string {
+	v, ok := codeToKick[k]
+	if !ok {
+		return ""
+	}
+	return v
+}
+
+// Returns a human readable description of why the
+// connection was closed, generic if the reason is unknown.
+func KickDescription(k Kick) string {
+	v, ok := kickToDesc[k]
+	if !ok {
+		return "disconnected by the server"
+	}
+	return v
+}
+
 /* USER LISTING */
 
 // Specifies the user option for the command
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"sync"
 	"time"
 
 	"github.com/Sprinter05/gochat/internal/log"
@@ -12,35 +13,41 @@ import (
 
 /* INITIAL CONNECTION */
 
-// Waits for a possible TLS handshake and sends an initial welcome OK
-func welcomeConn(cl *spec.Connection, motd string) {
+// Waits for a possible TLS handshake and sends an initial welcome
+// HELLO, which includes the banner, empty if there is none, the
+// version of the server and who is allowed to list users
+func welcomeConn(cl *spec.Connection, motd string, banner string, list spec.Listing) {
 	// Set timeout for the initial write to prevent blocking forever
 	deadline := time.Now().Add(
 		time.Duration(spec.HandshakeTimeout) * time.Second,
 	)
 	cl.Conn.SetDeadline(deadline)
 
+	hello := spec.Command{
+		HD: spec.Header{
+			Op:   spec.HELLO,
+			ID:   spec.NullID,
+			Info: spec.EmptyInfo,
+		},
+		Args: [][]byte{
+			[]byte(motd),
+			[]byte(banner),
+			[]byte(version()),
+			spec.ListingToBytes(list),
+		},
+	}
+
 	// Notify the user they are connected to the server
-	pak, err := spec.NewPacket(
-		spec.HELLO,
-		spec.NullID,
-		spec.EmptyInfo,
-		[]byte(motd),
-	)
+	err := cl.WritePacket(hello)
 	if err != nil {
-		log.Packet(spec.OK, err)
-	} else {
-		_, err := cl.Conn.Write(pak)
-		if err != nil {
-			log.Error("handshake with new connection", err)
-		}
+		log.Error("handshake with new connection", err)
 	}
 
 	// Disable timeout as it is only for the first write
 	cl.Conn.SetDeadline(time.Time{})
 
 	// Check if its a TLS connection
-	_, ok := cl.Conn.(*tls.Conn)
+	_, ok := cl.Raw().(*tls.Conn)
 	cl.TLS = ok
 }
 
@@ -51,30 +58,21 @@ func welcomeConn(cl *spec.Connection, motd string) {
 func readCommand(cl spec.Connection) (cmd spec.Command, err error) {
 	ip := cl.Conn.RemoteAddr().String()
 
-	// Error logged by the function
-	if err := cmd.ListenHeader(cl); err != nil {
-		log.Read("header", ip, err)
+	// Framing and size limits are handled by the connection
+	cmd, err = cl.ReadPacket()
+	if err != nil {
+		log.Read("packet", ip, err)
 		hubs.SendErrorPacket(spec.NullID, err, cl.Conn)
 		return cmd, err
 	}
 
-	// Check that all header fields are correct
-	if err := cmd.HD.ServerCheck(); err != nil {
-		log.Read("header checking", ip, err)
+	// Check that the whole command is correct
+	if err := spec.ValidateServerCommand(cmd); err != nil {
+		log.Read("command checking", ip, err)
 		hubs.SendErrorPacket(spec.NullID, err, cl.Conn)
 		return cmd, err
 	}
 
-	// If there are no arguments we do not process the payload
-	if cmd.HD.Args != 0 && cmd.HD.Len != 0 {
-		// Error logged by the function
-		if err := cmd.ListenPayload(cl); err != nil {
-			log.Read("payload", ip, err)
-			hubs.SendErrorPacket(spec.NullID, err, cl.Conn)
-			return cmd, err
-		}
-	}
-
 	return cmd, nil
 }
 
@@ -92,26 +90,24 @@ func ListenConnection(cl spec.Connection, c *models.Counter, req chan<- hubs.Req
 			cl.Conn.RemoteAddr().String(),
 			true,
 		)
+		read, written := cl.Traffic()
+		log.Traffic(cl.Conn.RemoteAddr().String(), read, written)
 	}()
 
 	// Perform initial welcome handshake
-	welcomeConn(&cl, hub.Motd())
+	welcomeConn(&cl, hub.Motd(), hub.Banner(), hub.Listing())
 
-	// Set timeout and log connection
-	ip := cl.Conn.RemoteAddr().String()
-	deadline := time.Now().Add(time.Duration(spec.ReadTimeout) * time.Minute)
+	// Connection must authenticate before it expires
+	hub.Anonymous(cl.Conn)
+
+	// Set idle timeout and log connection
+	cl.Timeout = time.Duration(spec.ReadTimeout) * time.Minute
 	log.Connection(
 		cl.Conn.RemoteAddr().String(),
 		false,
 	)
 
 	for {
-		// Works as an idle timeout calling it each time
-		err := cl.Conn.SetReadDeadline(deadline)
-		if err != nil {
-			log.Read("deadline setup", ip, err)
-		}
-
 		cmd, err := readCommand(cl)
 		if err != nil {
 			// Malformed, cleanup connection
@@ -132,21 +128,50 @@ func ListenConnection(cl spec.Connection, c *models.Counter, req chan<- hubs.Req
 
 }
 
-// Runs all commands for a single client
-func RunTask(hub *hubs.Hub, req <-chan hubs.Request) {
-	for r := range req {
-		// Show request
-		ip := r.Conn.RemoteAddr().String()
-		log.Request(ip, r.Command)
+// Runs a single command of a client
+func runRequest(hub *hubs.Hub, r hubs.Request) {
+	// Show request
+	ip := r.Conn.RemoteAddr().String()
+	log.Request(ip, r.Command)
 
-		// Check if the user can be served
-		u, err := hub.Session(r)
-		if err != nil {
-			hubs.SendErrorPacket(r.Command.HD.ID, err, r.Conn)
-			log.Error("session checking for "+ip, err)
-			continue // Next request
+	// Check if the user can be served
+	u, err := hub.Session(r)
+	if err != nil {
+		hubs.SendErrorPacket(r.Command.HD.ID, err, r.Conn)
+		log.Error("session checking for "+ip, err)
+		return
+	}
+
+	hubs.Process(hub, r, *u)
+}
+
+// Runs all commands for a single client using up to the
+// given amount of workers. Only concurrent actions are run
+// in parallel, any other action waits for the running ones
+// to finish so that, for example, a login is never processed
+// at the same time as other commands. Each reply is written
+// with a single call, which is already serialized by the
+// connection, so replies never interleave.
+func RunTask(hub *hubs.Hub, req <-chan hubs.Request, workers uint) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(workers, 1))
+	defer wg.Wait()
+
+	for r := range req {
+		if workers <= 1 || !hubs.Concurrent(r.Command.HD.Op) {
+			wg.Wait()
+			runRequest(hub, r)
+			continue
 		}
 
-		hubs.Process(hub, r, *u)
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			runRequest(hub, r)
+		}()
 	}
 }
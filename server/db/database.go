@@ -120,6 +120,9 @@ const (
 	OWNER                   // Can designate new administrators
 )
 
+// Permission level given to newly registered users
+const DefaultPermission Permission = USER
+
 var permsToString map[Permission]string = map[Permission]string{
 	USER:  "USER",
 	ADMIN: "ADMIN",
@@ -140,14 +143,19 @@ type User struct {
 	Username   string         `gorm:"unique;not null;size:32"`
 	Pubkey     sql.NullString `gorm:"unique;size:2047"`
 	Permission Permission     `gorm:"not null;default:0"`
+	LastSeen   time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP()"`
+	Bio        sql.NullString `gorm:"size:256"`
 }
 
 // Identifies messages stored in the database
 type Message struct {
+	Sequence    uint64    `gorm:"primaryKey;autoIncrement;not null"`
 	SrcUser     uint      `gorm:"not null;check:src_user <> dst_user"`
 	DstUser     uint      `gorm:"not null"`
 	Message     string    `gorm:"not null;size:2047"`
 	Stamp       time.Time `gorm:"not null;default:CURRENT_TIMESTAMP()"`
+	SelfCopy    bool      `gorm:"not null;default:false"`
+	MsgType     uint8     `gorm:"not null;default:0"`
 	Source      User      `gorm:"foreignKey:src_user;OnDelete:RESTRICT"`
 	Destination User      `gorm:"foreignKey:dst_user;OnDelete:RESTRICT"`
 }
@@ -161,6 +169,7 @@ var (
 	ErrorConsistency   = errors.New("invalid data found in the database")              // invalid data found in the database
 	ErrorEmpty         = errors.New("empty result found")                              // empty result found
 	ErrorNullPubkey    = errors.New("null public key found")                           // null public key found
+	ErrorQuota         = errors.New("message cache quota exceeded")                    // message cache quota exceeded
 )
 
 /* FUNCTIONS */
@@ -198,6 +207,10 @@ func StringPermission(s string) Permission {
 // Runs database migrations, ensuring all tables
 // are up to date.
 func Migrate(db *gorm.DB) {
+	if err := migrateSequence(db); err != nil {
+		log.Fatal("message sequence migration", err)
+	}
+
 	err := db.Set(
 		"gorm:table_options",
 		"ENGINE=InnoDB",
@@ -206,3 +219,34 @@ func Migrate(db *gorm.DB) {
 		log.Fatal("database migrations", err)
 	}
 }
+
+// Adds the sequence number to a messages table created before it
+// existed, which had no primary key. Automatic migrations cannot add
+// an auto increment key to a table with rows, so the column is added
+// empty, filled in the order the messages were cached and only then
+// turned into the key, which keeps counting from the highest one.
+func migrateSequence(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&Message{}) || m.HasColumn(&Message{}, "sequence") {
+		return nil
+	}
+
+	log.Notice("adding sequence numbers to cached messages")
+	return db.Connection(func(tx *gorm.DB) error {
+		steps := []string{
+			"ALTER TABLE messages ADD COLUMN sequence BIGINT UNSIGNED NULL FIRST",
+			"SET @seq := 0",
+			"UPDATE messages SET sequence = (@seq := @seq + 1) ORDER BY stamp ASC",
+			"ALTER TABLE messages MODIFY sequence BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, ADD PRIMARY KEY (sequence)",
+		}
+
+		// The counter only exists in this connection
+		for _, v := range steps {
+			if err := tx.Exec(v).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/Sprinter05/gochat/internal/spec"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 /* QUERIES */
@@ -34,6 +35,10 @@ func QueryUser(db *gorm.DB, uname string) (*User, error) {
 	return &user, nil
 }
 
+// Condition matching the cached messages a user should receive, which are
+// the ones sent to it and the copies of the ones it sent to other users.
+const pendingFor = "((messages.dst_user = @user AND NOT messages.self_copy) OR (messages.src_user = @user AND messages.self_copy))"
+
 // Gets all messages directed to the specified user as an array of pointers,
 // this was it is easier to pass it around. It uses the specification
 // Message type and not the database one due to how messages are stored,
@@ -47,13 +52,15 @@ func QueryMessages(db *gorm.DB, uname string) ([]*spec.Message, error) {
 	// We give it a context so its safe to reuse
 	// for first counting and then returning results
 	res := db.Model(&Message{}).Select(
-		"username", "message", "stamp",
+		"s.username", "d.username", "message", "stamp", "sequence", "self_copy", "msg_type",
+	).Joins(
+		"JOIN users s ON messages.src_user = s.user_id",
 	).Joins(
-		"JOIN users u ON messages.src_user = u.user_id",
+		"JOIN users d ON messages.dst_user = d.user_id",
 	).Where(
-		"messages.dst_user = ?", user.UserID,
+		pendingFor, sql.Named("user", user.UserID),
 	).Order(
-		"stamp ASC",
+		"stamp ASC, sequence ASC",
 	).WithContext(context.Background())
 
 	var size int64
@@ -79,19 +86,30 @@ func QueryMessages(db *gorm.DB, uname string) ([]*spec.Message, error) {
 	messages := make([]*spec.Message, 0, size)
 
 	for i := 0; rows.Next(); i++ {
-		var undec string
+		var undec, dst string
+		var selfCopy bool
+		var msgType uint8
 		var temp spec.Message
 
 		err := rows.Scan(
 			&temp.Sender,
+			&dst,
 			&undec,
 			&temp.Stamp,
+			&temp.Sequence,
+			&selfCopy,
+			&msgType,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
+		if selfCopy {
+			temp.Recipient = dst
+		}
+		temp.Type = spec.MsgType(msgType)
+
 		// Conversion from hex string
 		dec, err := hex.DecodeString(undec)
 		if err != nil {
@@ -175,6 +193,23 @@ func QueryUsernamesAndPerms(db *gorm.DB) (string, error) {
 	return slice[:l-1], nil
 }
 
+// Returns all registered users that have not been seen
+// since the given time, ordered from the least recent one.
+// Users that are already deregistered are not included.
+func QueryInactiveUsers(db *gorm.DB, since time.Time) ([]User, error) {
+	var dbusers []User
+
+	res := db.Where(
+		"pubkey IS NOT NULL AND last_seen < ?", since,
+	).Order("last_seen ASC").Find(&dbusers)
+	if res.Error != nil {
+		log.DBError(res.Error)
+		return nil, res.Error
+	}
+
+	return dbusers, nil
+}
+
 /* INSERTIONS */
 
 // Inserts a user into a database, the public key provided must be
@@ -183,7 +218,9 @@ func QueryUsernamesAndPerms(db *gorm.DB) (string, error) {
 func InsertUser(db *gorm.DB, uname string, pubkey []byte) error {
 	// Public key must be a sql null string
 	res := db.Create(&User{
-		Username: uname,
+		Username:   uname,
+		Permission: DefaultPermission,
+		LastSeen:   time.Now(),
 		Pubkey: sql.NullString{
 			String: string(pubkey),
 			Valid:  true,
@@ -207,15 +244,55 @@ func InsertUser(db *gorm.DB, uname string, pubkey []byte) error {
 	return nil
 }
 
+// Caches a message as CacheMessage() does, as long as the cache of
+// the destination stays within the given amount of bytes. The user
+// is locked until the message is inserted, so that concurrent senders
+// cannot exceed the quota. Returns ErrorQuota if it does not fit.
+func CacheMessageWithin(db *gorm.DB, dst string, msg spec.Message, quota uint64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		res := tx.Clauses(
+			clause.Locking{Strength: "UPDATE"},
+		).Where("username = ?", dst).First(&user)
+		if res.Error != nil {
+			log.DBError(res.Error)
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return ErrorNotFound
+			}
+			return res.Error
+		}
+
+		used, err := CachedBytes(tx, dst)
+		if err != nil {
+			return err
+		}
+
+		if used+uint64(len(msg.Content)) > quota {
+			return ErrorQuota
+		}
+
+		return CacheMessage(tx, dst, msg)
+	})
+}
+
 // Cache a message into the database for future retrieval
 // by the destination user. Message should be encrypted when
 // inserting, as the database makes no checks whatsoever.
+// If the message has a recipient it is cached as a copy for
+// its own sender, which is given as the destination.
 func CacheMessage(db *gorm.DB, dst string, msg spec.Message) error {
 	srcuser, srcerr := QueryUser(db, msg.Sender)
 	if srcerr != nil {
 		return srcerr
 	}
 
+	// Copies are stored as the original message so
+	// that they keep referencing the real recipient
+	selfCopy := msg.Recipient != ""
+	if selfCopy {
+		dst = msg.Recipient
+	}
+
 	dstuser, dsterr := QueryUser(db, dst)
 	if dsterr != nil {
 		return dsterr
@@ -225,10 +302,12 @@ func CacheMessage(db *gorm.DB, dst string, msg spec.Message) error {
 	// better compatibility
 	str := hex.EncodeToString([]byte(msg.Content))
 	res := db.Create(&Message{
-		SrcUser: srcuser.UserID,
-		DstUser: dstuser.UserID,
-		Message: str,
-		Stamp:   msg.Stamp,
+		SrcUser:  srcuser.UserID,
+		DstUser:  dstuser.UserID,
+		Message:  str,
+		Stamp:    msg.Stamp,
+		SelfCopy: selfCopy,
+		MsgType:  uint8(msg.Type),
 	})
 
 	if res.Error != nil {
@@ -254,6 +333,11 @@ func RemoveKey(db *gorm.DB, uname string) error {
 		Valid: false,
 	}
 
+	// The bio is not kept for whoever reuses the username
+	user.Bio = sql.NullString{
+		Valid: false,
+	}
+
 	res := db.Save(&user)
 	if res.Error != nil {
 		log.DBError(res.Error)
@@ -282,6 +366,39 @@ func ChangePermission(db *gorm.DB, uname string, perm Permission) error {
 	return nil
 }
 
+// Marks a user as seen at the current time, which is used
+// to determine whether the account is still in use.
+func UpdateLastSeen(db *gorm.DB, uname string) error {
+	res := db.Model(&User{}).Where(
+		"username = ?", uname,
+	).Update("last_seen", time.Now())
+	if res.Error != nil {
+		log.DBError(res.Error)
+		return res.Error
+	}
+
+	return nil
+}
+
+// Changes the profile bio of a user, an empty
+// bio removes the stored one.
+func UpdateBio(db *gorm.DB, uname string, bio string) error {
+	value := sql.NullString{
+		String: bio,
+		Valid:  bio != "",
+	}
+
+	res := db.Model(&User{}).Where(
+		"username = ?", uname,
+	).Update("bio", value)
+	if res.Error != nil {
+		log.DBError(res.Error)
+		return res.Error
+	}
+
+	return nil
+}
+
 /* DELETIONS */
 
 // Attempts to remove a user from the database,
@@ -306,23 +423,66 @@ func RemoveUser(db *gorm.DB, uname string) error {
 	return nil
 }
 
-// Removes all cached messages destinated to a given user before a
-// given stamp, this is done to prevent messages from being lost
-// due to concurrent access. It is advised to use the timestamp
-// of the last retrieved message, as that should be the newest one.
-func RemoveMessages(db *gorm.DB, uname string, stamp time.Time) error {
+// Returns the amount of cached messages destinated to a given user,
+// including the copies of the messages it sent.
+func CountMessages(db *gorm.DB, uname string) (int64, error) {
+	user, err := QueryUser(db, uname)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	res := db.Model(&Message{}).Where(
+		pendingFor, sql.Named("user", user.UserID),
+	).Count(&count)
+	if res.Error != nil {
+		log.DBError(res.Error)
+		return 0, res.Error
+	}
+
+	return count, nil
+}
+
+// Returns the size in bytes of the cached messages destinated to a
+// given user, including the copies of the messages it sent. It is
+// computed from the stored messages so it always matches the cache.
+func CachedBytes(db *gorm.DB, uname string) (uint64, error) {
+	user, err := QueryUser(db, uname)
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint64
+	res := db.Model(&Message{}).Select(
+		"COALESCE(SUM(LENGTH(message)), 0)",
+	).Where(
+		pendingFor, sql.Named("user", user.UserID),
+	).Scan(&size)
+	if res.Error != nil {
+		log.DBError(res.Error)
+		return 0, res.Error
+	}
+
+	// Messages are stored as hexadecimal, which doubles their size
+	return size / 2, nil
+}
+
+// Removes all cached messages destinated to a given user up to a
+// given sequence number, this is done to prevent messages from being
+// lost due to concurrent access. It is advised to use the highest
+// sequence number of the retrieved messages, as that is the newest one.
+func RemoveMessages(db *gorm.DB, uname string, seq uint64) error {
 	user, err := QueryUser(db, uname)
 	if err != nil {
 		return err
 	}
 
-	// Delete, checking the timestamp
-	res := db.Delete(
-		&Message{},
-		"dst_user = ? AND stamp <= ?",
-		user.UserID,
-		stamp,
-	)
+	// Delete, checking the sequence number
+	res := db.Where(
+		pendingFor, sql.Named("user", user.UserID),
+	).Where(
+		"sequence <= ?", seq,
+	).Delete(&Message{})
 
 	if res.Error != nil {
 		log.DBError(res.Error)
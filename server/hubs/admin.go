@@ -2,6 +2,8 @@ package hubs
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sprinter05/gochat/internal/log"
@@ -18,6 +20,11 @@ var adminPerms map[spec.Admin]db.Permission = map[spec.Admin]db.Permission{
 	spec.AdminChangePerms: db.OWNER,
 	spec.AdminDisconnect:  db.ADMIN,
 	spec.AdminMotd:        db.OWNER,
+	spec.AdminCancel:      db.OWNER,
+	spec.AdminExpire:      db.OWNER,
+	spec.AdminPrune:       db.OWNER,
+	spec.AdminGetPerms:    db.ADMIN,
+	spec.AdminResetPerms:  db.OWNER,
 }
 
 var adminLookup map[spec.Admin]action = map[spec.Admin]action{
@@ -27,6 +34,11 @@ var adminLookup map[spec.Admin]action = map[spec.Admin]action{
 	spec.AdminChangePerms: adminChangePerms,
 	spec.AdminDisconnect:  adminDisconnect,
 	spec.AdminMotd:        adminChangeMotd,
+	spec.AdminCancel:      adminCancelShutdown,
+	spec.AdminExpire:      adminExpireTokens,
+	spec.AdminPrune:       adminPruneInactive,
+	spec.AdminGetPerms:    adminGetPerms,
+	spec.AdminResetPerms:  adminResetPerms,
 }
 
 /* WRAPPER FUNCTIONS */
@@ -49,13 +61,6 @@ func adminOperation(h *Hub, u User, cmd spec.Command) {
 		return
 	}
 
-	// No need to check for -1 since we already checked if it existed
-	args := spec.AdminArgs(op)
-	if int(cmd.HD.Args) < args {
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
-		return
-	}
-
 	perms := adminPerms[op]
 	if u.perms < perms {
 		SendErrorPacket(cmd.HD.ID, spec.ErrorPrivileges, u.conn)
@@ -67,32 +72,45 @@ func adminOperation(h *Hub, u User, cmd spec.Command) {
 
 /* COMMANDS */
 
-// Shuts down the server at a certain time.
+// Shuts down the server at a certain time, broadcasting
+// a warning to all online users at the given times.
 //
 // Requires ADMIN or more.
-// Uses 1 argument for the unix stamp
+// Uses 1 argument for the unix stamp and optionally
+// 1 argument for each warning in minutes before it
 func adminShutdown(h *Hub, u User, cmd spec.Command) {
 	stamp, err := spec.BytesToUnixStamp(cmd.Args[0])
 	if err != nil {
 		// Invalid number given
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "invalid timestamp"), u.conn)
 		return
 	}
 
 	duration := time.Until(stamp)
 	if duration < 0 {
 		// Invalid duration
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "shutdown time is in the past"), u.conn)
 		return
 	}
 
-	// Block until the specified time
-	go func() {
-		time.Sleep(duration)
+	warnings := make([]time.Duration, 0, len(cmd.Args)-1)
+	for _, v := range cmd.Args[1:] {
+		mins, err := spec.BytesToCount(v)
+		if err != nil || mins == 0 {
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "invalid warning time"), u.conn)
+			return
+		}
+		warnings = append(warnings, time.Duration(mins)*time.Minute)
+	}
 
-		// Send shutdown signal to hub
-		h.close()
-	}()
+	warn := func(left time.Duration) {
+		mins := int(left.Round(time.Minute).Minutes())
+		h.Broadcast(fmt.Sprintf("the server will shut down for maintenance in %d minutes", mins), u)
+		log.Notice(fmt.Sprintf("shutdown warning sent, %d minutes left", mins))
+	}
+
+	// Replaces any previously scheduled shutdown
+	h.ScheduleShutdown(duration, warn, warnings...)
 
 	pak, err := spec.NewPacket(spec.SHTDWN, spec.NullID, spec.EmptyInfo, cmd.Args[0])
 	if err != nil {
@@ -138,7 +156,7 @@ func adminDeregister(h *Hub, u User, cmd spec.Command) {
 	if err != nil {
 		if errors.Is(err, db.ErrorNotFound) {
 			// Invalid user provided
-			SendErrorPacket(cmd.HD.ID, spec.ErrorNotFound, u.conn)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user does not exist"), u.conn)
 		} else {
 			SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
 		}
@@ -162,6 +180,7 @@ func adminDeregister(h *Hub, u User, cmd spec.Command) {
 	if ok {
 		// We close the connection with the target,
 		// also triggering the cleanup function
+		SendKickPacket(spec.KickBanned, "", dc.conn)
 		dc.conn.Close()
 	}
 
@@ -177,7 +196,7 @@ func adminChangePerms(h *Hub, u User, cmd spec.Command) {
 
 	if dest == u.name {
 		// Cannot change your own permissions
-		SendErrorPacket(cmd.HD.ID, spec.ErrorInvalid, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "cannot change your own permissions"), u.conn)
 		return
 	}
 
@@ -185,7 +204,7 @@ func adminChangePerms(h *Hub, u User, cmd spec.Command) {
 	if err != nil {
 		if errors.Is(err, db.ErrorNotFound) {
 			// Invalid user provided
-			SendErrorPacket(cmd.HD.ID, spec.ErrorNotFound, u.conn)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user does not exist"), u.conn)
 		} else {
 			SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
 		}
@@ -195,66 +214,93 @@ func adminChangePerms(h *Hub, u User, cmd spec.Command) {
 	level, err := spec.BytesToPermission(cmd.Args[1])
 	if err != nil {
 		// Invalid permission provided
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "invalid permission level"), u.conn)
 		return
 	}
 
 	check := db.PermissionExists(level)
 	if !check {
 		// Invalid permisison provided
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "permission level does not exist"), u.conn)
 		return
 	}
 
-	if uint(u.perms) <= level {
-		// Cannot change perms that are over your permissions
-		SendErrorPacket(cmd.HD.ID, spec.ErrorPrivileges, u.conn)
+	setPermission(h, u, cmd, target, db.Permission(level))
+}
+
+// Queries the permission level of any user, which
+// unlike REQ also works for deregistered users.
+//
+// Requires ADMIN or more
+// Requires 1 argument for the user
+func adminGetPerms(h *Hub, u User, cmd spec.Command) {
+	target, err := db.QueryUser(h.db, string(cmd.Args[0]))
+	if err != nil {
+		if errors.Is(err, db.ErrorNotFound) {
+			// Invalid user provided
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user does not exist"), u.conn)
+		} else {
+			SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+		}
 		return
 	}
 
-	if uint(u.perms) <= uint(target.Permission) {
-		// Cannot change permissions of someone with more
-		SendErrorPacket(cmd.HD.ID, spec.ErrorPrivileges, u.conn)
+	if !db.PermissionExists(uint(target.Permission)) {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorCorrupted, u.conn)
 		return
 	}
 
-	if uint(target.Permission) == level {
-		// Cannot change permissions if they are the same
-		SendErrorPacket(cmd.HD.ID, spec.ErrorInvalid, u.conn)
+	pak, err := spec.NewPacket(spec.OK, cmd.HD.ID, spec.EmptyInfo,
+		[]byte{
+			byte(target.Permission),
+		},
+	)
+	if err != nil {
+		log.Packet(spec.OK, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
 		return
 	}
 
-	// Update in database, we do not check error
-	// because it was already queried
-	new := db.Permission(level)
-	err = db.ChangePermission(h.db, dest, new)
-	if err != nil {
-		log.DBError(err)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+	u.conn.Write(pak)
+}
+
+// Resets the permission level of a user to the
+// one every user gets when registering.
+//
+// Requires OWNER or more
+// Requires 1 argument for the user
+func adminResetPerms(h *Hub, u User, cmd spec.Command) {
+	dest := string(cmd.Args[0])
+
+	if dest == u.name {
+		// Cannot change your own permissions
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "cannot change your own permissions"), u.conn)
+		return
 	}
 
-	// Update if online
-	chg, ok := h.FindUser(string(cmd.Args[0]))
-	if ok {
-		chg.perms = new
-		go h.Notify(
-			spec.HookPermsChange, nil,
-			[]byte(dest),
-			[]byte{byte(level)},
-		)
+	target, err := db.QueryUser(h.db, dest)
+	if err != nil {
+		if errors.Is(err, db.ErrorNotFound) {
+			// Invalid user provided
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user does not exist"), u.conn)
+		} else {
+			SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+		}
+		return
 	}
 
-	SendOKPacket(cmd.HD.ID, u.conn)
+	setPermission(h, u, cmd, target, db.DefaultPermission)
 }
 
-// Disconnects an online user if it's connected.
+// Disconnects an online user if it's connected,
+// telling it why if a reason is given.
 //
 // Requires ADMIN or more
-// Requires 1 argument for the user
+// Requires 1 argument for the user and 1 optional for the reason
 func adminDisconnect(h *Hub, u User, cmd spec.Command) {
 	dc, ok := h.FindUser(string(cmd.Args[0]))
 	if !ok {
-		SendErrorPacket(cmd.HD.ID, spec.ErrorNotFound, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user is not online"), u.conn)
 		return
 	}
 
@@ -263,8 +309,14 @@ func adminDisconnect(h *Hub, u User, cmd spec.Command) {
 		return
 	}
 
+	var reason string
+	if len(cmd.Args) > 1 {
+		reason = string(cmd.Args[1])
+	}
+
 	// This should trigger the cleanup on
 	// the goroutine listening to the client
+	SendKickPacket(spec.KickAdmin, reason, dc.conn)
 	dc.conn.Close()
 
 	SendOKPacket(cmd.HD.ID, u.conn)
@@ -275,6 +327,213 @@ func adminDisconnect(h *Hub, u User, cmd spec.Command) {
 // Requires OWNER or more
 // Requires 1 argument for the new MOTD
 func adminChangeMotd(h *Hub, u User, cmd spec.Command) {
-	h.motd = string(cmd.Args[0])
+	h.SetMotd(string(cmd.Args[0]))
+	SendOKPacket(cmd.HD.ID, u.conn)
+}
+
+// Cancels a previously scheduled shutdown and
+// notifies all users of the cancellation.
+//
+// Requires OWNER or more
+// Requires no arguments
+func adminCancelShutdown(h *Hub, u User, cmd spec.Command) {
+	if !h.CancelShutdown() {
+		err := spec.ErrorWithDetail(spec.ErrorNotFound, "no shutdown is scheduled")
+		SendErrorPacket(cmd.HD.ID, err, u.conn)
+		return
+	}
+
+	// A SHTDWN without arguments means cancellation
+	pak, err := spec.NewPacket(spec.SHTDWN, spec.NullID, spec.EmptyInfo)
+	if err != nil {
+		log.Packet(spec.SHTDWN, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+
+	list := h.users.GetAll()
+	for _, v := range list {
+		v.conn.Write(pak)
+	}
+
+	log.Notice("scheduled server shutdown cancelled")
+	SendOKPacket(cmd.HD.ID, u.conn)
+}
+
+// Expires all reusable tokens, forcing every user to go
+// through the whole handshake when logging in again.
+// If only the token of a user is expired, its session is
+// also revoked as long as it has less permissions.
+//
+// Requires OWNER or more
+// Uses 1 optional argument to only expire the token of a user
+func adminExpireTokens(h *Hub, u User, cmd spec.Command) {
+	var target string
+	if len(cmd.Args) > 0 {
+		target = string(cmd.Args[0])
+	}
+
+	count := h.ExpireTokens(target)
+	if target == "" {
+		log.Notice(fmt.Sprintf(
+			"%s expired all reusable tokens (%d)",
+			u.name, count,
+		))
+	} else {
+		log.Notice(fmt.Sprintf(
+			"%s expired the reusable token of %s (%d)",
+			u.name, target, count,
+		))
+
+		// This should trigger the cleanup on
+		// the goroutine listening to the client
+		dc, ok := h.FindUser(target)
+		if ok && dc.conn != u.conn && u.perms > dc.perms {
+			SendKickPacket(spec.KickRevoked, "", dc.conn)
+			dc.conn.Close()
+		}
+	}
+
+	SendOKPacket(cmd.HD.ID, u.conn)
+}
+
+// Deregisters all users that have not been seen for a given
+// amount of days, keeping their cached messages as with
+// ADMIN_DEREG. Users that are online or that have the same or
+// more permissions are skipped. In a dry run nobody is deregistered
+// and only the users that would be affected are reported.
+//
+// Requires OWNER or more
+// Requires 1 argument for the days and 1 optional for the dry run
+func adminPruneInactive(h *Hub, u User, cmd spec.Command) {
+	days, err := spec.BytesToCount(cmd.Args[0])
+	if err != nil || days == 0 {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "invalid amount of days"), u.conn)
+		return
+	}
+
+	dry := len(cmd.Args) > 1 && len(cmd.Args[1]) > 0 && cmd.Args[1][0] != 0
+
+	since := time.Now().AddDate(0, 0, -int(days))
+	list, err := db.QueryInactiveUsers(h.db, since)
+	if err != nil {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+		return
+	}
+
+	pruned := make([]string, 0, len(list))
+	for _, v := range list {
+		if uint(u.perms) <= uint(v.Permission) {
+			// Same rules as deregistering a single user
+			continue
+		}
+
+		if _, ok := h.FindUser(v.Username); ok {
+			// Still in use even if the last login is old
+			continue
+		}
+
+		if dry {
+			log.Notice(fmt.Sprintf(
+				"%s would prune %s (last seen %s)",
+				u.name, v.Username, v.LastSeen.Format(time.DateTime),
+			))
+			pruned = append(pruned, v.Username)
+			continue
+		}
+
+		err := db.RemoveKey(h.db, v.Username)
+		if err != nil {
+			log.DB("pruning "+v.Username, err)
+			continue
+		}
+
+		log.Notice(fmt.Sprintf(
+			"%s pruned %s (last seen %s)",
+			u.name, v.Username, v.LastSeen.Format(time.DateTime),
+		))
+		pruned = append(pruned, v.Username)
+	}
+
+	log.Notice(fmt.Sprintf(
+		"%s pruned users inactive for %d days (%d, dry run: %t)",
+		u.name, days, len(pruned), dry,
+	))
+
+	count := spec.CountToBytes(uint64(len(pruned)))
+	args := [][]byte{count}
+	if len(pruned) > 0 {
+		args = append(args, []byte(strings.Join(pruned, "\n")))
+	}
+
+	pak, err := spec.NewPacket(spec.OK, cmd.HD.ID, spec.EmptyInfo, args...)
+	if err != nil {
+		// Too many names to fit, only the amount is sent
+		pak, err = spec.NewPacket(spec.OK, cmd.HD.ID, spec.EmptyInfo, count)
+	}
+
+	if err != nil {
+		log.Packet(spec.OK, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+
+	u.conn.Write(pak)
+}
+
+/* AUXILIARY FUNCTIONS */
+
+// Changes the permission level of a user that has already been
+// queried if the requesting user is allowed to, notifying the
+// change if the user is online and replying to the request.
+func setPermission(h *Hub, u User, cmd spec.Command, target *db.User, new db.Permission) {
+	dest := target.Username
+	level := uint(new)
+
+	if uint(u.perms) <= level {
+		// Cannot change perms that are over your permissions
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPrivileges, u.conn)
+		return
+	}
+
+	if uint(u.perms) <= uint(target.Permission) {
+		// Cannot change permissions of someone with more
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPrivileges, u.conn)
+		return
+	}
+
+	if uint(target.Permission) == level {
+		// Cannot change permissions if they are the same
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "user already has that permission level"), u.conn)
+		return
+	}
+
+	// Update in database, we do not check error
+	// because it was already queried
+	err := db.ChangePermission(h.db, dest, new)
+	if err != nil {
+		log.DBError(err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+		return
+	}
+
+	log.Notice(fmt.Sprintf(
+		"%s changed the permissions of %s from %s to %s",
+		u.name, dest,
+		db.PermissionString(target.Permission),
+		db.PermissionString(new),
+	))
+
+	// Update if online
+	chg, ok := h.FindUser(dest)
+	if ok {
+		chg.perms = new
+		go h.Notify(
+			spec.HookPermsChange, nil,
+			[]byte(dest),
+			[]byte{byte(level)},
+		)
+	}
+
 	SendOKPacket(cmd.HD.ID, u.conn)
 }
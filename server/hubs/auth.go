@@ -0,0 +1,81 @@
+package hubs
+
+import "github.com/Sprinter05/gochat/internal/spec"
+
+/* TYPES */
+
+// Specifies how a user proves their identity when logging in.
+// The challenge is sent to the client in a VERIF packet and the
+// expected answer is kept by the hub until the client replies.
+//
+// If the backend allows reusable tokens, the accepted answer is
+// kept after a successful verification on a secure connection and
+// any token provided in a later LOGIN goes through Verify again.
+type Authenticator interface {
+	// Returns the challenge to send and the answer expected for it.
+	// The error must be a specification error.
+	Challenge(u User) (challenge []byte, expected []byte, err error)
+
+	// Checks the answer given by the client against the expected one.
+	// The error must be a specification error.
+	Verify(u User, expected []byte, answer []byte) error
+
+	// Whether an accepted answer may be used as a reusable token.
+	Reusable() bool
+}
+
+// Default authentication that encrypts a random text with the
+// public key of the user, who must reply with the decrypted text.
+type ChallengeAuth struct{}
+
+/* LOOKUP */
+
+// Name of the authentication backend used if none is configured.
+const DefaultAuth string = "challenge"
+
+var authLookup map[string]Authenticator = map[string]Authenticator{
+	DefaultAuth: ChallengeAuth{},
+}
+
+/* AUTHENTICATORS */
+
+// Returns the authentication backend associated to a name,
+// using the default one if the name is empty.
+func AuthBackend(name string) (Authenticator, bool) {
+	if name == "" {
+		name = DefaultAuth
+	}
+
+	v, ok := authLookup[name]
+	return v, ok
+}
+
+func (ChallengeAuth) Challenge(u User) ([]byte, []byte, error) {
+	// Smaller keys cannot hold the default challenge, and keys
+	// too small to hold a secure one are treated as corrupted
+	size := min(spec.ChallengeSize, spec.MaxEncryptSize(u.pubkey))
+	ran, err := spec.RandText(size)
+	if err != nil {
+		return nil, nil, spec.ErrorCorrupted
+	}
+
+	enc, err := spec.EncryptText(ran, u.pubkey)
+	if err != nil {
+		// This shouldnt happen, it means the database for the user is corrupted
+		return nil, nil, spec.ErrorCorrupted
+	}
+
+	return enc, ran, nil
+}
+
+func (ChallengeAuth) Verify(u User, expected []byte, answer []byte) error {
+	if !spec.SecureCompare(expected, answer) {
+		return spec.ErrorHandshake
+	}
+
+	return nil
+}
+
+func (ChallengeAuth) Reusable() bool {
+	return true
+}
@@ -1,7 +1,6 @@
 package hubs
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"net"
@@ -30,21 +29,34 @@ type Request struct {
 // asocciated channel will block once this limit is reached.
 const MaxUserRequests int = 5
 
+// Actions that only read shared data, which means they can
+// run at the same time as other actions of the same connection.
+// Any other action must run in the order it was received.
+var concurrentOps = []spec.Action{
+	spec.REQ,
+	spec.USRS,
+	spec.SUBLIST,
+}
+
 /* LOOKUP */
 
 var cmdLookup map[spec.Action]action = map[spec.Action]action{
-	spec.REG:    registerUser,
-	spec.LOGIN:  loginUser,
-	spec.VERIF:  verifyUser,
-	spec.LOGOUT: logoutUser,
-	spec.DEREG:  deregisterUser,
-	spec.REQ:    requestUser,
-	spec.USRS:   listUsers,
-	spec.MSG:    messageUser,
-	spec.RECIV:  recivMessages,
-	spec.ADMIN:  adminOperation,
-	spec.SUB:    subscribeHook,
-	spec.UNSUB:  unsubscribeHook,
+	spec.REG:     registerUser,
+	spec.LOGIN:   loginUser,
+	spec.VERIF:   verifyUser,
+	spec.LOGOUT:  logoutUser,
+	spec.DEREG:   deregisterUser,
+	spec.REQ:     requestUser,
+	spec.USRS:    listUsers,
+	spec.MSG:     messageUser,
+	spec.RECIV:   recivMessages,
+	spec.ADMIN:   adminOperation,
+	spec.SUB:     subscribeHook,
+	spec.UNSUB:   unsubscribeHook,
+	spec.SUBLIST: listSubscriptions,
+	spec.ECHO:    echoPayload,
+	spec.PROFILE: profileUser,
+	spec.SIGNAL:  signalUser,
 }
 
 /* WRAPPER FUNCTIONS */
@@ -64,10 +76,34 @@ func Process(h *Hub, r Request, u User) {
 		return
 	}
 
+	// Handlers index arguments directly so
+	// they must never run with fewer than needed
+	if err := spec.ValidateServerCommand(r.Command); err != nil {
+		log.User(string(u.name), spec.CodeToString(id), err)
+		SendErrorPacket(r.Command.HD.ID, err, r.Conn)
+		return
+	}
+
+	// A failing handler must only affect its connection
+	defer func() {
+		if err := recover(); err != nil {
+			log.Panic(spec.CodeToString(id), r.Conn.RemoteAddr().String(), err)
+			SendErrorPacket(r.Command.HD.ID, spec.ErrorServer, r.Conn)
+			h.Cleanup(r.Conn)
+			r.Conn.Close()
+		}
+	}()
+
 	// Run command
 	fun(h, u, r.Command)
 }
 
+// Returns true if the action does not need to wait
+// for the previous actions of the connection to finish.
+func Concurrent(op spec.Action) bool {
+	return slices.Contains(concurrentOps, op)
+}
+
 /* COMMANDS */
 
 // Registers a new user into the database, also filling the
@@ -79,7 +115,7 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 
 	if len(uname) > spec.UsernameSize {
 		log.User(string(uname), "username registration", spec.ErrorMaxSize)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "username too long"), u.conn)
 		return
 	}
 
@@ -92,7 +128,14 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 
 	if !match {
 		log.User(string(uname), "username registration", spec.ErrorArguments)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "username has invalid characters"), u.conn)
+		return
+	}
+
+	// Check if the operators disallow the username
+	if reason := h.NameFilter().Deny(uname); reason != "" {
+		log.User(string(uname), "username registration", spec.ErrorArguments)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, reason), u.conn)
 		return
 	}
 
@@ -100,7 +143,7 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 	_, err = spec.PEMToPubkey(cmd.Args[1])
 	if err != nil {
 		log.User(string(uname), "pubkey registration", err)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "public key is not valid"), u.conn)
 		return
 	}
 
@@ -110,7 +153,7 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 		log.User(string(uname), "registration", err)
 		if errors.Is(err, db.ErrorDuplicatedKey) {
 			// User already exists
-			SendErrorPacket(cmd.HD.ID, spec.ErrorExists, u.conn)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorExists, "username already taken"), u.conn)
 		} else if errors.Is(err, db.ErrorNullPubkey) {
 			// Public key is null (deregistered)
 			SendErrorPacket(cmd.HD.ID, spec.ErrorDeregistered, u.conn)
@@ -121,6 +164,7 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 		return
 	}
 
+	h.identified(u.conn)
 	SendOKPacket(cmd.HD.ID, u.conn)
 }
 
@@ -130,7 +174,7 @@ func registerUser(h *Hub, u User, cmd spec.Command) {
 // Replies with VERIF, OK or ERR
 func loginUser(h *Hub, u User, cmd spec.Command) {
 	// Check if it can be logged in through a reusable token
-	if int(cmd.HD.Args) > spec.ServerArgs(cmd.HD.Op) {
+	if len(cmd.Args) > spec.ServerArgs(cmd.HD.Op) {
 		err := h.checkToken(u, cmd.Args[1])
 		if err != nil {
 			SendErrorPacket(cmd.HD.ID, err, u.conn)
@@ -139,25 +183,32 @@ func loginUser(h *Hub, u User, cmd spec.Command) {
 
 		// Cache the user
 		h.users.Add(u.conn, &u)
-		go h.Notify(
-			spec.HookNewLogin, nil,
-			[]byte(u.name),
-			[]byte{byte(u.perms)},
-		)
-		SendOKPacket(cmd.HD.ID, u.conn)
+		h.identified(u.conn)
+		go h.notifyLogin(u)
+		sendLoginOK(h, u, cmd.HD.ID)
+		return
+	}
+
+	// Decoys get random bytes that look like a challenge
+	if u.decoy {
+		vpak, err := spec.NewPacket(spec.VERIF, cmd.HD.ID, spec.EmptyInfo, randChallenge())
+		if err != nil {
+			log.Packet(spec.VERIF, err)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+			return
+		}
+		u.conn.Write(vpak) // send VERIF
 		return
 	}
 
-	ran := randText()
-	enc, err := spec.EncryptText(ran, u.pubkey)
+	enc, ran, err := h.auth.Challenge(u)
 	if err != nil {
-		// This shouldnt happen, it means the database for the user is corrupted
-		SendErrorPacket(cmd.HD.ID, spec.ErrorCorrupted, u.conn)
-		log.DB(string(u.name)+"'s pubkey", err)
+		SendErrorPacket(cmd.HD.ID, err, u.conn)
+		log.User(string(u.name), "authentication challenge", err)
 		return
 	}
 
-	// We create and send the packet with the enconded text
+	// We create and send the packet with the challenge
 	vpak, err := spec.NewPacket(spec.VERIF, cmd.HD.ID, spec.EmptyInfo, enc)
 	if err != nil {
 		log.Packet(spec.VERIF, err)
@@ -198,20 +249,41 @@ func loginUser(h *Hub, u User, cmd spec.Command) {
 //
 // Replies with OK or ERR
 func verifyUser(h *Hub, u User, cmd spec.Command) {
+	// Decoys fail just like a wrong answer would
+	if u.decoy {
+		h.Cleanup(u.conn)
+		log.User(string(u.name), "verification validation", spec.ErrorHandshake)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorHandshake, u.conn)
+		return
+	}
+
 	verif, ok := h.verifs.Get(u.name)
 
+	if !ok && h.Hidden() {
+		// Same reply as a decoy so the user is not revealed
+		h.Cleanup(u.conn)
+		log.User(string(u.name), "verification existance", spec.ErrorHandshake)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorHandshake, u.conn)
+		return
+	}
+
 	if !ok {
 		log.User(string(u.name), "verification existance", spec.ErrorNotFound)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorInvalid, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "no pending verification"), u.conn)
 		return
 	}
 
-	if !bytes.Equal(verif.text, cmd.Args[1]) || verif.conn != u.conn {
+	err := h.auth.Verify(u, verif.text, cmd.Args[1])
+	if verif.conn != u.conn {
+		err = spec.ErrorHandshake
+	}
+
+	if err != nil {
 		// Incorrect verification so we cancel the handshake process
 		verif.cancel()
 		h.Cleanup(u.conn)
-		log.User(string(u.name), "verification validation", spec.ErrorHandshake)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorHandshake, u.conn)
+		log.User(string(u.name), "verification validation", err)
+		SendErrorPacket(cmd.HD.ID, err, u.conn)
 		return
 	}
 
@@ -219,13 +291,10 @@ func verifyUser(h *Hub, u User, cmd spec.Command) {
 	// We modify the tables and cancel the goroutine
 	verif.cancel()
 	h.users.Add(u.conn, &u)
-	go h.Notify(
-		spec.HookNewLogin, nil,
-		[]byte(u.name),
-		[]byte{byte(u.perms)},
-	)
+	h.identified(u.conn)
+	go h.notifyLogin(u)
 
-	if u.secure {
+	if u.secure && h.auth.Reusable() {
 		// If we are using TLS we mark a soft delete,
 		// that way it can remain as a reusable token.
 		verif.pending = false
@@ -234,7 +303,7 @@ func verifyUser(h *Hub, u User, cmd spec.Command) {
 		h.verifs.Remove(u.name)
 	}
 
-	SendOKPacket(cmd.HD.ID, u.conn)
+	sendLoginOK(h, u, cmd.HD.ID)
 }
 
 // Marks an online user as offline.
@@ -254,6 +323,9 @@ func logoutUser(h *Hub, u User, cmd spec.Command) {
 	// Otherwise we cleanup
 	h.Cleanup(u.conn)
 
+	// It must authenticate again before it expires
+	h.Anonymous(u.conn)
+
 	SendOKPacket(cmd.HD.ID, u.conn)
 }
 
@@ -271,6 +343,7 @@ func deregisterUser(h *Hub, u User, cmd spec.Command) {
 
 	// Log the user out
 	h.Cleanup(u.conn)
+	h.Anonymous(u.conn)
 	SendOKPacket(cmd.HD.ID, u.conn)
 }
 
@@ -281,7 +354,7 @@ func requestUser(h *Hub, u User, cmd spec.Command) {
 	req, err := h.userFromDB(string(cmd.Args[0]))
 	if err != nil {
 		log.DB(string(u.name)+"'s account", err)
-		SendErrorPacket(cmd.HD.ID, err, u.conn)
+		SendErrorPacket(cmd.HD.ID, h.hideUser(err), u.conn)
 		return
 	}
 
@@ -294,13 +367,20 @@ func requestUser(h *Hub, u User, cmd spec.Command) {
 	}
 
 	// We reply with the username that was requested as well
-	pak, err := spec.NewPacket(spec.REQ, cmd.HD.ID, spec.EmptyInfo,
+	args := [][]byte{
 		[]byte(req.name),
 		p,
 		[]byte{
 			byte(req.perms),
 		},
-	)
+	}
+
+	// The bio is only sent if the user has set one
+	if req.bio != "" {
+		args = append(args, []byte(req.bio))
+	}
+
+	pak, err := spec.NewPacket(spec.REQ, cmd.HD.ID, spec.EmptyInfo, args...)
 	if err != nil {
 		log.Packet(spec.REQ, err)
 		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
@@ -318,11 +398,18 @@ func listUsers(h *Hub, u User, cmd spec.Command) {
 	online := cmd.HD.Info
 	ulist := spec.Userlist(online)
 
+	// The server may restrict who can see other users
+	if uint(u.perms) < h.Listing().Required(ulist) {
+		log.User(string(u.name), "userlist permissions", spec.ErrorPrivileges)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorPrivileges, "listing these users is restricted"), u.conn)
+		return
+	}
+
 	usrs := h.Userlist(ulist)
 	if usrs == "" {
 		// Error due to invalid argument in header info
 		log.User(string(u.name), "userlist argument", spec.ErrorOption)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorOption, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorOption, "unknown userlist option"), u.conn)
 		return
 	}
 
@@ -337,21 +424,35 @@ func listUsers(h *Hub, u User, cmd spec.Command) {
 
 // Sends a message to a user, if said user is online, a RECIV
 // packet will be sent directly, otherwise it will be stored
-// in the database for future retrieval.
+// in the database for future retrieval. Copies of sent messages
+// addressed to the sender itself are always stored, as the
+// sender cannot have another session to deliver them to.
 //
 // Replies with OK or ERR
 func messageUser(h *Hub, u User, cmd spec.Command) {
-	// Cannot send to self
-	if string(cmd.Args[0]) == u.name {
-		SendErrorPacket(cmd.HD.ID, spec.ErrorInvalid, u.conn)
+	// Broadcasts can only be sent with ADMIN_BRDCAST
+	msgType := spec.InfoToMsgType(cmd.HD.Info)
+	if msgType == spec.MsgBroadcast {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorOption, "cannot send a broadcast as a message"), u.conn)
 		return
 	}
 
+	// Only copies of sent messages, which carry the recipient
+	// of the original message, can be addressed to the sender
+	var recipient string
+	if string(cmd.Args[0]) == u.name {
+		if len(cmd.Args) < 4 || string(cmd.Args[3]) == u.name {
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "cannot message yourself"), u.conn)
+			return
+		}
+		recipient = string(cmd.Args[3])
+	}
+
 	// Check if its online cached
 	send, ok := h.FindUser(string(cmd.Args[0]))
-	if ok {
+	if ok && recipient == "" {
 		// We send the message directly to the connection
-		pak, err := spec.NewPacket(spec.RECIV, spec.NullID, spec.EmptyInfo,
+		pak, err := spec.NewPacket(spec.RECIV, spec.NullID, byte(msgType),
 			[]byte(u.name),
 			cmd.Args[1],
 			cmd.Args[2],
@@ -369,24 +470,49 @@ func messageUser(h *Hub, u User, cmd spec.Command) {
 
 	// We check if the user is still registered
 	uname := string(cmd.Args[0])
-	_, err := h.userFromDB(uname)
+	target := uname
+	if recipient != "" {
+		target = recipient
+	}
+	stamp, err := spec.BytesToUnixStamp(cmd.Args[1])
 	if err != nil {
-		SendErrorPacket(cmd.HD.ID, err, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "invalid timestamp"), u.conn)
 		return
 	}
 
-	// Otherwise we just send it to the message cache
-	stamp, err := spec.BytesToUnixStamp(cmd.Args[1])
+	_, err = h.userFromDB(target)
 	if err != nil {
-		SendErrorPacket(cmd.HD.ID, spec.ErrorArguments, u.conn)
+		// Messages to unknown users are dropped as if they were sent
+		if h.Hidden() && errors.Is(h.hideUser(err), spec.ErrorNotFound) {
+			SendOKPacket(cmd.HD.ID, u.conn)
+			return
+		}
+
+		SendErrorPacket(cmd.HD.ID, err, u.conn)
 		return
 	}
-	err = db.CacheMessage(h.db, uname, spec.Message{
-		Sender:  u.name,
-		Content: cmd.Args[2],
-		Stamp:   stamp,
-	})
+
+	// Otherwise we just send it to the message cache
+
+	msg := spec.Message{
+		Sender:    u.name,
+		Content:   cmd.Args[2],
+		Stamp:     stamp,
+		Recipient: recipient,
+		Type:      msgType,
+	}
+
+	// The message must fit in the cache of its owner
+	if quota := h.Quota(); quota > 0 {
+		err = db.CacheMessageWithin(h.db, uname, msg, quota)
+	} else {
+		err = db.CacheMessage(h.db, uname, msg)
+	}
 	if err != nil {
+		if errors.Is(err, db.ErrorQuota) {
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorMaxSize, "message cache of the user is full"), u.conn)
+			return
+		}
 		if errors.Is(err, db.ErrorNotFound) {
 			SendErrorPacket(cmd.HD.ID, spec.ErrorNotFound, u.conn)
 			return
@@ -422,10 +548,12 @@ func recivMessages(h *Hub, u User, cmd spec.Command) {
 	SendOKPacket(cmd.HD.ID, u.conn) // confirm query
 	catchUp(u.conn, msgs...)        // send RECIV(s)
 
-	// Get the timestamp of the newest message as threshold for deletion
-	size := len(msgs)
-	ts := msgs[size-1].Stamp
-	err = db.RemoveMessages(h.db, u.name, ts)
+	// Get the sequence of the newest message as threshold for deletion
+	var last uint64
+	for _, v := range msgs {
+		last = max(last, v.Sequence)
+	}
+	err = db.RemoveMessages(h.db, u.name, last)
 	if err != nil {
 		// We dont send an ERR here or we would be sending 2 packets
 		log.DB("deleting cached messages for "+string(u.name), err)
@@ -441,7 +569,7 @@ func subscribeHook(h *Hub, u User, cmd spec.Command) {
 	if !slices.Contains(spec.Hooks, hook) && hook != spec.HookAllHooks {
 		// Provided hook does not exist
 		log.User(string(u.name), "invalid hook", spec.ErrorOption)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorOption, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorOption, "unknown hook"), u.conn)
 		return
 	}
 
@@ -469,7 +597,7 @@ func subscribeHook(h *Hub, u User, cmd spec.Command) {
 			}
 
 			// User is already subscribed
-			SendErrorPacket(cmd.HD.ID, spec.ErrorExists, u.conn)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorExists, "already subscribed to hook"), u.conn)
 			return
 		}
 
@@ -489,7 +617,7 @@ func unsubscribeHook(h *Hub, u User, cmd spec.Command) {
 	if !slices.Contains(spec.Hooks, hook) && hook != spec.HookAllHooks {
 		// Provided hook does not exist
 		log.User(string(u.name), "invalid hook", spec.ErrorOption)
-		SendErrorPacket(cmd.HD.ID, spec.ErrorOption, u.conn)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorOption, "unknown hook"), u.conn)
 		return
 	}
 
@@ -517,7 +645,7 @@ func unsubscribeHook(h *Hub, u User, cmd spec.Command) {
 			}
 
 			// User cannot be unsubscribed if not subscribed
-			SendErrorPacket(cmd.HD.ID, spec.ErrorNotFound, u.conn)
+			SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "not subscribed to hook"), u.conn)
 			return
 		}
 
@@ -527,3 +655,137 @@ func unsubscribeHook(h *Hub, u User, cmd spec.Command) {
 
 	SendOKPacket(cmd.HD.ID, u.conn)
 }
+
+// Lists all hooks the user is currently subscribed to,
+// each hook being a single byte of the argument.
+//
+// Replies with SUBLIST or ERR
+func listSubscriptions(h *Hub, u User, cmd spec.Command) {
+	list := make([]byte, 0, len(spec.Hooks))
+	for _, v := range spec.Hooks {
+		sl, ok := h.subs.Get(v)
+		if !ok {
+			//! This means the hook slice no longer exists even though it should
+			SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+			log.Fatal("hub hook slices", spec.ErrorNotFound)
+			return
+		}
+
+		if sl.Has(u.conn) {
+			list = append(list, byte(v))
+		}
+	}
+
+	if len(list) == 0 {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorEmpty, "not subscribed to any hook"), u.conn)
+		return
+	}
+
+	pak, err := spec.NewPacket(spec.SUBLIST, cmd.HD.ID, spec.EmptyInfo, list)
+	if err != nil {
+		log.Packet(spec.SUBLIST, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+	u.conn.Write(pak) // send SUBLIST
+}
+
+// Sends back the payload received, used by clients
+// to measure the latency and throughput of the link.
+// The amount of echoes per connection is limited.
+//
+// Replies with ECHO or ERR
+func echoPayload(h *Hub, u User, cmd spec.Command) {
+	window := time.Duration(spec.EchoWindow) * time.Second
+	w, ok := h.echoes.Get(u.conn)
+	if !ok || time.Since(w.start) > window {
+		w = &echoWindow{start: time.Now()}
+		h.echoes.Add(u.conn, w)
+	}
+
+	if w.count >= uint(spec.MaxEchoes) {
+		log.User(string(u.name), "echo", spec.ErrorInvalid)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "too many echo requests"), u.conn)
+		return
+	}
+	w.count += 1
+
+	pak, err := spec.NewPacket(spec.ECHO, cmd.HD.ID, spec.EmptyInfo, cmd.Args[0])
+	if err != nil {
+		log.Packet(spec.ECHO, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+	u.conn.Write(pak) // send ECHO
+}
+
+// Changes the profile bio of the user, which is returned
+// to anyone requesting the user. Sending no bio or an
+// empty one removes the stored bio.
+//
+// Replies with OK or ERR
+func profileUser(h *Hub, u User, cmd spec.Command) {
+	var bio string
+	if len(cmd.Args) > 0 {
+		bio = sanitizeBio(string(cmd.Args[0]))
+	}
+
+	if len(bio) > spec.MaxBio {
+		log.User(string(u.name), "profile bio", spec.ErrorArguments)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "bio is too long"), u.conn)
+		return
+	}
+
+	err := db.UpdateBio(h.db, u.name, bio)
+	if err != nil {
+		log.DB(string(u.name)+"'s bio", err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorServer, u.conn)
+		return
+	}
+
+	SendOKPacket(cmd.HD.ID, u.conn)
+}
+
+// Relays signaling data to an online user without
+// interpreting it, so that both users can set up a direct
+// connection. It is never cached as it is only useful
+// while both users are online.
+//
+// Replies with OK or ERR
+func signalUser(h *Hub, u User, cmd spec.Command) {
+	// Cannot send to self
+	if string(cmd.Args[0]) == u.name {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorInvalid, "cannot signal yourself"), u.conn)
+		return
+	}
+
+	// Relayed as is, but it must be base64 to be readable
+	if _, err := spec.BytesToSignal(cmd.Args[1]); err != nil {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorArguments, "signaling data must be base64"), u.conn)
+		return
+	}
+
+	dest, ok := h.FindUser(string(cmd.Args[0]))
+	if !ok {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorWithDetail(spec.ErrorNotFound, "user is not online"), u.conn)
+		return
+	}
+
+	pak, err := spec.NewPacket(spec.SIGNAL, spec.NullID, spec.EmptyInfo,
+		[]byte(u.name),
+		cmd.Args[1],
+	)
+	if err != nil {
+		log.Packet(spec.SIGNAL, err)
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+
+	_, err = dest.conn.Write(pak) // send SIGNAL (to destination)
+	if err != nil {
+		SendErrorPacket(cmd.HD.ID, spec.ErrorPacket, u.conn)
+		return
+	}
+
+	SendOKPacket(cmd.HD.ID, u.conn)
+}
@@ -6,13 +6,14 @@ package hubs
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/Sprinter05/gochat/internal/log"
 	"github.com/Sprinter05/gochat/internal/models"
 	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/Sprinter05/gochat/server/db"
 	"gorm.io/gorm"
 )
 
@@ -23,10 +24,24 @@ import (
 type Hub struct {
 	db     *gorm.DB                                         // Database with all relevant information
 	motd   string                                           // Initial message sent to all clients
+	banner string                                           // Notice sent to all clients before logging in
 	close  context.CancelFunc                               // Used to trigger a shutdown
 	users  models.Table[net.Conn, *User]                    // Stores all online users
 	verifs models.Table[string, *Verif]                     // Stores all verifications and/or reusable tokens
 	subs   models.Table[spec.Hook, *models.Slice[net.Conn]] // Stores all users subscribed to an event
+	anons  models.Table[net.Conn, context.CancelFunc]       // Stores all connections that have not authenticated yet
+	anmut  sync.Mutex                                       // Prevents a connection from expiring while it authenticates
+	expiry time.Duration                                    // Time an unauthenticated connection may stay open
+	mut    sync.RWMutex                                     // Protects the settings that can be changed at runtime
+	timer  *time.Timer                                      // Pending shutdown, nil if none is scheduled
+	warns  []*time.Timer                                    // Warnings sent before the pending shutdown
+	auth   Authenticator                                    // Backend used to verify logins
+	echoes models.Table[net.Conn, *echoWindow]              // Stores the echoes requested by each connection
+	names  NameFilter                                       // Usernames that cannot be registered
+	list   spec.Listing                                     // Permissions needed to list users
+	geo    Resolver                                         // Describes where new logins come from
+	hidden bool                                             // Whether errors avoid revealing which users exist
+	quota  uint64                                           // Bytes of cached messages each user may have, 0 for no limit
 }
 
 /* HUB FUNCTIONS */
@@ -34,12 +49,188 @@ type Hub struct {
 // Returns the message of the day that is
 // currently active
 func (hub *Hub) Motd() string {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
 	return hub.motd
 }
 
+// Changes the message of the day sent
+// to new connections.
+func (hub *Hub) SetMotd(motd string) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.motd = motd
+}
+
+// Returns the notice shown to new
+// connections before logging in.
+func (hub *Hub) Banner() string {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.banner
+}
+
+// Changes the notice sent to new connections,
+// removing control characters and truncating
+// it to the maximum size allowed.
+func (hub *Hub) SetBanner(banner string) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.banner = sanitizeBanner(banner)
+}
+
+// Returns the filter applied to the
+// usernames of new registrations.
+func (hub *Hub) NameFilter() NameFilter {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.names
+}
+
+// Changes the usernames that cannot be
+// registered from now on.
+func (hub *Hub) SetNameFilter(filter NameFilter) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.names = filter
+}
+
+// Returns the permissions needed
+// to list users in the server.
+func (hub *Hub) Listing() spec.Listing {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.list
+}
+
+// Changes the permissions needed to list
+// users, new connections are told about it.
+func (hub *Hub) SetListing(list spec.Listing) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.list = list
+}
+
+// Returns whether errors should avoid
+// revealing which users exist.
+func (hub *Hub) Hidden() bool {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.hidden
+}
+
+// Changes whether errors should avoid revealing
+// which users exist, applies to new requests.
+func (hub *Hub) SetHidden(hidden bool) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.hidden = hidden
+}
+
+// Returns how many bytes of cached messages
+// each user may have, 0 if there is no limit.
+func (hub *Hub) Quota() uint64 {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.quota
+}
+
+// Changes how many bytes of cached messages each
+// user may have, applies to new messages.
+func (hub *Hub) SetQuota(quota uint64) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.quota = quota
+}
+
+// Returns the resolver used to describe
+// where new logins come from.
+func (hub *Hub) Resolver() Resolver {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.geo
+}
+
+// Changes the resolver used to describe
+// where new logins come from.
+func (hub *Hub) SetResolver(geo Resolver) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.geo = geo
+}
+
+// Returns the time an unauthenticated
+// connection may stay open.
+func (hub *Hub) Expiry() time.Duration {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+	return hub.expiry
+}
+
+// Changes the time an unauthenticated connection
+// may stay open. Only new connections are affected.
+func (hub *Hub) SetExpiry(expiry time.Duration) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	hub.expiry = expiry
+}
+
+// Schedules a shutdown of the server after the given
+// duration, replacing any previously scheduled one. The
+// warning function is called with the time left for each
+// of the given times before the shutdown that is not past.
+func (hub *Hub) ScheduleShutdown(after time.Duration, warn func(time.Duration), before ...time.Duration) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+
+	if hub.timer != nil {
+		hub.timer.Stop()
+	}
+	hub.stopWarnings()
+
+	// Send shutdown signal to hub
+	hub.timer = time.AfterFunc(after, hub.close)
+
+	for _, v := range before {
+		if v <= 0 || v >= after {
+			continue
+		}
+
+		timer := time.AfterFunc(after-v, func() { warn(v) })
+		hub.warns = append(hub.warns, timer)
+	}
+}
+
+// Stops a scheduled shutdown and its warnings,
+// returning false if there was no shutdown to be stopped.
+func (hub *Hub) CancelShutdown() bool {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+
+	if hub.timer == nil {
+		return false
+	}
+
+	ok := hub.timer.Stop()
+	hub.timer = nil
+	hub.stopWarnings()
+	return ok
+}
+
+// Stops the warnings of the scheduled shutdown.
+// The mutex must be held when calling it.
+func (hub *Hub) stopWarnings() {
+	for _, v := range hub.warns {
+		v.Stop()
+	}
+	hub.warns = nil
+}
+
 // Sends a message to all users on the server, creating
 // the corresponding RECIV for each user and encrypting
-// the data correspondingly
+// the data correspondingly. It is marked as a broadcast
+// so that clients can tell it apart from other messages,
+// and the text keeps the prefix for older clients.
 func (hub *Hub) Broadcast(message string, sender User) {
 	list := hub.users.GetAll()
 
@@ -49,9 +240,7 @@ func (hub *Hub) Broadcast(message string, sender User) {
 			continue
 		}
 
-		bdcast := fmt.Sprintf(
-			"ADMINISTRATIVE BROADCAST:\n%s", message,
-		)
+		bdcast := spec.BroadcastPrefix + message
 
 		enc, err := spec.EncryptText([]byte(bdcast), v.pubkey)
 		if err != nil {
@@ -61,7 +250,7 @@ func (hub *Hub) Broadcast(message string, sender User) {
 		}
 
 		pak, err := spec.NewPacket(
-			spec.RECIV, spec.NullID, spec.EmptyInfo,
+			spec.RECIV, spec.NullID, byte(spec.MsgBroadcast),
 			[]byte(sender.name),
 			spec.UnixStampToBytes(time.Now()),
 			enc,
@@ -112,6 +301,60 @@ func (hub *Hub) Notify(h spec.Hook, only net.Conn, args ...[]byte) {
 
 }
 
+// Marks a connection as anonymous until it successfully
+// registers or logs in. If it fails to do so before the
+// configured expiry, the connection will be closed. A zero
+// expiry means that anonymous connections never expire.
+func (hub *Hub) Anonymous(cl net.Conn) {
+	expiry := hub.Expiry()
+	if expiry == 0 {
+		return
+	}
+
+	// Cancel function will be used to stop the following goroutine
+	ctx, cancel := context.WithCancel(context.Background())
+	hub.anmut.Lock()
+	hub.anons.Add(cl, cancel)
+	hub.anmut.Unlock()
+
+	go func() {
+		select {
+		case <-time.After(expiry):
+			hub.anmut.Lock()
+			defer hub.anmut.Unlock()
+
+			// It may have authenticated while waiting for the lock
+			if ctx.Err() != nil {
+				return
+			}
+
+			hub.anons.Remove(cl)
+			log.Timeout(cl.RemoteAddr().String(), "anonymous connection")
+			SendErrorPacket(spec.NullID, spec.ErrorLogin, cl)
+			// This will trigger the cleanup of the listening goroutine
+			cl.Close()
+		case <-ctx.Done():
+			// Connection authenticated or closed
+			return
+		}
+	}()
+}
+
+// Stops treating a connection as anonymous,
+// preventing it from expiring.
+func (hub *Hub) identified(cl net.Conn) {
+	hub.anmut.Lock()
+	defer hub.anmut.Unlock()
+
+	cancel, ok := hub.anons.Get(cl)
+	if !ok {
+		return
+	}
+
+	cancel()
+	hub.anons.Remove(cl)
+}
+
 // Removes all mentions of a user that just disconnected
 // from the hub, except the reusable token if the connection
 // is secure (condition that is not checked here).
@@ -121,6 +364,7 @@ func (hub *Hub) Cleanup(cl net.Conn) {
 	if ok {
 		// Cleanup on the users table
 		hub.users.Remove(cl)
+		go db.UpdateLastSeen(hub.db, user.name)
 		go hub.Notify(
 			spec.HookNewLogout, nil,
 			[]byte(user.name),
@@ -150,6 +394,12 @@ func (hub *Hub) Cleanup(cl net.Conn) {
 
 	// Cleanup on the hooks table
 	removeFromHooks(hub, cl)
+
+	// Cleanup on the echoes table
+	hub.echoes.Remove(cl)
+
+	// Cleanup on the anonymous table
+	hub.identified(cl)
 }
 
 // Checks if a session is present in the hub (including the database)
@@ -161,12 +411,17 @@ func (hub *Hub) Cleanup(cl net.Conn) {
 func (hub *Hub) Session(r Request) (*User, error) {
 	op := r.Command.HD.Op
 
+	// Logins read the username before any handler runs
+	if len(r.Command.Args) < spec.ServerArgs(op) {
+		return nil, spec.ErrorArguments
+	}
+
 	// Check for users online in any situation
 	cached, err := hub.cachedLogin(r)
 	if err == nil {
 		// Valid user found in cache, serve request
 		return cached, nil
-	} else if err != spec.ErrorNotFound {
+	} else if !errors.Is(err, spec.ErrorNotFound) {
 		// We do not continue checking if its a different error
 		return nil, err
 	}
@@ -209,6 +464,16 @@ func (hub *Hub) dbLogin(r Request) (*User, error) {
 		if errors.Is(err, spec.ErrorCorrupted) || errors.Is(err, spec.ErrorServer) {
 			return nil, spec.ErrorLogin
 		}
+
+		// Unknown users get a challenge nobody can answer
+		if hub.Hidden() {
+			return &User{
+				conn:   r.Conn,
+				secure: r.TLS,
+				name:   u,
+				decoy:  true,
+			}, nil
+		}
 		return nil, err
 	}
 
@@ -237,13 +502,25 @@ func (hub *Hub) cachedLogin(r Request) (*User, error) {
 	if id == spec.LOGIN {
 		// We check if the user is logged in from another IP
 		dup, ipok := hub.FindUser(string(r.Command.Args[0]))
+		if ipok && hub.Hidden() {
+			// Telling it apart would reveal that the user is online
+			return &User{
+				conn:   r.Conn,
+				secure: r.TLS,
+				name:   dup.name,
+				decoy:  true,
+			}, nil
+		}
+
 		if ipok {
 			// Cannot have two sessions of the same user
-			ip := r.Conn.RemoteAddr()
-			remote, _ := net.ResolveTCPAddr("tcp", ip.String())
+			ip := r.Conn.RemoteAddr().String()
+			if remote := connIP(r.Conn); remote != nil {
+				ip = remote.String()
+			}
 			go hub.Notify(
 				spec.HookDuplicateSession, dup.conn,
-				[]byte(remote.IP.String()),
+				[]byte(ip),
 			)
 			return nil, spec.ErrorDupSession
 		}
@@ -256,16 +533,23 @@ func (hub *Hub) cachedLogin(r Request) (*User, error) {
 /* HUB MAIN */
 
 // Initialises all data structures the hub needs to function:
-// database, shutdown context and table sizes.
-func NewHub(database *gorm.DB, cancel context.CancelFunc, size uint, motd string) *Hub {
+// database, shutdown context, table sizes, the time after
+// which unauthenticated connections are closed and the
+// authentication backend used for logins.
+func NewHub(database *gorm.DB, cancel context.CancelFunc, size uint, motd string, expiry time.Duration, auth Authenticator) *Hub {
 	// Allocate fields
 	hub := &Hub{
 		close:  cancel,
 		users:  models.NewTable[net.Conn, *User](size),
 		verifs: models.NewTable[string, *Verif](size),
 		subs:   models.NewTable[spec.Hook, *models.Slice[net.Conn]](uint(len(spec.Hooks))),
+		anons:  models.NewTable[net.Conn, context.CancelFunc](size),
+		echoes: models.NewTable[net.Conn, *echoWindow](size),
 		db:     database,
 		motd:   motd,
+		expiry: expiry,
+		auth:   auth,
+		geo:    NoResolver{},
 	}
 
 	// Allocate subscription lists
@@ -289,6 +573,7 @@ func (hub *Hub) Wait(ctx context.Context, socks ...net.Listener) {
 	for _, v := range list {
 		// This should trigger the cleanup function that is
 		// listening to connections
+		SendKickPacket(spec.KickMaintenance, "", v.conn)
 		v.conn.Close()
 	}
 
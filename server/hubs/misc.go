@@ -1,23 +1,61 @@
 package hubs
 
 import (
-	"math/rand"
+	crand "crypto/rand"
 	"net"
-	"time"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/Sprinter05/gochat/internal/log"
 	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/Sprinter05/gochat/server/db"
 )
 
-/* CONSTANTS */
+/* AUXILIARY FUNCTIONS */
+
+// Removes control characters other than newlines and tabs
+// from a banner and truncates it to the maximum size
+// allowed without splitting any character.
+func sanitizeBanner(banner string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
 
-// Charset to be used by the random text generator
-const randTextCharset string = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%&*+-?!"
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
 
-// Amount of characters the random text should have
-const randTextLength int = 128
+		return r
+	}, banner)
+	clean = strings.TrimSpace(clean)
 
-/* AUXILIARY FUNCTIONS */
+	if len(clean) <= spec.MaxBanner {
+		return clean
+	}
+
+	cut := spec.MaxBanner
+	for cut > 0 && !utf8.RuneStart(clean[cut]) {
+		cut--
+	}
+
+	return clean[:cut]
+}
+
+// Removes every control character from a profile bio,
+// as it is shown in a single line by clients.
+func sanitizeBio(bio string) string {
+	clean := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+
+		return r
+	}, bio)
+
+	return strings.TrimSpace(clean)
+}
 
 // Removes a use from all hooks that exist, mainly
 // for the purpose of cleaning up the connection.
@@ -41,12 +79,19 @@ func catchUp(cl net.Conn, msgs ...*spec.Message) {
 	for _, v := range msgs {
 		// Turn timestamp to byte array and create packet
 		stp := spec.UnixStampToBytes(v.Stamp)
-
-		pak, err := spec.NewPacket(spec.RECIV, spec.NullID, spec.EmptyInfo,
+		args := [][]byte{
 			[]byte(v.Sender),
 			stp,
 			v.Content,
-		)
+			spec.SequenceToBytes(v.Sequence),
+		}
+
+		// Copies of sent messages also carry their recipient
+		if v.Recipient != "" {
+			args = append(args, []byte(v.Recipient))
+		}
+
+		pak, err := spec.NewPacket(spec.RECIV, spec.NullID, byte(v.Type), args...)
 
 		if err != nil {
 			log.Packet(spec.RECIV, err)
@@ -57,8 +102,14 @@ func catchUp(cl net.Conn, msgs ...*spec.Message) {
 }
 
 // Auxiliary function to reduce code when sending errors.
+// If the error has a detail it is sent as an argument.
 func SendErrorPacket(id spec.ID, err error, cl net.Conn) {
-	pak, err := spec.NewPacket(spec.ERR, id, spec.ErrorCode(err))
+	var args [][]byte
+	if detail := spec.ErrorDetail(err); detail != "" {
+		args = append(args, []byte(detail))
+	}
+
+	pak, err := spec.NewPacket(spec.ERR, id, spec.ErrorCode(err), args...)
 	if err != nil {
 		log.Packet(spec.ERR, err)
 	} else {
@@ -66,6 +117,55 @@ func SendErrorPacket(id spec.ID, err error, cl net.Conn) {
 	}
 }
 
+// Tells a connection why it is about to be closed by the
+// server. The detail is only sent as an argument if not empty.
+func SendKickPacket(reason spec.Kick, detail string, cl net.Conn) {
+	var args [][]byte
+	if detail != "" {
+		args = append(args, []byte(detail))
+	}
+
+	pak, err := spec.NewPacket(spec.KICK, spec.NullID, byte(reason), args...)
+	if err != nil {
+		log.Packet(spec.KICK, err)
+	} else {
+		cl.Write(pak)
+	}
+}
+
+// Confirms a login with an OK packet that includes the amount
+// of messages cached for the user while it was offline and, if
+// there is a quota, the bytes of the cache in use and the quota.
+// A plain OK is sent instead if they cannot be counted.
+func sendLoginOK(h *Hub, u User, id spec.ID) {
+	count, err := db.CountMessages(h.db, u.name)
+	if err != nil {
+		log.DB("message count for "+u.name, err)
+		SendOKPacket(id, u.conn)
+		return
+	}
+
+	args := [][]byte{spec.CountToBytes(uint64(count))}
+	if quota := h.Quota(); quota > 0 {
+		used, err := db.CachedBytes(h.db, u.name)
+		if err != nil {
+			log.DB("cached size for "+u.name, err)
+		} else {
+			args = append(args,
+				spec.CountToBytes(used),
+				spec.CountToBytes(quota),
+			)
+		}
+	}
+
+	pak, err := spec.NewPacket(spec.OK, id, spec.EmptyInfo, args...)
+	if err != nil {
+		log.Packet(spec.OK, err)
+	} else {
+		u.conn.Write(pak)
+	}
+}
+
 // Auxiliary function to reduce code when sending ok packets.
 func SendOKPacket(id spec.ID, cl net.Conn) {
 	pak, err := spec.NewPacket(spec.OK, id, spec.EmptyInfo)
@@ -76,17 +176,10 @@ func SendOKPacket(id spec.ID, cl net.Conn) {
 	}
 }
 
-// Generate a random text using a fixed charset and size
-// This can be used for verification tokens.
-func randText() []byte {
-	// Set seed in nanoseconds for better randomness
-	seed := rand.New(rand.NewSource(time.Now().UnixNano()))
-	set := []byte(randTextCharset)
-
-	r := make([]byte, randTextLength)
-	for i := range r {
-		r[i] = set[seed.Intn(len(randTextCharset))]
-	}
-
+// Generate random bytes with the size of a challenge
+// encrypted with the public key of a user.
+func randChallenge() []byte {
+	r := make([]byte, spec.RSABitSize/8)
+	crand.Read(r)
 	return r
 }
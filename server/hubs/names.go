@@ -0,0 +1,55 @@
+package hubs
+
+import (
+	"regexp"
+	"strings"
+)
+
+/* TYPES */
+
+// Restricts which usernames can be registered, either because
+// they are reserved for the operators or because they match
+// one of the blocked patterns. The zero value allows any name.
+type NameFilter struct {
+	reserved []string         // Exact names, compared ignoring case
+	blocked  []*regexp.Regexp // Patterns that cannot be matched
+}
+
+/* FUNCTIONS */
+
+// Creates a filter from a list of reserved names and
+// a list of regular expressions, returning an error
+// if any of the expressions cannot be compiled.
+func NewNameFilter(reserved []string, patterns []string) (NameFilter, error) {
+	blocked := make([]*regexp.Regexp, 0, len(patterns))
+	for _, v := range patterns {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return NameFilter{}, err
+		}
+		blocked = append(blocked, re)
+	}
+
+	return NameFilter{
+		reserved: reserved,
+		blocked:  blocked,
+	}, nil
+}
+
+// Returns the reason why a username cannot be
+// registered, or an empty string if it is allowed.
+func (f NameFilter) Deny(uname string) string {
+	for _, v := range f.reserved {
+		if strings.EqualFold(v, uname) {
+			return "username is reserved"
+		}
+	}
+
+	for _, v := range f.blocked {
+		if v.MatchString(uname) {
+			return "username is not allowed"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,101 @@
+package hubs
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Sprinter05/gochat/internal/log"
+	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/Sprinter05/gochat/server/db"
+)
+
+/* TYPES */
+
+// Looks up a coarse description of where an address comes
+// from, such as its country or network, to enrich the logs
+// and hooks of new logins. An empty result means unknown.
+type Resolver interface {
+	Resolve(ip net.IP) (string, error)
+}
+
+// Default resolver that never knows where an address comes
+// from, so that no external dependency is required.
+type NoResolver struct{}
+
+func (NoResolver) Resolve(net.IP) (string, error) {
+	return "", nil
+}
+
+/* FUNCTIONS */
+
+// Returns the address a connection comes from without the port
+// or zone, handled the same way for plain, TLS and IPv6 connections.
+// The result is nil if the address is not an IP.
+func connIP(c net.Conn) net.IP {
+	addr := c.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	host, _, _ = strings.Cut(host, "%")
+	return net.ParseIP(host)
+}
+
+// Returns where a connection comes from, including the
+// result of the resolver if it knows the address.
+func (hub *Hub) origin(c net.Conn) string {
+	ip := connIP(c)
+	if ip == nil {
+		return c.RemoteAddr().String()
+	}
+
+	location, err := hub.Resolver().Resolve(ip)
+	if err != nil {
+		log.Error("origin resolution", err)
+	}
+
+	if location == "" {
+		return ip.String()
+	}
+
+	return ip.String() + " (" + location + ")"
+}
+
+// Logs where a new login comes from and notifies the subscribers
+// of the login hook. Only administrators are told about the origin.
+func (hub *Hub) notifyLogin(u User) {
+	origin := hub.origin(u.conn)
+	log.Login(string(u.name), origin)
+	db.UpdateLastSeen(hub.db, u.name)
+
+	sl, ok := hub.subs.Get(spec.HookNewLogin)
+	if !ok {
+		//! This means the hook slice no longer exists even though it should
+		log.Fatal("hub hook slices", spec.ErrorNotFound)
+		return
+	}
+
+	args := [][]byte{[]byte(u.name), {byte(u.perms)}}
+	pak, err := spec.NewPacket(spec.HOOK, spec.NullID, byte(spec.HookNewLogin), args...)
+	if err != nil {
+		log.Packet(spec.HOOK, err)
+		return
+	}
+
+	detailed, err := spec.NewPacket(spec.HOOK, spec.NullID, byte(spec.HookNewLogin), append(args, []byte(origin))...)
+	if err != nil {
+		log.Packet(spec.HOOK, err)
+		return
+	}
+
+	list := sl.Copy(0)
+	for _, v := range list {
+		sub, ok := hub.users.Get(v)
+		if ok && sub.perms >= db.ADMIN {
+			v.Write(detailed)
+		} else {
+			v.Write(pak)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package hubs
 
 import (
-	"bytes"
 	"context"
 	"crypto/rsa"
 	"errors"
@@ -27,6 +26,8 @@ type User struct {
 	name   string         // Username, must conform to the specification size
 	perms  db.Permission  // Level of permission
 	pubkey *rsa.PublicKey // Public RSA key
+	decoy  bool           // Not a real user, only used to hide that it does not exist
+	bio    string         // Profile bio, empty if it has not been set
 }
 
 // Specifies a verification in process or
@@ -41,6 +42,13 @@ type Verif struct {
 	expiry  time.Time          // How long it is available for after a disconnection
 }
 
+// Specifies how many echoes a connection
+// has requested since the window started.
+type echoWindow struct {
+	start time.Time // When the window started
+	count uint      // Echoes requested in the window
+}
+
 /* USER FUNCTIONS */
 
 // Queries and transforms a user from the database into
@@ -84,9 +92,33 @@ func (hub *Hub) userFromDB(uname string) (*User, error) {
 		name:   uname,
 		pubkey: key,
 		perms:  dbuser.Permission,
+		bio:    dbuser.Bio.String,
 	}, nil
 }
 
+// Removes the reusable tokens of every user, or only the one of
+// the given user if the name is not empty, returning how many were
+// removed. Pending verifications are kept. Tokens of users that are
+// still online are removed too, so none is left once they disconnect.
+func (hub *Hub) ExpireTokens(name string) int {
+	count := 0
+	list := hub.verifs.GetAll()
+	for _, v := range list {
+		if v.pending {
+			continue
+		}
+
+		if name != "" && v.name != name {
+			continue
+		}
+
+		hub.verifs.Remove(v.name)
+		count++
+	}
+
+	return count
+}
+
 // Checks if a reusable token is applicable to a user and if
 // it is valid and safe to use.
 //
@@ -99,8 +131,8 @@ func (hub *Hub) checkToken(u User, text []byte) error {
 	}
 
 	v, ok := hub.verifs.Get(u.name)
-	if !ok {
-		return spec.ErrorNotFound
+	if !ok || u.decoy {
+		return spec.ErrorWithDetail(spec.ErrorLogin, "no reusable token available")
 	}
 
 	if v.pending {
@@ -110,14 +142,26 @@ func (hub *Hub) checkToken(u User, text []byte) error {
 	// Check if it has expired
 	if time.Until(v.expiry) <= 0 {
 		hub.verifs.Remove(u.name)
-		return spec.ErrorNotFound
+		return spec.ErrorWithDetail(spec.ErrorLogin, "reusable token has expired")
 	}
 
-	if !bytes.Equal(v.text, text) {
-		return spec.ErrorHandshake
+	// Tokens are answers accepted by the same backend
+	return hub.auth.Verify(u, v.text, text)
+}
+
+// Turns the errors that tell apart unknown and
+// deregistered users into a single one if the
+// hub is hiding which users exist.
+func (hub *Hub) hideUser(err error) error {
+	if !hub.Hidden() {
+		return err
+	}
+
+	if errors.Is(err, spec.ErrorNotFound) || errors.Is(err, spec.ErrorDeregistered) {
+		return spec.ErrorNotFound
 	}
 
-	return nil
+	return err
 }
 
 /* EXPORTED FUNCTIONS */
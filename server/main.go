@@ -3,14 +3,19 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	stdlog "log"
 	"net"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Sprinter05/gochat/internal/log"
@@ -37,6 +42,12 @@ func version() string {
 	)
 }
 
+/* ERRORS */
+
+var (
+	ErrorExpiredCert error = errors.New("certificate has expired") // certificate has expired
+)
+
 /* CONFIG */
 
 // Config struct.
@@ -50,6 +61,7 @@ type Config struct {
 		Clients *uint   `json:"max_clients"`
 		TLS     struct {
 			Enabled     bool    `json:"enabled"`
+			Required    bool    `json:"required"`
 			Port        *uint16 `json:"port"`
 			Certificate *string `json:"cert_file"`
 			Key         *string `json:"key_file"`
@@ -58,30 +70,57 @@ type Config struct {
 			Level string `json:"level"`
 			File  string `json:"log_file"`
 		} `json:"logs"`
-		Motd string `json:"default_motd"`
+		Motd      string   `json:"default_motd"`
+		Banner    string   `json:"banner"`
+		Anonymous uint     `json:"anonymous_timeout"`
+		PerIP     uint     `json:"max_clients_per_ip"`
+		Trusted   []string `json:"trusted_addresses"`
+		Auth      string   `json:"authentication"`
+		Workers   uint     `json:"workers_per_client"`
+		Usernames struct {
+			Reserved []string `json:"reserved"`
+			Blocked  []string `json:"blocked"`
+		} `json:"usernames"`
+		Listing struct {
+			All    uint `json:"all"`
+			Online uint `json:"online"`
+		} `json:"user_listing"`
+		Hidden bool   `json:"hide_users"`
+		Quota  uint64 `json:"max_cached_bytes"` // 0 for no limit
 	} `json:"server"`
 }
 
 /* INIT */
 
-// Reads a JSON file for config options
-func readJSON(path string) (cfg Config) {
+// Parses a JSON file for config options
+func parseJSON(path string) (cfg Config, err error) {
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal("config file reading", err)
+		return cfg, err
 	}
 	defer file.Close()
 
 	parser := json.NewDecoder(file)
-	parser.Decode(&cfg)
+	err = parser.Decode(&cfg)
+
+	return cfg, err
+}
+
+// Reads a JSON file for config options
+func readJSON(path string) Config {
+	cfg, err := parseJSON(path)
+	if err != nil {
+		log.Fatal("config file reading", err)
+	}
 
 	return cfg
 }
 
-// Reads CLI flags and JSON file
+// Reads CLI flags and JSON file, returning
+// the configuration and the path it was read from.
 //
 // setup() should always run first when the program starts
-func setup() Config {
+func setup() (Config, string) {
 	var configFile string
 	var useShell bool
 
@@ -98,7 +137,7 @@ func setup() Config {
 		os.Exit(0)
 	}
 
-	return config
+	return config, configFile
 }
 
 /* SETUP FUNCTIONS */
@@ -125,20 +164,7 @@ func setupLog(config Config) (file *os.File) {
 	stdlog.SetOutput(file)
 
 	// Setup logging levels
-	// No need to check if the env var exists
-	// We just default to FATAL
-	lv := config.Server.Logs.Level
-	switch lv {
-	case "ALL":
-		log.Level = log.ALL
-	case "INFO":
-		log.Level = log.INFO
-	case "ERROR":
-		log.Level = log.ERROR
-	default:
-		log.Level = log.FATAL
-		lv = "FATAL"
-	}
+	lv := setupLevel(config)
 	now := time.Now()
 	fmt.Printf(
 		"-> Logging at %s with log level %s on server version %s and protocol version %d\n",
@@ -151,6 +177,27 @@ func setupLog(config Config) (file *os.File) {
 	return file
 }
 
+// Sets the log level specified in the configuration,
+// returning its name.
+func setupLevel(config Config) string {
+	// No need to check if the option exists
+	// We just default to FATAL
+	lv := config.Server.Logs.Level
+	switch lv {
+	case "ALL":
+		log.SetLevel(log.ALL)
+	case "INFO":
+		log.SetLevel(log.INFO)
+	case "ERROR":
+		log.SetLevel(log.ERROR)
+	default:
+		log.SetLevel(log.FATAL)
+		lv = "FATAL"
+	}
+
+	return lv
+}
+
 // Creates a database log file and returns it.
 func setupDBLog(config Config) (file *os.File) {
 	path := config.Database.Logs
@@ -210,18 +257,54 @@ func setupConn(config Config) net.Listener {
 	return l
 }
 
+// Holds the TLS certificate in use so that it can
+// be swapped while the server is running.
+type certHolder struct {
+	cert     atomic.Pointer[tls.Certificate] // Certificate currently in use
+	certFile string                          // Path to the certificate
+	keyFile  string                          // Path to the private key
+}
+
+// Loads the keypair from the files, only replacing the
+// certificate in use if the new one is valid.
+func (h *certHolder) load() error {
+	cert, err := tls.LoadX509KeyPair(h.certFile, h.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return ErrorExpiredCert
+	}
+
+	cert.Leaf = leaf
+	h.cert.Store(&cert)
+	return nil
+}
+
+// Returns the certificate in use, to be used
+// by the TLS configuration on each handshake.
+func (h *certHolder) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return h.cert.Load(), nil
+}
+
 // Create a TLS listener
-func setupTLSConn(config Config) net.Listener {
+func setupTLSConn(config Config) (net.Listener, *certHolder) {
 	addr := config.Server.Address
 	if addr == nil {
 		log.Config("server.address")
-		return nil
+		return nil, nil
 	}
 
 	port := config.Server.TLS.Port
 	if port == nil {
 		log.Config("server.tls.port")
-		return nil
+		return nil, nil
 	}
 
 	socket := fmt.Sprintf(
@@ -234,23 +317,26 @@ func setupTLSConn(config Config) net.Listener {
 	keyFile := config.Server.TLS.Key
 	if certFile == nil {
 		log.Config("server.tls.cert_file")
-		return nil
+		return nil, nil
 	}
 	if keyFile == nil {
 		log.Config("server.tls.key_file")
-		return nil
+		return nil, nil
 	}
 
-	cert, err := tls.LoadX509KeyPair(
-		*certFile,
-		*keyFile,
-	)
+	certs := &certHolder{
+		certFile: *certFile,
+		keyFile:  *keyFile,
+	}
+	err := certs.load()
 	if err != nil {
 		log.Fatal("tls loading", err)
 	}
 
+	// The certificate is queried on each handshake
+	// so that it can be reloaded at any time
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+		GetCertificate: certs.get,
 	}
 
 	l, err := tls.Listen("tcp", socket, tlsConfig)
@@ -259,7 +345,7 @@ func setupTLSConn(config Config) net.Listener {
 	}
 
 	log.Notice(fmt.Sprintf("Running TLS Socket on port %d", *port))
-	return l
+	return l, certs
 }
 
 /* MAIN FUNCTIONS */
@@ -270,6 +356,67 @@ func setupTLSConn(config Config) net.Listener {
 type Server struct {
 	wg    sync.WaitGroup // How many sockets are running
 	count models.Counter // How many clients are connected
+
+	mut     sync.Mutex      // Protects the per address count
+	ips     map[string]uint // How many clients are connected per address
+	perIP   uint            // Maximum clients per address, 0 means unlimited
+	trusted []string        // Addresses exempt from the per address limit
+	workers uint            // Commands of a client that can run at once, 0 means 1
+}
+
+// Registers a new connection from the given address,
+// returning false if said address has reached its limit.
+func (sock *Server) acquire(ip string) bool {
+	sock.mut.Lock()
+	defer sock.mut.Unlock()
+
+	limited := sock.perIP != 0 && !slices.Contains(sock.trusted, ip)
+	if limited && sock.ips[ip] >= sock.perIP {
+		return false
+	}
+
+	sock.ips[ip] += 1
+	return true
+}
+
+// Changes the per address limits, only
+// affecting new connections.
+func (sock *Server) limits(perIP uint, trusted []string) {
+	sock.mut.Lock()
+	defer sock.mut.Unlock()
+
+	sock.perIP = perIP
+	sock.trusted = trusted
+}
+
+// Changes the amount of commands of a new client that can
+// run at once. Existing connections keep the previous value.
+func (sock *Server) concurrency(workers uint) {
+	sock.mut.Lock()
+	defer sock.mut.Unlock()
+
+	sock.workers = workers
+}
+
+// Removes a connection from the given address.
+func (sock *Server) release(ip string) {
+	sock.mut.Lock()
+	defer sock.mut.Unlock()
+
+	sock.ips[ip] -= 1
+	if sock.ips[ip] == 0 {
+		delete(sock.ips, ip)
+	}
+}
+
+// Returns the address of a connection without the port.
+func remoteIP(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ip
 }
 
 // Runs a listener to accept connections until the
@@ -292,6 +439,14 @@ func (sock *Server) Run(ctx context.Context, l net.Listener, hub *hubs.Hub) {
 			}
 		}
 
+		// Refuse the connection if the address has too many
+		ip := remoteIP(c)
+		if !sock.acquire(ip) {
+			log.Refused(ip, "too many connections from the same address")
+			c.Close()
+			continue
+		}
+
 		// Increase and wait if the client counter is full
 		sock.count.Inc()
 
@@ -300,16 +455,22 @@ func (sock *Server) Run(ctx context.Context, l net.Listener, hub *hubs.Hub) {
 		req := make(chan hubs.Request, hubs.MaxUserRequests)
 
 		// Listens to the client's packets
-		go ListenConnection(
-			// We assume no TLS until it passes the handshake
-			spec.NewConnection(c, false),
-			&sock.count,
-			req,
-			hub,
-		)
+		go func() {
+			ListenConnection(
+				// We assume no TLS until it passes the handshake
+				spec.NewConnection(c, false),
+				&sock.count,
+				req,
+				hub,
+			)
+			sock.release(ip)
+		}()
 
 		// Runs the client's commands
-		go RunTask(hub, req)
+		sock.mut.Lock()
+		workers := sock.workers
+		sock.mut.Unlock()
+		go RunTask(hub, req, workers)
 	}
 }
 
@@ -328,9 +489,93 @@ func manual(close context.CancelFunc) {
 	close()
 }
 
+// Returns whether an obligatory option has changed
+func changed[T comparable](old *T, new *T) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return *old != *new
+}
+
+// Waits on SIGHUP signals by the OS to read the configuration
+// file again, applying the options that can be changed
+// without restarting. Sockets and database are not touched.
+// If TLS is enabled, the certificate files are loaded again.
+func reload(path string, config Config, hub *hubs.Hub, server *Server, certs *certHolder) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		log.Notice("reload signal received! reading configuration")
+
+		new, err := parseJSON(path)
+		if err != nil {
+			log.Error("config file reloading", err)
+			continue
+		}
+
+		lv := setupLevel(new)
+		hub.SetMotd(new.Server.Motd)
+		hub.SetBanner(new.Server.Banner)
+		hub.SetListing(spec.Listing(new.Server.Listing))
+		hub.SetHidden(new.Server.Hidden)
+		hub.SetQuota(new.Server.Quota)
+		names, err := hubs.NewNameFilter(new.Server.Usernames.Reserved, new.Server.Usernames.Blocked)
+		if err != nil {
+			log.Error("username filter reloading", err)
+		} else {
+			hub.SetNameFilter(names)
+		}
+		hub.SetExpiry(time.Duration(new.Server.Anonymous) * time.Second)
+		server.limits(new.Server.PerIP, new.Server.Trusted)
+		server.concurrency(new.Server.Workers)
+
+		// Options that cannot be applied at runtime
+		old := config.Server
+		restart := map[string]bool{
+			"server.address":        changed(old.Address, new.Server.Address),
+			"server.port":           changed(old.Port, new.Server.Port),
+			"server.max_clients":    changed(old.Clients, new.Server.Clients),
+			"server.tls.enabled":    old.TLS.Enabled != new.Server.TLS.Enabled,
+			"server.tls.required":   old.TLS.Required != new.Server.TLS.Required,
+			"server.tls.port":       changed(old.TLS.Port, new.Server.TLS.Port),
+			"server.tls.cert_file":  changed(old.TLS.Certificate, new.Server.TLS.Certificate),
+			"server.tls.key_file":   changed(old.TLS.Key, new.Server.TLS.Key),
+			"server.logs.log_file":  old.Logs.File != new.Server.Logs.File,
+			"server.authentication": old.Auth != new.Server.Auth,
+			"database.address":      changed(config.Database.Address, new.Database.Address),
+			"database.port":         changed(config.Database.Port, new.Database.Port),
+			"database.user":         changed(config.Database.User, new.Database.User),
+			"database.password":     changed(config.Database.Password, new.Database.Password),
+			"database.db_name":      changed(config.Database.Name, new.Database.Name),
+			"database.enable_logs":  config.Database.Logging != new.Database.Logging,
+			"database.log_file":     config.Database.Logs != new.Database.Logs,
+		}
+		for k, v := range restart {
+			if v {
+				log.Notice(k + " change requires restart")
+			}
+		}
+
+		log.Notice("configuration reloaded with log level " + lv)
+
+		// The previous certificate is kept if the new one is invalid
+		if certs != nil {
+			err := certs.load()
+			if err != nil {
+				log.Error("tls certificate reloading", err)
+				continue
+			}
+
+			expiry := certs.cert.Load().Leaf.NotAfter
+			log.Notice("tls certificate reloaded, valid until " + expiry.String())
+		}
+	}
+}
+
 func main() {
 	// Setup config struct
-	config := setup()
+	config, path := setup()
 
 	// Setup logging (and file optionally)
 	logFile := setupLog(config)
@@ -347,14 +592,31 @@ func main() {
 		dblog = stdlog.New(f, "", stdlog.LstdFlags)
 	}
 
-	// Setup sockets
-	var sock, tlssock net.Listener
-	sockets := 1
+	// Setup sockets, the plain one is not
+	// created if TLS is required
+	var socks []net.Listener
+	var certs *certHolder
+
+	if config.Server.TLS.Required && !config.Server.TLS.Enabled {
+		log.Config("server.tls.enabled")
+	}
+
+	if !config.Server.TLS.Required {
+		socks = append(socks, setupConn(config))
+	}
 
-	sock = setupConn(config)
 	if config.Server.TLS.Enabled {
-		tlssock = setupTLSConn(config)
-		sockets += 1
+		var tlssock net.Listener
+		tlssock, certs = setupTLSConn(config)
+		socks = append(socks, tlssock)
+	}
+
+	if config.Server.TLS.Required {
+		log.Notice("Accepting TLS connections only")
+	} else if config.Server.TLS.Enabled {
+		log.Notice("Accepting both plain TCP and TLS connections")
+	} else {
+		log.Notice("Accepting plain TCP connections only")
 	}
 
 	// Setup database
@@ -367,6 +629,12 @@ func main() {
 		log.Config("server.max_clients")
 	}
 
+	// Check that the authentication backend exists
+	auth, ok := hubs.AuthBackend(config.Server.Auth)
+	if !ok {
+		log.Config("server.authentication")
+	}
+
 	// Setup hub and make it wait until a shutdown signal is sent
 	ctx, cancel := context.WithCancel(context.Background())
 	hub := hubs.NewHub(
@@ -374,13 +642,22 @@ func main() {
 		cancel,
 		*config.Server.Clients,
 		config.Server.Motd,
+		time.Duration(config.Server.Anonymous)*time.Second,
+		auth,
 	)
+	hub.SetBanner(config.Server.Banner)
+	hub.SetListing(spec.Listing(config.Server.Listing))
+	hub.SetHidden(config.Server.Hidden)
+	hub.SetQuota(config.Server.Quota)
 
-	if config.Server.TLS.Enabled {
-		go hub.Wait(ctx, sock, tlssock)
-	} else {
-		go hub.Wait(ctx, sock)
+	// Check that the username patterns are valid
+	names, err := hubs.NewNameFilter(config.Server.Usernames.Reserved, config.Server.Usernames.Blocked)
+	if err != nil {
+		log.Fatal("username filter", err)
 	}
+	hub.SetNameFilter(names)
+
+	go hub.Wait(ctx, socks...)
 
 	// Just in case a CTRL-C signal happens
 	go manual(cancel)
@@ -390,14 +667,20 @@ func main() {
 
 	// Used for managing all possible sockets
 	server := Server{
-		count: models.NewCounter(int(*config.Server.Clients)),
+		count:   models.NewCounter(int(*config.Server.Clients)),
+		ips:     make(map[string]uint),
+		perIP:   config.Server.PerIP,
+		trusted: config.Server.Trusted,
+		workers: config.Server.Workers,
 	}
 
+	// Reload configuration on demand
+	go reload(path, config, hub, &server, certs)
+
 	// Endless loop to listen for connections
-	server.wg.Add(sockets)
-	go server.Run(ctx, sock, hub)
-	if config.Server.TLS.Enabled {
-		go server.Run(ctx, tlssock, hub)
+	server.wg.Add(len(socks))
+	for _, v := range socks {
+		go server.Run(ctx, v, hub)
 	}
 
 	// Condition to end program
@@ -6,10 +6,10 @@ import (
 	"errors"
 	"fmt"
 	stdlog "log"
+	"math"
 	"net"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/Sprinter05/gochat/server/db"
 	"gorm.io/gorm"
@@ -42,12 +42,14 @@ var (
 var lookupShell map[string]shellFunc = map[string]shellFunc{
 	"SETOWNER":   ownerUser,
 	"CLEARCACHE": clearCache,
+	"CACHEUSAGE": cacheUsage,
 	"HELP":       shellHelp,
 }
 
 var shellArgs map[string]uint = map[string]uint{
 	"SETOWNER":   1,
 	"CLEARCACHE": 1,
+	"CACHEUSAGE": 1,
 	"HELP":       0,
 }
 
@@ -71,6 +73,7 @@ func shellHelp(shell *Shell, args []string) {
 	fmt.Print(
 		"SETOWNER <username>: Sets a user as owner of the server\n" +
 			"CLEARCACHE <destination>: Clears the message cache of a user\n" +
+			"CACHEUSAGE <destination>: Shows how many messages and bytes a user has cached\n" +
 			"EXIT: Exits the shell\n",
 	)
 }
@@ -99,7 +102,7 @@ func clearCache(shell *Shell, args []string) {
 	err := db.RemoveMessages(
 		shell.db,
 		args[0],
-		time.Now(),
+		math.MaxUint64,
 	)
 
 	if err != nil {
@@ -110,6 +113,25 @@ func clearCache(shell *Shell, args []string) {
 	shell.showOk()
 }
 
+// Shows the amount and total size of the
+// messages cached for a specific user
+func cacheUsage(shell *Shell, args []string) {
+	count, err := db.CountMessages(shell.db, args[0])
+	if err != nil {
+		shell.showError(err)
+		return
+	}
+
+	size, err := db.CachedBytes(shell.db, args[0])
+	if err != nil {
+		shell.showError(err)
+		return
+	}
+
+	fmt.Printf("%d messages cached, %d bytes in total\n", count, size)
+	shell.showOk()
+}
+
 /* SHELL FUNCTIONS */
 
 // Loops the shell execution forever by
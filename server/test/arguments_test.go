@@ -0,0 +1,89 @@
+package test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sprinter05/gochat/internal/spec"
+)
+
+// Builds a raw header with arbitrary values that
+// may not match the payload that follows it.
+func rawHeader(op, info, args byte, length uint16) []byte {
+	h := uint64(spec.ProtocolVersion)<<60 |
+		uint64(op)<<52 |
+		uint64(info)<<44 |
+		uint64(args&0x0F)<<40 |
+		uint64(length&0x3FFF)<<26 |
+		uint64(1)<<16
+
+	b := make([]byte, spec.HeaderSize, spec.HeaderSize+2)
+	binary.BigEndian.PutUint64(b, h)
+	return append(b, "\r\n"...)
+}
+
+func FuzzServerArguments(f *testing.F) {
+	f.Add(byte(0x04), byte(0xFF), byte(2), []byte("user\r\n"))
+	f.Add(byte(0x08), byte(0xFF), byte(2), []byte("user\r\ntext\r\n"))
+	f.Add(byte(0x0B), byte(0xFF), byte(3), []byte("\r\n\r\n"))
+	f.Add(byte(0x0E), byte(0x03), byte(1), []byte("user\r\n"))
+	f.Add(byte(0x0E), byte(0x00), byte(0), []byte{})
+
+	f.Fuzz(func(t *testing.T, op, info, args byte, payload []byte) {
+		if len(payload) > spec.MaxPayload {
+			return
+		}
+
+		server, client := net.Pipe()
+		deadline := time.Now().Add(time.Second)
+		server.SetDeadline(deadline)
+		client.SetDeadline(deadline)
+
+		// Closing the pipe unblocks the writer if the payload is not read
+		written := make(chan struct{})
+		defer func() {
+			server.Close()
+			client.Close()
+			<-written
+		}()
+
+		go func() {
+			defer close(written)
+			if _, err := client.Write(rawHeader(op, info, args, uint16(len(payload)))); err != nil {
+				return
+			}
+
+			// Empty writes block until the other end reads
+			if len(payload) > 0 {
+				client.Write(payload)
+			}
+		}()
+
+		var cmd spec.Command
+		conn := spec.NewConnection(server, false)
+		if err := cmd.ListenHeader(conn); err != nil {
+			t.Fatal(err)
+		}
+
+		if cmd.HD.Args != 0 && cmd.HD.Len != 0 {
+			if err := cmd.ListenPayload(conn); err != nil {
+				return
+			}
+		}
+
+		if spec.ValidateServerCommand(cmd) != nil {
+			return
+		}
+
+		// Validated commands must have every argument handlers index
+		if len(cmd.Args) < spec.ServerArgs(cmd.HD.Op) {
+			t.Fatalf("%d arguments validated for %s", len(cmd.Args), spec.CodeToString(cmd.HD.Op))
+		}
+
+		if cmd.HD.Op == spec.ADMIN && len(cmd.Args) < spec.AdminArgs(spec.Admin(cmd.HD.Info)) {
+			t.Fatalf("%d arguments validated for %s", len(cmd.Args), spec.AdminString(spec.Admin(cmd.HD.Info)))
+		}
+	})
+}
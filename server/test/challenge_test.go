@@ -0,0 +1,63 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/Sprinter05/gochat/internal/spec"
+)
+
+func TestRandText(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 1000 {
+		text, err := spec.RandText(spec.ChallengeSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(text) != spec.ChallengeSize {
+			t.Fatalf("expected %d characters, got %d", spec.ChallengeSize, len(text))
+		}
+
+		if seen[string(text)] {
+			t.Fatalf("repeated random text %s", text)
+		}
+		seen[string(text)] = true
+	}
+
+	_, err := spec.RandText(spec.MinChallengeSize - 1)
+	if err == nil {
+		t.Fatal("random text shorter than the minimum was generated")
+	}
+}
+
+func TestChallengeSizes(t *testing.T) {
+	for _, bits := range []int{1024, 2048, spec.RSABitSize} {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Same size as the one used by the server
+		size := min(spec.ChallengeSize, spec.MaxEncryptSize(&key.PublicKey))
+		text, err := spec.RandText(size)
+		if err != nil {
+			t.Fatalf("%d bits: %s", bits, err)
+		}
+
+		enc, err := spec.EncryptText(text, &key.PublicKey)
+		if err != nil {
+			t.Fatalf("%d bits: %s", bits, err)
+		}
+
+		dec, err := spec.DecryptText(enc, key)
+		if err != nil {
+			t.Fatalf("%d bits: %s", bits, err)
+		}
+
+		if string(dec) != string(text) {
+			t.Fatalf("%d bits: decrypted challenge does not match", bits)
+		}
+	}
+}
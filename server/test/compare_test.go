@@ -0,0 +1,28 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/Sprinter05/gochat/internal/spec"
+)
+
+func TestSecureCompare(t *testing.T) {
+	cases := []struct {
+		a, b []byte
+		want bool
+	}{
+		{[]byte("token"), []byte("token"), true},
+		{[]byte("token"), []byte("tokem"), false},
+		{[]byte("token"), []byte("token2"), false},
+		{[]byte("token"), []byte("tok"), false},
+		{[]byte("token"), nil, false},
+		{nil, nil, true},
+	}
+
+	for _, v := range cases {
+		got := spec.SecureCompare(v.a, v.b)
+		if got != v.want {
+			t.Errorf("comparing %q and %q: got %t, want %t", v.a, v.b, got, v.want)
+		}
+	}
+}
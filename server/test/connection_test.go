@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/Sprinter05/gochat/server/hubs"
+)
+
+func TestConnectionPackets(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sender := spec.NewConnection(server, false)
+	receiver := spec.NewConnection(client, false)
+
+	sent := spec.Command{
+		HD: spec.Header{
+			Op: spec.MSG,
+			ID: 7,
+		},
+		Args: [][]byte{[]byte("user"), []byte("text")},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sender.WritePacket(sent)
+	}()
+
+	got, err := receiver.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if got.HD.Op != sent.HD.Op || got.HD.ID != sent.HD.ID || len(got.Args) != len(sent.Args) {
+		t.Fatalf("received packet does not match:\n%s", got.Contents())
+	}
+	for i := range sent.Args {
+		if !bytes.Equal(got.Args[i], sent.Args[i]) {
+			t.Fatalf("argument %d does not match", i)
+		}
+	}
+
+	// Writes that do not use the connection must be counted too
+	done := make(chan struct{})
+	go func() {
+		hubs.SendOKPacket(8, sender.Conn)
+		close(done)
+	}()
+	if _, err := receiver.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	// Both ends must agree on the size of the packets
+	_, written := sender.Traffic()
+	read, _ := receiver.Traffic()
+	if written == 0 || written != read {
+		t.Fatalf("wrote %d bytes but read %d bytes", written, read)
+	}
+}
+
+func TestKickPacket(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go hubs.SendKickPacket(spec.KickAdmin, "spamming", server)
+
+	got, err := spec.NewConnection(client, false).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients must accept it without a request of their own
+	if err := spec.ValidateClientCommand(got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.HD.Op != spec.KICK || spec.Kick(got.HD.Info) != spec.KickAdmin {
+		t.Fatalf("unexpected packet received:\n%s", got.Contents())
+	}
+	if len(got.Args) != 1 || string(got.Args[0]) != "spamming" {
+		t.Fatal("reason of the kick does not match")
+	}
+}
+
+func TestMessageTypes(t *testing.T) {
+	cases := map[byte]spec.MsgType{
+		spec.EmptyInfo:          spec.MsgText,
+		byte(spec.MsgText):      spec.MsgText,
+		byte(spec.MsgAction):    spec.MsgAction,
+		byte(spec.MsgBroadcast): spec.MsgBroadcast,
+		byte(spec.MsgReply):     spec.MsgReply,
+		0x7F:                    spec.MsgText,
+	}
+
+	// Missing and unknown types must be read as plain text
+	for info, expected := range cases {
+		if got := spec.InfoToMsgType(info); got != expected {
+			t.Fatalf("info 0x%02x read as %s instead of %s", info, spec.MsgTypeString(got), spec.MsgTypeString(expected))
+		}
+	}
+}
+
+func TestSignalData(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// Session descriptions use CRLF line endings
+	sdp := []byte("v=0\r\no=- 46117317 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n")
+
+	pak, err := spec.NewPacket(spec.SIGNAL, spec.NullID, spec.EmptyInfo,
+		[]byte("user"),
+		spec.SignalToBytes(sdp),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Write(pak)
+
+	got, err := spec.NewConnection(client, false).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Args) != 2 {
+		t.Fatalf("signal split into %d arguments", len(got.Args))
+	}
+
+	data, err := spec.BytesToSignal(got.Args[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, sdp) {
+		t.Fatalf("signaling data does not match:\n%q", data)
+	}
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sprinter05/gochat/internal/spec"
+	"github.com/Sprinter05/gochat/server/hubs"
+)
+
+func TestHandlerPanic(t *testing.T) {
+	// Without a database any query makes the handler panic
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := hubs.NewHub(nil, cancel, 1, "", 0, hubs.ChallengeAuth{})
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	req := hubs.Request{
+		Conn: server,
+		Command: spec.Command{
+			HD: spec.Header{
+				Ver:  spec.ProtocolVersion,
+				Op:   spec.USRS,
+				Info: byte(spec.UsersAll),
+				ID:   1,
+			},
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hubs.Process(hub, req, hubs.User{})
+	}()
+
+	var reply spec.Command
+	conn := spec.NewConnection(client, false)
+	if err := reply.ListenHeader(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.HD.Op != spec.ERR || reply.HD.Info != spec.ErrorCode(spec.ErrorServer) {
+		t.Fatalf("expected ERR_SERVER but got %s", reply.Contents())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after panicking")
+	}
+
+	// The connection must have been closed
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection is still open after panicking")
+	}
+}
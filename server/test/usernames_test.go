@@ -0,0 +1,54 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/Sprinter05/gochat/server/hubs"
+)
+
+func TestNameFilter(t *testing.T) {
+	filter, err := hubs.NewNameFilter(
+		[]string{"admin", "system"},
+		[]string{"^root", "(?i)badword"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"admin":       false,
+		"ADMIN":       false,
+		"System":      false,
+		"administr":   true,
+		"rootkit":     false,
+		"notroot":     true,
+		"myBadWord42": false,
+		"alice":       true,
+	}
+
+	for name, allowed := range cases {
+		reason := filter.Deny(name)
+		if allowed && reason != "" {
+			t.Errorf("%s should be allowed but got %q", name, reason)
+		}
+		if !allowed && reason == "" {
+			t.Errorf("%s should not be allowed", name)
+		}
+	}
+
+	if filter.Deny("admin") != "username is reserved" {
+		t.Error("reserved names should report being reserved")
+	}
+
+	var empty hubs.NameFilter
+	if empty.Deny("admin") != "" {
+		t.Error("empty filter should allow any name")
+	}
+}
+
+func TestNameFilterInvalid(t *testing.T) {
+	_, err := hubs.NewNameFilter(nil, []string{"("})
+	if err == nil {
+		t.Fatal("invalid pattern should fail to compile")
+	}
+}